@@ -30,10 +30,17 @@ func MarshalLengthBytes(l int) []byte {
 }
 
 // GetLengthFromASN returns the length of a slice of ASN1 encoded bytes from the ASN1 length header it contains.
+// If the bytes do not contain a complete length header 0 is returned.
 func GetLengthFromASN(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
 	if int(b[1]) <= 127 {
 		return int(b[1])
 	}
+	if len(b) < 2+int(b[1])-128 {
+		return 0
+	}
 	// The bytes that indicate the length
 	lb := b[2 : 2+int(b[1])-128]
 	base := 1
@@ -46,7 +53,11 @@ func GetLengthFromASN(b []byte) int {
 }
 
 // GetNumberBytesInLengthHeader returns the number of bytes in the ASn1 header that indicate the length.
+// If the bytes do not contain a length header 0 is returned.
 func GetNumberBytesInLengthHeader(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
 	if int(b[1]) <= 127 {
 		return 1
 	}
@@ -1,16 +1,21 @@
 package client
 
 import (
+	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/crypto/etype"
 	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
 	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/iana/patype"
 	"github.com/jcmturner/gokrb5/v8/krberror"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/types"
 )
 
+// maxPreAuthRounds is the maximum number of further pre-authentication rounds performed for the pre-auth handlers.
+const maxPreAuthRounds = 5
+
 // ASExchange performs an AS exchange for the client to retrieve a TGT.
 func (cl *Client) ASExchange(realm string, ASReq messages.ASReq, referral int) (messages.ASRep, error) {
 	if ok, err := cl.IsConfigured(); !ok {
@@ -34,6 +39,12 @@ func (cl *Client) ASExchange(realm string, ASReq messages.ASReq, referral int) (
 		if e, ok := err.(messages.KRBError); ok {
 			switch e.ErrorCode {
 			case errorcode.KDC_ERR_PREAUTH_REQUIRED, errorcode.KDC_ERR_PREAUTH_FAILED:
+				// A KDC offering FAST is used with FAST when the client has a source of FAST armor configured
+				if offered, required := cl.fastOffered(&e); offered && cl.settings.FASTArmorClient() != nil {
+					return cl.fastASExchange(realm, ASReq, e)
+				} else if required {
+					return messages.ASRep{}, ErrFASTRequired
+				}
 				// From now on assume this client will need to do this pre-auth and set the PAData
 				cl.settings.assumePreAuthentication = true
 				err = setPAData(cl, &e, &ASReq)
@@ -45,19 +56,37 @@ func (cl *Client) ASExchange(realm string, ASReq messages.ASReq, referral int) (
 					return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed marshaling AS_REQ with PAData")
 				}
 				rb, err = cl.sendToKDC(b, realm)
+				// Further rounds of pre-authentication for mechanisms provided by the pre-auth handlers
+				for round := 0; err != nil && round < maxPreAuthRounds; round++ {
+					me, ok := err.(messages.KRBError)
+					if !ok || me.ErrorCode != errorcode.KDC_ERR_MORE_PREAUTH_DATA_REQUIRED || len(cl.settings.PreAuthHandlers()) < 1 {
+						break
+					}
+					if herr := setHandlerPAData(cl, &me, &ASReq); herr != nil {
+						return messages.ASRep{}, krberror.Errorf(herr, krberror.KRBMsgError, "AS Exchange Error: failed setting AS_REQ PAData for further pre-authentication")
+					}
+					mb, merr := ASReq.Marshal()
+					if merr != nil {
+						return messages.ASRep{}, krberror.Errorf(merr, krberror.EncodingError, "AS Exchange Error: failed marshaling AS_REQ with PAData")
+					}
+					rb, err = cl.sendToKDC(mb, realm)
+				}
 				if err != nil {
-					if _, ok := err.(messages.KRBError); ok {
+					if me, ok := err.(messages.KRBError); ok {
+						if me.ErrorCode == errorcode.KDC_ERR_WRONG_REALM {
+							return cl.clientReferral(me, realm, ASReq, referral)
+						}
+						if me.ErrorCode == errorcode.KDC_ERR_KEY_EXPIRED {
+							return messages.ASRep{}, ErrMustChangePassword
+						}
 						return messages.ASRep{}, krberror.Errorf(err, krberror.KDCError, "AS Exchange Error: kerberos error response from KDC")
 					}
 					return messages.ASRep{}, krberror.Errorf(err, krberror.NetworkingError, "AS Exchange Error: failed sending AS_REQ to KDC")
 				}
 			case errorcode.KDC_ERR_WRONG_REALM:
-				// Client referral https://tools.ietf.org/html/rfc6806.html#section-7
-				if referral > 5 {
-					return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "maximum number of client referrals exceeded")
-				}
-				referral++
-				return cl.ASExchange(e.CRealm, ASReq, referral)
+				return cl.clientReferral(e, realm, ASReq, referral)
+			case errorcode.KDC_ERR_KEY_EXPIRED:
+				return messages.ASRep{}, ErrMustChangePassword
 			default:
 				return messages.ASRep{}, krberror.Errorf(err, krberror.KDCError, "AS Exchange Error: kerberos error response from KDC")
 			}
@@ -75,6 +104,100 @@ func (cl *Client) ASExchange(realm string, ASReq messages.ASReq, referral int) (
 	return ASRep, nil
 }
 
+// clientReferral follows a client referral, as defined in RFC 6806 section 7, by retrying the AS exchange against the
+// realm the KDC indicated in the KDC_ERR_WRONG_REALM error as the client's realm.
+func (cl *Client) clientReferral(e messages.KRBError, realm string, ASReq messages.ASReq, referral int) (messages.ASRep, error) {
+	if referral > 5 {
+		return messages.ASRep{}, krberror.Errorf(e, krberror.KRBMsgError, "maximum number of client referrals exceeded")
+	}
+	if e.CRealm == "" || e.CRealm == realm {
+		return messages.ASRep{}, krberror.Errorf(e, krberror.KDCError, "AS Exchange Error: KDC returned a client referral without a realm to refer to")
+	}
+	cl.Log("client referral from %s to %s", realm, e.CRealm)
+	// The request is for the referred realm so the realm of the TGT requested becomes that realm too
+	if len(ASReq.ReqBody.SName.NameString) == 2 && ASReq.ReqBody.SName.NameString[0] == "krbtgt" && ASReq.ReqBody.SName.NameString[1] == ASReq.ReqBody.Realm {
+		ASReq.ReqBody.SName.NameString = []string{"krbtgt", e.CRealm}
+	}
+	ASReq.ReqBody.Realm = e.CRealm
+	// The PA data is set again for the referred realm
+	ASReq.PAData = types.PADataSequence{}
+	// The client's keys are those of the client's principal in the referred realm
+	cl.Credentials.SetDomain(e.CRealm)
+	referral++
+	return cl.ASExchange(e.CRealm, ASReq, referral)
+}
+
+// SendASReq sends an AS_REQ constructed by the caller to a KDC of the realm in the request body and returns the AS_REP.
+//
+// This is intended for advanced use where the AS_REQ needs options, PAData or principal names that the client's
+// Login method does not provide. The AS_REQ is sent as is: no pre-authentication data is added and no retries or
+// referrals are followed. If the KDC responds with an error this is returned as a messages.KRBError so that the
+// caller can inspect it and build a subsequent request. The encrypted part of the returned AS_REP is not decrypted,
+// use the AS_REP's DecryptEncPart method with the appropriate credentials.
+func SendASReq(ASReq messages.ASReq, c *config.Config) (messages.ASRep, error) {
+	if c == nil {
+		return messages.ASRep{}, krberror.New(krberror.ConfigError, "AS Exchange cannot be performed: no configuration provided")
+	}
+	cl := &Client{
+		Config:   c,
+		settings: NewSettings(),
+	}
+	b, err := ASReq.Marshal()
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed marshaling AS_REQ")
+	}
+	rb, err := cl.sendToKDC(b, ASReq.ReqBody.Realm)
+	if err != nil {
+		if e, ok := err.(messages.KRBError); ok {
+			return messages.ASRep{}, e
+		}
+		return messages.ASRep{}, krberror.Errorf(err, krberror.NetworkingError, "AS Exchange Error: failed sending AS_REQ to KDC")
+	}
+	var ASRep messages.ASRep
+	err = ASRep.Unmarshal(rb)
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed to process the AS_REP")
+	}
+	return ASRep, nil
+}
+
+// CheckPreauthRequired reports whether the KDC requires pre-authentication for the principal in the realm specified.
+//
+// An AS_REQ without any pre-authentication data is sent for the principal. If the KDC responds with an AS_REP rather
+// than KDC_ERR_PREAUTH_REQUIRED the principal does not require pre-authentication, and the encrypted part of the
+// AS_REP is open to offline attack on its password (AS-REP roasting). This is intended for auditing a realm for such
+// accounts and only the client's configuration is used, no credentials are needed.
+func (cl *Client) CheckPreauthRequired(principal, realm string) (bool, error) {
+	if cl.Config == nil {
+		return false, krberror.New(krberror.ConfigError, "pre-authentication check cannot be performed: no configuration provided")
+	}
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, principal)
+	ASReq, err := messages.NewASReqForTGT(realm, cl.Config, cname)
+	if err != nil {
+		return false, krberror.Errorf(err, krberror.KRBMsgError, "pre-authentication check: failed to create AS_REQ")
+	}
+	b, err := ASReq.Marshal()
+	if err != nil {
+		return false, krberror.Errorf(err, krberror.EncodingError, "pre-authentication check: failed marshaling AS_REQ")
+	}
+	rb, err := cl.sendToKDC(b, realm)
+	if err != nil {
+		if e, ok := err.(messages.KRBError); ok {
+			if e.ErrorCode == errorcode.KDC_ERR_PREAUTH_REQUIRED {
+				return true, nil
+			}
+			return false, krberror.Errorf(err, krberror.KDCError, "pre-authentication check: kerberos error response from KDC")
+		}
+		return false, krberror.Errorf(err, krberror.NetworkingError, "pre-authentication check: failed sending AS_REQ to KDC")
+	}
+	var ASRep messages.ASRep
+	err = ASRep.Unmarshal(rb)
+	if err != nil {
+		return false, krberror.Errorf(err, krberror.EncodingError, "pre-authentication check: failed to process the AS_REP")
+	}
+	return false, nil
+}
+
 // setPAData adds pre-authentication data to the AS_REQ.
 func setPAData(cl *Client, krberr *messages.KRBError, ASReq *messages.ASReq) error {
 	if !cl.settings.DisablePAFXFAST() {
@@ -140,9 +263,56 @@ func setPAData(cl *Client, krberr *messages.KRBError, ASReq *messages.ASReq) err
 		}
 		ASReq.PAData = append(ASReq.PAData, pa)
 	}
+	if krberr != nil {
+		return setHandlerPAData(cl, krberr, ASReq)
+	}
 	return nil
 }
 
+// setHandlerPAData adds the PA-DATA produced by the client's pre-auth handlers for the PA-DATA types offered by the
+// KDC in the KRBError's e-data. Any PA-FX-COOKIE sent by the KDC is returned to it so the KDC can resume its state.
+func setHandlerPAData(cl *Client, krberr *messages.KRBError, ASReq *messages.ASReq) error {
+	handlers := cl.settings.PreAuthHandlers()
+	if len(handlers) < 1 || len(krberr.EData) < 1 {
+		return nil
+	}
+	var offered types.PADataSequence
+	err := offered.Unmarshal(krberr.EData)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmashalling KRBError e-data")
+	}
+	for _, pa := range offered {
+		if pa.PADataType == patype.PA_FX_COOKIE {
+			replacePAData(ASReq, pa)
+			continue
+		}
+		for _, h := range handlers {
+			if h.PADataType() != pa.PADataType {
+				continue
+			}
+			pas, err := h.PAData(pa, *ASReq)
+			if err != nil {
+				return krberror.Errorf(err, krberror.KRBMsgError, "pre-auth handler for PA-DATA type %d failed", pa.PADataType)
+			}
+			for _, p := range pas {
+				replacePAData(ASReq, p)
+			}
+		}
+	}
+	return nil
+}
+
+// replacePAData adds the PA-DATA to the AS_REQ replacing any existing PA-DATA of the same type.
+func replacePAData(ASReq *messages.ASReq, pa types.PAData) {
+	for i, p := range ASReq.PAData {
+		if p.PADataType == pa.PADataType {
+			ASReq.PAData[i] = pa
+			return
+		}
+	}
+	ASReq.PAData = append(ASReq.PAData, pa)
+}
+
 // preAuthEType establishes what encryption type to use for pre-authentication from the KRBError returned from the KDC.
 func preAuthEType(krberr *messages.KRBError) (etype etype.EType, err error) {
 	//RFC 4120 5.2.7.5 covers the preference order of ETYPE-INFO2 and ETYPE-INFO.
@@ -1,8 +1,8 @@
 package client
 
 import (
+	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/iana/flags"
-	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/krberror"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/types"
@@ -10,16 +10,29 @@ import (
 
 // TGSREQGenerateAndExchange generates the TGS_REQ and performs a TGS exchange to retrieve a ticket to the specified SPN.
 func (cl *Client) TGSREQGenerateAndExchange(spn types.PrincipalName, kdcRealm string, tgt messages.Ticket, sessionKey types.EncryptionKey, renewal bool) (tgsReq messages.TGSReq, tgsRep messages.TGSRep, err error) {
-	tgsReq, err = messages.NewTGSReq(cl.Credentials.CName(), kdcRealm, cl.Config, tgt, sessionKey, spn, renewal)
+	tgsReq, err = cl.newTGSReq(kdcRealm, tgt, sessionKey, spn, renewal)
 	if err != nil {
 		return tgsReq, tgsRep, krberror.Errorf(err, krberror.KRBMsgError, "TGS Exchange Error: failed to generate a new TGS_REQ")
 	}
 	return cl.TGSExchange(tgsReq, kdcRealm, tgsRep.Ticket, sessionKey, 0)
 }
 
+// newTGSReq generates a new TGS_REQ restricted to the client's configured addresses, if any.
+func (cl *Client) newTGSReq(kdcRealm string, tgt messages.Ticket, sessionKey types.EncryptionKey, spn types.PrincipalName, renewal bool) (messages.TGSReq, error) {
+	tgsReq, err := messages.NewTGSReq(cl.Credentials.CName(), kdcRealm, cl.realmConfig(kdcRealm), tgt, sessionKey, spn, renewal)
+	if err != nil {
+		return tgsReq, err
+	}
+	if ha := cl.settings.ClientAddresses(); len(ha) > 0 {
+		err = tgsReq.SetAddresses(ha, tgt, sessionKey)
+	}
+	return tgsReq, err
+}
+
 // TGSExchange exchanges the provided TGS_REQ with the KDC to retrieve a TGS_REP.
 // Referrals are automatically handled.
-// The client's cache is updated with the ticket received.
+// The client's cache is updated with the ticket received, unless the TGS_REQ included authorization data or was for
+// a user-to-user ticket.
 func (cl *Client) TGSExchange(tgsReq messages.TGSReq, kdcRealm string, tgt messages.Ticket, sessionKey types.EncryptionKey, referral int) (messages.TGSReq, messages.TGSRep, error) {
 	var tgsRep messages.TGSRep
 	b, err := tgsReq.Marshal()
@@ -54,33 +67,74 @@ func (cl *Client) TGSExchange(tgsReq messages.TGSReq, kdcRealm string, tgt messa
 		cl.addSession(tgsRep.Ticket, tgsRep.DecryptedEncPart)
 		realm := tgsRep.Ticket.SName.NameString[len(tgsRep.Ticket.SName.NameString)-1]
 		referral++
-		if types.IsFlagSet(&tgsReq.ReqBody.KDCOptions, flags.EncTktInSkey) && len(tgsReq.ReqBody.AdditionalTickets) > 0 {
-			tgsReq, err = messages.NewUser2UserTGSReq(cl.Credentials.CName(), kdcRealm, cl.Config, tgt, sessionKey, tgsReq.ReqBody.SName, tgsReq.Renewal, tgsReq.ReqBody.AdditionalTickets[0])
+		u2u := isUser2User(tgsReq)
+		additional := tgsReq.ReqBody.AdditionalTickets
+		ad, err := tgsReq.AuthorizationData(sessionKey)
+		if err != nil {
+			return tgsReq, tgsRep, err
+		}
+		tgsReq, err = cl.newTGSReq(realm, tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, tgsReq.ReqBody.SName, tgsReq.Renewal)
+		if err != nil {
+			return tgsReq, tgsRep, err
+		}
+		if u2u {
+			// Carry the user-to-user request over, with the server's TGT, to the request to the referred realm
+			err = tgsReq.SetUser2User(additional[0], tgsRep.Ticket, tgsRep.DecryptedEncPart.Key)
 			if err != nil {
 				return tgsReq, tgsRep, err
 			}
 		}
-		tgsReq, err = messages.NewTGSReq(cl.Credentials.CName(), realm, cl.Config, tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, tgsReq.ReqBody.SName, tgsReq.Renewal)
-		if err != nil {
-			return tgsReq, tgsRep, err
+		if len(ad) > 0 {
+			// Carry the requested authorization data over to the request to the referred realm
+			err = tgsReq.SetAuthorizationData(ad, tgsRep.Ticket, tgsRep.DecryptedEncPart.Key)
+			if err != nil {
+				return tgsReq, tgsRep, err
+			}
 		}
 		return cl.TGSExchange(tgsReq, realm, tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, referral)
 	}
+	if len(tgsReq.ReqBody.EncAuthData.Cipher) > 0 || isUser2User(tgsReq) {
+		// Tickets requested with authorization data are restricted, and user-to-user tickets are encrypted with the
+		// session key of the server's TGT, so neither are cached for general use for the SPN
+		return tgsReq, tgsRep, err
+	}
+	// The KDC may grant a shorter lifetime, or not grant a renewable ticket, so the times granted are cached rather
+	// than those requested
 	cl.cache.addEntry(
 		tgsRep.Ticket,
 		tgsRep.DecryptedEncPart.AuthTime,
 		tgsRep.DecryptedEncPart.StartTime,
 		tgsRep.DecryptedEncPart.EndTime,
-		tgsRep.DecryptedEncPart.RenewTill,
+		grantedRenewTill(tgsRep.DecryptedEncPart),
 		tgsRep.DecryptedEncPart.Key,
+		tgsRep.DecryptedEncPart.Flags,
 	)
 	cl.Log("ticket added to cache for %s (EndTime: %v)", tgsRep.Ticket.SName.PrincipalNameString(), tgsRep.DecryptedEncPart.EndTime)
 	return tgsReq, tgsRep, err
 }
 
+// tgsRepCacheEntry returns the cache entry for the ticket of the TGS_REP, as TGSExchange caches it.
+func tgsRepCacheEntry(tgsRep messages.TGSRep) CacheEntry {
+	return newCacheEntry(
+		tgsRep.Ticket,
+		tgsRep.DecryptedEncPart.AuthTime,
+		tgsRep.DecryptedEncPart.StartTime,
+		tgsRep.DecryptedEncPart.EndTime,
+		grantedRenewTill(tgsRep.DecryptedEncPart),
+		tgsRep.DecryptedEncPart.Key,
+		tgsRep.DecryptedEncPart.Flags,
+	)
+}
+
 // GetServiceTicket makes a request to get a service ticket for the SPN specified
 // SPN format: <SERVICE>/<FQDN> Eg. HTTP/www.example.com
+// The realm of the service is resolved from the FQDN using the krb5.conf domain_realm mappings. It can instead be
+// specified with the SPN format <SERVICE>/<FQDN>@<REALM>, so that one client can obtain tickets for services in
+// several realms whose hostnames are not mapped. The client obtains and holds a TGT for each realm as required and
+// sends the TGS_REQ to the KDC of the service's realm.
 // The ticket will be added to the client's ticket cache
+// The session key returned carries its own enctype which may differ from the enctype of the ticket's encrypted part.
+// Use crypto.GetKeyChksumType with the session key to select the checksum type for an AP_REQ authenticator.
 func (cl *Client) GetServiceTicket(spn string) (messages.Ticket, types.EncryptionKey, error) {
 	var tkt messages.Ticket
 	var skey types.EncryptionKey
@@ -88,21 +142,139 @@ func (cl *Client) GetServiceTicket(spn string) (messages.Ticket, types.Encryptio
 		// Already a valid ticket in the cache
 		return tkt, skey, nil
 	}
-	princ := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, spn)
-	realm := cl.spnRealm(princ)
+	princ, realm := cl.serviceRealm(spn)
+	tgt, skey, err := cl.sessionTGT(realm)
+	if err != nil {
+		return tkt, skey, err
+	}
+	_, tgsRep, err := cl.TGSREQGenerateAndExchange(princ, realm, tgt, skey, false)
+	if err != nil {
+		return tkt, skey, err
+	}
+	if e := tgsRepCacheEntry(tgsRep); e.SPN != princ.PrincipalNameString() || e.Ticket.Realm != realm {
+		// Also cache the ticket under the SPN and realm requested. The entry is that of the TGS_REP received, rather
+		// than that cached, as a concurrent request may have replaced it.
+		cl.cache.addAlias(princ.PrincipalNameString(), realm, e)
+	}
+	return tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, nil
+}
 
+// serviceRealm returns the principal name of the SPN and the realm to request its ticket in. The realm is that of an
+// <SERVICE>/<FQDN>@<REALM> SPN, otherwise it is resolved from the FQDN and if it cannot be the client realm's KDC is
+// asked.
+func (cl *Client) serviceRealm(spn string) (types.PrincipalName, string) {
+	princ, realm := types.ParseSPNString(spn)
+	if realm == "" {
+		realm = cl.spnRealm(princ)
+	}
 	// if we don't know the SPN's realm, ask the client realm's KDC
 	if realm == "" {
 		realm = cl.Credentials.Realm()
 	}
+	return princ, realm
+}
+
+// Prewarm obtains the client's TGT, if it does not already have one, and service tickets for each of the SPNs provided
+// so that they are held in the client's cache before they are first needed. Latency sensitive services can call this
+// at startup so that the first request to each service does not incur the exchanges with the KDC.
+// SPN format: <SERVICE>/<FQDN> Eg. HTTP/www.example.com
+func (cl *Client) Prewarm(spns []string) error {
+	err := cl.AffirmLogin()
+	if err != nil {
+		return err
+	}
+	for _, spn := range spns {
+		_, _, err := cl.GetServiceTicket(spn)
+		if err != nil {
+			return krberror.Errorf(err, krberror.KRBMsgError, "could not get service ticket for %s", spn)
+		}
+	}
+	return nil
+}
 
+// GetServiceTicketWithAuthorizationData makes a request to get a service ticket for the SPN specified that includes
+// the authorization data provided, for example AD-RESTRICTION-ENTRY elements to restrict the ticket.
+// SPN format: <SERVICE>/<FQDN> or <SERVICE>/<FQDN>@<REALM> Eg. HTTP/www.example.com
+// As the ticket is restricted it is not added to the client's ticket cache and the cache is not consulted.
+func (cl *Client) GetServiceTicketWithAuthorizationData(spn string, ad types.AuthorizationData) (messages.Ticket, types.EncryptionKey, error) {
+	var tkt messages.Ticket
+	princ, realm := cl.serviceRealm(spn)
 	tgt, skey, err := cl.sessionTGT(realm)
 	if err != nil {
 		return tkt, skey, err
 	}
-	_, tgsRep, err := cl.TGSREQGenerateAndExchange(princ, realm, tgt, skey, false)
+	tgsReq, err := cl.newTGSReq(realm, tgt, skey, princ, false)
+	if err != nil {
+		return tkt, skey, krberror.Errorf(err, krberror.KRBMsgError, "TGS Exchange Error: failed to generate a new TGS_REQ")
+	}
+	err = tgsReq.SetAuthorizationData(ad, tgt, skey)
+	if err != nil {
+		return tkt, skey, krberror.Errorf(err, krberror.KRBMsgError, "TGS Exchange Error: failed to set authorization data on TGS_REQ")
+	}
+	_, tgsRep, err := cl.TGSExchange(tgsReq, realm, tgt, skey, 0)
+	if err != nil {
+		return tkt, skey, err
+	}
+	return tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, nil
+}
+
+// GetDownstreamServiceTicket makes a request, on behalf of the client of a service, for a service ticket for the SPN
+// specified that carries forward the restrictions of the ticket the upstream client authenticated to the service with,
+// along with the further authorization data provided. Each hop of a delegation chain can so constrain what the next
+// can do but not relax the constraints placed on it.
+// The Client should be created from the upstream client's delegated credentials, for example with NewFromKRBCred, and
+// the upstream credentials must hold the ticket's authorization data, see service.ContextValueAuthorizationData.
+// As the ticket is restricted it is not added to the client's ticket cache and the cache is not consulted.
+func (cl *Client) GetDownstreamServiceTicket(spn string, upstream *credentials.Credentials, ad types.AuthorizationData) (messages.Ticket, types.EncryptionKey, error) {
+	inherited, ok := upstream.Attributes()[credentials.AttributeKeyAuthorizationData].(types.AuthorizationData)
+	if !ok {
+		return messages.Ticket{}, types.EncryptionKey{}, krberror.New(krberror.KRBMsgError, "upstream credentials do not hold the authorization data of their ticket")
+	}
+	restrictions := append(inherited.Restrictions(), ad...)
+	return cl.GetServiceTicketWithAuthorizationData(spn, restrictions)
+}
+
+// GetUser2UserTicket makes a request for a user-to-user ticket to the principal specified, encrypted with the session
+// key of the TGT the principal provided rather than its long term key, as for peer to peer applications where the
+// server does not hold a keytab (https://tools.ietf.org/html/rfc4120#section-3.7).
+// Principal format: <NAME> or <NAME>@<REALM> Eg. user2@EXAMPLE.COM
+// The request is sent to the KDC of the realm that can decrypt the server's TGT, which may differ from the client's
+// realm, in which case the client obtains a cross-realm TGT for that realm as required.
+// As the ticket can only be used with the server's current session it is not added to the client's ticket cache and
+// the cache is not consulted.
+func (cl *Client) GetUser2UserTicket(principal string, serverTGT messages.Ticket) (messages.Ticket, types.EncryptionKey, error) {
+	var tkt messages.Ticket
+	princ, _ := types.ParseSPNString(principal)
+	realm := tgtRealm(serverTGT)
+	tgt, skey, err := cl.sessionTGT(realm)
+	if err != nil {
+		return tkt, skey, err
+	}
+	tgsReq, err := cl.newTGSReq(realm, tgt, skey, princ, false)
+	if err != nil {
+		return tkt, skey, krberror.Errorf(err, krberror.KRBMsgError, "TGS Exchange Error: failed to generate a new TGS_REQ")
+	}
+	err = tgsReq.SetUser2User(serverTGT, tgt, skey)
+	if err != nil {
+		return tkt, skey, krberror.Errorf(err, krberror.KRBMsgError, "TGS Exchange Error: failed to request a user-to-user ticket")
+	}
+	_, tgsRep, err := cl.TGSExchange(tgsReq, realm, tgt, skey, 0)
 	if err != nil {
 		return tkt, skey, err
 	}
 	return tgsRep.Ticket, tgsRep.DecryptedEncPart.Key, nil
 }
+
+// tgtRealm returns the realm whose KDC can decrypt the TGT, that is the realm of its krbtgt service principal. For a
+// cross-realm TGT this differs from the realm that issued it.
+func tgtRealm(tgt messages.Ticket) string {
+	if len(tgt.SName.NameString) == 2 && tgt.SName.NameString[1] != "" {
+		return tgt.SName.NameString[1]
+	}
+	return tgt.Realm
+}
+
+// isUser2User indicates if the TGS_REQ is for a user-to-user ticket.
+func isUser2User(tgsReq messages.TGSReq) bool {
+	return types.IsFlagSet(&tgsReq.ReqBody.KDCOptions, flags.EncTktInSkey) && len(tgsReq.ReqBody.AdditionalTickets) > 0
+}
@@ -2,11 +2,11 @@ package client
 
 import (
 	"encoding/json"
-	"errors"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/types"
 )
@@ -25,6 +25,7 @@ type CacheEntry struct {
 	StartTime  time.Time
 	EndTime    time.Time
 	RenewTill  time.Time
+	Flags      asn1.BitString      `json:"-"`
 	SessionKey types.EncryptionKey `json:"-"`
 }
 
@@ -35,11 +36,17 @@ func NewCache() *Cache {
 	}
 }
 
-// getEntry returns a cache entry that matches the SPN.
-func (c *Cache) getEntry(spn string) (CacheEntry, bool) {
+// cacheKey returns the key that the ticket for the principal name within the realm is cached under. Tickets are keyed
+// by realm as the same SPN may be held in several realms.
+func cacheKey(spn, realm string) string {
+	return spn + "@" + realm
+}
+
+// getEntry returns a cache entry that matches the SPN within the realm.
+func (c *Cache) getEntry(spn, realm string) (CacheEntry, bool) {
 	c.mux.RLock()
 	defer c.mux.RUnlock()
-	e, ok := (*c).Entries[spn]
+	e, ok := (*c).Entries[cacheKey(spn, realm)]
 	return e, ok
 }
 
@@ -63,49 +70,79 @@ func (c *Cache) JSON() (string, error) {
 	return string(b), nil
 }
 
-// addEntry adds a ticket to the cache.
-func (c *Cache) addEntry(tkt messages.Ticket, authTime, startTime, endTime, renewTill time.Time, sessionKey types.EncryptionKey) CacheEntry {
-	spn := tkt.SName.PrincipalNameString()
-	c.mux.Lock()
-	defer c.mux.Unlock()
-	(*c).Entries[spn] = CacheEntry{
-		SPN:        spn,
+// newCacheEntry returns a cache entry for the ticket.
+func newCacheEntry(tkt messages.Ticket, authTime, startTime, endTime, renewTill time.Time, sessionKey types.EncryptionKey, flags asn1.BitString) CacheEntry {
+	return CacheEntry{
+		SPN:        tkt.SName.PrincipalNameString(),
 		Ticket:     tkt,
 		AuthTime:   authTime,
 		StartTime:  startTime,
 		EndTime:    endTime,
 		RenewTill:  renewTill,
+		Flags:      flags,
 		SessionKey: sessionKey,
 	}
-	return c.Entries[spn]
 }
 
-// clear deletes all the cache entries
+// addEntry adds a ticket to the cache under its SPN within the ticket's realm.
+func (c *Cache) addEntry(tkt messages.Ticket, authTime, startTime, endTime, renewTill time.Time, sessionKey types.EncryptionKey, flags asn1.BitString) CacheEntry {
+	e := newCacheEntry(tkt, authTime, startTime, endTime, renewTill, sessionKey, flags)
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	(*c).Entries[cacheKey(e.SPN, tkt.Realm)] = e
+	return e
+}
+
+// addAlias adds the cache entry provided to the cache under an alias of its SPN within the realm.
+func (c *Cache) addAlias(spn, realm string, e CacheEntry) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	e.SPN = spn
+	(*c).Entries[cacheKey(spn, realm)] = e
+}
+
+// clear deletes all the cache entries and zeroizes their session keys
 func (c *Cache) clear() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	for k := range c.Entries {
+	for k, e := range c.Entries {
+		e.SessionKey.Zeroize()
 		delete(c.Entries, k)
 	}
 }
 
 // RemoveEntry removes the cache entry for the defined SPN.
+// An SPN of the format <SERVICE>/<FQDN>@<REALM> removes only the entry within that realm, otherwise the entries for the
+// SPN within every realm are removed.
 func (c *Cache) RemoveEntry(spn string) {
+	princ, realm := types.ParseSPNString(spn)
+	name := princ.PrincipalNameString()
 	c.mux.Lock()
 	defer c.mux.Unlock()
-	delete(c.Entries, spn)
+	if realm != "" {
+		delete(c.Entries, cacheKey(name, realm))
+		return
+	}
+	for k, e := range c.Entries {
+		if e.SPN == name {
+			delete(c.Entries, k)
+		}
+	}
 }
 
 // GetCachedTicket returns a ticket from the cache for the SPN.
 // Only a ticket that is currently valid will be returned.
+// The realm of the SPN is resolved as it is by GetServiceTicket, so that a ticket for the same SPN in another realm is
+// not returned.
 func (cl *Client) GetCachedTicket(spn string) (messages.Ticket, types.EncryptionKey, bool) {
-	if e, ok := cl.cache.getEntry(spn); ok {
+	princ, realm := cl.serviceRealm(spn)
+	if e, ok := cl.cache.getEntry(princ.PrincipalNameString(), realm); ok {
 		//If within time window of ticket return it
 		if time.Now().UTC().After(e.StartTime) && time.Now().UTC().Before(e.EndTime) {
 			cl.Log("ticket received from cache for %s", spn)
 			return e.Ticket, e.SessionKey, true
 		} else if time.Now().UTC().Before(e.RenewTill) {
-			e, err := cl.renewTicket(e)
+			e, err := cl.renewTicket(e, realm)
 			if err != nil {
 				return e.Ticket, e.SessionKey, false
 			}
@@ -117,18 +154,89 @@ func (cl *Client) GetCachedTicket(spn string) (messages.Ticket, types.Encryption
 	return tkt, key, false
 }
 
-// renewTicket renews a cache entry ticket.
+// renewTicket renews a cache entry ticket. The entry is cached under its SPN within the realm provided.
 // To renew from outside the client package use GetCachedTicket
-func (cl *Client) renewTicket(e CacheEntry) (CacheEntry, error) {
+func (cl *Client) renewTicket(e CacheEntry, realm string) (CacheEntry, error) {
 	spn := e.Ticket.SName
-	_, _, err := cl.TGSREQGenerateAndExchange(spn, e.Ticket.Realm, e.Ticket, e.SessionKey, true)
+	_, tgsRep, err := cl.TGSREQGenerateAndExchange(spn, e.Ticket.Realm, e.Ticket, e.SessionKey, true)
 	if err != nil {
 		return e, err
 	}
-	e, ok := cl.cache.getEntry(e.Ticket.SName.PrincipalNameString())
-	if !ok {
-		return e, errors.New("ticket was not added to cache")
+	alias := e.SPN
+	e = tgsRepCacheEntry(tgsRep)
+	if alias != e.SPN || realm != e.Ticket.Realm {
+		cl.cache.addAlias(alias, realm, e)
 	}
 	cl.Log("ticket renewed for %s (EndTime: %v)", spn.PrincipalNameString(), e.EndTime)
 	return e, nil
 }
+
+// TicketInfo describes a ticket held by the client, as would be listed by klist.
+type TicketInfo struct {
+	ClientName string
+	ServerName string
+	TGT        bool
+	Flags      asn1.BitString
+	AuthTime   time.Time
+	StartTime  time.Time
+	EndTime    time.Time
+	RenewTill  time.Time
+	EType      int32
+}
+
+// CachedTickets returns details of the tickets held by the client: the TGT of each realm followed by the cached
+// service tickets. The server name of a service ticket is the name within the ticket, and a ticket cached under an
+// alias of its name, such as when the KDC canonicalized the name requested, is listed only once.
+func (cl *Client) CachedTickets() []TicketInfo {
+	var cname string
+	if cl.Credentials != nil {
+		cname = cl.Credentials.CName().PrincipalNameString() + "@" + cl.Credentials.Domain()
+	}
+	var tkts []TicketInfo
+	cl.sessions.mux.RLock()
+	realms := make([]string, 0, len(cl.sessions.Entries))
+	for k := range cl.sessions.Entries {
+		realms = append(realms, k)
+	}
+	sort.Strings(realms)
+	for _, r := range realms {
+		i := cl.sessions.Entries[r].ticketInfo()
+		i.ClientName = cname
+		tkts = append(tkts, i)
+	}
+	cl.sessions.mux.RUnlock()
+	cl.cache.mux.RLock()
+	defer cl.cache.mux.RUnlock()
+	type ticketID struct {
+		name   string
+		cipher string
+		end    time.Time
+	}
+	seen := make(map[ticketID]bool)
+	var svc []TicketInfo
+	for _, e := range cl.cache.Entries {
+		name := e.Ticket.SName.PrincipalNameString() + "@" + e.Ticket.Realm
+		id := ticketID{name: name, cipher: string(e.Ticket.EncPart.Cipher), end: e.EndTime}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		svc = append(svc, TicketInfo{
+			ClientName: cname,
+			ServerName: name,
+			Flags:      e.Flags,
+			AuthTime:   e.AuthTime,
+			StartTime:  e.StartTime,
+			EndTime:    e.EndTime,
+			RenewTill:  e.RenewTill,
+			EType:      e.SessionKey.KeyType,
+		})
+	}
+	sort.SliceStable(svc, func(i, j int) bool {
+		if svc[i].ServerName != svc[j].ServerName {
+			return svc[i].ServerName < svc[j].ServerName
+		}
+		return svc[i].EndTime.Before(svc[j].EndTime)
+	})
+	return append(tkts, svc...)
+}
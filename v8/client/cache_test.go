@@ -6,6 +6,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
@@ -19,6 +26,7 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 	for i := 0; i < cnt; i++ {
 		wg.Add(1)
 		tkt := messages.Ticket{
+			Realm: "TEST.GOKRB5",
 			SName: types.PrincipalName{
 				NameType:   1,
 				NameString: []string{fmt.Sprintf("%d", i), "test.cache"},
@@ -29,7 +37,7 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 			KeyValue: []byte{byte(i)},
 		}
 		go func(i int) {
-			e := c.addEntry(tkt, time.Unix(int64(0+i), 0).UTC(), time.Unix(int64(10+i), 0).UTC(), time.Unix(int64(20+i), 0).UTC(), time.Unix(int64(30+i), 0).UTC(), key)
+			e := c.addEntry(tkt, time.Unix(int64(0+i), 0).UTC(), time.Unix(int64(10+i), 0).UTC(), time.Unix(int64(20+i), 0).UTC(), time.Unix(int64(30+i), 0).UTC(), key, types.NewKrbFlags())
 			assert.Equal(t, fmt.Sprintf("%d/test.cache", i), e.SPN, "SPN cache key not as expected")
 			wg.Done()
 		}(i)
@@ -38,7 +46,7 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 	for i := 0; i < cnt; i++ {
 		wg.Add(1)
 		go func(i int) {
-			e, ok := c.getEntry(fmt.Sprintf("%d/test.cache", i))
+			e, ok := c.getEntry(fmt.Sprintf("%d/test.cache", i), "TEST.GOKRB5")
 			assert.True(t, ok, "cache entry %d was not found", i)
 			assert.Equal(t, time.Unix(int64(0+i), 0).UTC(), e.AuthTime, "auth time not as expected")
 			assert.Equal(t, time.Unix(int64(10+i), 0).UTC(), e.StartTime, "start time not as expected")
@@ -50,7 +58,7 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 		}(i)
 	}
 	wg.Wait()
-	_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1))
+	_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1), "TEST.GOKRB5")
 	assert.False(t, ok, "entry found in cache when it shouldn't have been")
 
 	// Remove just the even entries
@@ -67,10 +75,10 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 		wg.Add(1)
 		go func(i int) {
 			if i%2 == 0 {
-				_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1))
+				_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1), "TEST.GOKRB5")
 				assert.False(t, ok, "entry %d found in cache when it shouldn't have been", i)
 			} else {
-				e, ok := c.getEntry(fmt.Sprintf("%d/test.cache", i))
+				e, ok := c.getEntry(fmt.Sprintf("%d/test.cache", i), "TEST.GOKRB5")
 				assert.True(t, ok, "cache entry %d was not found", i)
 				assert.Equal(t, time.Unix(int64(0+i), 0).UTC(), e.AuthTime, "auth time not as expected")
 				assert.Equal(t, time.Unix(int64(10+i), 0).UTC(), e.StartTime, "start time not as expected")
@@ -89,7 +97,7 @@ func TestCache_addEntry_getEntry_remove_clear(t *testing.T) {
 	for i := 0; i < cnt; i++ {
 		wg.Add(1)
 		go func(i int) {
-			_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1))
+			_, ok := c.getEntry(fmt.Sprintf("%d/test.cache", cnt+1), "TEST.GOKRB5")
 			assert.False(t, ok, "entry %d found in cache when it shouldn't have been", i)
 			wg.Done()
 		}(i)
@@ -112,7 +120,7 @@ func TestCache_JSON(t *testing.T) {
 			KeyType:  1,
 			KeyValue: []byte{byte(i)},
 		}
-		e := c.addEntry(tkt, time.Unix(int64(0+i), 0).UTC(), time.Unix(int64(10+i), 0).UTC(), time.Unix(int64(20+i), 0).UTC(), time.Unix(int64(30+i), 0).UTC(), key)
+		e := c.addEntry(tkt, time.Unix(int64(0+i), 0).UTC(), time.Unix(int64(10+i), 0).UTC(), time.Unix(int64(20+i), 0).UTC(), time.Unix(int64(30+i), 0).UTC(), key, types.NewKrbFlags())
 		assert.Equal(t, fmt.Sprintf("%d/test.cache", i), e.SPN, "SPN cache key not as expected")
 	}
 	expected := `[
@@ -144,3 +152,198 @@ func TestCache_JSON(t *testing.T) {
 	}
 	assert.Equal(t, expected, j, "json output not as expected")
 }
+
+func TestClient_CachedTickets(t *testing.T) {
+	t.Parallel()
+	c := testKDCConfig(t, "127.0.0.1:88")
+	now := time.Now().UTC().Truncate(time.Second)
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	kc.DecryptedEncPart.TicketInfo[0].Flags = types.NewKrbFlags()
+	types.SetFlag(&kc.DecryptedEncPart.TicketInfo[0].Flags, flags.Initial)
+	cl, err := NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	tf := types.NewKrbFlags()
+	types.SetFlag(&tf, flags.Renewable)
+	tkt := messages.Ticket{Realm: "TEST.GOKRB5", SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "HTTP/host.test.gokrb5")}
+	skey := types.EncryptionKey{KeyType: 17, KeyValue: make([]byte, 16)}
+	e := cl.cache.addEntry(tkt, now, now.Add(time.Minute), now.Add(time.Hour), now.Add(time.Hour*2), skey, tf)
+	// Aliases of the ticket's name, including one in another realm, do not list the ticket again
+	cl.cache.addAlias("HTTP/host", "TEST.GOKRB5", e)
+	cl.cache.addAlias("HTTP/host.test.gokrb5", "RESDOM.GOKRB5", e)
+
+	tkts := cl.CachedTickets()
+	if !assert.Len(t, tkts, 2, "number of cached tickets not as expected") {
+		t.FailNow()
+	}
+	assert.True(t, tkts[0].TGT, "first ticket should be the TGT")
+	assert.Equal(t, "testuser1@TEST.GOKRB5", tkts[0].ClientName, "TGT client name not as expected")
+	assert.Equal(t, "krbtgt/TEST.GOKRB5@TEST.GOKRB5", tkts[0].ServerName, "TGT server name not as expected")
+	assert.True(t, types.IsFlagSet(&tkts[0].Flags, flags.Initial), "TGT flags not as expected")
+	assert.Equal(t, now.Add(time.Hour*10), tkts[0].EndTime, "TGT end time not as expected")
+	assert.Equal(t, int32(18), tkts[0].EType, "TGT session key etype not as expected")
+
+	assert.False(t, tkts[1].TGT, "second ticket should not be a TGT")
+	assert.Equal(t, "testuser1@TEST.GOKRB5", tkts[1].ClientName, "service ticket client name not as expected")
+	assert.Equal(t, "HTTP/host.test.gokrb5@TEST.GOKRB5", tkts[1].ServerName, "service ticket server name not as expected")
+	assert.True(t, types.IsFlagSet(&tkts[1].Flags, flags.Renewable), "service ticket flags not as expected")
+	assert.Equal(t, now, tkts[1].AuthTime, "service ticket auth time not as expected")
+	assert.Equal(t, now.Add(time.Minute), tkts[1].StartTime, "service ticket start time not as expected")
+	assert.Equal(t, now.Add(time.Hour), tkts[1].EndTime, "service ticket end time not as expected")
+	assert.Equal(t, now.Add(time.Hour*2), tkts[1].RenewTill, "service ticket renew till not as expected")
+	assert.Equal(t, int32(17), tkts[1].EType, "service ticket session key etype not as expected")
+}
+
+func TestClient_GetServiceTicket_GrantedLifetime(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC().Truncate(time.Second)
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	spn := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	endTime := now.Add(time.Minute * 30)
+	addr := testKDC(t, func(req []byte) []byte {
+		var tgsReq messages.TGSReq
+		if err := tgsReq.Unmarshal(req); err != nil {
+			t.Errorf("KDC did not receive a TGS_REQ: %v", err)
+			return []byte{0}
+		}
+		if !endTime.Before(tgsReq.ReqBody.Till) {
+			t.Errorf("test requires a shorter lifetime than requested: %v", tgsReq.ReqBody.Till)
+		}
+		// The KDC grants a shorter lifetime than requested and a renew till time without the ticket being renewable
+		dep := messages.EncKDCRepPart{
+			Key:       types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)},
+			LastReqs:  []messages.LastReq{{LRType: 0, LRValue: now}},
+			Nonce:     tgsReq.ReqBody.Nonce,
+			Flags:     types.NewKrbFlags(),
+			AuthTime:  now,
+			StartTime: now,
+			EndTime:   endTime,
+			RenewTill: now.Add(time.Hour * 24),
+			SRealm:    "TEST.GOKRB5",
+			SName:     spn,
+		}
+		return testTGSRep(tgsReq, dep, sessionKey)
+	})
+	c := testKDCConfig(t, addr)
+	c.LibDefaults.TicketLifetime = time.Hour * 10
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	_, _, err = cl.GetServiceTicket("HTTP/host.test.gokrb5")
+	if err != nil {
+		t.Fatalf("a ticket with a shorter lifetime than requested should be accepted: %v", err)
+	}
+	e, ok := cl.cache.getEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5")
+	if !ok {
+		t.Fatal("service ticket not cached")
+	}
+	assert.Equal(t, endTime, e.EndTime, "cached end time should be that granted by the KDC")
+	assert.True(t, e.RenewTill.IsZero(), "renew till of a ticket that is not renewable should not be cached")
+}
+
+// testTGSRep returns a marshaled TGS_REP replying to the TGS_REQ with the encrypted part provided, encrypted with the
+// TGT session key.
+func testTGSRep(tgsReq messages.TGSReq, dep messages.EncKDCRepPart, sessionKey types.EncryptionKey) []byte {
+	b, _ := dep.Marshal()
+	ed, _ := crypto.GetEncryptedData(b, sessionKey, keyusage.TGS_REP_ENCPART_SESSION_KEY, 0)
+	tgsRep := messages.TGSRep{
+		KDCRepFields: messages.KDCRepFields{
+			PVNO:    iana.PVNO,
+			MsgType: msgtype.KRB_TGS_REP,
+			CRealm:  tgsReq.ReqBody.Realm,
+			CName:   tgsReq.ReqBody.CName,
+			Ticket: messages.Ticket{
+				TktVNO:  iana.PVNO,
+				Realm:   dep.SRealm,
+				SName:   dep.SName,
+				EncPart: types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, KVNO: 1, Cipher: []byte{0}},
+			},
+			EncPart: ed,
+		},
+	}
+	rb, _ := tgsRep.Marshal()
+	return rb
+}
+
+func TestClient_GetServiceTicket_Realm(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC().Truncate(time.Second)
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	requests := make(map[string]int)
+	var mux sync.Mutex
+	kdc := func(realm string) string {
+		return testKDC(t, func(req []byte) []byte {
+			mux.Lock()
+			requests[realm]++
+			mux.Unlock()
+			var tgsReq messages.TGSReq
+			if err := tgsReq.Unmarshal(req); err != nil {
+				t.Errorf("KDC did not receive a TGS_REQ: %v", err)
+				return []byte{0}
+			}
+			assert.Equal(t, realm, tgsReq.ReqBody.Realm, "TGS_REQ sent to the KDC of another realm")
+			return testTGSRep(tgsReq, messages.EncKDCRepPart{
+				Key:       types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)},
+				LastReqs:  []messages.LastReq{{LRType: 0, LRValue: now}},
+				Nonce:     tgsReq.ReqBody.Nonce,
+				Flags:     types.NewKrbFlags(),
+				AuthTime:  now,
+				StartTime: now,
+				EndTime:   now.Add(time.Hour),
+				SRealm:    realm,
+				SName:     tgsReq.ReqBody.SName,
+			}, sessionKey)
+		})
+	}
+	c := testKDCConfig(t, kdc("TEST.GOKRB5"))
+	c.Realms[1].KDC = []string{kdc("RESDOM.GOKRB5")}
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	// The client also holds a TGT for the second realm
+	cl.sessions.Entries["RESDOM.GOKRB5"] = &session{
+		realm:      "RESDOM.GOKRB5",
+		authTime:   now.Add(-time.Hour),
+		endTime:    now.Add(time.Hour * 10),
+		tgt:        messages.Ticket{TktVNO: 5, Realm: "TEST.GOKRB5", SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/RESDOM.GOKRB5")},
+		sessionKey: sessionKey,
+	}
+
+	for _, realm := range []string{"TEST.GOKRB5", "RESDOM.GOKRB5"} {
+		tkt, _, err := cl.GetServiceTicket("HTTP/host.test.gokrb5@" + realm)
+		if err != nil {
+			t.Fatalf("error getting service ticket in %s: %v", realm, err)
+		}
+		assert.Equal(t, realm, tkt.Realm, "service ticket realm not as expected")
+		tkt, _, err = cl.GetServiceTicket("HTTP/host.test.gokrb5@" + realm)
+		if err != nil {
+			t.Fatalf("error getting cached service ticket in %s: %v", realm, err)
+		}
+		assert.Equal(t, realm, tkt.Realm, "cached service ticket realm not as expected")
+	}
+	// Obtaining the ticket in the second realm does not replace that cached for the first
+	for _, realm := range []string{"TEST.GOKRB5", "RESDOM.GOKRB5"} {
+		tkt, _, ok := cl.GetCachedTicket("HTTP/host.test.gokrb5@" + realm)
+		if assert.True(t, ok, "service ticket in %s not cached", realm) {
+			assert.Equal(t, realm, tkt.Realm, "cached service ticket realm not as expected")
+		}
+	}
+	tkt, _, ok := cl.GetCachedTicket("HTTP/host.test.gokrb5")
+	if assert.True(t, ok, "service ticket in the realm mapped to the host not cached") {
+		assert.Equal(t, "TEST.GOKRB5", tkt.Realm, "cached service ticket realm of an SPN without a realm not as expected")
+	}
+	mux.Lock()
+	assert.Equal(t, map[string]int{"TEST.GOKRB5": 1, "RESDOM.GOKRB5": 1}, requests, "TGS_REQs sent to each realm's KDC not as expected")
+	mux.Unlock()
+
+	cl.cache.RemoveEntry("HTTP/host.test.gokrb5@RESDOM.GOKRB5")
+	_, _, ok = cl.GetCachedTicket("HTTP/host.test.gokrb5@RESDOM.GOKRB5")
+	assert.False(t, ok, "service ticket in the realm removed should not be cached")
+	_, _, ok = cl.GetCachedTicket("HTTP/host.test.gokrb5@TEST.GOKRB5")
+	assert.True(t, ok, "service ticket in the other realm should remain cached")
+	cl.cache.RemoveEntry("HTTP/host.test.gokrb5")
+	assert.Empty(t, cl.cache.Entries, "removing an SPN without a realm should remove it in every realm")
+}
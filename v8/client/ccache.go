@@ -0,0 +1,89 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/krberror"
+	"github.com/jcmturner/gokrb5/v8/messages"
+)
+
+// LoginToCCache performs an AS exchange for the user and password provided, in the manner of kinit, and writes the
+// TGT obtained into an MIT format credential cache file at the path provided so that it can be used by other
+// Kerberos tools on the host.
+//
+// If the path is empty the KRB5CCNAME environment variable is used, otherwise the default of /tmp/krb5cc_<uid>.
+// Only FILE credential caches are supported. Any existing credential cache at the path is replaced.
+func LoginToCCache(username, realm, password, ccachePath string, krb5conf *config.Config, settings ...func(*Settings)) error {
+	p, err := ccacheFilePath(ccachePath)
+	if err != nil {
+		return err
+	}
+	cl := NewWithPassword(username, realm, password, krb5conf, settings...)
+	defer cl.Destroy()
+	if ok, err := cl.IsConfigured(); !ok {
+		return err
+	}
+	ASReq, err := messages.NewASReqForTGT(cl.Credentials.Domain(), cl.Config, cl.Credentials.CName())
+	if err != nil {
+		return krberror.Errorf(err, krberror.KRBMsgError, "error generating new AS_REQ")
+	}
+	ASRep, err := cl.ASExchange(cl.Credentials.Domain(), ASReq, 0)
+	if err != nil {
+		return err
+	}
+	tb, err := ASRep.Ticket.Marshal()
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error marshaling TGT for the credential cache")
+	}
+	c := new(credentials.CCache)
+	c.Version = 4
+	c.DefaultPrincipal.Realm = ASRep.CRealm
+	c.DefaultPrincipal.PrincipalName = ASRep.CName
+	cred := new(credentials.Credential)
+	cred.Client = c.DefaultPrincipal
+	cred.Server.Realm = ASRep.DecryptedEncPart.SRealm
+	cred.Server.PrincipalName = ASRep.DecryptedEncPart.SName
+	cred.Key = ASRep.DecryptedEncPart.Key
+	cred.AuthTime = ASRep.DecryptedEncPart.AuthTime
+	cred.StartTime = ASRep.DecryptedEncPart.StartTime
+	cred.EndTime = ASRep.DecryptedEncPart.EndTime
+	cred.RenewTill = ASRep.DecryptedEncPart.RenewTill
+	cred.TicketFlags = ASRep.DecryptedEncPart.Flags
+	cred.Addresses = ASRep.DecryptedEncPart.CAddr
+	cred.Ticket = tb
+	c.Credentials = []*credentials.Credential{cred}
+	b, err := c.Marshal()
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error marshaling the credential cache")
+	}
+	err = os.WriteFile(p, b, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write credential cache to %s: %v", p, err)
+	}
+	cl.Log("TGT for %s@%s written to credential cache %s", ASRep.CName.PrincipalNameString(), ASRep.CRealm, p)
+	return nil
+}
+
+// ccacheFilePath returns the file path of the credential cache to write to.
+func ccacheFilePath(ccachePath string) (string, error) {
+	p := ccachePath
+	if p == "" {
+		p = os.Getenv("KRB5CCNAME")
+	}
+	if p == "" {
+		return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()), nil
+	}
+	if strings.HasPrefix(p, "FILE:") {
+		return strings.TrimPrefix(p, "FILE:"), nil
+	}
+	for _, t := range []string{"DIR:", "KEYRING:", "KCM:", "API:", "MEMORY:", "MSLSA:"} {
+		if strings.HasPrefix(p, t) {
+			return "", fmt.Errorf("credential cache type %s is not supported, only FILE credential caches can be written", strings.TrimSuffix(t, ":"))
+		}
+	}
+	return p, nil
+}
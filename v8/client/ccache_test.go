@@ -0,0 +1,165 @@
+package client
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCCacheFilePath(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		path     string
+		expected string
+		err      bool
+	}{
+		{"/tmp/krb5cc_test", "/tmp/krb5cc_test", false},
+		{"FILE:/tmp/krb5cc_test", "/tmp/krb5cc_test", false},
+		{"KEYRING:persistent:1000", "", true},
+		{"DIR:/run/user/1000/krb5cc", "", true},
+	}
+	for _, test := range tests {
+		p, err := ccacheFilePath(test.path)
+		if test.err {
+			assert.Error(t, err, "credential cache %s should not be supported", test.path)
+			continue
+		}
+		assert.NoError(t, err, "credential cache %s should be supported", test.path)
+		assert.Equal(t, test.expected, p, "credential cache file path for %s not as expected", test.path)
+	}
+}
+
+func TestLoginToCCache_KDCError(t *testing.T) {
+	t.Parallel()
+	addr := testKDC(t, func(req []byte) []byte {
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+	p := filepath.Join(t.TempDir(), "krb5cc")
+	err := LoginToCCache("testuser1", "TEST.GOKRB5", "passwordvalue", p, c)
+	assert.Error(t, err, "login should fail when the KDC returns an error")
+	_, err = os.Stat(p)
+	assert.True(t, os.IsNotExist(err), "credential cache should not be written when login fails")
+}
+
+func TestNewFromCCache_GetServiceTicket(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC().Truncate(time.Second)
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	authTime := now.Add(-time.Hour * 8)
+	spn := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	var tgsReqs int
+	var mux sync.Mutex
+	addr := testKDC(t, func(req []byte) []byte {
+		var tgsReq messages.TGSReq
+		if err := tgsReq.Unmarshal(req); err != nil {
+			t.Errorf("KDC did not receive a TGS_REQ: %v", err)
+			return []byte{0}
+		}
+		mux.Lock()
+		tgsReqs++
+		mux.Unlock()
+		// The TGS_REQ must use the TGT from the credential cache and be authenticated with its session key
+		var apReq messages.APReq
+		if len(tgsReq.PAData) < 1 || tgsReq.PAData[0].PADataType != patype.PA_TGS_REQ {
+			t.Error("TGS_REQ does not contain PA-TGS-REQ")
+			return []byte{0}
+		}
+		if err := apReq.Unmarshal(tgsReq.PAData[0].PADataValue); err != nil {
+			t.Errorf("TGS_REQ does not contain an AP_REQ: %v", err)
+			return []byte{0}
+		}
+		assert.Equal(t, "krbtgt/TEST.GOKRB5", apReq.Ticket.SName.PrincipalNameString(), "TGT in TGS_REQ not as expected")
+		if err := apReq.DecryptAuthenticator(sessionKey); err != nil {
+			t.Errorf("TGS_REQ authenticator not encrypted with the TGT session key: %v", err)
+		}
+		// A service ticket issued using a TGT obtained some time ago carries the TGT's auth time and the optional
+		// start time is omitted
+		dep := messages.EncKDCRepPart{
+			Key:      types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)},
+			LastReqs: []messages.LastReq{{LRType: 0, LRValue: authTime}},
+			Nonce:    tgsReq.ReqBody.Nonce,
+			Flags:    types.NewKrbFlags(),
+			AuthTime: authTime,
+			EndTime:  now.Add(time.Hour),
+			SRealm:   "TEST.GOKRB5",
+			SName:    spn,
+		}
+		b, _ := dep.Marshal()
+		ed, _ := crypto.GetEncryptedData(b, sessionKey, keyusage.TGS_REP_ENCPART_SESSION_KEY, 0)
+		tgsRep := messages.TGSRep{
+			KDCRepFields: messages.KDCRepFields{
+				PVNO:    iana.PVNO,
+				MsgType: msgtype.KRB_TGS_REP,
+				CRealm:  "TEST.GOKRB5",
+				CName:   tgsReq.ReqBody.CName,
+				Ticket: messages.Ticket{
+					TktVNO:  iana.PVNO,
+					Realm:   "TEST.GOKRB5",
+					SName:   spn,
+					EncPart: types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, KVNO: 1, Cipher: []byte{0}},
+				},
+				EncPart: ed,
+			},
+		}
+		rb, _ := tgsRep.Marshal()
+		return rb
+	})
+	c := testKDCConfig(t, addr)
+
+	b, _ := hex.DecodeString(testdata.CCACHE_TEST)
+	cc := new(credentials.CCache)
+	if err := cc.Unmarshal(b); err != nil {
+		t.Fatalf("error unmarshaling test credential cache: %v", err)
+	}
+	// Only keep the TGT, as obtained by kinit, so the service ticket must be requested from the KDC
+	var creds []*credentials.Credential
+	for _, cred := range cc.Credentials {
+		if cred.Server.PrincipalName.NameString[0] == "krbtgt" {
+			cred.Key = sessionKey
+			cred.AuthTime = authTime
+			cred.StartTime = authTime
+			cred.EndTime = now.Add(time.Hour * 4)
+			cred.RenewTill = time.Time{}
+			creds = append(creds, cred)
+		}
+	}
+	cc.Credentials = creds
+
+	cl, err := NewFromCCache(cc, c)
+	if err != nil {
+		t.Fatalf("error creating client from credential cache: %v", err)
+	}
+	tkt, key, err := cl.GetServiceTicket("HTTP/host.test.gokrb5")
+	if err != nil {
+		t.Fatalf("error getting service ticket with TGT from credential cache: %v", err)
+	}
+	assert.Equal(t, "HTTP/host.test.gokrb5", tkt.SName.PrincipalNameString(), "service ticket SName not as expected")
+	assert.Equal(t, int32(etypeID.AES256_CTS_HMAC_SHA1_96), key.KeyType, "service ticket session key type not as expected")
+
+	// The ticket obtained should now be cached
+	_, _, err = cl.GetServiceTicket("HTTP/host.test.gokrb5")
+	assert.NoError(t, err, "error getting cached service ticket")
+	mux.Lock()
+	assert.Equal(t, 1, tgsReqs, "number of TGS_REQs sent to the KDC not as expected")
+	mux.Unlock()
+}
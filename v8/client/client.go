@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -28,6 +30,7 @@ type Client struct {
 	settings    *Settings
 	sessions    *sessions
 	cache       *Cache
+	etypes      realmETypes
 }
 
 // NewWithPassword creates a new client from a password credential.
@@ -59,8 +62,69 @@ func NewWithKeytab(username, realm string, kt *keytab.Keytab, krb5conf *config.C
 	}
 }
 
+// NewForHost creates a new client for the local machine account, using the host service principal host/<fqdn>.
+//
+// If hostname is empty the Hostname setting is used, if configured, otherwise the hostname of the local machine is
+// used. The hostname is canonicalised via DNS to derive
+// the fully qualified domain name. If realm is empty it is resolved from the fully qualified domain name using the
+// krb5.conf. If kt is nil the machine keytab is loaded from the default_keytab_name of the krb5.conf.
+func NewForHost(hostname, realm string, kt *keytab.Keytab, krb5conf *config.Config, settings ...func(*Settings)) (*Client, error) {
+	s := NewSettings(settings...)
+	if hostname == "" {
+		hostname = s.Hostname()
+	}
+	pn, err := hostPrincipalName(hostname)
+	if err != nil {
+		return nil, err
+	}
+	if realm == "" {
+		realm = krb5conf.ResolveRealm(pn.NameString[1])
+	}
+	if kt == nil {
+		kt, err = keytab.Load(strings.TrimPrefix(krb5conf.LibDefaults.DefaultKeytabName, "FILE:"))
+		if err != nil {
+			return nil, krberror.Errorf(err, krberror.ConfigError, "could not load the machine keytab")
+		}
+	}
+	creds := credentials.NewFromPrincipalName(pn, realm)
+	return &Client{
+		Credentials: creds.WithKeytab(kt),
+		Config:      krb5conf,
+		settings:    s,
+		sessions: &sessions{
+			Entries: make(map[string]*session),
+		},
+		cache: NewCache(),
+	}, nil
+}
+
+// hostPrincipalName returns the host service principal name, host/<fqdn>, of the hostname provided or of the local
+// machine if hostname is empty.
+func hostPrincipalName(hostname string) (types.PrincipalName, error) {
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return types.PrincipalName{}, krberror.Errorf(err, krberror.ConfigError, "could not determine the hostname")
+		}
+		hostname = h
+	}
+	name, err := net.LookupCNAME(hostname)
+	if name != "" && err == nil {
+		// Underlying canonical name should be used for SPN
+		hostname = name
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	return types.PrincipalName{
+		NameType:   nametype.KRB_NT_SRV_HST,
+		NameString: []string{"host", hostname},
+	}, nil
+}
+
 // NewFromCCache create a client from a populated client cache.
 //
+// Service tickets in the credential cache, for example those obtained by kinit or kvno, are added to the client's
+// ticket cache so that they are used, while valid, rather than requesting new tickets from the KDC.
+//
 // WARNING: A client created from CCache does not automatically renew TGTs and a failure will occur after the TGT expires.
 func NewFromCCache(c *credentials.CCache, krb5conf *config.Config, settings ...func(*Settings)) (*Client, error) {
 	cl := &Client{
@@ -90,6 +154,7 @@ func NewFromCCache(c *credentials.CCache, krb5conf *config.Config, settings ...f
 		authTime:   cred.AuthTime,
 		endTime:    cred.EndTime,
 		renewTill:  cred.RenewTill,
+		flags:      cred.TicketFlags,
 		tgt:        tgt,
 		sessionKey: cred.Key,
 	}
@@ -99,14 +164,65 @@ func NewFromCCache(c *credentials.CCache, krb5conf *config.Config, settings ...f
 		if err != nil {
 			return cl, fmt.Errorf("cache entry ticket bytes are not valid: %v", err)
 		}
-		cl.cache.addEntry(
+		e := cl.cache.addEntry(
 			tkt,
 			cred.AuthTime,
 			cred.StartTime,
 			cred.EndTime,
 			cred.RenewTill,
 			cred.Key,
+			cred.TicketFlags,
 		)
+		// A ticket the KDC issued for a canonicalized name is held in the credential cache under the name requested
+		if name := cred.Server.PrincipalName.PrincipalNameString(); name != e.SPN || cred.Server.Realm != tkt.Realm {
+			cl.cache.addAlias(name, cred.Server.Realm, e)
+		}
+	}
+	return cl, nil
+}
+
+// NewFromKRBCred creates a client from the forwarded TGT within a KRB_CRED, such as a credential delegated by a GSS-API
+// initiator. The encrypted part of the KRB_CRED must already have been decrypted.
+//
+// The client holds no password or keytab so once the forwarded TGT expires, and can no longer be renewed, the client
+// refuses to use it and returns an error rather than sending an expired TGT to the KDC.
+func NewFromKRBCred(c messages.KRBCred, krb5conf *config.Config, settings ...func(*Settings)) (*Client, error) {
+	cl := &Client{
+		Config:   krb5conf,
+		settings: NewSettings(settings...),
+		sessions: &sessions{
+			Entries: make(map[string]*session),
+		},
+		cache: NewCache(),
+	}
+	if len(c.Tickets) != len(c.DecryptedEncPart.TicketInfo) {
+		return cl, errors.New("KRB_CRED encrypted part has not been decrypted or does not describe all of its tickets")
+	}
+	var endTime time.Time
+	for i, tkt := range c.Tickets {
+		info := c.DecryptedEncPart.TicketInfo[i]
+		if len(tkt.SName.NameString) == 2 && tkt.SName.NameString[0] == "krbtgt" && cl.Credentials == nil {
+			cl.Credentials = credentials.NewFromPrincipalName(info.PName, info.PRealm)
+			cl.Credentials.SetValidUntil(info.EndTime)
+			endTime = info.EndTime
+			cl.sessions.Entries[tkt.SName.NameString[1]] = &session{
+				realm:      tkt.SName.NameString[1],
+				authTime:   info.AuthTime,
+				endTime:    info.EndTime,
+				renewTill:  info.RenewTill,
+				flags:      info.Flags,
+				tgt:        tkt,
+				sessionKey: info.Key,
+			}
+			continue
+		}
+		cl.cache.addEntry(tkt, info.AuthTime, info.StartTime, info.EndTime, info.RenewTill, info.Key, info.Flags)
+	}
+	if cl.Credentials == nil {
+		return cl, errors.New("TGT not found in KRB_CRED")
+	}
+	if time.Now().UTC().After(endTime) {
+		return cl, fmt.Errorf("forwarded TGT expired at %v", endTime)
 	}
 	return cl, nil
 }
@@ -174,15 +290,18 @@ func (cl *Client) Login() error {
 			return krberror.Errorf(err, krberror.KRBMsgError, "no user credentials available and error getting any existing session")
 		}
 		if time.Now().UTC().After(endTime) {
-			return krberror.New(krberror.KRBMsgError, "cannot login, no user credentials available and no valid existing session")
+			return krberror.NewErrorf(krberror.KRBMsgError, "cannot login, no user credentials available and the existing session expired at %v", endTime)
 		}
 		// no credentials but there is a session with tgt already
 		return nil
 	}
-	ASReq, err := messages.NewASReqForTGT(cl.Credentials.Domain(), cl.Config, cl.Credentials.CName())
+	ASReq, err := messages.NewASReqForTGT(cl.Credentials.Domain(), cl.realmConfig(cl.Credentials.Domain()), cl.Credentials.CName())
 	if err != nil {
 		return krberror.Errorf(err, krberror.KRBMsgError, "error generating new AS_REQ")
 	}
+	if ha := cl.settings.ClientAddresses(); len(ha) > 0 {
+		ASReq.ReqBody.Addresses = ha
+	}
 	ASRep, err := cl.ASExchange(cl.Credentials.Domain(), ASReq, 0)
 	if err != nil {
 		return err
@@ -196,6 +315,9 @@ func (cl *Client) AffirmLogin() error {
 	_, endTime, _, _, err := cl.sessionTimes(cl.Credentials.Domain())
 	if err != nil || time.Now().UTC().After(endTime) {
 		err := cl.Login()
+		if err == ErrMustChangePassword {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("could not get valid TGT for client's realm: %v", err)
 		}
@@ -211,6 +333,9 @@ func (cl *Client) realmLogin(realm string) error {
 	_, endTime, _, _, err := cl.sessionTimes(cl.Credentials.Domain())
 	if err != nil || time.Now().UTC().After(endTime) {
 		err := cl.Login()
+		if err == ErrMustChangePassword {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("could not get valid TGT for client's realm: %v", err)
 		}
@@ -235,6 +360,7 @@ func (cl *Client) realmLogin(realm string) error {
 }
 
 // Destroy stops the auto-renewal of all sessions and removes the sessions and cache entries from the client.
+// The session keys held by the sessions and cache entries are zeroized.
 func (cl *Client) Destroy() {
 	creds := credentials.New("", "")
 	cl.sessions.destroy()
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -768,3 +769,31 @@ func TestClient_Destroy(t *testing.T) {
 	is, _ := cl.IsConfigured()
 	assert.False(t, is, "client is still configured after it was destroyed")
 }
+
+func TestLoginToCCache(t *testing.T) {
+	test.Integration(t)
+
+	addr := os.Getenv("TEST_KDC_ADDR")
+	if addr == "" {
+		addr = testdata.KDC_IP_TEST_GOKRB5
+	}
+	c, _ := config.NewFromString(testdata.KRB5_CONF)
+	c.Realms[0].KDC = []string{addr + ":" + testdata.KDC_PORT_TEST_GOKRB5}
+	p := filepath.Join(t.TempDir(), "krb5cc")
+	err := client.LoginToCCache("testuser1", "TEST.GOKRB5", "passwordvalue", p, c)
+	if err != nil {
+		t.Fatalf("error logging in to credential cache: %v", err)
+	}
+	cc, err := credentials.LoadCCache(p)
+	if err != nil {
+		t.Fatalf("error loading credential cache written: %v", err)
+	}
+	cl, err := client.NewFromCCache(cc, c)
+	if err != nil {
+		t.Fatalf("error creating client from credential cache written: %v", err)
+	}
+	_, _, err = cl.GetServiceTicket("HTTP/host.test.gokrb5")
+	if err != nil {
+		t.Errorf("error getting service ticket with the TGT from the credential cache: %v", err)
+	}
+}
@@ -1,12 +1,83 @@
 package client
 
 import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/addrtype"
+	"github.com/jcmturner/gokrb5/v8/iana/adtype"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
 )
 
+// testKDC starts a TCP listener that responds to every request with the bytes returned by the reply function.
+// It returns the address of the listener.
+func testKDC(t *testing.T, reply func(req []byte) []byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test KDC listener: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				hb := make([]byte, 4)
+				if _, err := io.ReadFull(conn, hb); err != nil {
+					return
+				}
+				req := make([]byte, binary.BigEndian.Uint32(hb))
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				rb := reply(req)
+				binary.BigEndian.PutUint32(hb, uint32(len(rb)))
+				conn.Write(append(hb, rb...))
+			}(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// testKDCConfig returns a configuration with the TEST.GOKRB5 realm pointing at the address provided and TCP only.
+func testKDCConfig(t *testing.T, addr string) *config.Config {
+	c, err := config.NewFromString(testdata.KRB5_CONF)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	c.LibDefaults.UDPPreferenceLimit = 1
+	c.Realms[0].KDC = []string{addr}
+	return c
+}
+
 func TestAssumePreauthentication(t *testing.T) {
 	t.Parallel()
 
@@ -18,3 +89,742 @@ func TestAssumePreauthentication(t *testing.T) {
 		t.Fatal("AssumePreAuthentication() should be true")
 	}
 }
+
+func TestSendASReq(t *testing.T) {
+	t.Parallel()
+
+	var received messages.ASReq
+	addr := testKDC(t, func(req []byte) []byte {
+		received.Unmarshal(req)
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+
+	cname := types.NewPrincipalName(nametype.KRB_NT_ENTERPRISE, "user@example.com")
+	ASReq, err := messages.NewASReqForTGT("TEST.GOKRB5", c, cname)
+	if err != nil {
+		t.Fatalf("error creating AS_REQ: %v", err)
+	}
+	_, err = SendASReq(ASReq, c)
+	if assert.Error(t, err, "expected an error from the test KDC") {
+		krberr, ok := err.(messages.KRBError)
+		if assert.True(t, ok, "error returned should be a KRBError, got %T", err) {
+			assert.Equal(t, errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, krberr.ErrorCode, "error code not as expected")
+		}
+	}
+	assert.Equal(t, nametype.KRB_NT_ENTERPRISE, received.ReqBody.CName.NameType, "KDC did not receive the custom cname")
+	assert.Equal(t, ASReq.ReqBody.Nonce, received.ReqBody.Nonce, "KDC did not receive the request as constructed")
+}
+
+func TestSendASReq_NoConfig(t *testing.T) {
+	t.Parallel()
+
+	_, err := SendASReq(messages.ASReq{}, nil)
+	assert.Error(t, err, "expected an error when no config provided")
+}
+
+func TestClient_CheckPreauthRequired(t *testing.T) {
+	t.Parallel()
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")
+	var tests = []struct {
+		reply    func(req []byte) []byte
+		required bool
+		err      bool
+	}{
+		{func(req []byte) []byte {
+			krberr := messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "pre-auth required")
+			b, _ := krberr.Marshal()
+			return b
+		}, true, false},
+		{func(req []byte) []byte {
+			var ASReq messages.ASReq
+			ASReq.Unmarshal(req)
+			ASRep := messages.ASRep{KDCRepFields: messages.KDCRepFields{
+				PVNO:    iana.PVNO,
+				MsgType: msgtype.KRB_AS_REP,
+				CRealm:  ASReq.ReqBody.Realm,
+				CName:   ASReq.ReqBody.CName,
+				Ticket:  messages.Ticket{TktVNO: iana.PVNO, Realm: ASReq.ReqBody.Realm, SName: ASReq.ReqBody.SName},
+			}}
+			b, _ := ASRep.Marshal()
+			return b
+		}, false, false},
+		{func(req []byte) []byte {
+			krberr := messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+			b, _ := krberr.Marshal()
+			return b
+		}, false, true},
+	}
+	for i, test := range tests {
+		c := testKDCConfig(t, testKDC(t, test.reply))
+		cl := NewWithPassword("auditor", "TEST.GOKRB5", "passwordvalue", c)
+		required, err := cl.CheckPreauthRequired("testuser1", "TEST.GOKRB5")
+		if test.err {
+			assert.Error(t, err, "test %d: expected an error", i)
+			continue
+		}
+		if assert.NoError(t, err, "test %d: unexpected error", i) {
+			assert.Equal(t, test.required, required, "test %d: pre-authentication required not as expected", i)
+		}
+	}
+}
+
+func TestClient_ClientAddresses(t *testing.T) {
+	t.Parallel()
+	ipv4, _ := types.NewHostAddress(addrtype.IPv4, "192.168.1.100")
+	ipv6, _ := types.NewHostAddress(addrtype.IPv6, "fe80::1")
+	netbios, _ := types.NewHostAddress(addrtype.NetBios, "HOST1")
+	ha := []types.HostAddress{ipv4, ipv6, netbios}
+
+	var mux sync.Mutex
+	var asReq messages.ASReq
+	var tgsReq messages.TGSReq
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		defer mux.Unlock()
+		if err := asReq.Unmarshal(req); err != nil {
+			tgsReq.Unmarshal(req)
+		}
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c, ClientAddresses(ha...))
+	cl.Login()
+	mux.Lock()
+	assert.Equal(t, ha, asReq.ReqBody.Addresses, "AS_REQ addresses not as specified")
+	mux.Unlock()
+
+	now := time.Now().UTC()
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	cl, err := NewFromKRBCred(kc, c, ClientAddresses(ha...))
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	cl.GetServiceTicket("HTTP/host.test.gokrb5")
+	mux.Lock()
+	defer mux.Unlock()
+	assert.Equal(t, ha, tgsReq.ReqBody.Addresses, "TGS_REQ addresses not as specified")
+	// The authenticator checksum must cover the request body with the addresses
+	var apReq messages.APReq
+	if err := apReq.Unmarshal(tgsReq.PAData[0].PADataValue); err != nil {
+		t.Fatalf("error unmarshaling TGS_REQ PA-TGS-REQ: %v", err)
+	}
+	if err := apReq.DecryptAuthenticator(kc.DecryptedEncPart.TicketInfo[0].Key); err != nil {
+		t.Fatalf("error decrypting TGS_REQ authenticator: %v", err)
+	}
+	et, _ := crypto.GetEtype(etypeID.AES256_CTS_HMAC_SHA1_96)
+	bb, _ := tgsReq.ReqBody.Marshal()
+	assert.True(t, et.VerifyChecksum(kc.DecryptedEncPart.TicketInfo[0].Key.KeyValue, bb, apReq.Authenticator.Cksum.Checksum, keyusage.TGS_REQ_PA_TGS_REQ_AP_REQ_AUTHENTICATOR_CHKSUM),
+		"authenticator checksum does not cover the TGS_REQ body")
+}
+
+func TestClient_GetDownstreamServiceTicket(t *testing.T) {
+	t.Parallel()
+	var mux sync.Mutex
+	var tgsReq messages.TGSReq
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		defer mux.Unlock()
+		tgsReq.Unmarshal(req)
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_S_PRINCIPAL_UNKNOWN, "unknown")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+	now := time.Now().UTC()
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	cl, err := NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+
+	upstream := credentials.New("testuser1", "TEST.GOKRB5")
+	_, _, err = cl.GetDownstreamServiceTicket("HTTP/host.test.gokrb5", upstream, nil)
+	if assert.Error(t, err, "expected an error when the upstream credentials do not hold authorization data") {
+		assert.Contains(t, err.Error(), "do not hold the authorization data", "error not as expected")
+	}
+
+	pac, _ := asn1.Marshal(types.AuthorizationData{{ADType: adtype.ADWin2KPAC, ADData: []byte{1, 2, 3, 4}}})
+	inherited := types.AuthorizationData{
+		{ADType: adtype.ADIfRelevant, ADData: pac},
+		{ADType: adtype.ADIntendedForServer, ADData: []byte{5}},
+	}
+	upstream.SetAttribute(credentials.AttributeKeyAuthorizationData, inherited)
+	added := types.AuthorizationData{{ADType: adtype.ADIntendedForServer, ADData: []byte{6}}}
+	_, _, err = cl.GetDownstreamServiceTicket("HTTP/host.test.gokrb5", upstream, added)
+	assert.Error(t, err, "expected the KDC error")
+	mux.Lock()
+	defer mux.Unlock()
+	ad, err := tgsReq.AuthorizationData(kc.DecryptedEncPart.TicketInfo[0].Key)
+	if err != nil {
+		t.Fatalf("error decrypting TGS_REQ authorization data: %v", err)
+	}
+	assert.Equal(t, types.AuthorizationData{inherited[1], added[0]}, ad, "TGS_REQ authorization data not as expected")
+}
+
+func TestClient_GetUser2UserTicket(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC().Truncate(time.Second)
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	serverTGT := messages.Ticket{TktVNO: 5, Realm: "RESDOM.GOKRB5", SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/RESDOM.GOKRB5"),
+		EncPart: types.EncryptedData{EType: etypeID.AES256_CTS_HMAC_SHA1_96, KVNO: 1, Cipher: []byte{1}}}
+	requests := make(map[string]int)
+	var mux sync.Mutex
+	kdc := func(realm string) string {
+		return testKDC(t, func(req []byte) []byte {
+			var tgsReq messages.TGSReq
+			if err := tgsReq.Unmarshal(req); err != nil {
+				t.Errorf("KDC did not receive a TGS_REQ: %v", err)
+				return []byte{0}
+			}
+			sname := tgsReq.ReqBody.SName
+			if isUser2User(tgsReq) {
+				mux.Lock()
+				requests[realm]++
+				mux.Unlock()
+				assert.Equal(t, "krbtgt", tgsReq.ReqBody.AdditionalTickets[0].SName.NameString[0], "additional ticket is not the server's TGT")
+				if realm == "TEST.GOKRB5" {
+					// Refer the request to the realm of the server
+					sname = types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/RESDOM.GOKRB5")
+				}
+			}
+			return testTGSRep(tgsReq, messages.EncKDCRepPart{
+				Key:       types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)},
+				LastReqs:  []messages.LastReq{{LRType: 0, LRValue: now}},
+				Nonce:     tgsReq.ReqBody.Nonce,
+				Flags:     types.NewKrbFlags(),
+				AuthTime:  now,
+				StartTime: now,
+				EndTime:   now.Add(time.Hour),
+				SRealm:    realm,
+				SName:     sname,
+			}, sessionKey)
+		})
+	}
+	c := testKDCConfig(t, kdc("TEST.GOKRB5"))
+	c.Realms[1].KDC = []string{kdc("RESDOM.GOKRB5")}
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+
+	// The request is sent to the KDC of the realm of the server's TGT, the client obtaining a cross-realm TGT for it
+	tkt, _, err := cl.GetUser2UserTicket("testuser2@RESDOM.GOKRB5", serverTGT)
+	if err != nil {
+		t.Fatalf("error getting user-to-user ticket: %v", err)
+	}
+	assert.Equal(t, "testuser2", tkt.SName.PrincipalNameString(), "ticket not for the server principal")
+	assert.Equal(t, map[string]int{"RESDOM.GOKRB5": 1}, requests, "user-to-user requests to the KDCs not as expected")
+	_, _, ok := cl.GetCachedTicket("testuser2")
+	assert.False(t, ok, "user-to-user ticket should not be cached")
+
+	// A user-to-user request referred to another realm remains a user-to-user request
+	serverTGT.Realm = "TEST.GOKRB5"
+	serverTGT.SName = types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")
+	_, _, err = cl.GetUser2UserTicket("testuser2", serverTGT)
+	if err != nil {
+		t.Fatalf("error getting referred user-to-user ticket: %v", err)
+	}
+	assert.Equal(t, map[string]int{"TEST.GOKRB5": 1, "RESDOM.GOKRB5": 2}, requests, "user-to-user requests to the KDCs not as expected")
+}
+
+func TestClient_ClientReferral(t *testing.T) {
+	t.Parallel()
+
+	var received messages.ASReq
+	addr := testKDC(t, func(req []byte) []byte {
+		received.Unmarshal(req)
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	referralKDC := func(crealm string) string {
+		return testKDC(t, func(req []byte) []byte {
+			krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/REFERRAL.GOKRB5"), "REFERRAL.GOKRB5", errorcode.KDC_ERR_WRONG_REALM, "wrong realm")
+			krberr.CRealm = crealm
+			b, _ := krberr.Marshal()
+			return b
+		})
+	}
+	c := testKDCConfig(t, addr)
+	c.Realms = append(c.Realms, config.Realm{Realm: "REFERRAL.GOKRB5", KDC: []string{referralKDC("TEST.GOKRB5")}})
+
+	cl := NewWithPassword("testuser1", "REFERRAL.GOKRB5", "passwordvalue", c, DisablePAFXFAST(true))
+	err := cl.Login()
+	if assert.Error(t, err, "expected an error from the referred KDC") {
+		assert.Contains(t, err.Error(), "KDC_ERR_C_PRINCIPAL_UNKNOWN", "error should be from the referred realm's KDC")
+	}
+	assert.Equal(t, "TEST.GOKRB5", received.ReqBody.Realm, "realm of the AS_REQ to the referred realm not as expected")
+	assert.Equal(t, "krbtgt/TEST.GOKRB5", received.ReqBody.SName.PrincipalNameString(), "TGT requested from the referred realm not as expected")
+	assert.Equal(t, "TEST.GOKRB5", cl.Credentials.Domain(), "client's realm should be the referred realm")
+
+	// A referral that does not indicate a realm cannot be followed
+	c.Realms[len(c.Realms)-1].KDC = []string{referralKDC("")}
+	cl = NewWithPassword("testuser1", "REFERRAL.GOKRB5", "passwordvalue", c, DisablePAFXFAST(true))
+	err = cl.Login()
+	if assert.Error(t, err, "expected an error for a referral without a realm") {
+		assert.Contains(t, err.Error(), "without a realm", "error not as expected")
+	}
+}
+
+func TestClient_KDCOrder(t *testing.T) {
+	t.Parallel()
+	c := testKDCConfig(t, "kdc1.test.gokrb5:88")
+	c.Realms[0].KDC = []string{"kdc1.test.gokrb5:88", "kdc2.test.gokrb5:88", "kdc3.test.gokrb5:88"}
+	realm := c.Realms[0].Realm
+
+	cl := NewWithKeytab("username", realm, &keytab.Keytab{}, c, PreferredKDC("kdc2.test.gokrb5"))
+	kdcs, err := cl.kdcs(realm, true)
+	if err != nil {
+		t.Fatalf("error getting KDCs: %v", err)
+	}
+	assert.Equal(t, 3, len(kdcs), "number of KDCs not as expected")
+	assert.Equal(t, "kdc2.test.gokrb5:88", kdcs[1], "preferred KDC not first")
+
+	reverse := func(realm string, kdcs []string) []string {
+		sort.Sort(sort.Reverse(sort.StringSlice(kdcs)))
+		return kdcs
+	}
+	cl = NewWithKeytab("username", realm, &keytab.Keytab{}, c, KDCOrdering(reverse))
+	kdcs, err = cl.kdcs(realm, true)
+	if err != nil {
+		t.Fatalf("error getting KDCs: %v", err)
+	}
+	assert.Equal(t, map[int]string{1: "kdc3.test.gokrb5:88", 2: "kdc2.test.gokrb5:88", 3: "kdc1.test.gokrb5:88"}, kdcs, "KDC order not as expected")
+
+	cl = NewWithKeytab("username", realm, &keytab.Keytab{}, c, KDCOrdering(reverse), PreferredKDC("kdc1.test.gokrb5:88"))
+	kdcs, err = cl.kdcs(realm, true)
+	if err != nil {
+		t.Fatalf("error getting KDCs: %v", err)
+	}
+	assert.Equal(t, map[int]string{1: "kdc1.test.gokrb5:88", 2: "kdc3.test.gokrb5:88", 3: "kdc2.test.gokrb5:88"}, kdcs, "KDC order not as expected")
+
+	// A preferred KDC that is not a KDC of the realm is not used
+	cl = NewWithKeytab("username", realm, &keytab.Keytab{}, c, KDCOrdering(reverse), PreferredKDC("other.example.com"))
+	kdcs, err = cl.kdcs(realm, true)
+	if err != nil {
+		t.Fatalf("error getting KDCs: %v", err)
+	}
+	assert.Equal(t, map[int]string{1: "kdc3.test.gokrb5:88", 2: "kdc2.test.gokrb5:88", 3: "kdc1.test.gokrb5:88"}, kdcs, "KDC order not as expected")
+}
+
+func TestClient_KDCSourcePorts(t *testing.T) {
+	t.Parallel()
+	krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "unknown")
+	eb, _ := krberr.Marshal()
+	// UDP KDC recording the source address of the request
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test UDP KDC: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	udpSrc := make(chan net.Addr, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		_, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		udpSrc <- addr
+		pc.WriteTo(eb, addr)
+	}()
+	// TCP KDC recording the source address of the request
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error starting test TCP KDC: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	tcpSrc := make(chan net.Addr, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tcpSrc <- conn.RemoteAddr()
+		hb := make([]byte, 4)
+		if _, err := io.ReadFull(conn, hb); err != nil {
+			return
+		}
+		io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(hb)))
+		binary.BigEndian.PutUint32(hb, uint32(len(eb)))
+		conn.Write(append(hb, eb...))
+	}()
+
+	// Find a free local port to bind to
+	fl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error finding a free port: %v", err)
+	}
+	port := fl.Addr().(*net.TCPAddr).Port
+	fl.Close()
+
+	c := testKDCConfig(t, pc.LocalAddr().String())
+	c.LibDefaults.UDPPreferenceLimit = 1465
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c, KDCSourcePorts(port, port))
+	min, max := cl.settings.KDCSourcePorts()
+	assert.Equal(t, port, min, "minimum source port not as expected")
+	assert.Equal(t, port, max, "maximum source port not as expected")
+
+	_, err = cl.sendKDCUDP(map[int]string{1: pc.LocalAddr().String()}, []byte{1, 2, 3})
+	assert.IsType(t, messages.KRBError{}, err, "UDP exchange with the test KDC failed")
+	assert.Equal(t, port, (<-udpSrc).(*net.UDPAddr).Port, "UDP source port not as configured")
+
+	_, err = cl.sendKDCTCP(map[int]string{1: l.Addr().String()}, []byte{1, 2, 3})
+	assert.IsType(t, messages.KRBError{}, err, "TCP exchange with the test KDC failed")
+	assert.Equal(t, port, (<-tcpSrc).(*net.TCPAddr).Port, "TCP source port not as configured")
+}
+
+func testKRBCred(authTime, endTime time.Time) messages.KRBCred {
+	realm := "TEST.GOKRB5"
+	return messages.KRBCred{
+		Tickets: []messages.Ticket{
+			{
+				TktVNO: 5,
+				Realm:  realm,
+				SName:  types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+realm),
+			},
+		},
+		DecryptedEncPart: messages.EncKrbCredPart{
+			TicketInfo: []messages.KrbCredInfo{
+				{
+					Key:       types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)},
+					PRealm:    realm,
+					PName:     types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"),
+					AuthTime:  authTime,
+					StartTime: authTime,
+					EndTime:   endTime,
+					SRealm:    realm,
+					SName:     types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+realm),
+				},
+			},
+		},
+	}
+}
+
+func TestNewFromKRBCred(t *testing.T) {
+	t.Parallel()
+	c := testKDCConfig(t, "127.0.0.1:88")
+	now := time.Now().UTC()
+
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	assert.Equal(t, "testuser1", cl.Credentials.UserName(), "client username not as expected")
+	assert.Equal(t, "TEST.GOKRB5", cl.Credentials.Domain(), "client realm not as expected")
+	assert.Equal(t, now.Add(time.Hour*10), cl.Credentials.ValidUntil(), "credentials valid until not as expected")
+	_, _, err = cl.sessionTGT("TEST.GOKRB5")
+	assert.NoError(t, err, "forwarded TGT should be usable")
+
+	// Once the forwarded TGT expires the client should refuse to use it
+	cl.sessions.Entries["TEST.GOKRB5"].endTime = now.Add(-time.Minute)
+	_, _, err = cl.sessionTGT("TEST.GOKRB5")
+	if assert.Error(t, err, "expired forwarded TGT should not be usable") {
+		assert.Contains(t, err.Error(), "expired", "error not as expected")
+	}
+
+	_, err = NewFromKRBCred(testKRBCred(now.Add(-time.Hour*10), now.Add(-time.Hour)), c)
+	assert.Error(t, err, "creating a client from an expired forwarded TGT should fail")
+
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	kc.DecryptedEncPart = messages.EncKrbCredPart{}
+	_, err = NewFromKRBCred(kc, c)
+	assert.Error(t, err, "creating a client from a KRB_CRED that has not been decrypted should fail")
+}
+
+func TestClient_SendToKDCProxy(t *testing.T) {
+	t.Parallel()
+	var userAgent, contentType, targetDomain string
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		contentType = r.Header.Get("Content-Type")
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var m messages.KDCProxyMessage
+		if err := m.Unmarshal(b); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		targetDomain = m.TargetDomain
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+m.TargetDomain), m.TargetDomain, errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "")
+		eb, _ := krberr.Marshal()
+		rm := messages.NewKDCProxyMessage(eb, "")
+		rb, _ := rm.Marshal()
+		w.Header().Set("Content-Type", "application/kerberos")
+		w.Write(rb)
+	}))
+	defer s.Close()
+	c := testKDCConfig(t, s.URL+"/KdcProxy")
+	realm := c.Realms[0].Realm
+	h := make(http.Header)
+	h.Set("User-Agent", "gokrb5-test")
+	cl := NewWithPassword("testuser1", realm, "passwordvalue", c, KKDCPHTTPClient(s.Client()), KKDCPHeaders(h))
+	_, err := cl.sendToKDC([]byte{0x6a, 0x00}, realm)
+	if err == nil {
+		t.Fatal("expected a KRBError from the KDC proxy")
+	}
+	krberr, ok := err.(messages.KRBError)
+	if !ok {
+		t.Fatalf("error is not a KRBError: %v", err)
+	}
+	assert.Equal(t, errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, krberr.ErrorCode, "error code not as expected")
+	assert.Equal(t, "gokrb5-test", userAgent, "User-Agent header not as expected")
+	assert.Equal(t, "application/kerberos", contentType, "Content-Type header not as expected")
+	assert.Equal(t, realm, targetDomain, "target domain not as expected")
+
+	// Without the custom HTTP client the test server's certificate is not trusted
+	cl = NewWithPassword("testuser1", realm, "passwordvalue", c)
+	_, err = cl.sendToKDC([]byte{0x6a, 0x00}, realm)
+	if assert.Error(t, err, "expected an error when the KDC proxy certificate is not trusted") {
+		_, ok = err.(messages.KRBError)
+		assert.False(t, ok, "error should not be a KRBError")
+	}
+}
+
+func TestClient_SendToKDCProxy_Mixed(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+	addr := testKDC(t, func(req []byte) []byte {
+		krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+	realm := c.Realms[0].Realm
+	c.Realms[0].KDC = []string{s.URL + "/KdcProxy", addr}
+	var resolved int32
+	order := func(realm string, kdcs []string) []string {
+		atomic.AddInt32(&resolved, 1)
+		return kdcs
+	}
+	cl := NewWithPassword("testuser1", realm, "passwordvalue", c, KKDCPHTTPClient(s.Client()), KDCOrdering(order))
+	_, err := cl.sendToKDC([]byte{0x6a, 0x00}, realm)
+	// The KDC reached directly is tried when the KDC proxy fails
+	if krberr, ok := err.(messages.KRBError); assert.True(t, ok, "error is not a KRBError from the KDC: %v", err) {
+		assert.Equal(t, errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, krberr.ErrorCode, "error code not as expected")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&resolved), "KDCs should be resolved once for each send")
+}
+
+func TestClient_Destroy(t *testing.T) {
+	t.Parallel()
+	c := testKDCConfig(t, "127.0.0.1:88")
+	now := time.Now().UTC()
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	kc.DecryptedEncPart.TicketInfo[0].Key.KeyValue = []byte{1, 2, 3, 4}
+	cl, err := NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	tkt := messages.Ticket{Realm: "TEST.GOKRB5", SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "HTTP/host.test.gokrb5")}
+	skey := types.EncryptionKey{KeyType: 18, KeyValue: []byte{5, 6, 7, 8}}
+	cl.cache.addEntry(tkt, now, now, now.Add(time.Hour), now.Add(time.Hour), skey, types.NewKrbFlags())
+	cl.Destroy()
+	assert.Equal(t, []byte{0, 0, 0, 0}, kc.DecryptedEncPart.TicketInfo[0].Key.KeyValue, "TGT session key not zeroized")
+	assert.Equal(t, []byte{0, 0, 0, 0}, skey.KeyValue, "service ticket session key not zeroized")
+	assert.Equal(t, 0, len(cl.cache.Entries), "cache entries not removed")
+}
+
+func TestNewForHost(t *testing.T) {
+	t.Parallel()
+	c, _ := config.NewFromString(testdata.KRB5_CONF)
+	kt := keytab.New()
+	kt.AddEntry("host/myhost.test.gokrb5", "TEST.GOKRB5", "passwordvalue", time.Now(), 1, 18)
+	b, _ := kt.Marshal()
+	path := filepath.Join(t.TempDir(), "krb5.keytab")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("error writing test keytab: %v", err)
+	}
+	c.LibDefaults.DefaultKeytabName = "FILE:" + path
+
+	cl, err := NewForHost("MyHost.test.gokrb5.", "", nil, c)
+	if err != nil {
+		t.Fatalf("error creating client for host: %v", err)
+	}
+	assert.Equal(t, nametype.KRB_NT_SRV_HST, cl.Credentials.CName().NameType, "name type not as expected")
+	assert.Equal(t, "host/myhost.test.gokrb5", cl.Credentials.CName().PrincipalNameString(), "host principal not as expected")
+	assert.Equal(t, "TEST.GOKRB5", cl.Credentials.Realm(), "realm not resolved from the host's domain")
+	assert.True(t, cl.Credentials.HasKeytab(), "machine keytab not loaded")
+	assert.Equal(t, 1, len(cl.Credentials.Keytab().Entries), "machine keytab entries not as expected")
+
+	cl, err = NewForHost("", "", nil, c, Hostname("MyHost.test.gokrb5"))
+	if err != nil {
+		t.Fatalf("error creating client for configured hostname: %v", err)
+	}
+	assert.Equal(t, "host/myhost.test.gokrb5", cl.Credentials.CName().PrincipalNameString(), "host principal not constructed from the configured hostname")
+	assert.Equal(t, "TEST.GOKRB5", cl.Credentials.Realm(), "realm not resolved from the configured hostname's domain")
+
+	c.LibDefaults.DefaultKeytabName = filepath.Join(t.TempDir(), "missing.keytab")
+	_, err = NewForHost("myhost.test.gokrb5", "TEST.GOKRB5", nil, c)
+	assert.Error(t, err, "missing machine keytab should error")
+}
+
+// testOTPHandler responds to each OTP challenge offered by the KDC with an OTP request derived from it.
+type testOTPHandler struct {
+	challenges []string
+}
+
+func (h *testOTPHandler) PADataType() int32 {
+	return patype.PA_OTP_CHALLENGE
+}
+
+func (h *testOTPHandler) PAData(offered types.PAData, ASReq messages.ASReq) ([]types.PAData, error) {
+	h.challenges = append(h.challenges, string(offered.PADataValue))
+	return []types.PAData{{PADataType: patype.PA_OTP_REQUEST, PADataValue: append([]byte("resp:"), offered.PADataValue...)}}, nil
+}
+
+func TestClient_PreAuthHandlers(t *testing.T) {
+	t.Parallel()
+	var mux sync.Mutex
+	var received []messages.ASReq
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		defer mux.Unlock()
+		var ASReq messages.ASReq
+		ASReq.Unmarshal(req)
+		received = append(received, ASReq)
+		var krberr messages.KRBError
+		var pas types.PADataSequence
+		switch len(received) {
+		case 1:
+			krberr = messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "")
+			info, _ := asn1.Marshal(types.ETypeInfo2{{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Salt: "TEST.GOKRB5testuser1"}})
+			pas = types.PADataSequence{
+				{PADataType: patype.PA_ETYPE_INFO2, PADataValue: info},
+				{PADataType: patype.PA_OTP_CHALLENGE, PADataValue: []byte("chal1")},
+			}
+		case 2:
+			krberr = messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_MORE_PREAUTH_DATA_REQUIRED, "")
+			pas = types.PADataSequence{
+				{PADataType: patype.PA_FX_COOKIE, PADataValue: []byte("cookie")},
+				{PADataType: patype.PA_OTP_CHALLENGE, PADataValue: []byte("chal2")},
+			}
+		default:
+			krberr = messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_C_PRINCIPAL_UNKNOWN, "")
+		}
+		if len(pas) > 0 {
+			krberr.EData, _ = asn1.Marshal(pas)
+		}
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+	h := &testOTPHandler{}
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c, PreAuthHandlers(h))
+	err := cl.Login()
+	assert.Error(t, err, "login should fail with the final error from the test KDC")
+
+	assert.Equal(t, []string{"chal1", "chal2"}, h.challenges, "handler not called for each challenge offered")
+	if !assert.Equal(t, 3, len(received), "number of AS_REQs sent not as expected") {
+		t.FailNow()
+	}
+	paValue := func(ASReq messages.ASReq, patype int32) string {
+		for _, pa := range ASReq.PAData {
+			if pa.PADataType == patype {
+				return string(pa.PADataValue)
+			}
+		}
+		return ""
+	}
+	assert.Equal(t, "resp:chal1", paValue(received[1], patype.PA_OTP_REQUEST), "handler PA-DATA not sent")
+	assert.True(t, received[1].PAData.Contains(patype.PA_ENC_TIMESTAMP), "encrypted timestamp not sent")
+	assert.Equal(t, "resp:chal2", paValue(received[2], patype.PA_OTP_REQUEST), "handler PA-DATA not replaced in further round")
+	assert.Equal(t, "cookie", paValue(received[2], patype.PA_FX_COOKIE), "PA-FX-COOKIE not returned to the KDC")
+}
+
+func TestClient_MaxConcurrentKDCRequests(t *testing.T) {
+	t.Parallel()
+	var inFlight, maxInFlight int
+	var mux sync.Mutex
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mux.Unlock()
+		time.Sleep(time.Millisecond * 50)
+		mux.Lock()
+		inFlight--
+		mux.Unlock()
+		return []byte{0}
+	})
+	c := testKDCConfig(t, addr)
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c, MaxConcurrentKDCRequests(2))
+	assert.Equal(t, 2, cl.settings.MaxConcurrentKDCRequests(), "maximum concurrent KDC requests not as expected")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl.sendToKDC([]byte{1, 2, 3}, "TEST.GOKRB5")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, maxInFlight, "number of concurrent requests to the KDC not limited as expected")
+}
+
+func TestClient_Prewarm(t *testing.T) {
+	t.Parallel()
+	var requests int
+	var mux sync.Mutex
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		requests++
+		mux.Unlock()
+		return []byte{0}
+	})
+	c := testKDCConfig(t, addr)
+	now := time.Now().UTC()
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	tkt := messages.Ticket{Realm: "TEST.GOKRB5", SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "HTTP/host.test.gokrb5")}
+	cl.cache.addEntry(tkt, now, now, now.Add(time.Hour), now.Add(time.Hour), types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)}, types.NewKrbFlags())
+
+	err = cl.Prewarm([]string{"HTTP/host.test.gokrb5"})
+	assert.NoError(t, err, "prewarming a cached ticket with a valid TGT should not error")
+	mux.Lock()
+	assert.Equal(t, 0, requests, "no request should be sent to the KDC when the TGT and ticket are already held")
+	mux.Unlock()
+
+	err = cl.Prewarm([]string{"HTTP/host.test.gokrb5", "HTTP/other.test.gokrb5"})
+	if assert.Error(t, err, "prewarming should fail with the invalid KDC response") {
+		assert.Contains(t, err.Error(), "HTTP/other.test.gokrb5", "error should identify the SPN")
+	}
+	mux.Lock()
+	assert.Equal(t, 1, requests, "TGS_REQ for the uncached SPN not sent to the KDC")
+	mux.Unlock()
+}
+
+func TestClient_Login_MustChangePassword(t *testing.T) {
+	t.Parallel()
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")
+	addr := testKDC(t, func(req []byte) []byte {
+		krberr := messages.NewKRBError(sname, "TEST.GOKRB5", errorcode.KDC_ERR_KEY_EXPIRED, "password has expired")
+		b, _ := krberr.Marshal()
+		return b
+	})
+	c := testKDCConfig(t, addr)
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c)
+	assert.Equal(t, ErrMustChangePassword, cl.Login(), "login with an expired password should indicate the password must be changed")
+	assert.Equal(t, ErrMustChangePassword, cl.AffirmLogin(), "affirming login with an expired password should indicate the password must be changed")
+
+	cl = NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c, AssumePreAuthentication(true))
+	assert.Equal(t, ErrMustChangePassword, cl.Login(), "login with pre-authentication and an expired password should indicate the password must be changed")
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/jcmturner/gokrb5/v8/config"
+)
+
+// realmETypes records the encryption type of the session key each realm's KDC issued on the last successful exchange
+// for a TGT, so that subsequent requests to the realm can prefer it.
+type realmETypes struct {
+	entries map[string]int32
+	mux     sync.RWMutex
+}
+
+// set records the encryption type negotiated with the realm's KDC.
+func (r *realmETypes) set(realm string, etype int32) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.entries == nil {
+		r.entries = make(map[string]int32)
+	}
+	r.entries[realm] = etype
+}
+
+// get returns the encryption type last negotiated with the realm's KDC.
+func (r *realmETypes) get(realm string) (int32, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	etype, ok := r.entries[realm]
+	return etype, ok
+}
+
+// NegotiatedEType returns the encryption type of the session key the realm's KDC issued on the last successful
+// exchange for a TGT. Requests to the realm list this encryption type first so that the KDC, if it restricts the
+// encryption types it supports, selects one already known to be accepted.
+func (cl *Client) NegotiatedEType(realm string) (int32, bool) {
+	return cl.etypes.get(realm)
+}
+
+// realmConfig returns the client's configuration for requests to the realm specified. If an encryption type has been
+// negotiated with the realm's KDC the configuration returned is a copy with that encryption type listed first.
+func (cl *Client) realmConfig(realm string) *config.Config {
+	etype, ok := cl.etypes.get(realm)
+	if !ok {
+		return cl.Config
+	}
+	c := *cl.Config
+	c.LibDefaults.DefaultTktEnctypeIDs = preferEType(c.LibDefaults.DefaultTktEnctypeIDs, etype)
+	c.LibDefaults.DefaultTGSEnctypeIDs = preferEType(c.LibDefaults.DefaultTGSEnctypeIDs, etype)
+	return &c
+}
+
+// preferEType returns a copy of the encryption types with the one specified moved to the front.
+// If the encryption type is not in the list the list is returned unchanged so that only configured encryption types are
+// requested.
+func preferEType(etypes []int32, etype int32) []int32 {
+	for i, et := range etypes {
+		if et == etype {
+			p := make([]int32, 0, len(etypes))
+			p = append(p, etype)
+			p = append(p, etypes[:i]...)
+			return append(p, etypes[i+1:]...)
+		}
+	}
+	return etypes
+}
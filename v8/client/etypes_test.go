@@ -0,0 +1,57 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferEType(t *testing.T) {
+	t.Parallel()
+	etypes := []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC}
+	assert.Equal(t, []int32{etypeID.RC4_HMAC, etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96},
+		preferEType(etypes, etypeID.RC4_HMAC), "negotiated etype not moved to the front")
+	assert.Equal(t, []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.RC4_HMAC},
+		etypes, "configured etypes should not be modified")
+	assert.Equal(t, etypes, preferEType(etypes, etypeID.DES3_CBC_SHA1_KD), "etype not configured should not be added")
+}
+
+func TestClient_NegotiatedEType(t *testing.T) {
+	t.Parallel()
+	c := config.New()
+	c.LibDefaults.DefaultTktEnctypeIDs = []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96}
+	c.LibDefaults.DefaultTGSEnctypeIDs = []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96}
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", c)
+
+	_, ok := cl.NegotiatedEType("TEST.GOKRB5")
+	assert.False(t, ok, "no etype should be known before an exchange with the KDC")
+	assert.Equal(t, c, cl.realmConfig("TEST.GOKRB5"), "configuration should be unchanged before an exchange with the KDC")
+
+	now := time.Now().UTC()
+	cl.addSession(messages.Ticket{
+		Realm: "TEST.GOKRB5",
+		SName: types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"),
+	}, messages.EncKDCRepPart{
+		Key:       types.EncryptionKey{KeyType: etypeID.AES128_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 16)},
+		Flags:     types.NewKrbFlags(),
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   now.Add(time.Hour),
+	})
+	defer cl.Destroy()
+	et, ok := cl.NegotiatedEType("TEST.GOKRB5")
+	assert.True(t, ok, "etype negotiated with the KDC not recorded")
+	assert.Equal(t, etypeID.AES128_CTS_HMAC_SHA1_96, et, "negotiated etype not as expected")
+
+	rc := cl.realmConfig("TEST.GOKRB5")
+	assert.Equal(t, []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96}, rc.LibDefaults.DefaultTktEnctypeIDs, "negotiated etype not preferred for AS_REQs")
+	assert.Equal(t, []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96}, rc.LibDefaults.DefaultTGSEnctypeIDs, "negotiated etype not preferred for TGS_REQs")
+	assert.Equal(t, []int32{etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.AES128_CTS_HMAC_SHA1_96}, cl.Config.LibDefaults.DefaultTktEnctypeIDs, "client configuration should not be modified")
+	assert.Equal(t, c, cl.realmConfig("OTHER.GOKRB5"), "configuration for other realms should be unchanged")
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/krberror"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// ErrFASTRequired is returned by an AS exchange when the KDC requires FAST but the client has no FAST armor client
+// configured with the FASTArmorClient setting.
+var ErrFASTRequired = errors.New("KDC requires FAST but no FAST armor client is configured")
+
+// FASTArmor returns an armor, and the armor key derived from it, to protect a FAST exchange with the KDC of the realm
+// specified, as defined in RFC 6113. The armor is an AP_REQ for the client's TGT for the realm.
+//
+// The armor key is cached with the client's TGT session and is reused for each exchange until the TGT is renewed or
+// replaced, so that many FAST protected exchanges do not each require the armor to be established. Only the
+// authenticator of the AP_REQ is formed for each call so that it is within the KDC's clock skew.
+func (cl *Client) FASTArmor(realm string) (messages.KrbFastArmor, types.EncryptionKey, error) {
+	var armor messages.KrbFastArmor
+	err := cl.ensureValidSession(realm)
+	if err != nil {
+		return armor, types.EncryptionKey{}, krberror.Errorf(err, krberror.KRBMsgError, "could not get TGT for the FAST armor")
+	}
+	s, ok := cl.sessions.get(realm)
+	if !ok {
+		return armor, types.EncryptionKey{}, krberror.NewErrorf(krberror.KRBMsgError, "could not find TGT session for %s", realm)
+	}
+	tgt, sessionKey, subkey, armorKey, err := s.fastArmorKey()
+	if err != nil {
+		return armor, types.EncryptionKey{}, err
+	}
+	a, err := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	if err != nil {
+		return armor, types.EncryptionKey{}, krberror.Errorf(err, krberror.KRBMsgError, "error generating new authenticator for the FAST armor")
+	}
+	a.SubKey = subkey
+	ab, err := a.Marshal()
+	if err != nil {
+		return armor, types.EncryptionKey{}, krberror.Errorf(err, krberror.EncodingError, "error marshaling authenticator for the FAST armor")
+	}
+	// The armor is an AP_REQ rather than a TGS_REQ so the AP_REQ authenticator key usage applies.
+	ed, err := crypto.GetEncryptedData(ab, sessionKey, keyusage.AP_REQ_AUTHENTICATOR, tgt.EncPart.KVNO)
+	if err != nil {
+		return armor, types.EncryptionKey{}, krberror.Errorf(err, krberror.EncryptingError, "error encrypting authenticator for the FAST armor")
+	}
+	apReq := messages.APReq{
+		PVNO:                   iana.PVNO,
+		MsgType:                msgtype.KRB_AP_REQ,
+		APOptions:              types.NewKrbFlags(),
+		Ticket:                 tgt,
+		EncryptedAuthenticator: ed,
+	}
+	b, err := apReq.Marshal()
+	if err != nil {
+		return armor, types.EncryptionKey{}, krberror.Errorf(err, krberror.EncodingError, "error marshaling AP_REQ for the FAST armor")
+	}
+	armor = messages.KrbFastArmor{
+		ArmorType:  messages.FX_FAST_ARMOR_AP_REQUEST,
+		ArmorValue: b,
+	}
+	return armor, armorKey, nil
+}
+
+// fastArmorKey returns the session's TGT and session key along with the subkey and armor key for FAST armor formed
+// with them, deriving the armor key if it has not already been.
+func (s *session) fastArmorKey() (tgt messages.Ticket, sessionKey, subkey, armorKey types.EncryptionKey, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	tgt = s.tgt
+	sessionKey = s.sessionKey
+	if len(s.armorKey.KeyValue) > 0 {
+		return tgt, sessionKey, s.armorSubkey, s.armorKey, nil
+	}
+	et, err := crypto.GetEtype(s.sessionKey.KeyType)
+	if err != nil {
+		err = krberror.Errorf(err, krberror.EncryptingError, "error getting etype of TGT session key")
+		return
+	}
+	var a types.Authenticator
+	err = a.GenerateSeqNumberAndSubKey(s.sessionKey.KeyType, et.GetKeyByteSize())
+	if err != nil {
+		err = krberror.Errorf(err, krberror.EncryptingError, "error generating subkey for the FAST armor")
+		return
+	}
+	// RFC 6113 section 5.4.1.1
+	k, err := crypto.KRBFXCF2(a.SubKey, s.sessionKey, "subkeyarmor", "ticketarmor")
+	if err != nil {
+		err = krberror.Errorf(err, krberror.EncryptingError, "error deriving the FAST armor key")
+		return
+	}
+	s.armorSubkey = a.SubKey
+	s.armorKey = k
+	return tgt, sessionKey, s.armorSubkey, s.armorKey, nil
+}
+
+// fastOffered reports whether the KDC offered PA-FX-FAST in the padata of the KRBError, and whether FAST is required.
+// FAST is taken to be required when no pre-authentication mechanism the client can perform without FAST is offered
+// alongside it.
+func (cl *Client) fastOffered(krberr *messages.KRBError) (offered, required bool) {
+	var pas types.PADataSequence
+	if len(krberr.EData) < 1 || pas.Unmarshal(krberr.EData) != nil || !pas.Contains(patype.PA_FX_FAST) {
+		return false, false
+	}
+	if pas.Contains(patype.PA_ENC_TIMESTAMP) {
+		return true, false
+	}
+	for _, h := range cl.settings.PreAuthHandlers() {
+		if pas.Contains(h.PADataType()) {
+			return true, false
+		}
+	}
+	return true, true
+}
+
+// fastASExchange performs the AS exchange armored with FAST, as defined in RFC 6113, in response to the KDC offering
+// FAST in the KRBError. The armor is provided by the client's FAST armor client and the client pre-authenticates with
+// an encrypted challenge.
+func (cl *Client) fastASExchange(realm string, ASReq messages.ASReq, krberr messages.KRBError) (messages.ASRep, error) {
+	cl.Log("KDC for %s offered FAST, armoring the AS exchange", realm)
+	for round := 0; ; round++ {
+		armor, armorKey, err := cl.settings.FASTArmorClient().FASTArmor(realm)
+		if err != nil {
+			return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "AS Exchange Error: could not get FAST armor")
+		}
+		req, key, err := cl.fastASReq(ASReq, krberr, armor, armorKey)
+		if err != nil {
+			return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "AS Exchange Error: failed creating FAST armored AS_REQ")
+		}
+		b, err := req.Marshal()
+		if err != nil {
+			return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed marshaling FAST armored AS_REQ")
+		}
+		rb, err := cl.sendToKDC(b, realm)
+		if err != nil {
+			e, ok := err.(messages.KRBError)
+			if !ok {
+				return messages.ASRep{}, krberror.Errorf(err, krberror.NetworkingError, "AS Exchange Error: failed sending AS_REQ to KDC")
+			}
+			// The KDC returns the actual error encrypted within the armored response
+			if e.IsFASTArmored() {
+				e, err = e.DecryptFASTError(armorKey)
+				if err != nil {
+					return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "AS Exchange Error: failed processing FAST armored error from KDC")
+				}
+			}
+			switch e.ErrorCode {
+			case errorcode.KDC_ERR_PREAUTH_REQUIRED, errorcode.KDC_ERR_MORE_PREAUTH_DATA_REQUIRED:
+				if round < maxPreAuthRounds {
+					krberr = e
+					continue
+				}
+			case errorcode.KDC_ERR_KEY_EXPIRED:
+				return messages.ASRep{}, ErrMustChangePassword
+			}
+			return messages.ASRep{}, krberror.Errorf(e, krberror.KDCError, "AS Exchange Error: kerberos error response from KDC")
+		}
+		return cl.fastASRep(rb, req, key, armorKey)
+	}
+}
+
+// fastASReq returns the AS_REQ armored with FAST and the client's key used for its encrypted challenge, which is the
+// key the AS_REP is to be encrypted with before any strengthening by the KDC.
+func (cl *Client) fastASReq(ASReq messages.ASReq, krberr messages.KRBError, armor messages.KrbFastArmor, armorKey types.EncryptionKey) (messages.ASReq, types.EncryptionKey, error) {
+	et, err := preAuthEType(&krberr)
+	if err != nil {
+		return ASReq, types.EncryptionKey{}, krberror.Errorf(err, krberror.EncryptingError, "error getting etype for pre-auth encryption")
+	}
+	key, _, err := cl.Key(et, 0, &krberr)
+	if err != nil {
+		return ASReq, key, krberror.Errorf(err, krberror.EncryptingError, "error getting key from credentials")
+	}
+	// RFC 6113 section 5.4.6
+	ck, err := crypto.KRBFXCF2(armorKey, key, "clientchallengearmor", "challengelongterm")
+	if err != nil {
+		return ASReq, key, krberror.Errorf(err, krberror.EncryptingError, "error deriving the encrypted challenge key")
+	}
+	tsb, err := types.GetPAEncTSEncAsnMarshalled()
+	if err != nil {
+		return ASReq, key, krberror.Errorf(err, krberror.KRBMsgError, "error creating PAEncTSEnc for the encrypted challenge")
+	}
+	ed, err := crypto.GetEncryptedData(tsb, ck, keyusage.KEY_USAGE_ENC_CHALLENGE_CLIENT, 0)
+	if err != nil {
+		return ASReq, key, krberror.Errorf(err, krberror.EncryptingError, "error encrypting the encrypted challenge")
+	}
+	edb, err := ed.Marshal()
+	if err != nil {
+		return ASReq, key, krberror.Errorf(err, krberror.EncodingError, "error marshaling the encrypted challenge")
+	}
+	fastReq := messages.KrbFastReq{
+		FastOptions: types.NewKrbFlags(),
+		PAData:      types.PADataSequence{{PADataType: patype.PA_ENCRYPTED_CHALLENGE, PADataValue: edb}},
+		ReqBody:     ASReq.ReqBody,
+	}
+	// Any PA-FX-COOKIE sent by the KDC is returned to it so the KDC can resume its state
+	var pas types.PADataSequence
+	if len(krberr.EData) > 0 && pas.Unmarshal(krberr.EData) == nil {
+		for _, pa := range pas {
+			if pa.PADataType == patype.PA_FX_COOKIE {
+				fastReq.PAData = append(fastReq.PAData, pa)
+			}
+		}
+	}
+	ar, err := messages.NewKrbFastArmoredReq(armor, armorKey, fastReq, ASReq.ReqBody)
+	if err != nil {
+		return ASReq, key, err
+	}
+	ab, err := ar.Marshal()
+	if err != nil {
+		return ASReq, key, err
+	}
+	ASReq.PAData = types.PADataSequence{{PADataType: patype.PA_FX_FAST, PADataValue: ab}}
+	return ASReq, key, nil
+}
+
+// fastASRep processes the AS_REP to a FAST armored AS_REQ. The reply key is strengthened with any strengthen key in the
+// FAST response and the ticket is checked against the checksum in the FAST response's finished field. The client name
+// and realm are taken from the finished field and the KDC's encrypted challenge is verified if the KDC returned one.
+func (cl *Client) fastASRep(rb []byte, ASReq messages.ASReq, key, armorKey types.EncryptionKey) (messages.ASRep, error) {
+	var ASRep messages.ASRep
+	err := ASRep.Unmarshal(rb)
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed to process the AS_REP")
+	}
+	var fastRep messages.KrbFastResponse
+	var found bool
+	for _, pa := range ASRep.PAData {
+		if pa.PADataType == patype.PA_FX_FAST {
+			var armored messages.KrbFastArmoredRep
+			if err := armored.Unmarshal(pa.PADataValue); err != nil {
+				return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed to process the FAST response")
+			}
+			fastRep, err = armored.Decrypt(armorKey)
+			if err != nil {
+				return messages.ASRep{}, krberror.Errorf(err, krberror.DecryptingError, "AS Exchange Error: failed to process the FAST response")
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return messages.ASRep{}, krberror.NewErrorf(krberror.KRBMsgError, "AS Exchange Error: KDC did not return a FAST response to the FAST armored AS_REQ")
+	}
+	if fastRep.Nonce != ASReq.ReqBody.Nonce {
+		return messages.ASRep{}, krberror.NewErrorf(krberror.KRBMsgError, "AS Exchange Error: possible replay attack, nonce in FAST response does not match that in request")
+	}
+	et, err := crypto.GetChksumEtype(fastRep.Finished.TicketChecksum.CksumType)
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.ChksumError, "AS Exchange Error: FAST response ticket checksum type not supported")
+	}
+	tb, err := ASRep.Ticket.Marshal()
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.EncodingError, "AS Exchange Error: failed marshaling the AS_REP ticket")
+	}
+	if !et.VerifyChecksum(armorKey.KeyValue, tb, fastRep.Finished.TicketChecksum.Checksum, keyusage.KEY_USAGE_FAST_FINISHED) {
+		return messages.ASRep{}, krberror.NewErrorf(krberror.ChksumError, "AS Exchange Error: FAST response ticket checksum invalid")
+	}
+	// RFC 6113 section 5.4.3 the client name and realm of the outer AS_REP are not protected so those in the finished
+	// field are used in their place
+	ASRep.CName = fastRep.Finished.CName
+	ASRep.CRealm = fastRep.Finished.CRealm
+	// RFC 6113 section 5.4.6
+	err = verifyKDCChallenge(fastRep.PAData, armorKey, key, cl.Config.LibDefaults.Clockskew)
+	if err != nil {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "AS Exchange Error: KDC encrypted challenge not valid")
+	}
+	// RFC 6113 section 5.4.3
+	if len(fastRep.StrengthenKey.KeyValue) > 0 {
+		key, err = crypto.KRBFXCF2(fastRep.StrengthenKey, key, "strengthenkey", "replykey")
+		if err != nil {
+			return messages.ASRep{}, krberror.Errorf(err, krberror.EncryptingError, "AS Exchange Error: failed strengthening the reply key")
+		}
+	}
+	if ok, err := ASRep.VerifyWithReplyKey(cl.Config, key, ASReq); !ok {
+		return messages.ASRep{}, krberror.Errorf(err, krberror.KRBMsgError, "AS Exchange Error: AS_REP is not valid or client password/keytab incorrect")
+	}
+	return ASRep, nil
+}
+
+// verifyKDCChallenge verifies the PA-ENCRYPTED-CHALLENGE returned by the KDC in the padata of the FAST response, if
+// present, which authenticates the KDC to the client. The challenge must decrypt with the KDC challenge key derived
+// from the armor key and the client's key and hold a timestamp within the clock skew.
+func verifyKDCChallenge(pas types.PADataSequence, armorKey, key types.EncryptionKey, skew time.Duration) error {
+	for _, pa := range pas {
+		if pa.PADataType != patype.PA_ENCRYPTED_CHALLENGE {
+			continue
+		}
+		var ed types.EncryptedData
+		err := ed.Unmarshal(pa.PADataValue)
+		if err != nil {
+			return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling the KDC encrypted challenge")
+		}
+		ck, err := crypto.KRBFXCF2(armorKey, key, "kdcchallengearmor", "challengelongterm")
+		if err != nil {
+			return krberror.Errorf(err, krberror.EncryptingError, "error deriving the KDC challenge key")
+		}
+		b, err := crypto.DecryptEncPart(ed, ck, keyusage.KEY_USAGE_ENC_CHALLENGE_KDC)
+		if err != nil {
+			return krberror.Errorf(err, krberror.DecryptingError, "error decrypting the KDC encrypted challenge")
+		}
+		var ts types.PAEncTSEnc
+		err = ts.Unmarshal(b)
+		if err != nil {
+			return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling the KDC encrypted challenge timestamp")
+		}
+		if d := time.Now().UTC().Sub(ts.PATimestamp); d > skew || -d > skew {
+			return krberror.NewErrorf(krberror.KRBMsgError, "KDC encrypted challenge timestamp %v is outside the clock skew", ts.PATimestamp)
+		}
+	}
+	return nil
+}
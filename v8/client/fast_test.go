@@ -0,0 +1,306 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_FASTArmor(t *testing.T) {
+	t.Parallel()
+	c := testKDCConfig(t, "127.0.0.1:88")
+	now := time.Now().UTC()
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	sessionKey := kc.DecryptedEncPart.TicketInfo[0].Key
+	cl, err := NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+
+	armor, armorKey, err := cl.FASTArmor("TEST.GOKRB5")
+	if err != nil {
+		t.Fatalf("error getting FAST armor: %v", err)
+	}
+	assert.Equal(t, messages.FX_FAST_ARMOR_AP_REQUEST, armor.ArmorType, "armor type not as expected")
+	var apReq messages.APReq
+	err = apReq.Unmarshal(armor.ArmorValue)
+	if err != nil {
+		t.Fatalf("error unmarshaling armor AP_REQ: %v", err)
+	}
+	assert.Equal(t, "krbtgt/TEST.GOKRB5", apReq.Ticket.SName.PrincipalNameString(), "armor ticket not the TGT")
+	ab, err := crypto.DecryptEncPart(apReq.EncryptedAuthenticator, sessionKey, keyusage.AP_REQ_AUTHENTICATOR)
+	if err != nil {
+		t.Fatalf("error decrypting armor authenticator: %v", err)
+	}
+	var a types.Authenticator
+	err = a.Unmarshal(ab)
+	if err != nil {
+		t.Fatalf("error unmarshaling armor authenticator: %v", err)
+	}
+	k, err := crypto.KRBFXCF2(a.SubKey, sessionKey, "subkeyarmor", "ticketarmor")
+	if err != nil {
+		t.Fatalf("error deriving armor key: %v", err)
+	}
+	assert.Equal(t, k, armorKey, "armor key not derived from the authenticator subkey and TGT session key")
+
+	// The armor key is reused
+	armor2, armorKey2, err := cl.FASTArmor("TEST.GOKRB5")
+	if err != nil {
+		t.Fatalf("error getting FAST armor: %v", err)
+	}
+	assert.Equal(t, armorKey, armorKey2, "armor key should be reused")
+	assert.NotEqual(t, armor.ArmorValue, armor2.ArmorValue, "armor authenticator should be formed for each exchange")
+
+	// A new TGT requires a new armor key
+	s, _ := cl.sessions.get("TEST.GOKRB5")
+	s.update(kc.Tickets[0], messages.EncKDCRepPart{Key: sessionKey, AuthTime: now, EndTime: now.Add(time.Hour)})
+	_, armorKey3, err := cl.FASTArmor("TEST.GOKRB5")
+	if err != nil {
+		t.Fatalf("error getting FAST armor: %v", err)
+	}
+	assert.NotEqual(t, armorKey.KeyValue, armorKey3.KeyValue, "armor key should be derived again for a new TGT")
+
+	cl.Destroy()
+	assert.Equal(t, make([]byte, len(armorKey3.KeyValue)), armorKey3.KeyValue, "armor key not zeroized")
+}
+
+func TestClient_Login_FASTRequired(t *testing.T) {
+	t.Parallel()
+	info, _ := asn1.Marshal(types.ETypeInfo2{{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Salt: "TEST.GOKRB5testuser1"}})
+	var tests = []struct {
+		offered types.PADataSequence
+		fast    bool
+	}{
+		{types.PADataSequence{{PADataType: patype.PA_FX_FAST}, {PADataType: patype.PA_ETYPE_INFO2, PADataValue: info}}, true},
+		{types.PADataSequence{{PADataType: patype.PA_FX_FAST}, {PADataType: patype.PA_ENC_TIMESTAMP}, {PADataType: patype.PA_ETYPE_INFO2, PADataValue: info}}, false},
+	}
+	for i, test := range tests {
+		var mux sync.Mutex
+		var received []messages.ASReq
+		addr := testKDC(t, func(req []byte) []byte {
+			mux.Lock()
+			defer mux.Unlock()
+			var ASReq messages.ASReq
+			ASReq.Unmarshal(req)
+			received = append(received, ASReq)
+			krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "")
+			krberr.EData, _ = asn1.Marshal(test.offered)
+			b, _ := krberr.Marshal()
+			return b
+		})
+		cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", testKDCConfig(t, addr))
+		err := cl.Login()
+		if test.fast {
+			assert.True(t, errors.Is(err, ErrFASTRequired), "test %d: expected the FAST required error: %v", i, err)
+			assert.Len(t, received, 1, "test %d: plain pre-authentication should not be attempted", i)
+			continue
+		}
+		assert.Error(t, err, "test %d: expected an error from the KDC", i)
+		if assert.Len(t, received, 2, "test %d: plain pre-authentication should be attempted", i) {
+			assert.True(t, received[1].PAData.Contains(patype.PA_ENC_TIMESTAMP), "test %d: encrypted timestamp not sent", i)
+		}
+	}
+}
+
+func TestClient_Login_FAST(t *testing.T) {
+	t.Parallel()
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	sessionKey.KeyValue[0] = 2
+	cl, err := testFASTLogin(t, sessionKey, nil)
+	if !assert.NoError(t, err, "error logging in with FAST") {
+		return
+	}
+	s, ok := cl.sessions.get("TEST.GOKRB5")
+	if assert.True(t, ok, "TGT session not added") {
+		_, _, k := s.tgtDetails()
+		assert.Equal(t, sessionKey, k, "TGT session key not from the AS_REP decrypted with the strengthened reply key")
+	}
+}
+
+func TestClient_Login_FAST_Response(t *testing.T) {
+	t.Parallel()
+	sessionKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	// The outer AS_REP names the client requested but the authenticated name in the finished field does not
+	_, err := testFASTLogin(t, sessionKey, func(fastRep *messages.KrbFastResponse) {
+		fastRep.Finished.CName = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser2")
+	})
+	if assert.Error(t, err, "AS_REP should be checked against the client name in the FAST finished field") {
+		assert.Contains(t, err.Error(), "CName in response does not match", "error not as expected")
+	}
+
+	// A KDC encrypted challenge that does not decrypt with the KDC challenge key
+	_, err = testFASTLogin(t, sessionKey, func(fastRep *messages.KrbFastResponse) {
+		tsb, _ := types.GetPAEncTSEncAsnMarshalled()
+		ed, _ := crypto.GetEncryptedData(tsb, sessionKey, keyusage.KEY_USAGE_ENC_CHALLENGE_KDC, 0)
+		edb, _ := ed.Marshal()
+		fastRep.PAData = types.PADataSequence{{PADataType: patype.PA_ENCRYPTED_CHALLENGE, PADataValue: edb}}
+	})
+	if assert.Error(t, err, "KDC encrypted challenge that is not valid should be rejected") {
+		assert.Contains(t, err.Error(), "KDC encrypted challenge not valid", "error not as expected")
+	}
+}
+
+// testFASTLogin logs in a client with FAST to a test KDC that returns a TGT with the session key provided. The FAST
+// response of the KDC is modified with the function provided, if not nil.
+func testFASTLogin(t *testing.T, sessionKey types.EncryptionKey, modify func(*messages.KrbFastResponse)) (*Client, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	kc := testKRBCred(now.Add(-time.Hour), now.Add(time.Hour*10))
+	tgtSessionKey := kc.DecryptedEncPart.TicketInfo[0].Key
+	armorCl, err := NewFromKRBCred(kc, testKDCConfig(t, "127.0.0.1:88"))
+	if err != nil {
+		t.Fatalf("error creating armor client from KRB_CRED: %v", err)
+	}
+	info, _ := asn1.Marshal(types.ETypeInfo2{{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Salt: "TEST.GOKRB5testuser1"}})
+	offered := types.PADataSequence{{PADataType: patype.PA_FX_FAST}, {PADataType: patype.PA_ETYPE_INFO2, PADataValue: info}}
+	clientKey, _, err := crypto.GetKeyFromPassword("passwordvalue", types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), "TEST.GOKRB5", etypeID.AES256_CTS_HMAC_SHA1_96, offered)
+	if err != nil {
+		t.Fatalf("error getting client key: %v", err)
+	}
+	strengthenKey := types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+	strengthenKey.KeyValue[0] = 1
+
+	var mux sync.Mutex
+	var kdcErr error
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		defer mux.Unlock()
+		var ASReq messages.ASReq
+		ASReq.Unmarshal(req)
+		if !ASReq.PAData.Contains(patype.PA_FX_FAST) {
+			krberr := messages.NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "")
+			krberr.EData, _ = asn1.Marshal(offered)
+			b, _ := krberr.Marshal()
+			return b
+		}
+		var b []byte
+		b, kdcErr = testFASTASRep(ASReq, tgtSessionKey, clientKey, strengthenKey, sessionKey, now, modify)
+		return b
+	})
+	cl := NewWithPassword("testuser1", "TEST.GOKRB5", "passwordvalue", testKDCConfig(t, addr), FASTArmorClient(armorCl))
+	err = cl.Login()
+	mux.Lock()
+	defer mux.Unlock()
+	if kdcErr != nil {
+		t.Fatalf("test KDC could not process the FAST armored AS_REQ: %v", kdcErr)
+	}
+	return cl, err
+}
+
+// testFASTASRep processes a FAST armored AS_REQ as a KDC would, checking its armor, checksum and encrypted challenge,
+// and returns an AS_REP with the reply key strengthened and the KDC's encrypted challenge. The FAST response is
+// modified with the function provided, if not nil, before it is encrypted.
+func testFASTASRep(ASReq messages.ASReq, tgtSessionKey, clientKey, strengthenKey, sessionKey types.EncryptionKey, now time.Time, modify func(*messages.KrbFastResponse)) ([]byte, error) {
+	var armored messages.KrbFastArmoredReq
+	for _, pa := range ASReq.PAData {
+		if pa.PADataType == patype.PA_FX_FAST {
+			if err := armored.Unmarshal(pa.PADataValue); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var apReq messages.APReq
+	if err := apReq.Unmarshal(armored.Armor.ArmorValue); err != nil {
+		return nil, err
+	}
+	ab, err := crypto.DecryptEncPart(apReq.EncryptedAuthenticator, tgtSessionKey, keyusage.AP_REQ_AUTHENTICATOR)
+	if err != nil {
+		return nil, err
+	}
+	var a types.Authenticator
+	if err := a.Unmarshal(ab); err != nil {
+		return nil, err
+	}
+	armorKey, err := crypto.KRBFXCF2(a.SubKey, tgtSessionKey, "subkeyarmor", "ticketarmor")
+	if err != nil {
+		return nil, err
+	}
+	et, err := crypto.GetEtype(armorKey.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	bb, _ := ASReq.ReqBody.Marshal()
+	if !et.VerifyChecksum(armorKey.KeyValue, bb, armored.ReqChecksum.Checksum, keyusage.KEY_USAGE_FAST_REQ_CHKSUM) {
+		return nil, errors.New("FAST request checksum invalid")
+	}
+	fastReq, err := armored.Decrypt(armorKey)
+	if err != nil {
+		return nil, err
+	}
+	var challenge types.EncryptedData
+	for _, pa := range fastReq.PAData {
+		if pa.PADataType == patype.PA_ENCRYPTED_CHALLENGE {
+			if err := challenge.Unmarshal(pa.PADataValue); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ck, _ := crypto.KRBFXCF2(armorKey, clientKey, "clientchallengearmor", "challengelongterm")
+	if _, err := crypto.DecryptEncPart(challenge, ck, keyusage.KEY_USAGE_ENC_CHALLENGE_CLIENT); err != nil {
+		return nil, err
+	}
+
+	kck, _ := crypto.KRBFXCF2(armorKey, clientKey, "kdcchallengearmor", "challengelongterm")
+	tsb, _ := types.GetPAEncTSEncAsnMarshalled()
+	ked, _ := crypto.GetEncryptedData(tsb, kck, keyusage.KEY_USAGE_ENC_CHALLENGE_KDC, 0)
+	kedb, _ := ked.Marshal()
+
+	tkt := messages.Ticket{TktVNO: iana.PVNO, Realm: fastReq.ReqBody.Realm, SName: fastReq.ReqBody.SName}
+	tb, _ := tkt.Marshal()
+	cs, _ := et.GetChecksumHash(armorKey.KeyValue, tb, keyusage.KEY_USAGE_FAST_FINISHED)
+	fastRep := messages.KrbFastResponse{
+		PAData:        types.PADataSequence{{PADataType: patype.PA_ENCRYPTED_CHALLENGE, PADataValue: kedb}},
+		StrengthenKey: strengthenKey,
+		Finished: messages.KrbFastFinished{
+			Timestamp:      now,
+			CRealm:         fastReq.ReqBody.Realm,
+			CName:          fastReq.ReqBody.CName,
+			TicketChecksum: types.Checksum{CksumType: et.GetHashID(), Checksum: cs},
+		},
+		Nonce: fastReq.ReqBody.Nonce,
+	}
+	if modify != nil {
+		modify(&fastRep)
+	}
+	fb, _ := fastRep.Marshal()
+	fed, _ := crypto.GetEncryptedData(fb, armorKey, keyusage.KEY_USAGE_FAST_REP, 0)
+	armoredRep := messages.KrbFastArmoredRep{EncFastRep: fed}
+	rb, _ := armoredRep.Marshal()
+
+	replyKey, _ := crypto.KRBFXCF2(strengthenKey, clientKey, "strengthenkey", "replykey")
+	dep := messages.EncKDCRepPart{
+		Key:      sessionKey,
+		Nonce:    fastReq.ReqBody.Nonce,
+		Flags:    types.NewKrbFlags(),
+		AuthTime: now,
+		EndTime:  now.Add(time.Hour),
+		SRealm:   fastReq.ReqBody.Realm,
+		SName:    fastReq.ReqBody.SName,
+		CAddr:    fastReq.ReqBody.Addresses,
+	}
+	db, _ := dep.Marshal()
+	ed, _ := crypto.GetEncryptedData(db, replyKey, keyusage.AS_REP_ENCPART, 0)
+	ASRep := messages.ASRep{KDCRepFields: messages.KDCRepFields{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AS_REP,
+		PAData:  types.PADataSequence{{PADataType: patype.PA_FX_FAST, PADataValue: rb}},
+		CRealm:  fastReq.ReqBody.Realm,
+		CName:   fastReq.ReqBody.CName,
+		Ticket:  tkt,
+		EncPart: ed,
+	}}
+	return ASRep.Marshal()
+}
@@ -1,11 +1,15 @@
 package client
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
@@ -14,10 +18,37 @@ import (
 
 // SendToKDC performs network actions to send data to the KDC.
 func (cl *Client) sendToKDC(b []byte, realm string) ([]byte, error) {
+	if sem := cl.settings.kdcSemaphore; sem != nil {
+		// Limit the number of requests in flight to the KDCs
+		sem <- struct{}{}
+		defer func() { <-sem }()
+	}
+	// The KDCs, which may require a DNS SRV lookup, are resolved once for the transport tried first
+	tcp := cl.Config.LibDefaults.UDPPreferenceLimit == 1 || len(b) > cl.Config.LibDefaults.UDPPreferenceLimit
+	ks, err := cl.kdcs(realm, tcp)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve the KDCs of %s: %v", realm, err)
+	}
+	proxies, kdcs := splitKDCProxies(ks)
 	var rb []byte
+	if len(proxies) > 0 {
+		// KDC proxy URLs are configured so the KDC is reached via HTTPS
+		rb, err := cl.sendKDCKKDCP(realm, proxies, b)
+		if err == nil {
+			return rb, nil
+		}
+		if e, ok := err.(messages.KRBError); ok {
+			return rb, e
+		}
+		if len(kdcs) < 1 {
+			return rb, fmt.Errorf("communication error with KDC via KDC proxy: %v", err)
+		}
+		// Fall back to the KDCs configured alongside the proxies
+		cl.Log("communication error with KDC via KDC proxy, trying the KDCs directly: %v", err)
+	}
 	if cl.Config.LibDefaults.UDPPreferenceLimit == 1 {
 		//1 means we should always use TCP
-		rb, errtcp := cl.sendKDCTCP(realm, b)
+		rb, errtcp := cl.sendKDCTCP(kdcs, b)
 		if errtcp != nil {
 			if e, ok := errtcp.(messages.KRBError); ok {
 				return rb, e
@@ -28,7 +59,7 @@ func (cl *Client) sendToKDC(b []byte, realm string) ([]byte, error) {
 	}
 	if len(b) <= cl.Config.LibDefaults.UDPPreferenceLimit {
 		//Try UDP first, TCP second
-		rb, errudp := cl.sendKDCUDP(realm, b)
+		rb, errudp := cl.sendKDCUDP(kdcs, b)
 		if errudp != nil {
 			if e, ok := errudp.(messages.KRBError); ok && e.ErrorCode != errorcode.KRB_ERR_RESPONSE_TOO_BIG {
 				// Got a KRBError from KDC
@@ -36,7 +67,7 @@ func (cl *Client) sendToKDC(b []byte, realm string) ([]byte, error) {
 				return rb, e
 			}
 			// Try TCP
-			r, errtcp := cl.sendKDCTCP(realm, b)
+			r, errtcp := cl.sendKDCTCP(kdcs, b)
 			if errtcp != nil {
 				if e, ok := errtcp.(messages.KRBError); ok {
 					// Got a KRBError
@@ -49,13 +80,13 @@ func (cl *Client) sendToKDC(b []byte, realm string) ([]byte, error) {
 		return rb, nil
 	}
 	//Try TCP first, UDP second
-	rb, errtcp := cl.sendKDCTCP(realm, b)
+	rb, errtcp := cl.sendKDCTCP(kdcs, b)
 	if errtcp != nil {
 		if e, ok := errtcp.(messages.KRBError); ok {
 			// Got a KRBError from KDC so returning and not trying UDP.
 			return rb, e
 		}
-		rb, errudp := cl.sendKDCUDP(realm, b)
+		rb, errudp := cl.sendKDCUDP(kdcs, b)
 		if errudp != nil {
 			if e, ok := errudp.(messages.KRBError); ok {
 				// Got a KRBError
@@ -67,25 +98,146 @@ func (cl *Client) sendToKDC(b []byte, realm string) ([]byte, error) {
 	return rb, nil
 }
 
-// sendKDCUDP sends bytes to the KDC via UDP.
-func (cl *Client) sendKDCUDP(realm string, b []byte) ([]byte, error) {
-	var r []byte
-	_, kdcs, err := cl.Config.GetKDCs(realm, false)
+// kdcs returns the KDCs of the realm keyed on the order they should be tried, applying the client's KDC ordering and
+// preferred KDC settings to the order from the configuration.
+func (cl *Client) kdcs(realm string, tcp bool) (map[int]string, error) {
+	_, kdcs, err := cl.Config.GetKDCs(realm, tcp)
 	if err != nil {
-		return r, err
+		return kdcs, err
+	}
+	if cl.settings.KDCOrdering() == nil && cl.settings.PreferredKDC() == "" {
+		return kdcs, nil
+	}
+	ks := make([]string, 0, len(kdcs))
+	for i := 1; i <= len(kdcs); i++ {
+		ks = append(ks, kdcs[i])
+	}
+	if f := cl.settings.KDCOrdering(); f != nil {
+		ks = f(realm, ks)
+	}
+	if p := cl.settings.PreferredKDC(); p != "" {
+		if _, _, err := net.SplitHostPort(p); err != nil {
+			p = p + ":88"
+		}
+		for i, k := range ks {
+			if k == p {
+				ks = append([]string{p}, append(ks[:i:i], ks[i+1:]...)...)
+				break
+			}
+		}
+	}
+	kdcs = make(map[int]string)
+	for i, k := range ks {
+		kdcs[i+1] = k
+	}
+	return kdcs, nil
+}
+
+// splitKDCProxies splits the KDCs into those that are MS-KKDCP proxy URLs and those that are reached directly, each
+// keyed on the order they should be tried.
+func splitKDCProxies(kdcs map[int]string) (proxies, direct map[int]string) {
+	proxies = make(map[int]string)
+	direct = make(map[int]string)
+	for i := 1; i <= len(kdcs); i++ {
+		if strings.HasPrefix(strings.ToLower(kdcs[i]), "https://") {
+			proxies[len(proxies)+1] = kdcs[i]
+		} else {
+			direct[len(direct)+1] = kdcs[i]
+		}
+	}
+	return proxies, direct
+}
+
+// sendKDCKKDCP sends bytes to the KDC via a MS-KKDCP proxy over HTTPS.
+func (cl *Client) sendKDCKKDCP(realm string, proxies map[int]string, b []byte) ([]byte, error) {
+	m := messages.NewKDCProxyMessage(b, realm)
+	mb, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for i := 1; i <= len(proxies); i++ {
+		rb, err := cl.postKKDCP(proxies[i], mb)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error sending to %s: %v", proxies[i], err))
+			continue
+		}
+		return checkForKRBError(rb)
+	}
+	return nil, fmt.Errorf("error sending to a KDC proxy: %s", strings.Join(errs, "; "))
+}
+
+// postKKDCP posts the KDC-PROXY-MESSAGE bytes to the KDC proxy URL and returns the Kerberos message from the reply.
+func (cl *Client) postKKDCP(url string, b []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range cl.settings.KKDCPHeaders() {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/kerberos")
+	resp, err := cl.settings.KKDCPHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("KDC proxy responded with status %s", resp.Status)
+	}
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading KDC proxy response: %v", err)
+	}
+	var m messages.KDCProxyMessage
+	err = m.Unmarshal(rb)
+	if err != nil {
+		return nil, err
 	}
-	r, err = dialSendUDP(kdcs, b)
+	return m.Message()
+}
+
+// sendKDCUDP sends bytes to the KDC via UDP.
+func (cl *Client) sendKDCUDP(kdcs map[int]string, b []byte) ([]byte, error) {
+	r, err := cl.dialSendUDP(kdcs, b)
 	if err != nil {
 		return r, err
 	}
 	return checkForKRBError(r)
 }
 
+// dialKDC establishes a connection to the KDC address. If the client is configured with KDC source ports the first
+// available port in the range is bound to as the local port.
+func (cl *Client) dialKDC(network, address string) (net.Conn, error) {
+	min, max := cl.settings.KDCSourcePorts()
+	if min < 1 {
+		return net.DialTimeout(network, address, 5*time.Second)
+	}
+	var err error
+	for p := min; p <= max; p++ {
+		d := net.Dialer{Timeout: 5 * time.Second}
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{Port: p}
+		} else {
+			d.LocalAddr = &net.TCPAddr{Port: p}
+		}
+		var conn net.Conn
+		conn, err = d.Dial(network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no local port available in the KDC source port range %d-%d: %v", min, max, err)
+}
+
 // dialSendUDP establishes a UDP connection to a KDC.
-func dialSendUDP(kdcs map[int]string, b []byte) ([]byte, error) {
+func (cl *Client) dialSendUDP(kdcs map[int]string, b []byte) ([]byte, error) {
 	var errs []string
 	for i := 1; i <= len(kdcs); i++ {
-		conn, err := net.DialTimeout("udp", kdcs[i], 5*time.Second)
+		conn, err := cl.dialKDC("udp", kdcs[i])
 		if err != nil {
 			errs = append(errs, fmt.Sprintf("error establishing connection to %s: %v", kdcs[i], err))
 			continue
@@ -126,24 +278,19 @@ func sendUDP(conn *net.UDPConn, b []byte) ([]byte, error) {
 }
 
 // sendKDCTCP sends bytes to the KDC via TCP.
-func (cl *Client) sendKDCTCP(realm string, b []byte) ([]byte, error) {
-	var r []byte
-	_, kdcs, err := cl.Config.GetKDCs(realm, true)
-	if err != nil {
-		return r, err
-	}
-	r, err = dialSendTCP(kdcs, b)
+func (cl *Client) sendKDCTCP(kdcs map[int]string, b []byte) ([]byte, error) {
+	r, err := cl.dialSendTCP(kdcs, b)
 	if err != nil {
 		return r, err
 	}
 	return checkForKRBError(r)
 }
 
-// dialKDCTCP establishes a TCP connection to a KDC.
-func dialSendTCP(kdcs map[int]string, b []byte) ([]byte, error) {
+// dialSendTCP establishes a TCP connection to a KDC.
+func (cl *Client) dialSendTCP(kdcs map[int]string, b []byte) ([]byte, error) {
 	var errs []string
 	for i := 1; i <= len(kdcs); i++ {
-		conn, err := net.DialTimeout("tcp", kdcs[i], 5*time.Second)
+		conn, err := cl.dialKDC("tcp", kdcs[i])
 		if err != nil {
 			errs = append(errs, fmt.Sprintf("error establishing connection to %s: %v", kdcs[i], err))
 			continue
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/jcmturner/gokrb5/v8/kadmin"
@@ -19,6 +20,11 @@ const (
 	KRB5_KPASSWD_INITIAL_FLAG_NEEDED = 7
 )
 
+// ErrMustChangePassword is returned by Login when the KDC indicates, with KDC_ERR_KEY_EXPIRED, that the client's
+// password has expired and must be changed before a TGT is issued. An interactive client should route the user to
+// change their password, for example with ChangePasswd, rather than failing.
+var ErrMustChangePassword = errors.New("password has expired and must be changed")
+
 // ChangePasswd changes the password of the client to the value provided.
 func (cl *Client) ChangePasswd(newPasswd string) (bool, error) {
 	ASReq, err := messages.NewASReqForChgPasswd(cl.Credentials.Domain(), cl.Config, cl.Credentials.CName())
@@ -60,12 +66,12 @@ func (cl *Client) sendToKPasswd(msg kadmin.Request) (r kadmin.Reply, err error)
 	}
 	var rb []byte
 	if len(b) <= cl.Config.LibDefaults.UDPPreferenceLimit {
-		rb, err = dialSendUDP(kps, b)
+		rb, err = cl.dialSendUDP(kps, b)
 		if err != nil {
 			return
 		}
 	} else {
-		rb, err = dialSendTCP(kps, b)
+		rb, err = cl.dialSendTCP(kps, b)
 		if err != nil {
 			return
 		}
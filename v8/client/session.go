@@ -2,18 +2,25 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/krberror"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/types"
 )
 
+// ErrRenewTillExceeded is returned by RenewTGT when the TGT cannot be renewed as its renew-till time has been reached.
+// A new TGT must be obtained by logging in again.
+var ErrRenewTillExceeded = errors.New("TGT renew till time exceeded, re-authentication required")
+
 // sessions hold TGTs and are keyed on the realm name
 type sessions struct {
 	Entries map[string]*session
@@ -66,9 +73,12 @@ type session struct {
 	authTime             time.Time
 	endTime              time.Time
 	renewTill            time.Time
+	flags                asn1.BitString
 	tgt                  messages.Ticket
 	sessionKey           types.EncryptionKey
 	sessionKeyExpiration time.Time
+	armorSubkey          types.EncryptionKey
+	armorKey             types.EncryptionKey
 	cancel               chan bool
 	mux                  sync.RWMutex
 }
@@ -94,11 +104,17 @@ func (cl *Client) addSession(tgt messages.Ticket, dep messages.EncKDCRepPart) {
 		realm:                realm,
 		authTime:             dep.AuthTime,
 		endTime:              dep.EndTime,
-		renewTill:            dep.RenewTill,
+		renewTill:            grantedRenewTill(dep),
+		flags:                dep.Flags,
 		tgt:                  tgt,
 		sessionKey:           dep.Key,
 		sessionKeyExpiration: dep.KeyExpiration,
 	}
+	if cl.Config.LibDefaults.RenewLifetime > 0 && s.renewTill.IsZero() {
+		cl.Log("renewable TGT requested for %s but the KDC did not grant one", realm)
+	}
+	// The session key encryption type is that selected by the KDC of the realm that issued the TGT
+	cl.etypes.set(tgt.Realm, dep.Key.KeyType)
 	cl.sessions.update(s)
 	cl.enableAutoSessionRenewal(s)
 	cl.Log("TGT session added for %s (EndTime: %v)", realm, dep.EndTime)
@@ -110,10 +126,24 @@ func (s *session) update(tgt messages.Ticket, dep messages.EncKDCRepPart) {
 	defer s.mux.Unlock()
 	s.authTime = dep.AuthTime
 	s.endTime = dep.EndTime
-	s.renewTill = dep.RenewTill
+	s.renewTill = grantedRenewTill(dep)
+	s.flags = dep.Flags
 	s.tgt = tgt
 	s.sessionKey = dep.Key
 	s.sessionKeyExpiration = dep.KeyExpiration
+	// The FAST armor key is derived from the TGT session key so must be derived again
+	s.armorSubkey = types.EncryptionKey{}
+	s.armorKey = types.EncryptionKey{}
+}
+
+// grantedRenewTill returns the renew-till time of the ticket granted by the KDC. The KDC may not grant a renewable
+// ticket even when one is requested, or with RENEWABLE-OK may grant one when it was not, so the renew-till time is
+// only used if the ticket's flags mark it as renewable.
+func grantedRenewTill(dep messages.EncKDCRepPart) time.Time {
+	if len(dep.Flags.Bytes) <= flags.Renewable/8 || !types.IsFlagSet(&dep.Flags, flags.Renewable) {
+		return time.Time{}
+	}
+	return dep.RenewTill
 }
 
 // destroy will cancel any auto renewal of the session and set the expiration times to the current time
@@ -126,6 +156,9 @@ func (s *session) destroy() {
 	s.endTime = time.Now().UTC()
 	s.renewTill = s.endTime
 	s.sessionKeyExpiration = s.endTime
+	s.sessionKey.Zeroize()
+	s.armorSubkey.Zeroize()
+	s.armorKey.Zeroize()
 }
 
 // valid informs if the TGT is still within the valid time window
@@ -153,6 +186,22 @@ func (s *session) timeDetails() (string, time.Time, time.Time, time.Time, time.T
 	return s.realm, s.authTime, s.endTime, s.renewTill, s.sessionKeyExpiration
 }
 
+// ticketInfo is a thread safe way to get the details of the session's TGT. The TGT is valid from the auth time.
+func (s *session) ticketInfo() TicketInfo {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return TicketInfo{
+		ServerName: s.tgt.SName.PrincipalNameString() + "@" + s.tgt.Realm,
+		TGT:        true,
+		Flags:      s.flags,
+		AuthTime:   s.authTime,
+		StartTime:  s.authTime,
+		EndTime:    s.endTime,
+		RenewTill:  s.renewTill,
+		EType:      s.sessionKey.KeyType,
+	}
+}
+
 // JSON return information about the held sessions in a JSON format.
 func (s *sessions) JSON() (string, error) {
 	s.mux.RLock()
@@ -232,6 +281,26 @@ func (cl *Client) renewTGT(s *session) error {
 	return nil
 }
 
+// RenewTGT renews the client's TGT for its realm with a TGS exchange using the RENEW option.
+//
+// If the TGT is not renewable or its renew-till time has been reached then ErrRenewTillExceeded is returned and no
+// request is sent to the KDC. In this case the caller should log in again to obtain a new TGT.
+func (cl *Client) RenewTGT() error {
+	realm := cl.Credentials.Domain()
+	s, ok := cl.sessions.get(realm)
+	if !ok {
+		return fmt.Errorf("could not find TGT session for %s", realm)
+	}
+	s.mux.RLock()
+	renewTill := s.renewTill
+	s.mux.RUnlock()
+	if !time.Now().UTC().Before(renewTill) {
+		cl.Log("TGT session for %s cannot be renewed (RenewTill: %v)", realm, renewTill)
+		return ErrRenewTillExceeded
+	}
+	return cl.renewTGT(s)
+}
+
 // refreshSession updates either through renewal or creating a new login.
 // The boolean indicates if the update was a renewal.
 func (cl *Client) refreshSession(s *session) (bool, error) {
@@ -12,9 +12,12 @@ import (
 
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/test"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -158,3 +161,62 @@ func TestSessions_JSON(t *testing.T) {
 ]`
 	assert.Equal(t, expected, j, "json output not as expected")
 }
+
+func TestClient_RenewTGT(t *testing.T) {
+	t.Parallel()
+	var requests int
+	var mux sync.Mutex
+	addr := testKDC(t, func(req []byte) []byte {
+		mux.Lock()
+		requests++
+		mux.Unlock()
+		return []byte{0}
+	})
+	c := testKDCConfig(t, addr)
+	now := time.Now().UTC()
+
+	// TGT that is not renewable
+	cl, err := NewFromKRBCred(testKRBCred(now.Add(-time.Hour), now.Add(time.Hour)), c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	assert.Equal(t, ErrRenewTillExceeded, cl.RenewTGT(), "renewal of a TGT that is not renewable should require re-authentication")
+
+	// TGT past its renew till time
+	kc := testKRBCred(now.Add(-time.Hour*2), now.Add(time.Hour))
+	kc.DecryptedEncPart.TicketInfo[0].RenewTill = now.Add(-time.Minute)
+	cl, err = NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	assert.Equal(t, ErrRenewTillExceeded, cl.RenewTGT(), "renewal beyond the renew till time should require re-authentication")
+	mux.Lock()
+	assert.Equal(t, 0, requests, "no request should be sent to the KDC when renewal is not possible")
+	mux.Unlock()
+
+	// TGT within its renew till time is sent to the KDC for renewal
+	kc = testKRBCred(now.Add(-time.Hour), now.Add(time.Hour))
+	kc.DecryptedEncPart.TicketInfo[0].RenewTill = now.Add(time.Hour * 24)
+	cl, err = NewFromKRBCred(kc, c)
+	if err != nil {
+		t.Fatalf("error creating client from KRB_CRED: %v", err)
+	}
+	err = cl.RenewTGT()
+	assert.Error(t, err, "renewal should fail with the invalid KDC response")
+	assert.NotEqual(t, ErrRenewTillExceeded, err, "renewal within the renew till time should be attempted")
+	mux.Lock()
+	assert.Equal(t, 1, requests, "renewal request not sent to the KDC")
+	mux.Unlock()
+}
+
+func TestGrantedRenewTill(t *testing.T) {
+	t.Parallel()
+	rt := time.Now().UTC().Add(time.Hour * 24)
+	dep := messages.EncKDCRepPart{
+		Flags:     types.NewKrbFlags(),
+		RenewTill: rt,
+	}
+	assert.True(t, grantedRenewTill(dep).IsZero(), "renew till should be ignored when the ticket is not renewable")
+	types.SetFlag(&dep.Flags, flags.Renewable)
+	assert.Equal(t, rt, grantedRenewTill(dep), "renew till of a renewable ticket not as expected")
+}
@@ -4,6 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
 )
 
 // Settings holds optional client settings.
@@ -12,6 +17,26 @@ type Settings struct {
 	assumePreAuthentication bool
 	preAuthEType            int32
 	logger                  *log.Logger
+	preferredKDC            string
+	kdcOrdering             func(realm string, kdcs []string) []string
+	kkdcpHTTPClient         *http.Client
+	kkdcpHeaders            http.Header
+	preAuthHandlers         []PreAuthHandler
+	fastArmorClient         *Client
+	clientAddresses         []types.HostAddress
+	kdcSemaphore            chan struct{}
+	hostname                string
+	kdcSourcePortMin        int
+	kdcSourcePortMax        int
+}
+
+// PreAuthHandler provides the PA-DATA for an additional pre-authentication mechanism requested by the KDC, such as
+// OTP (RFC 6560) or SPAKE (RFC 8636).
+type PreAuthHandler interface {
+	// PADataType returns the PA-DATA type offered by the KDC that the handler responds to.
+	PADataType() int32
+	// PAData returns the PA-DATA to include in the AS_REQ in response to the PA-DATA offered by the KDC.
+	PAData(offered types.PAData, ASReq messages.ASReq) ([]types.PAData, error)
 }
 
 // jsonSettings is used when marshaling the Settings details to JSON format.
@@ -57,6 +82,178 @@ func (s *Settings) AssumePreAuthentication() bool {
 	return s.assumePreAuthentication
 }
 
+// PreferredKDC used to configure the client to send its KDC exchanges to the KDC host provided first, overriding the
+// order from the configuration. The other KDCs for the realm are still tried if the preferred KDC cannot be reached.
+// The host is only preferred for realms it is a KDC of. If no port is specified the default of 88 is used.
+//
+// s := NewSettings(PreferredKDC("kdc1.example.com:88"))
+func PreferredKDC(host string) func(*Settings) {
+	return func(s *Settings) {
+		s.preferredKDC = host
+	}
+}
+
+// PreferredKDC returns the KDC host the client should try first, if one has been configured.
+func (s *Settings) PreferredKDC() string {
+	return s.preferredKDC
+}
+
+// Hostname used to configure the hostname of the local machine used to construct the host service principal name,
+// overriding the hostname reported by the operating system. This is needed when the local hostname, such as that of a
+// container, is not the fully qualified domain name the KDC and clients know the host by.
+//
+// s := NewSettings(Hostname("host.example.com"))
+func Hostname(h string) func(*Settings) {
+	return func(s *Settings) {
+		s.hostname = h
+	}
+}
+
+// Hostname returns the hostname of the local machine to use, if one has been configured.
+func (s *Settings) Hostname() string {
+	return s.hostname
+}
+
+// KDCOrdering used to configure the client with a function to order the KDCs of a realm before they are tried.
+// The function is passed the realm and the KDC hosts in the order from the configuration, or DNS, and should return
+// the KDC hosts in the order they should be tried. Any PreferredKDC is placed first after the ordering is applied.
+//
+// s := NewSettings(KDCOrdering(f))
+func KDCOrdering(f func(realm string, kdcs []string) []string) func(*Settings) {
+	return func(s *Settings) {
+		s.kdcOrdering = f
+	}
+}
+
+// KDCOrdering returns the function used to order the KDCs of a realm, if one has been configured.
+func (s *Settings) KDCOrdering() func(realm string, kdcs []string) []string {
+	return s.kdcOrdering
+}
+
+// KKDCPHTTPClient used to configure the client with the *http.Client to use when sending to a KDC proxy over HTTPS
+// using MS-KKDCP. This allows the HTTPS transport to be configured, for example with a corporate proxy, a custom CA
+// pool or client certificates for mutual TLS.
+//
+// s := NewSettings(KKDCPHTTPClient(c))
+func KKDCPHTTPClient(c *http.Client) func(*Settings) {
+	return func(s *Settings) {
+		s.kkdcpHTTPClient = c
+	}
+}
+
+// KKDCPHTTPClient returns the *http.Client to use when sending to a KDC proxy.
+func (s *Settings) KKDCPHTTPClient() *http.Client {
+	if s.kkdcpHTTPClient == nil {
+		return &http.Client{Timeout: 5 * time.Second}
+	}
+	return s.kkdcpHTTPClient
+}
+
+// KKDCPHeaders used to configure the client with additional HTTP headers, such as User-Agent, to send with requests
+// to a KDC proxy.
+//
+// s := NewSettings(KKDCPHeaders(h))
+func KKDCPHeaders(h http.Header) func(*Settings) {
+	return func(s *Settings) {
+		s.kkdcpHeaders = h
+	}
+}
+
+// KKDCPHeaders returns the additional HTTP headers to send with requests to a KDC proxy.
+func (s *Settings) KKDCPHeaders() http.Header {
+	return s.kkdcpHeaders
+}
+
+// PreAuthHandlers used to configure the client with handlers for additional pre-authentication PA-DATA types the
+// KDC may request.
+//
+// s := NewSettings(PreAuthHandlers(h))
+func PreAuthHandlers(h ...PreAuthHandler) func(*Settings) {
+	return func(s *Settings) {
+		s.preAuthHandlers = h
+	}
+}
+
+// PreAuthHandlers returns the client's handlers for additional pre-authentication PA-DATA types.
+func (s *Settings) PreAuthHandlers() []PreAuthHandler {
+	return s.preAuthHandlers
+}
+
+// FASTArmorClient used to configure the client with a source of FAST armor, as defined in RFC 6113, for its AS
+// exchanges. The armor client's TGT, typically obtained with a host keytab, armors the AS exchange so that when the
+// KDC offers or requires FAST the client's pre-authentication is protected by it.
+//
+// s := NewSettings(FASTArmorClient(hostClient))
+func FASTArmorClient(armor *Client) func(*Settings) {
+	return func(s *Settings) {
+		s.fastArmorClient = armor
+	}
+}
+
+// FASTArmorClient returns the client that provides the FAST armor for the client's AS exchanges.
+func (s *Settings) FASTArmorClient() *Client {
+	return s.fastArmorClient
+}
+
+// ClientAddresses used to configure the addresses the client requests its tickets to be restricted to, in place of
+// those determined from the krb5.conf noaddresses and extra_addresses settings. Addresses of any type can be provided,
+// for example created with types.NewHostAddress.
+//
+// s := NewSettings(ClientAddresses(ha...))
+func ClientAddresses(ha ...types.HostAddress) func(*Settings) {
+	return func(s *Settings) {
+		s.clientAddresses = ha
+	}
+}
+
+// ClientAddresses returns the addresses the client requests its tickets to be restricted to.
+func (s *Settings) ClientAddresses() []types.HostAddress {
+	return s.clientAddresses
+}
+
+// MaxConcurrentKDCRequests used to configure the maximum number of requests the client will have in flight to KDCs at
+// any one time. Further exchanges wait until an earlier one completes so that a client acquiring many tickets at once,
+// for example on startup, does not overwhelm the KDC. A value less than one means there is no limit, which is the default.
+//
+// s := NewSettings(MaxConcurrentKDCRequests(4))
+func MaxConcurrentKDCRequests(n int) func(*Settings) {
+	return func(s *Settings) {
+		s.kdcSemaphore = nil
+		if n > 0 {
+			s.kdcSemaphore = make(chan struct{}, n)
+		}
+	}
+}
+
+// MaxConcurrentKDCRequests returns the maximum number of requests the client will have in flight to KDCs at any one
+// time. Zero indicates there is no limit.
+func (s *Settings) MaxConcurrentKDCRequests() int {
+	return cap(s.kdcSemaphore)
+}
+
+// KDCSourcePorts used to configure the range of local ports, inclusive, the client binds to when communicating with
+// KDCs and kpasswd servers, for deployments whose firewall rules only permit Kerberos traffic from fixed source ports.
+// The ports are tried in order until one is available. The binding applies to UDP and equally to TCP, including when
+// the client falls back from one to the other. To use a single port pass it as both the minimum and maximum.
+// By default the operating system chooses the local port.
+//
+// s := NewSettings(KDCSourcePorts(60000, 60100))
+func KDCSourcePorts(min, max int) func(*Settings) {
+	return func(s *Settings) {
+		if max < min {
+			max = min
+		}
+		s.kdcSourcePortMin = min
+		s.kdcSourcePortMax = max
+	}
+}
+
+// KDCSourcePorts returns the range of local ports the client binds to when communicating with KDCs. Zero values
+// indicate the operating system chooses the local port.
+func (s *Settings) KDCSourcePorts() (int, int) {
+	return s.kdcSourcePortMin, s.kdcSourcePortMax
+}
+
 // Logger used to configure client with a logger.
 //
 // s := NewSettings(kt, Logger(l))
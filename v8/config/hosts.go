@@ -58,59 +58,67 @@ func (c *Config) GetKDCs(realm string, tcp bool) (int, map[int]string, error) {
 }
 
 // GetKpasswdServers returns the count of kpasswd servers available and a map of kpasswd host names keyed on preference order.
+// The kpasswd_server entries of the realm are used, or if there are none the admin_server entries on port 464. These
+// are distinct from the realm's KDCs, which may not run the password changing service.
+// If neither are configured for the realm DNS SRV records are used if configured to do so in krb5.conf.
 // https://web.mit.edu/kerberos/krb5-latest/doc/admin/conf_files/krb5_conf.html#realms - see kpasswd_server section
 func (c *Config) GetKpasswdServers(realm string, tcp bool) (int, map[int]string, error) {
 	kdcs := make(map[int]string)
 	var count int
 
-	// Use DNS to resolve kerberos SRV records if configured to do so in krb5.conf.
-	if c.LibDefaults.DNSLookupKDC {
-		proto := "udp"
-		if tcp {
-			proto = "tcp"
-		}
-		c, addrs, err := dnsutils.OrderedSRV("kpasswd", proto, realm)
-		if err != nil {
-			return count, kdcs, err
+	// Get the kpasswd servers from the krb5.conf.
+	var ks []string
+	var ka []string
+	for _, r := range c.Realms {
+		if r.Realm == realm {
+			ks = r.KPasswdServer
+			ka = r.AdminServer
+			break
 		}
-		if c < 1 {
-			c, addrs, err = dnsutils.OrderedSRV("kerberos-adm", proto, realm)
+	}
+	if len(ks) < 1 {
+		for _, k := range ka {
+			h, _, err := net.SplitHostPort(k)
 			if err != nil {
-				return count, kdcs, err
-			}
-		}
-		if len(addrs) < 1 {
-			return count, kdcs, fmt.Errorf("no kpasswd or kadmin SRV records found for realm %s", realm)
-		}
-		count = c
-		for k, v := range addrs {
-			kdcs[k] = strings.TrimRight(v.Target, ".") + ":" + strconv.Itoa(int(v.Port))
-		}
-	} else {
-		// Get the KDCs from the krb5.conf an order them randomly for preference.
-		var ks []string
-		var ka []string
-		for _, r := range c.Realms {
-			if r.Realm == realm {
-				ks = r.KPasswdServer
-				ka = r.AdminServer
-				break
-			}
-		}
-		if len(ks) < 1 {
-			for _, k := range ka {
-				h, _, err := net.SplitHostPort(k)
-				if err != nil {
-					continue
-				}
-				ks = append(ks, h+":464")
+				// admin_server specified without a port
+				h = k
 			}
+			ks = append(ks, net.JoinHostPort(h, "464"))
 		}
-		count = len(ks)
-		if count < 1 {
-			return count, kdcs, fmt.Errorf("no kpasswd or kadmin defined in configuration for realm %s", realm)
-		}
+	}
+	count = len(ks)
+
+	if count > 0 {
+		// Order the servers randomly for preference.
 		kdcs = randServOrder(ks)
+		return count, kdcs, nil
+	}
+
+	if !c.LibDefaults.DNSLookupKDC {
+		return count, kdcs, fmt.Errorf("no kpasswd or kadmin defined in configuration for realm %s", realm)
+	}
+
+	// Use DNS to resolve kpasswd SRV records, falling back to kerberos-adm.
+	proto := "udp"
+	if tcp {
+		proto = "tcp"
+	}
+	index, addrs, err := dnsutils.OrderedSRV("kpasswd", proto, realm)
+	if err != nil {
+		return count, kdcs, err
+	}
+	if index < 1 {
+		index, addrs, err = dnsutils.OrderedSRV("kerberos-adm", proto, realm)
+		if err != nil {
+			return count, kdcs, err
+		}
+	}
+	if len(addrs) < 1 {
+		return count, kdcs, fmt.Errorf("no kpasswd or kadmin SRV records found for realm %s", realm)
+	}
+	count = index
+	for k, v := range addrs {
+		kdcs[k] = strings.TrimRight(v.Target, ".") + ":" + strconv.Itoa(int(v.Port))
 	}
 	return count, kdcs, nil
 }
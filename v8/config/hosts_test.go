@@ -40,6 +40,55 @@ func TestConfig_GetKDCsUsesConfiguredKDC(t *testing.T) {
 	}
 }
 
+func TestConfig_GetKpasswdServers(t *testing.T) {
+	t.Parallel()
+
+	// The configured kpasswd and admin servers are used rather than the KDCs or DNS
+	conf := `
+[libdefaults]
+ dns_lookup_kdc = true
+
+[realms]
+ KPASSWD.GOKRB5 = {
+  kdc = kdc.test.gokrb5
+  admin_server = admin.test.gokrb5:749
+  kpasswd_server = kpasswd.test.gokrb5
+  master_kdc = master.test.gokrb5
+ }
+ ADMIN.GOKRB5 = {
+  kdc = kdc.test.gokrb5
+  admin_server = admin.test.gokrb5
+ }
+`
+	c, err := NewFromString(conf)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	assert.Equal(t, []string{"master.test.gokrb5:88"}, c.Realms[0].MasterKDC, "master KDC not as expected")
+
+	count, kps, err := c.GetKpasswdServers("KPASSWD.GOKRB5", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, count, "count of kpasswd servers not as expected")
+	assert.Equal(t, "kpasswd.test.gokrb5:464", kps[1], "kpasswd server not as expected")
+
+	count, kps, err = c.GetKpasswdServers("ADMIN.GOKRB5", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, count, "count of kpasswd servers not as expected")
+	assert.Equal(t, "admin.test.gokrb5:464", kps[1], "kpasswd server on the admin server not as expected")
+
+	// Servers added programmatically without a port are also resolved to the kpasswd port
+	c.Realms[1].KPasswdServer = nil
+	_, kps, err = c.GetKpasswdServers("ADMIN.GOKRB5", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "admin.test.gokrb5:464", kps[1], "kpasswd server on the admin server not as expected")
+}
+
 func TestResolveKDC(t *testing.T) {
 	test.Privileged(t)
 
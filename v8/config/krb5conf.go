@@ -28,6 +28,9 @@ type Config struct {
 	//CaPaths
 	//AppDefaults
 	//Plugins
+	// Ignored lists the sections and keys of the configuration that are not supported and so were skipped when parsing.
+	// Sections are recorded as "[name]" and keys as "[section] key", or "[realms] REALM key" for realm entries.
+	Ignored []string `json:"-"`
 }
 
 // WeakETypeList is a list of encryption types that have been deemed weak.
@@ -122,7 +125,9 @@ func newLibDefaults() LibDefaults {
 }
 
 // Parse the lines of the [libdefaults] section of the configuration into the LibDefaults struct.
-func (l *LibDefaults) parseLines(lines []string) error {
+// The keys that are not supported are returned so that they can be recorded as ignored.
+func (l *LibDefaults) parseLines(lines []string) (ignored []string, err error) {
+	var c int // counts the depth of blocks within brackets { }
 	for _, line := range lines {
 		//Remove comments after the values
 		if idx := strings.IndexAny(line, "#;"); idx != -1 {
@@ -132,36 +137,54 @@ func (l *LibDefaults) parseLines(lines []string) error {
 		if line == "" {
 			continue
 		}
+		// Realm specific relations within libdefaults are in blocks which are not supported
+		if strings.Contains(line, "{") {
+			if c == 0 {
+				ignored = append(ignored, strings.TrimSpace(strings.Split(line, "=")[0]))
+			}
+			c++
+			continue
+		}
+		if strings.Contains(line, "}") {
+			c--
+			if c < 0 {
+				return ignored, InvalidErrorf("libdefaults section has unpaired curly brackets")
+			}
+			continue
+		}
+		if c > 0 {
+			continue
+		}
 		if !strings.Contains(line, "=") {
-			return InvalidErrorf("libdefaults section line (%s)", line)
+			return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 		}
 
-		p := strings.Split(line, "=")
+		p := strings.SplitN(line, "=", 2)
 		key := strings.TrimSpace(strings.ToLower(p[0]))
 		switch key {
 		case "allow_weak_crypto":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.AllowWeakCrypto = v
 		case "canonicalize":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.Canonicalize = v
 		case "ccache_type":
 			p[1] = strings.TrimSpace(p[1])
 			v, err := strconv.ParseUint(p[1], 10, 32)
 			if err != nil || v < 0 || v > 4 {
-				return InvalidErrorf("libdefaults section line (%s)", line)
+				return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 			}
 			l.CCacheType = int(v)
 		case "clockskew":
 			d, err := parseDuration(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.Clockskew = d
 		case "default_client_keytab_name":
@@ -177,19 +200,19 @@ func (l *LibDefaults) parseLines(lines []string) error {
 		case "dns_canonicalize_hostname":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.DNSCanonicalizeHostname = v
 		case "dns_lookup_kdc":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.DNSLookupKDC = v
 		case "dns_lookup_realm":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.DNSLookupRealm = v
 		case "extra_addresses":
@@ -202,19 +225,19 @@ func (l *LibDefaults) parseLines(lines []string) error {
 		case "forwardable":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.Forwardable = v
 		case "ignore_acceptor_hostname":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.IgnoreAcceptorHostname = v
 		case "k5login_authoritative":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.K5LoginAuthoritative = v
 		case "k5login_directory":
@@ -224,7 +247,7 @@ func (l *LibDefaults) parseLines(lines []string) error {
 			v = strings.Replace(v, "0x", "", -1)
 			b, err := hex.DecodeString(v)
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.KDCDefaultOptions.Bytes = b
 			l.KDCDefaultOptions.BitLength = len(b) * 8
@@ -232,13 +255,13 @@ func (l *LibDefaults) parseLines(lines []string) error {
 			p[1] = strings.TrimSpace(p[1])
 			v, err := strconv.ParseInt(p[1], 10, 32)
 			if err != nil || v < 0 {
-				return InvalidErrorf("libdefaults section line (%s)", line)
+				return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 			}
 			l.KDCTimeSync = int(v)
 		case "noaddresses":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.NoAddresses = v
 		case "permitted_enctypes":
@@ -250,7 +273,7 @@ func (l *LibDefaults) parseLines(lines []string) error {
 			for _, s := range t {
 				i, err := strconv.ParseInt(s, 10, 32)
 				if err != nil {
-					return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+					return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 				}
 				v = append(v, int(i))
 			}
@@ -258,60 +281,62 @@ func (l *LibDefaults) parseLines(lines []string) error {
 		case "proxiable":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.Proxiable = v
 		case "rdns":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.RDNS = v
 		case "realm_try_domains":
 			p[1] = strings.TrimSpace(p[1])
 			v, err := strconv.ParseInt(p[1], 10, 32)
 			if err != nil || v < -1 {
-				return InvalidErrorf("libdefaults section line (%s)", line)
+				return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 			}
 			l.RealmTryDomains = int(v)
 		case "renew_lifetime":
 			d, err := parseDuration(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.RenewLifetime = d
 		case "safe_checksum_type":
 			p[1] = strings.TrimSpace(p[1])
 			v, err := strconv.ParseInt(p[1], 10, 32)
 			if err != nil || v < 0 {
-				return InvalidErrorf("libdefaults section line (%s)", line)
+				return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 			}
 			l.SafeChecksumType = int(v)
 		case "ticket_lifetime":
 			d, err := parseDuration(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.TicketLifetime = d
 		case "udp_preference_limit":
 			p[1] = strings.TrimSpace(p[1])
 			v, err := strconv.ParseUint(p[1], 10, 32)
 			if err != nil || v > 32700 {
-				return InvalidErrorf("libdefaults section line (%s)", line)
+				return ignored, InvalidErrorf("libdefaults section line (%s)", line)
 			}
 			l.UDPPreferenceLimit = int(v)
 		case "verify_ap_req_nofail":
 			v, err := parseBoolean(p[1])
 			if err != nil {
-				return InvalidErrorf("libdefaults section line (%s): %v", line, err)
+				return ignored, InvalidErrorf("libdefaults section line (%s): %v", line, err)
 			}
 			l.VerifyAPReqNofail = v
+		default:
+			ignored = append(ignored, key)
 		}
 	}
 	l.DefaultTGSEnctypeIDs = parseETypes(l.DefaultTGSEnctypes, l.AllowWeakCrypto)
 	l.DefaultTktEnctypeIDs = parseETypes(l.DefaultTktEnctypes, l.AllowWeakCrypto)
 	l.PermittedEnctypeIDs = parseETypes(l.PermittedEnctypes, l.AllowWeakCrypto)
-	return nil
+	return ignored, nil
 }
 
 // Realm represents an entry in the [realms] section of the configuration.
@@ -327,7 +352,8 @@ type Realm struct {
 }
 
 // Parse the lines of a [realms] entry into the Realm struct.
-func (r *Realm) parseLines(name string, lines []string) (err error) {
+// The keys that are not supported are returned so that they can be recorded as ignored.
+func (r *Realm) parseLines(name string, lines []string) (ignored []string, err error) {
 	r.Realm = name
 	var adminServerFinal bool
 	var KDCFinal bool
@@ -348,22 +374,25 @@ func (r *Realm) parseLines(name string, lines []string) (err error) {
 			continue
 		}
 		if !strings.Contains(line, "=") && !strings.Contains(line, "}") {
-			return InvalidErrorf("realms section line (%s)", line)
+			return ignored, InvalidErrorf("realms section line (%s)", line)
 		}
 		if strings.Contains(line, "v4_") {
 			ignore = true
 			err = UnsupportedDirective{"v4 configurations are not supported"}
 		}
 		if strings.Contains(line, "{") {
-			c++
-			if ignore {
-				continue
+			if !ignore {
+				// Relations with sub-blocks, such as auth_to_local_names, are not supported
+				ignore = true
+				ignored = append(ignored, strings.TrimSpace(strings.ToLower(strings.Split(line, "=")[0])))
 			}
+			c++
+			continue
 		}
 		if strings.Contains(line, "}") {
 			c--
 			if c < 0 {
-				return InvalidErrorf("unpaired curly brackets")
+				return ignored, InvalidErrorf("unpaired curly brackets")
 			}
 			if ignore {
 				if c < 1 {
@@ -383,19 +412,19 @@ func (r *Realm) parseLines(name string, lines []string) (err error) {
 		case "default_domain":
 			r.DefaultDomain = v
 		case "kdc":
-			if !strings.Contains(v, ":") {
-				// No port number specified default to 88
-				if strings.HasSuffix(v, `*`) {
-					v = strings.TrimSpace(strings.TrimSuffix(v, `*`)) + ":88*"
-				} else {
-					v = strings.TrimSpace(v) + ":88"
-				}
-			}
+			// If no port number is specified default to 88
+			v = withDefaultPort(v, "88")
 			appendUntilFinal(&r.KDC, v, &KDCFinal)
 		case "kpasswd_server":
+			// If no port number is specified default to the kpasswd port 464
+			v = withDefaultPort(v, "464")
 			appendUntilFinal(&r.KPasswdServer, v, &kpasswdServerFinal)
 		case "master_kdc":
+			// If no port number is specified default to 88
+			v = withDefaultPort(v, "88")
 			appendUntilFinal(&r.MasterKDC, v, &masterKDCFinal)
+		default:
+			ignored = append(ignored, key)
 		}
 	}
 	//default for Kpasswd_server = admin_server:464
@@ -408,8 +437,20 @@ func (r *Realm) parseLines(name string, lines []string) (err error) {
 	return
 }
 
+// withDefaultPort adds the default port to the host value if it does not specify one, preserving any final marker.
+func withDefaultPort(v, port string) string {
+	if strings.Contains(v, ":") {
+		return v
+	}
+	if strings.HasSuffix(v, `*`) {
+		return strings.TrimSpace(strings.TrimSuffix(v, `*`)) + ":" + port + "*"
+	}
+	return strings.TrimSpace(v) + ":" + port
+}
+
 // Parse the lines of the [realms] section of the configuration into an slice of Realm structs.
-func parseRealms(lines []string) (realms []Realm, err error) {
+// The realm keys that are not supported are returned, prefixed with the realm name, so that they can be recorded as ignored.
+func parseRealms(lines []string) (realms []Realm, ignored []string, err error) {
 	var name string
 	var start int
 	var c int
@@ -428,7 +469,7 @@ func parseRealms(lines []string) (realms []Realm, err error) {
 		if strings.Contains(l, "{") {
 			c++
 			if !strings.Contains(l, "=") {
-				return nil, fmt.Errorf("realm configuration line invalid: %s", l)
+				return nil, ignored, fmt.Errorf("realm configuration line invalid: %s", l)
 			}
 			if c == 1 {
 				start = i
@@ -439,12 +480,15 @@ func parseRealms(lines []string) (realms []Realm, err error) {
 		if strings.Contains(l, "}") {
 			if c < 1 {
 				// but not started a block!!!
-				return nil, errors.New("invalid Realms section in configuration")
+				return nil, ignored, errors.New("invalid Realms section in configuration")
 			}
 			c--
 			if c == 0 {
 				var r Realm
-				e := r.parseLines(name, lines[start+1:i])
+				ig, e := r.parseLines(name, lines[start+1:i])
+				for _, k := range ig {
+					ignored = append(ignored, name+" "+k)
+				}
 				if e != nil {
 					if _, ok := e.(UnsupportedDirective); !ok {
 						err = e
@@ -515,6 +559,9 @@ func (c *Config) ResolveRealm(domainName string) string {
 }
 
 // Load the KRB5 configuration from the specified file path.
+//
+// To load the configuration from a source other than a file, such as content embedded in the binary or fetched over
+// the network, use NewFromReader or NewFromString.
 func Load(cfgPath string) (*Config, error) {
 	fh, err := os.Open(cfgPath)
 	if err != nil {
@@ -565,8 +612,11 @@ func NewFromScanner(scanner *bufio.Scanner) (*Config, error) {
 			continue
 		}
 		if matched, _ := regexp.MatchString(`^\s*\[.*\]\s*`, scanner.Text()); matched {
+			// Sections such as [plugins], [capaths] and [appdefaults] are not supported and are skipped
 			sections[len(lines)] = "unknown_section"
 			sectionLineNum = append(sectionLineNum, len(lines))
+			t := strings.TrimSpace(scanner.Text())
+			c.Ignored = append(c.Ignored, t[:strings.Index(t, "]")+1])
 			continue
 		}
 		lines = append(lines, scanner.Text())
@@ -580,7 +630,10 @@ func NewFromScanner(scanner *bufio.Scanner) (*Config, error) {
 		}
 		switch section := sections[start]; section {
 		case "libdefaults":
-			err := c.LibDefaults.parseLines(lines[start:end])
+			ignored, err := c.LibDefaults.parseLines(lines[start:end])
+			for _, k := range ignored {
+				c.Ignored = append(c.Ignored, "[libdefaults] "+k)
+			}
 			if err != nil {
 				if _, ok := err.(UnsupportedDirective); !ok {
 					return nil, fmt.Errorf("error processing libdefaults section: %v", err)
@@ -588,7 +641,10 @@ func NewFromScanner(scanner *bufio.Scanner) (*Config, error) {
 				e = err
 			}
 		case "realms":
-			realms, err := parseRealms(lines[start:end])
+			realms, ignored, err := parseRealms(lines[start:end])
+			for _, k := range ignored {
+				c.Ignored = append(c.Ignored, "[realms] "+k)
+			}
 			if err != nil {
 				if _, ok := err.(UnsupportedDirective); !ok {
 					return nil, fmt.Errorf("error processing realms section: %v", err)
@@ -513,6 +513,63 @@ func TestLoadWithV4Lines(t *testing.T) {
 
 }
 
+func TestLoadUnknownSections(t *testing.T) {
+	t.Parallel()
+	c, err := NewFromString(`
+[libdefaults]
+ default_realm = TEST.GOKRB5
+ unknown_key = value
+ TEST.GOKRB5 = {
+  ticket_lifetime = 1h
+ }
+ ticket_lifetime = 10h
+
+[plugins]
+ ccselect = {
+  disable = k5identity
+ }
+ pwqual = {
+  module = mymodule:/path/to/mymodule.so
+ }
+
+[realms]
+ TEST.GOKRB5 = {
+  kdc = 10.80.88.88:88
+  auth_to_local_names = {
+   testuser = test
+  }
+  auth_to_local = DEFAULT
+  admin_server = 10.80.88.88:749
+ }
+
+[capaths] # comment to be ignored
+ TEST.GOKRB5 = {
+  EXAMPLE.COM = .
+ }
+
+[domain_realm]
+ .test.gokrb5 = TEST.GOKRB5
+`)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	assert.Equal(t, "TEST.GOKRB5", c.LibDefaults.DefaultRealm, "[libdefaults] default_realm not as expected")
+	assert.Equal(t, time.Duration(10)*time.Hour, c.LibDefaults.TicketLifetime, "[libdefaults] ticket_lifetime not as expected")
+	assert.Equal(t, 1, len(c.Realms), "number of realms not as expected")
+	assert.Equal(t, []string{"10.80.88.88:88"}, c.Realms[0].KDC, "[realm] kdc not as expected")
+	assert.Equal(t, []string{"10.80.88.88:749"}, c.Realms[0].AdminServer, "[realm] admin_server not as expected")
+	assert.Equal(t, "TEST.GOKRB5", c.DomainRealm[".test.gokrb5"], "domain to realm mapping not as expected")
+	expected := []string{
+		"[libdefaults] unknown_key",
+		"[libdefaults] TEST.GOKRB5",
+		"[plugins]",
+		"[realms] TEST.GOKRB5 auth_to_local_names",
+		"[realms] TEST.GOKRB5 auth_to_local",
+		"[capaths]",
+	}
+	assert.ElementsMatch(t, expected, c.Ignored, "ignored entries not as expected")
+}
+
 func TestLoad2(t *testing.T) {
 	t.Parallel()
 	c, err := NewFromString(krb5Conf2)
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"math"
 	"os"
 	"strings"
 	"time"
@@ -109,6 +110,54 @@ func (c *CCache) Unmarshal(b []byte) error {
 	return nil
 }
 
+// Marshal the CCache into a byte slice in the version 4 file format so that it can be used by other Kerberos
+// implementations.
+func (c *CCache) Marshal() ([]byte, error) {
+	b := []byte{5, 4}
+	var hb []byte
+	for _, f := range c.Header.fields {
+		hb = appendInt16(hb, f.tag)
+		hb = appendInt16(hb, uint16(len(f.value)))
+		hb = append(hb, f.value...)
+	}
+	if len(hb) > math.MaxUint16 {
+		return nil, errors.New("credential cache header too long")
+	}
+	b = appendInt16(b, uint16(len(hb)))
+	b = append(b, hb...)
+	b = appendPrincipal(b, c.DefaultPrincipal)
+	for _, cred := range c.Credentials {
+		b = appendPrincipal(b, cred.Client)
+		b = appendPrincipal(b, cred.Server)
+		b = appendInt16(b, uint16(cred.Key.KeyType))
+		b = appendData(b, cred.Key.KeyValue)
+		for _, t := range []time.Time{cred.AuthTime, cred.StartTime, cred.EndTime, cred.RenewTill} {
+			b = appendTimestamp(b, t)
+		}
+		if cred.IsSKey {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+		f := make([]byte, 4)
+		copy(f, cred.TicketFlags.Bytes)
+		b = append(b, f...)
+		b = appendInt32(b, uint32(len(cred.Addresses)))
+		for _, a := range cred.Addresses {
+			b = appendInt16(b, uint16(a.AddrType))
+			b = appendData(b, a.Address)
+		}
+		b = appendInt32(b, uint32(len(cred.AuthData)))
+		for _, a := range cred.AuthData {
+			b = appendInt16(b, uint16(a.ADType))
+			b = appendData(b, a.ADData)
+		}
+		b = appendData(b, cred.Ticket)
+		b = appendData(b, cred.SecondTicket)
+	}
+	return b, nil
+}
+
 func parseHeader(b []byte, p *int, c *CCache, e *binary.ByteOrder) error {
 	if c.Version != 4 {
 		return errors.New("Credentials cache version is not 4 so there is no header to parse.")
@@ -259,6 +308,37 @@ func (h *headerField) valid() bool {
 	return false
 }
 
+func appendPrincipal(b []byte, princ principal) []byte {
+	b = appendInt32(b, uint32(princ.PrincipalName.NameType))
+	b = appendInt32(b, uint32(len(princ.PrincipalName.NameString)))
+	b = appendData(b, []byte(princ.Realm))
+	for _, s := range princ.PrincipalName.NameString {
+		b = appendData(b, []byte(s))
+	}
+	return b
+}
+
+func appendData(b, d []byte) []byte {
+	b = appendInt32(b, uint32(len(d)))
+	return append(b, d...)
+}
+
+// Append the bytes representing a timestamp, zero if the time is not set.
+func appendTimestamp(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return appendInt32(b, 0)
+	}
+	return appendInt32(b, uint32(t.Unix()))
+}
+
+func appendInt16(b []byte, i uint16) []byte {
+	return append(b, byte(i>>8), byte(i))
+}
+
+func appendInt32(b []byte, i uint32) []byte {
+	return append(b, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+}
+
 func readData(b []byte, p *int, e *binary.ByteOrder) []byte {
 	l := readInt32(b, p, e)
 	return readBytes(b, p, int(l), e)
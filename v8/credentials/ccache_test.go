@@ -129,3 +129,21 @@ func TestCCache_GetEntries(t *testing.T) {
 	creds := c.GetEntries()
 	assert.Equal(t, 2, len(creds), "Number of credentials entries not as expected")
 }
+
+func TestCCache_Marshal(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(testdata.CCACHE_TEST)
+	if err != nil {
+		t.Fatal("Error decoding test data")
+	}
+	c := new(CCache)
+	err = c.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Error parsing cache: %v", err)
+	}
+	mb, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Error marshaling cache: %v", err)
+	}
+	assert.Equal(t, b, mb, "Marshaled cache not as expected")
+}
@@ -8,14 +8,45 @@ import (
 	"time"
 
 	"github.com/hashicorp/go-uuid"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/jcmturner/rpc/v2/mstypes"
 )
 
 const (
 	// AttributeKeyADCredentials assigned number for AD credentials.
 	AttributeKeyADCredentials = "gokrb5AttributeKeyADCredentials"
+	// AttributeKeyTicketFlags assigned number for the flags of the ticket the credentials were authenticated with.
+	AttributeKeyTicketFlags = "gokrb5AttributeKeyTicketFlags"
+	// AttributeKeyGSSContextAttributes assigned number for the attributes of the GSS-API security context established.
+	AttributeKeyGSSContextAttributes = "gokrb5AttributeKeyGSSContextAttributes"
+	// AttributeKeySubkeyEType assigned number for the encryption type of the subkey in the client's authenticator.
+	AttributeKeySubkeyEType = "gokrb5AttributeKeySubkeyEType"
+	// AttributeKeyTicketEType assigned number for the encryption type of the ticket the credentials were authenticated with.
+	AttributeKeyTicketEType = "gokrb5AttributeKeyTicketEType"
+	// AttributeKeySessionKeyEType assigned number for the encryption type of the session key of the ticket.
+	AttributeKeySessionKeyEType = "gokrb5AttributeKeySessionKeyEType"
+	// AttributeKeyTicketAuthTime assigned number for the time of the client's original authentication to the KDC, the
+	// authtime of the ticket.
+	AttributeKeyTicketAuthTime = "gokrb5AttributeKeyTicketAuthTime"
+	// AttributeKeyTicketRenewTill assigned number for the absolute time a renewable ticket can be renewed until, the
+	// renew-till of the ticket.
+	AttributeKeyTicketRenewTill = "gokrb5AttributeKeyTicketRenewTill"
+	// AttributeKeyTransitedRealms assigned number for the realms, in order, a cross-realm ticket transited between the
+	// client's realm and the service's realm, decoded from the transited field of the ticket.
+	AttributeKeyTransitedRealms = "gokrb5AttributeKeyTransitedRealms"
+	// AttributeKeyTicket assigned number for the raw ASN.1 encoding of the ticket the credentials were authenticated with.
+	AttributeKeyTicket = "gokrb5AttributeKeyTicket"
+	// AttributeKeySessionKey assigned number for the session key of the ticket the credentials were authenticated with.
+	AttributeKeySessionKey = "gokrb5AttributeKeySessionKey"
+	// AttributeKeyClientClaims assigned number for the client claims decoded from the PAC of the ticket.
+	AttributeKeyClientClaims = "gokrb5AttributeKeyClientClaims"
+	// AttributeKeyAuthorizationData assigned number for the authorization data of the ticket the credentials were
+	// authenticated with.
+	AttributeKeyAuthorizationData = "gokrb5AttributeKeyAuthorizationData"
 )
 
 // Credentials struct for a user.
@@ -333,6 +364,12 @@ func (c *Credentials) RemoveAttribute(k string) {
 func (c *Credentials) Marshal() ([]byte, error) {
 	gob.Register(map[string]interface{}{})
 	gob.Register(ADCredentials{})
+	gob.Register(asn1.BitString{})
+	gob.Register(gssapi.ContextAttributes{})
+	gob.Register(time.Time{})
+	gob.Register(types.EncryptionKey{})
+	gob.Register(mstypes.ClaimsSet{})
+	gob.Register(types.AuthorizationData{})
 	buf := new(bytes.Buffer)
 	enc := gob.NewEncoder(buf)
 	mc := marshalCredentials{
@@ -361,6 +398,12 @@ func (c *Credentials) Marshal() ([]byte, error) {
 func (c *Credentials) Unmarshal(b []byte) error {
 	gob.Register(map[string]interface{}{})
 	gob.Register(ADCredentials{})
+	gob.Register(asn1.BitString{})
+	gob.Register(gssapi.ContextAttributes{})
+	gob.Register(time.Time{})
+	gob.Register(types.EncryptionKey{})
+	gob.Register(mstypes.ClaimsSet{})
+	gob.Register(types.AuthorizationData{})
 	mc := new(marshalCredentials)
 	buf := bytes.NewBuffer(b)
 	dec := gob.NewDecoder(buf)
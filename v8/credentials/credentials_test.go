@@ -4,6 +4,9 @@ import (
 	"testing"
 
 	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,3 +29,27 @@ func TestCredentials_Marshal(t *testing.T) {
 		t.Fatalf("could not unmarshal credetials: %v", err)
 	}
 }
+
+func TestCredentials_Marshal_Attributes(t *testing.T) {
+	t.Parallel()
+	cred := New("testuser1", "TEST.GOKRB5")
+	f := types.NewKrbFlags()
+	types.SetFlag(&f, flags.Forwardable)
+	cred.SetAttribute(AttributeKeyTicketFlags, f)
+	ca := gssapi.ContextAttributes{
+		InitiatorName: "testuser1@TEST.GOKRB5",
+		Flags:         gssapi.ContextFlagMutual,
+	}
+	cred.SetAttribute(AttributeKeyGSSContextAttributes, ca)
+	b, err := cred.Marshal()
+	if err != nil {
+		t.Fatalf("could not marshal credetials: %v", err)
+	}
+	var credum Credentials
+	err = credum.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("could not unmarshal credetials: %v", err)
+	}
+	assert.Equal(t, f, credum.Attributes()[AttributeKeyTicketFlags], "ticket flags attribute not as expected")
+	assert.Equal(t, ca, credum.Attributes()[AttributeKeyGSSContextAttributes], "context attributes not as expected")
+}
@@ -0,0 +1,129 @@
+package credentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jcmturner/goidentity/v6"
+)
+
+// jwtHeader is the JOSE header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// jwtClaims are the claims asserted in a JWT minted from an identity.
+type jwtClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	Expiry    int64    `json:"exp"`
+	UserName  string   `json:"preferred_username"`
+	Realm     string   `json:"realm"`
+	Groups    []string `json:"groups,omitempty"`
+}
+
+// NewJWT mints a signed JWT, as defined in RFC 7519, asserting the identity provided so that a service terminating
+// Kerberos authentication can forward the identity to downstream services that use token based authentication.
+//
+// The claims are the identity's principal as the subject, its username and realm, and its group memberships, which
+// for an identity authenticated with a PAC are the group SIDs. The token is valid for the lifetime provided from the
+// time it is minted, which should be kept short.
+//
+// The key determines the signing algorithm:
+//
+// - []byte: HMAC SHA-256 (HS256)
+//
+// - *rsa.PrivateKey: RSASSA-PKCS1-v1_5 SHA-256 (RS256)
+//
+// - *ecdsa.PrivateKey on the P-256 curve: ECDSA SHA-256 (ES256)
+func NewJWT(id goidentity.Identity, issuer string, lifetime time.Duration, key interface{}) (string, error) {
+	if id == nil || id.UserName() == "" {
+		return "", errors.New("an identity with a username is required to mint a JWT")
+	}
+	if lifetime <= 0 {
+		return "", errors.New("the JWT lifetime must be positive")
+	}
+	var alg string
+	switch k := key.(type) {
+	case []byte:
+		if len(k) < 1 {
+			return "", errors.New("HMAC key for JWT is empty")
+		}
+		alg = "HS256"
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return "", errors.New("only ECDSA keys on the P-256 curve are supported for signing JWTs")
+		}
+		alg = "ES256"
+	default:
+		return "", fmt.Errorf("unsupported key type for signing JWT: %T", key)
+	}
+
+	now := time.Now().UTC()
+	groups := id.AuthzAttributes()
+	sort.Strings(groups)
+	c := jwtClaims{
+		Issuer:    issuer,
+		Subject:   id.UserName() + "@" + id.Domain(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		Expiry:    now.Add(lifetime).Unix(),
+		UserName:  id.UserName(),
+		Realm:     id.Domain(),
+		Groups:    groups,
+	}
+	hb, err := json.Marshal(jwtHeader{Alg: alg, Typ: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling JWT header: %v", err)
+	}
+	cb, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling JWT claims: %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(cb)
+	sig, err := jwtSign([]byte(signingInput), key)
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwtSign returns the signature over the JWT signing input with the key provided.
+func jwtSign(b []byte, key interface{}) ([]byte, error) {
+	h := sha256.Sum256(b)
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(b)
+		return mac.Sum(nil), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed length concatenation of R and S rather than the ASN.1 encoding
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %T", key)
+}
@@ -0,0 +1,91 @@
+package credentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJWT(t *testing.T) {
+	t.Parallel()
+	c := New("testuser1", "TEST.GOKRB5")
+	c.AddAuthzAttribute("S-1-5-21-1-2-3-513")
+	c.AddAuthzAttribute("S-1-5-21-1-2-3-1105")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ECDSA key: %v", err)
+	}
+	hmacKey := []byte("0123456789abcdef0123456789abcdef")
+
+	var tests = []struct {
+		alg    string
+		key    interface{}
+		verify func(input, sig []byte) bool
+	}{
+		{"HS256", hmacKey, func(input, sig []byte) bool {
+			mac := hmac.New(sha256.New, hmacKey)
+			mac.Write(input)
+			return hmac.Equal(sig, mac.Sum(nil))
+		}},
+		{"RS256", rsaKey, func(input, sig []byte) bool {
+			h := sha256.Sum256(input)
+			return rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, h[:], sig) == nil
+		}},
+		{"ES256", ecKey, func(input, sig []byte) bool {
+			h := sha256.Sum256(input)
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			return len(sig) == 64 && ecdsa.Verify(&ecKey.PublicKey, h[:], r, s)
+		}},
+	}
+	for _, test := range tests {
+		tkn, err := NewJWT(c, "https://edge.test.gokrb5", time.Minute*5, test.key)
+		if err != nil {
+			t.Fatalf("error minting %s JWT: %v", test.alg, err)
+		}
+		parts := strings.Split(tkn, ".")
+		if len(parts) != 3 {
+			t.Fatalf("%s JWT does not have three parts: %s", test.alg, tkn)
+		}
+		hb, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		var h jwtHeader
+		json.Unmarshal(hb, &h)
+		assert.Equal(t, test.alg, h.Alg, "JWT algorithm not as expected")
+		cb, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims jwtClaims
+		json.Unmarshal(cb, &claims)
+		assert.Equal(t, "https://edge.test.gokrb5", claims.Issuer, "issuer claim not as expected")
+		assert.Equal(t, "testuser1@TEST.GOKRB5", claims.Subject, "subject claim not as expected")
+		assert.Equal(t, "testuser1", claims.UserName, "username claim not as expected")
+		assert.Equal(t, "TEST.GOKRB5", claims.Realm, "realm claim not as expected")
+		assert.Equal(t, []string{"S-1-5-21-1-2-3-1105", "S-1-5-21-1-2-3-513"}, claims.Groups, "groups claim not as expected")
+		assert.Equal(t, int64(300), claims.Expiry-claims.IssuedAt, "lifetime of JWT not as expected")
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		assert.True(t, test.verify([]byte(parts[0]+"."+parts[1]), sig), "%s JWT signature not valid", test.alg)
+	}
+
+	_, err = NewJWT(c, "", time.Minute, "not a key")
+	assert.Error(t, err, "unsupported key type should error")
+	_, err = NewJWT(c, "", 0, hmacKey)
+	assert.Error(t, err, "non positive lifetime should error")
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, err = NewJWT(c, "", time.Minute, p384)
+	assert.Error(t, err, "unsupported curve should error")
+}
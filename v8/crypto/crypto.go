@@ -67,6 +67,18 @@ func GetChksumEtype(id int32) (etype.EType, error) {
 	}
 }
 
+// GetKeyChksumType returns the checksum type to use with the key provided.
+// The checksum in an authenticator must be of the type associated with the enctype of the session key it is
+// encrypted with, which can differ from the enctype of the ticket, otherwise the service rejects it with
+// KRB_AP_ERR_INAPP_CKSUM.
+func GetKeyChksumType(key types.EncryptionKey) (int32, error) {
+	et, err := GetEtype(key.KeyType)
+	if err != nil {
+		return 0, err
+	}
+	return et.GetHashID(), nil
+}
+
 // GetKeyFromPassword generates an encryption key from the principal's password.
 func GetKeyFromPassword(passwd string, cname types.PrincipalName, realm string, etypeID int32, pas types.PADataSequence) (types.EncryptionKey, etype.EType, error) {
 	var key types.EncryptionKey
@@ -109,16 +121,30 @@ func GetKeyFromPassword(passwd string, cname types.PrincipalName, realm string,
 			if err != nil {
 				return key, et, fmt.Errorf("error unmashalling PA Data to PA-ETYPE-INFO2: %v", err)
 			}
-			if etypeID != et2[0].EType {
-				et, err = GetEtype(et2[0].EType)
+			if len(et2) < 1 {
+				continue
+			}
+			// Use the entry for the requested etype if the KDC supplied one, otherwise fall back to the first.
+			e := et2[0]
+			for _, ent := range et2 {
+				if ent.EType == etypeID {
+					e = ent
+					break
+				}
+			}
+			if etypeID != e.EType {
+				et, err = GetEtype(e.EType)
 				if err != nil {
 					return key, et, fmt.Errorf("error getting encryption type: %v", err)
 				}
+				etypeID = e.EType
 			}
-			if len(et2[0].S2KParams) == 4 {
-				sk2p = hex.EncodeToString(et2[0].S2KParams)
+			// The s2kparams carry the iteration count for the AES string-to-key and apply only to this entry's etype.
+			sk2p = et.GetDefaultStringToKeyParams()
+			if len(e.S2KParams) == 4 {
+				sk2p = hex.EncodeToString(e.S2KParams)
 			}
-			salt = et2[0].Salt
+			salt = e.Salt
 		}
 	}
 	if salt == "" {
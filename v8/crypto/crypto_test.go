@@ -0,0 +1,59 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKeyChksumType(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		etype  int32
+		chksum int32
+	}{
+		{etypeID.AES128_CTS_HMAC_SHA1_96, chksumtype.HMAC_SHA1_96_AES128},
+		{etypeID.AES256_CTS_HMAC_SHA1_96, chksumtype.HMAC_SHA1_96_AES256},
+		{etypeID.AES128_CTS_HMAC_SHA256_128, chksumtype.HMAC_SHA256_128_AES128},
+		{etypeID.AES256_CTS_HMAC_SHA384_192, chksumtype.HMAC_SHA384_192_AES256},
+		{etypeID.DES3_CBC_SHA1_KD, chksumtype.HMAC_SHA1_DES3_KD},
+		{etypeID.RC4_HMAC, chksumtype.KERB_CHECKSUM_HMAC_MD5},
+	}
+	for _, test := range tests {
+		ct, err := GetKeyChksumType(types.EncryptionKey{KeyType: test.etype})
+		if err != nil {
+			t.Errorf("error getting checksum type for etype %d: %v", test.etype, err)
+			continue
+		}
+		assert.Equal(t, test.chksum, ct, "checksum type not as expected for etype %d", test.etype)
+	}
+	_, err := GetKeyChksumType(types.EncryptionKey{KeyType: 1})
+	assert.Error(t, err, "expected error for unsupported etype")
+}
+
+func TestGetKeyFromPassword_ETypeInfo2S2KParams(t *testing.T) {
+	t.Parallel()
+	et2 := types.ETypeInfo2{
+		{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Salt: "ATHENA.MIT.EDUraeburn"},
+		{EType: etypeID.AES128_CTS_HMAC_SHA1_96, Salt: "ATHENA.MIT.EDUraeburn", S2KParams: []byte{0, 0, 0, 1}},
+	}
+	b, err := asn1.Marshal(et2)
+	if err != nil {
+		t.Fatalf("error marshaling ETYPE-INFO2: %v", err)
+	}
+	pas := types.PADataSequence{{PADataType: patype.PA_ETYPE_INFO2, PADataValue: b}}
+	key, et, err := GetKeyFromPassword("password", types.PrincipalName{}, "", etypeID.AES128_CTS_HMAC_SHA1_96, pas)
+	if err != nil {
+		t.Fatalf("error getting key from password: %v", err)
+	}
+	assert.Equal(t, etypeID.AES128_CTS_HMAC_SHA1_96, et.GetETypeID(), "etype not as expected")
+	assert.Equal(t, etypeID.AES128_CTS_HMAC_SHA1_96, key.KeyType, "key type not as expected")
+	// RFC 3962 appendix B test vector for an iteration count of 1.
+	assert.Equal(t, "42263c6e89f4fc28b8df68ee09799f15", hex.EncodeToString(key.KeyValue), "key not derived using the s2kparams iteration count")
+}
@@ -0,0 +1,78 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/jcmturner/gokrb5/v8/crypto/rfc3961"
+	"github.com/jcmturner/gokrb5/v8/crypto/rfc8009"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// PseudoRandom returns the output of the pseudo-random function (PRF) of the key's encryption type for the octet
+// string provided.
+func PseudoRandom(key types.EncryptionKey, b []byte) ([]byte, error) {
+	et, err := GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	switch key.KeyType {
+	case etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.DES3_CBC_SHA1_KD:
+		// RFC 3962 section 6 and RFC 3961 section 5.3
+		return rfc3961.PseudoRandom(key.KeyValue, b, et)
+	case etypeID.AES128_CTS_HMAC_SHA256_128:
+		// RFC 8009 section 5
+		return rfc8009.KDF_HMAC_SHA2(key.KeyValue, []byte("prf"), b, 256, et), nil
+	case etypeID.AES256_CTS_HMAC_SHA384_192:
+		return rfc8009.KDF_HMAC_SHA2(key.KeyValue, []byte("prf"), b, 384, et), nil
+	case etypeID.RC4_HMAC:
+		// RFC 4757 section 5
+		mac := hmac.New(sha1.New, key.KeyValue)
+		mac.Write(b)
+		return mac.Sum(nil), nil
+	}
+	return nil, fmt.Errorf("pseudo-random function not supported for EType: %d", key.KeyType)
+}
+
+// prfPlus implements PRF+ as defined in RFC 6113 section 5.1, returning at least l bytes.
+func prfPlus(key types.EncryptionKey, pepper string, l int) ([]byte, error) {
+	var out []byte
+	for i := 1; len(out) < l; i++ {
+		if i > 255 {
+			return nil, fmt.Errorf("PRF+ cannot generate %d bytes", l)
+		}
+		b, err := PseudoRandom(key, append([]byte{byte(i)}, pepper...))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out[:l], nil
+}
+
+// KRBFXCF2 combines two keys using the pepper strings provided as defined in RFC 6113 section 5.1.
+// The key returned is of the same encryption type as the first key.
+func KRBFXCF2(k1, k2 types.EncryptionKey, pepper1, pepper2 string) (types.EncryptionKey, error) {
+	et, err := GetEtype(k1.KeyType)
+	if err != nil {
+		return types.EncryptionKey{}, err
+	}
+	l := et.GetKeySeedBitLength() / 8
+	b1, err := prfPlus(k1, pepper1, l)
+	if err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("error generating PRF+ from the first key: %v", err)
+	}
+	b2, err := prfPlus(k2, pepper2, l)
+	if err != nil {
+		return types.EncryptionKey{}, fmt.Errorf("error generating PRF+ from the second key: %v", err)
+	}
+	for i := range b1 {
+		b1[i] ^= b2[i]
+	}
+	return types.EncryptionKey{
+		KeyType:  k1.KeyType,
+		KeyValue: et.RandomToKey(b1),
+	}, nil
+}
@@ -0,0 +1,72 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPseudoRandom(t *testing.T) {
+	t.Parallel()
+	// Test vectors from RFC 8009 Appendix A
+	var tests = []struct {
+		etype int32
+		key   string
+		prf   string
+	}{
+		{etypeID.AES128_CTS_HMAC_SHA256_128, "3705d96080c17728a0e800eab6e0d23c", "9d188616f63852fe86915bb840b4a886ff3e6bb0f819b49b893393d393854295"},
+		{etypeID.AES256_CTS_HMAC_SHA384_192, "6d404d37faf79f9df0d33568d320669800eb4836472ea8a026d16b7182460c52", "9801f69a368c2bf675e59521e177d9a07f67efe1cfde8d3c8d6f6a0256e3b17db3c1b62ad1b8553360d17367eb1514d2"},
+	}
+	for _, test := range tests {
+		kb, _ := hex.DecodeString(test.key)
+		b, err := PseudoRandom(types.EncryptionKey{KeyType: test.etype, KeyValue: kb}, []byte("test"))
+		if err != nil {
+			t.Fatalf("error generating PRF output for etype %d: %v", test.etype, err)
+		}
+		assert.Equal(t, test.prf, hex.EncodeToString(b), "PRF output not as expected for etype %d", test.etype)
+	}
+
+	// The simplified profile output is the size of the hash truncated to a multiple of the cipher block size
+	for _, etype := range []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96, etypeID.DES3_CBC_SHA1_KD} {
+		et, _ := GetEtype(etype)
+		b, err := PseudoRandom(types.EncryptionKey{KeyType: etype, KeyValue: make([]byte, et.GetKeyByteSize())}, []byte("test"))
+		if err != nil {
+			t.Fatalf("error generating PRF output for etype %d: %v", etype, err)
+		}
+		assert.Equal(t, 16, len(b), "PRF output length not as expected for etype %d", etype)
+	}
+}
+
+func TestKRBFXCF2(t *testing.T) {
+	t.Parallel()
+	for _, etype := range []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96,
+		etypeID.AES128_CTS_HMAC_SHA256_128, etypeID.AES256_CTS_HMAC_SHA384_192, etypeID.DES3_CBC_SHA1_KD, etypeID.RC4_HMAC} {
+		et, _ := GetEtype(etype)
+		k1 := types.EncryptionKey{KeyType: etype, KeyValue: make([]byte, et.GetKeyByteSize())}
+		k2 := types.EncryptionKey{KeyType: etype, KeyValue: make([]byte, et.GetKeyByteSize())}
+		for i := range k2.KeyValue {
+			k2.KeyValue[i] = byte(i)
+		}
+		k, err := KRBFXCF2(k1, k2, "a", "b")
+		if err != nil {
+			t.Fatalf("error combining keys of etype %d: %v", etype, err)
+		}
+		assert.Equal(t, etype, k.KeyType, "key type not as expected")
+		assert.Equal(t, et.GetKeyByteSize(), len(k.KeyValue), "key length not as expected for etype %d", etype)
+
+		// The result is the XOR of PRF+ of each key with its pepper
+		l := et.GetKeySeedBitLength() / 8
+		p1, _ := prfPlus(k1, "a", l)
+		p2, _ := prfPlus(k2, "b", l)
+		for i := range p1 {
+			p1[i] ^= p2[i]
+		}
+		assert.Equal(t, et.RandomToKey(p1), k.KeyValue, "combined key not as expected for etype %d", etype)
+
+		ks, _ := KRBFXCF2(k1, k2, "b", "a")
+		assert.NotEqual(t, k.KeyValue, ks.KeyValue, "peppers should change the combined key for etype %d", etype)
+	}
+}
@@ -71,11 +71,14 @@ func DES3StringToKey(secret, salt string, e etype.EType) ([]byte, error) {
 	return e.DeriveKey(tkey, []byte("kerberos"))
 }
 
-// PseudoRandom function as defined in RFC 3961
+// PseudoRandom function as defined in RFC 3961 for the simplified profile.
 func PseudoRandom(key, b []byte, e etype.EType) ([]byte, error) {
 	h := e.GetHashFunc()()
 	h.Write(b)
-	tmp := h.Sum(nil)[:e.GetMessageBlockByteSize()]
+	// Truncate the hash to a multiple of the cipher block size
+	m := e.GetCypherBlockBitLength() / 8
+	tmp := h.Sum(nil)
+	tmp = tmp[:(len(tmp)/m)*m]
 	k, err := e.DeriveKey(key, []byte(prfconstant))
 	if err != nil {
 		return []byte{}, err
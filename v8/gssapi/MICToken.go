@@ -155,6 +155,44 @@ func (mt *MICToken) Unmarshal(b []byte, expectFromAcceptor bool) error {
 	return nil
 }
 
+// VerifyMIC verifies a detached MIC token received from the peer over the application data it protects, mirroring
+// GSS_VerifyMIC. As the token does not carry the data the checksum is computed over the data supplied followed by the
+// token's header. The token must have been sent by the peer, rather than reflected back from the local party, and
+// carry the sequence number expected for the next message received, which is then advanced.
+func (c *MessageContext) VerifyMIC(data, micToken []byte) (bool, error) {
+	var mt MICToken
+	err := mt.Unmarshal(micToken, c.Initiator)
+	if err != nil {
+		return false, err
+	}
+	if len(mt.Checksum) < 1 {
+		return false, errors.New("MIC token does not contain a checksum")
+	}
+	if mt.Flags&MICTokenFlagSealed != 0 {
+		return false, errors.New("MIC token must not have the sealed flag set")
+	}
+	if subkey := mt.Flags&MICTokenFlagAcceptorSubkey != 0; subkey != c.AcceptorSubkey {
+		return false, fmt.Errorf("MIC token acceptor subkey flag (%t) does not match the security context (%t)", subkey, c.AcceptorSubkey)
+	}
+	mt.Payload = data
+	if mt.Payload == nil {
+		mt.Payload = []byte{}
+	}
+	usage := uint32(keyusage.GSSAPI_ACCEPTOR_SIGN)
+	if !c.Initiator {
+		usage = keyusage.GSSAPI_INITIATOR_SIGN
+	}
+	ok, err := mt.Verify(c.Key, usage)
+	if !ok {
+		return false, err
+	}
+	if mt.SndSeqNum != c.RecvSeqNum {
+		return false, fmt.Errorf("MIC token sequence number %d is not the expected %d", mt.SndSeqNum, c.RecvSeqNum)
+	}
+	c.RecvSeqNum++
+	return true, nil
+}
+
 // NewInitiatorMICToken builds a new initiator token (acceptor flag will be set to 0) and computes the authenticated checksum.
 // Other flags are set to 0.
 // Note that in certain circumstances you may need to provide a sequence number that has been defined earlier.
@@ -169,3 +169,42 @@ func TestNewInitiatorMICTokenSignatureAndMarshalling(t *testing.T) {
 	assert.Nil(t, tErr, "Unexpected error.")
 	assert.Equal(t, getMICResponseReference(), token, "Token failed to be marshalled to the expected bytes.")
 }
+
+func TestMessageContext_VerifyMIC(t *testing.T) {
+	t.Parallel()
+	data, _ := hex.DecodeString(testMICPayload)
+	// Detached MIC sent by the acceptor and verified by the initiator
+	challenge, _ := hex.DecodeString(testMICChallengeFromAcceptor)
+	ctx := MessageContext{Key: getSessionKey(), Initiator: true, RecvSeqNum: binary.BigEndian.Uint64(challenge[8:16])}
+	ok, err := ctx.VerifyMIC(data, challenge)
+	if !ok || err != nil {
+		t.Fatalf("detached MIC from the acceptor not verified: %v", err)
+	}
+	assert.Equal(t, binary.BigEndian.Uint64(challenge[8:16])+1, ctx.RecvSeqNum, "receive sequence number not advanced")
+
+	// Detached MIC sent by the initiator and verified by the acceptor
+	reply, _ := hex.DecodeString(testMICChallengeReplyFromInitiator)
+	ctx = MessageContext{Key: getSessionKey()}
+	ok, err = ctx.VerifyMIC(data, reply)
+	if !ok || err != nil {
+		t.Fatalf("detached MIC from the initiator not verified: %v", err)
+	}
+
+	var tests = []struct {
+		name string
+		ctx  MessageContext
+		data []byte
+		tkn  []byte
+	}{
+		{"replayed sequence number", MessageContext{Key: getSessionKey(), RecvSeqNum: 1}, data, reply},
+		{"reflected token", MessageContext{Key: getSessionKey(), Initiator: true}, data, reply},
+		{"modified data", MessageContext{Key: getSessionKey()}, []byte("other"), reply},
+		{"acceptor subkey mismatch", MessageContext{Key: getSessionKey(), AcceptorSubkey: true}, data, reply},
+		{"header only", MessageContext{Key: getSessionKey()}, data, reply[:16]},
+	}
+	for _, test := range tests {
+		ok, err := test.ctx.VerifyMIC(test.data, test.tkn)
+		assert.False(t, ok, "%s: MIC should not be verified", test.name)
+		assert.Error(t, err, "%s: expected an error", test.name)
+	}
+}
@@ -0,0 +1,49 @@
+package gssapi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// GSSFlags are the GSS-API context flags, for example ContextFlagInteg|ContextFlagConf, an initiator requests in the
+// authenticator checksum.
+type GSSFlags uint32
+
+// NewAuthenticatorChecksum returns the GSS checksum (type 0x8003), as defined in RFC 4121 section 4.1.1, for the
+// authenticator of the AP_REQ an initiator sends to establish a security context.
+//
+// bindingsMD5 is the MD5 hash of the channel bindings, as returned by ChannelBindings.Hash, which binds the context
+// to the channel. If nil the context is not bound to a channel and the hash octets are zero.
+//
+// delegCred is the marshaled KRB_CRED holding the credentials delegated to the acceptor. If provided the delegation
+// flag is set, otherwise it is cleared as no credentials are delegated. An error is returned if the KRB_CRED is too
+// long for its 16 bit length field.
+func NewAuthenticatorChecksum(bindingsMD5 []byte, flags GSSFlags, delegCred []byte) (types.Checksum, error) {
+	if len(delegCred) > 0xFFFF {
+		return types.Checksum{}, fmt.Errorf("delegated KRB_CRED of %d bytes exceeds the maximum length of %d", len(delegCred), 0xFFFF)
+	}
+	if len(delegCred) > 0 {
+		flags |= ContextFlagDeleg
+	} else {
+		flags &^= ContextFlagDeleg
+	}
+	a := make([]byte, 24)
+	binary.LittleEndian.PutUint32(a[0:4], 16)
+	copy(a[4:20], bindingsMD5)
+	binary.LittleEndian.PutUint32(a[20:24], uint32(flags))
+	if len(delegCred) > 0 {
+		// DlgOpt is always 1 followed by the length of the KRB_CRED
+		d := make([]byte, 4)
+		binary.LittleEndian.PutUint16(d[0:2], 1)
+		binary.LittleEndian.PutUint16(d[2:4], uint16(len(delegCred)))
+		a = append(a, d...)
+		a = append(a, delegCred...)
+	}
+	return types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  a,
+	}, nil
+}
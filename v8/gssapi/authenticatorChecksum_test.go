@@ -0,0 +1,45 @@
+package gssapi
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuthenticatorChecksum(t *testing.T) {
+	t.Parallel()
+	cb := ChannelBindings{ApplicationData: []byte("tls-server-end-point:test")}
+	c, err := NewAuthenticatorChecksum(cb.Hash(), ContextFlagInteg|ContextFlagConf, nil)
+	if err != nil {
+		t.Fatalf("error creating authenticator checksum: %v", err)
+	}
+	assert.Equal(t, chksumtype.GSSAPI, c.CksumType, "checksum type not as expected")
+	assert.Equal(t, 24, len(c.Checksum), "checksum length not as expected")
+	assert.Equal(t, uint32(16), binary.LittleEndian.Uint32(c.Checksum[0:4]), "bindings length not as expected")
+	assert.Equal(t, cb.Hash(), c.Checksum[4:20], "channel bindings hash not as expected")
+	assert.Equal(t, uint32(ContextFlagInteg|ContextFlagConf), binary.LittleEndian.Uint32(c.Checksum[20:24]), "flags not as expected")
+
+	c, _ = NewAuthenticatorChecksum(nil, ContextFlagMutual, nil)
+	assert.Equal(t, make([]byte, 16), c.Checksum[4:20], "hash should be zero when not bound to a channel")
+
+	// The delegation flag is not set without delegated credentials
+	c, _ = NewAuthenticatorChecksum(nil, ContextFlagMutual|ContextFlagDeleg, nil)
+	assert.Equal(t, uint32(ContextFlagMutual), binary.LittleEndian.Uint32(c.Checksum[20:24]), "delegation flag should be cleared")
+	assert.Equal(t, 24, len(c.Checksum), "checksum length without delegated credentials not as expected")
+
+	cred := []byte{0x76, 0x03, 0x01, 0x02, 0x03}
+	c, err = NewAuthenticatorChecksum(nil, ContextFlagMutual, cred)
+	if err != nil {
+		t.Fatalf("error creating authenticator checksum with delegated credentials: %v", err)
+	}
+	assert.Equal(t, 28+len(cred), len(c.Checksum), "checksum length with delegated credentials not as expected")
+	assert.Equal(t, uint32(ContextFlagMutual|ContextFlagDeleg), binary.LittleEndian.Uint32(c.Checksum[20:24]), "delegation flag not set")
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(c.Checksum[24:26]), "DlgOpt not as expected")
+	assert.Equal(t, uint16(len(cred)), binary.LittleEndian.Uint16(c.Checksum[26:28]), "Dlgth not as expected")
+	assert.Equal(t, cred, c.Checksum[28:], "delegated credentials not as expected")
+
+	_, err = NewAuthenticatorChecksum(nil, ContextFlagMutual, make([]byte, 0x10000))
+	assert.Error(t, err, "delegated credentials too long for the length field should error")
+}
@@ -0,0 +1,75 @@
+package gssapi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+
+	// Register the hash functions used by the tls-server-end-point channel binding.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// tlsServerEndPointPrefix is the channel binding unique prefix of tls-server-end-point defined in RFC 5929.
+const tlsServerEndPointPrefix = "tls-server-end-point:"
+
+// ChannelBindings implements the GSS-API channel bindings structure: https://tools.ietf.org/html/rfc2744#section-3.11
+type ChannelBindings struct {
+	InitiatorAddrType uint32
+	InitiatorAddress  []byte
+	AcceptorAddrType  uint32
+	AcceptorAddress   []byte
+	ApplicationData   []byte
+}
+
+// NewTLSServerEndPointBindings returns the tls-server-end-point channel bindings, as defined in RFC 5929, for the TLS
+// server certificate provided. These are the channel bindings used by Extended Protection for Authentication.
+func NewTLSServerEndPointBindings(cert *x509.Certificate) (ChannelBindings, error) {
+	var cb ChannelBindings
+	var h crypto.Hash
+	// RFC 5929 Section 4.1 - MD5 and SHA-1 are replaced by SHA-256, otherwise the certificate's signature hash is used.
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.DSAWithSHA256, x509.ECDSAWithSHA256, x509.SHA256WithRSAPSS:
+		h = crypto.SHA256
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		h = crypto.SHA384
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		h = crypto.SHA512
+	default:
+		return cb, fmt.Errorf("tls-server-end-point channel bindings are not defined for certificate signature algorithm %v", cert.SignatureAlgorithm)
+	}
+	hf := h.New()
+	hf.Write(cert.Raw)
+	cb.ApplicationData = append([]byte(tlsServerEndPointPrefix), hf.Sum(nil)...)
+	return cb, nil
+}
+
+// Marshal the channel bindings into the byte encoding over which the channel bindings hash is calculated, as
+// defined in RFC 4121 Section 4.1.1.2.
+func (cb ChannelBindings) Marshal() []byte {
+	var b bytes.Buffer
+	put := func(i uint32) {
+		l := make([]byte, 4)
+		binary.LittleEndian.PutUint32(l, i)
+		b.Write(l)
+	}
+	put(cb.InitiatorAddrType)
+	put(uint32(len(cb.InitiatorAddress)))
+	b.Write(cb.InitiatorAddress)
+	put(cb.AcceptorAddrType)
+	put(uint32(len(cb.AcceptorAddress)))
+	b.Write(cb.AcceptorAddress)
+	put(uint32(len(cb.ApplicationData)))
+	b.Write(cb.ApplicationData)
+	return b.Bytes()
+}
+
+// Hash returns the MD5 hash of the channel bindings carried in the Bnd field of the authenticator checksum.
+func (cb ChannelBindings) Hash() []byte {
+	h := md5.Sum(cb.Marshal())
+	return h[:]
+}
@@ -0,0 +1,66 @@
+package gssapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelBindings_Marshal(t *testing.T) {
+	t.Parallel()
+	cb := ChannelBindings{
+		InitiatorAddrType: 2,
+		InitiatorAddress:  []byte{127, 0, 0, 1},
+		ApplicationData:   []byte("app"),
+	}
+	expected := []byte{
+		2, 0, 0, 0, 4, 0, 0, 0, 127, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 'a', 'p', 'p',
+	}
+	assert.Equal(t, expected, cb.Marshal(), "marshaled channel bindings not as expected")
+	assert.Equal(t, 16, len(cb.Hash()), "channel bindings hash length not as expected")
+}
+
+func TestNewTLSServerEndPointBindings(t *testing.T) {
+	t.Parallel()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "host.test.gokrb5"},
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	cb, err := NewTLSServerEndPointBindings(cert)
+	if err != nil {
+		t.Fatalf("error creating channel bindings: %v", err)
+	}
+	h := sha256.Sum256(der)
+	assert.Equal(t, append([]byte("tls-server-end-point:"), h[:]...), cb.ApplicationData, "application data not as expected")
+	assert.Equal(t, uint32(0), cb.InitiatorAddrType, "initiator address type should not be set")
+	assert.Equal(t, 0, len(cb.AcceptorAddress), "acceptor address should not be set")
+
+	cert.SignatureAlgorithm = x509.PureEd25519
+	_, err = NewTLSServerEndPointBindings(cert)
+	assert.Error(t, err, "channel bindings are not defined for Ed25519 signatures")
+}
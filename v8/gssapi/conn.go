@@ -0,0 +1,335 @@
+package gssapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// maxConnTokenSize is the largest wrap token that will be read from a Conn.
+const maxConnTokenSize = 1 << 24
+
+// Types of the payload of a token written to a Conn, carried in the first byte of the protected payload.
+const (
+	connDataToken  byte = 0
+	connRekeyToken byte = 1
+)
+
+// rekeyNonceSize is the byte size of the nonce the new key is derived with when re-keying.
+const rekeyNonceSize = 16
+
+// MessageContext holds the state of an established security context needed to protect the messages exchanged over it.
+type MessageContext struct {
+	// Key protecting the messages. This is the acceptor's subkey if one was asserted, otherwise the initiator's subkey
+	// or the ticket's session key.
+	Key types.EncryptionKey
+	// Initiator indicates if the local party initiated the security context.
+	Initiator bool
+	// AcceptorSubkey indicates if the Key is a subkey asserted by the acceptor.
+	AcceptorSubkey bool
+	// SendSeqNum is the sequence number of the next message to be sent.
+	SendSeqNum uint64
+	// RecvSeqNum is the sequence number expected for the next message received.
+	RecvSeqNum uint64
+	// RekeyMessages is the number of messages after which the key protecting the messages sent is replaced.
+	// Zero disables re-keying after a number of messages.
+	// Re-keying is not part of RFC 4121: the new key is derived as KRB-FX-CF2(key, key, pepper, hex(nonce)) with a
+	// pepper particular to gokrb5, so it must only be enabled when the peer is also a gokrb5 Conn.
+	RekeyMessages uint64
+	// RekeyInterval is the time after which the key protecting the messages sent is replaced.
+	// Zero disables re-keying after a time. As with RekeyMessages this only works between gokrb5 peers.
+	RekeyInterval time.Duration
+	// IntegrityOnly indicates that the messages sent over a Conn are only integrity protected rather than encrypted,
+	// for a peer that did not negotiate confidentiality. Both parties must agree on the protection of their messages.
+	IntegrityOnly bool
+}
+
+// Conn is a net.Conn that protects the data written to and read from the underlying connection with GSS-API wrap
+// tokens, as defined in RFC 4121, using an established security context.
+//
+// Each write is sent as a single wrap token prefixed with its length as a 4 byte big-endian integer. The payload of
+// the token is the data preceded by a one byte type, so that it is protected along with the data. The tokens are
+// sealed, that is the data is encrypted along with a copy of the token's header so that both are integrity protected,
+// and carry the sequence numbers of the context, which are verified when reading. If the context is IntegrityOnly the
+// data is sent in the clear with an integrity checksum instead, and sealed tokens are not required when reading.
+//
+// If the context's RekeyMessages or RekeyInterval is set, the key protecting the messages written is replaced once
+// the limit is reached so that long lived connections do not protect unbounded data with one key. A random nonce is
+// sent to the peer in a token protected by the current key and both parties derive the new key from the current key
+// and the nonce. Each direction is re-keyed independently and re-keying by the peer is always accepted when reading.
+// The framing and re-keying are particular to gokrb5 so a Conn can only be used with a peer that is also a Conn.
+type Conn struct {
+	net.Conn
+	ctx     MessageContext
+	rmux    sync.Mutex
+	wmux    sync.Mutex
+	rbuf    []byte
+	sendKey types.EncryptionKey
+	recvKey types.EncryptionKey
+	sent    uint64
+	keyed   time.Time
+}
+
+// NewConn returns a Conn wrapping the connection provided to transparently wrap writes and unwrap reads using the
+// established security context.
+func NewConn(conn net.Conn, ctx MessageContext) net.Conn {
+	return &Conn{
+		Conn:    conn,
+		ctx:     ctx,
+		sendKey: ctx.Key,
+		recvKey: ctx.Key,
+		keyed:   time.Now().UTC(),
+	}
+}
+
+// Write wraps the bytes in a wrap token and writes it to the underlying connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, nil
+	}
+	c.wmux.Lock()
+	defer c.wmux.Unlock()
+	if c.rekeyDue() {
+		err := c.rekey()
+		if err != nil {
+			return 0, fmt.Errorf("error re-keying the connection: %v", err)
+		}
+	}
+	err := c.writeToken(connDataToken, b)
+	if err != nil {
+		return 0, err
+	}
+	c.sent++
+	return len(b), nil
+}
+
+// rekeyDue indicates if the key protecting the messages sent has reached the context's re-keying limits.
+func (c *Conn) rekeyDue() bool {
+	if c.ctx.RekeyMessages > 0 && c.sent >= c.ctx.RekeyMessages {
+		return true
+	}
+	return c.ctx.RekeyInterval > 0 && time.Now().UTC().Sub(c.keyed) >= c.ctx.RekeyInterval
+}
+
+// rekey sends a new nonce to the peer protected by the current key and replaces the key for the messages sent with
+// one derived from the nonce.
+func (c *Conn) rekey() error {
+	nonce := make([]byte, rekeyNonceSize)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	err = c.writeToken(connRekeyToken, nonce)
+	if err != nil {
+		return err
+	}
+	k, err := rekeyKey(c.sendKey, nonce, c.ctx.Initiator)
+	if err != nil {
+		return err
+	}
+	c.sendKey = k
+	c.sent = 0
+	c.keyed = time.Now().UTC()
+	return nil
+}
+
+// rekeyKey derives the key that replaces the key provided using the nonce sent by the initiator or acceptor.
+func rekeyKey(key types.EncryptionKey, nonce []byte, initiator bool) (types.EncryptionKey, error) {
+	pepper := "gokrb5 rekey acceptor"
+	if initiator {
+		pepper = "gokrb5 rekey initiator"
+	}
+	return crypto.KRBFXCF2(key, key, pepper, hex.EncodeToString(nonce))
+}
+
+// writeToken wraps the payload, preceded by its type, in a wrap token protected by the current send key and writes it
+// to the underlying connection.
+func (c *Conn) writeToken(typ byte, b []byte) error {
+	var wt WrapToken
+	wt.SndSeqNum = c.ctx.SendSeqNum
+	usage := uint32(keyusage.GSSAPI_INITIATOR_SEAL)
+	if !c.ctx.Initiator {
+		wt.Flags |= 0x01
+		usage = keyusage.GSSAPI_ACCEPTOR_SEAL
+	}
+	if c.ctx.AcceptorSubkey {
+		wt.Flags |= 0x04
+	}
+	p := append([]byte{typ}, b...)
+	var tb []byte
+	var err error
+	if c.ctx.IntegrityOnly {
+		tb, err = checksumToken(wt, p, c.sendKey, usage)
+	} else {
+		tb, err = sealToken(wt, p, c.sendKey, usage)
+	}
+	if err != nil {
+		return err
+	}
+	hb := make([]byte, 4, 4+len(tb))
+	binary.BigEndian.PutUint32(hb, uint32(len(tb)))
+	_, err = c.Conn.Write(append(hb, tb...))
+	if err != nil {
+		return err
+	}
+	c.ctx.SendSeqNum++
+	return nil
+}
+
+// checksumToken returns the marshaled wrap token, with the header provided, carrying the payload in the clear along
+// with a checksum over the payload and header.
+func checksumToken(wt WrapToken, b []byte, key types.EncryptionKey, usage uint32) ([]byte, error) {
+	et, err := crypto.GetEtype(key.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	wt.EC = uint16(et.GetHMACBitLength() / 8)
+	wt.Payload = b
+	err = wt.SetCheckSum(key, usage)
+	if err != nil {
+		return nil, err
+	}
+	return wt.Marshal()
+}
+
+// sealToken returns the marshaled sealed wrap token, with the header provided, as defined in RFC 4121 section 4.2.4.
+// The payload is encrypted together with a copy of the header, with no filler as the encryption types' ciphertext
+// need not be padded, and the encrypted data is not rotated.
+func sealToken(wt WrapToken, b []byte, key types.EncryptionKey, usage uint32) ([]byte, error) {
+	wt.Flags |= 0x02
+	hdr := sealedTokenHeader(wt.Flags, 0, wt.SndSeqNum)
+	ed, err := crypto.GetEncryptedData(append(append([]byte{}, b...), hdr...), key, usage, 0)
+	if err != nil {
+		return nil, err
+	}
+	return append(hdr, ed.Cipher...), nil
+}
+
+// sealedTokenHeader returns the header of a sealed wrap token with the flags, extra count and sequence number provided
+// and a right rotation count of zero, as it is encrypted within the token.
+func sealedTokenHeader(flags byte, ec uint16, seqNum uint64) []byte {
+	hdr := make([]byte, HdrLen)
+	copy(hdr, getGssWrapTokenId()[:])
+	hdr[2] = flags
+	hdr[3] = FillerByte
+	binary.BigEndian.PutUint16(hdr[4:6], ec)
+	binary.BigEndian.PutUint64(hdr[8:16], seqNum)
+	return hdr
+}
+
+// unsealToken decrypts the sealed wrap token and returns its payload once the header encrypted within it has been
+// checked against the token's header.
+func unsealToken(wt WrapToken, tb []byte, key types.EncryptionKey, usage uint32) ([]byte, error) {
+	ct := tb[HdrLen:]
+	if len(ct) > 0 {
+		// Undo the right rotation of the encrypted data
+		r := int(wt.RRC) % len(ct)
+		ct = append(append([]byte{}, ct[r:]...), ct[:r]...)
+	}
+	pt, err := crypto.DecryptMessage(ct, key, usage)
+	if err != nil {
+		return nil, fmt.Errorf("sealed wrap token could not be decrypted: %v", err)
+	}
+	if len(pt) < int(wt.EC)+HdrLen {
+		return nil, errors.New("sealed wrap token is too short to hold its header")
+	}
+	if !hmac.Equal(pt[len(pt)-HdrLen:], sealedTokenHeader(wt.Flags, wt.EC, wt.SndSeqNum)) {
+		return nil, errors.New("header of sealed wrap token does not match the header encrypted within it")
+	}
+	return pt[:len(pt)-HdrLen-int(wt.EC)], nil
+}
+
+// Read reads and unwraps wrap tokens from the underlying connection, returning their verified payload.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.rmux.Lock()
+	defer c.rmux.Unlock()
+	for len(c.rbuf) < 1 {
+		p, err := c.readToken()
+		if err != nil {
+			return 0, err
+		}
+		c.rbuf = p
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// readToken reads the next wrap token from the underlying connection and returns its payload once verified.
+// If the token re-keys the messages from the peer no payload is returned.
+func (c *Conn) readToken() ([]byte, error) {
+	hb := make([]byte, 4)
+	_, err := io.ReadFull(c.Conn, hb)
+	if err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(hb)
+	if l > maxConnTokenSize {
+		return nil, fmt.Errorf("wrap token length %d exceeds the maximum of %d", l, maxConnTokenSize)
+	}
+	tb := make([]byte, l)
+	_, err = io.ReadFull(c.Conn, tb)
+	if err != nil {
+		return nil, err
+	}
+	var wt WrapToken
+	err = wt.Unmarshal(tb, c.ctx.Initiator)
+	if err != nil {
+		return nil, err
+	}
+	usage := uint32(keyusage.GSSAPI_ACCEPTOR_SEAL)
+	if !c.ctx.Initiator {
+		usage = keyusage.GSSAPI_INITIATOR_SEAL
+	}
+	switch {
+	case wt.Flags&0x02 != 0:
+		wt.Payload, err = unsealToken(wt, tb, c.recvKey, usage)
+		if err != nil {
+			return nil, err
+		}
+	case !c.ctx.IntegrityOnly:
+		return nil, errors.New("wrap token is not sealed")
+	case wt.RRC != 0:
+		return nil, errors.New("rotated wrap tokens are not supported")
+	default:
+		ok, err := wt.Verify(c.recvKey, usage)
+		if !ok {
+			return nil, fmt.Errorf("wrap token could not be verified: %v", err)
+		}
+	}
+	if wt.SndSeqNum != c.ctx.RecvSeqNum {
+		return nil, fmt.Errorf("wrap token sequence number %d is not the expected %d", wt.SndSeqNum, c.ctx.RecvSeqNum)
+	}
+	c.ctx.RecvSeqNum++
+	if len(wt.Payload) < 1 {
+		return nil, errors.New("wrap token payload does not hold its type")
+	}
+	p := wt.Payload[1:]
+	switch wt.Payload[0] {
+	case connDataToken:
+		return p, nil
+	case connRekeyToken:
+		if len(p) != rekeyNonceSize {
+			return nil, fmt.Errorf("re-keying nonce is %d bytes rather than %d", len(p), rekeyNonceSize)
+		}
+		k, err := rekeyKey(c.recvKey, p, !c.ctx.Initiator)
+		if err != nil {
+			return nil, fmt.Errorf("error re-keying the connection: %v", err)
+		}
+		c.recvKey = k
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("wrap token payload type %d is not known", wt.Payload[0])
+	}
+}
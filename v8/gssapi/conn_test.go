@@ -0,0 +1,199 @@
+package gssapi
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+	ic, ac := net.Pipe()
+	defer ic.Close()
+	defer ac.Close()
+	initiator := NewConn(ic, MessageContext{Key: getSessionKey(), Initiator: true, SendSeqNum: 10, RecvSeqNum: 20})
+	acceptor := NewConn(ac, MessageContext{Key: getSessionKey(), SendSeqNum: 20, RecvSeqNum: 10})
+
+	go func() {
+		initiator.Write([]byte("hello"))
+		initiator.Write([]byte(" world"))
+	}()
+	b := make([]byte, 11)
+	_, err := io.ReadFull(acceptor, b)
+	if err != nil {
+		t.Fatalf("error reading from acceptor conn: %v", err)
+	}
+	assert.Equal(t, "hello world", string(b), "payload read by acceptor not as expected")
+
+	go acceptor.Write([]byte("reply"))
+	b = make([]byte, 3)
+	n, err := initiator.Read(b)
+	if err != nil {
+		t.Fatalf("error reading from initiator conn: %v", err)
+	}
+	assert.Equal(t, "rep", string(b[:n]), "first payload read by initiator not as expected")
+	n, err = initiator.Read(b)
+	if err != nil {
+		t.Fatalf("error reading from initiator conn: %v", err)
+	}
+	assert.Equal(t, "ly", string(b[:n]), "second payload read by initiator not as expected")
+}
+
+func TestConn_Tampered(t *testing.T) {
+	t.Parallel()
+	ic, ac := net.Pipe()
+	defer ic.Close()
+	defer ac.Close()
+	acceptor := NewConn(ac, MessageContext{Key: getSessionKey(), IntegrityOnly: true})
+
+	wt, err := NewInitiatorWrapToken([]byte("hello"), getSessionKey())
+	if err != nil {
+		t.Fatalf("error creating wrap token: %v", err)
+	}
+	tb, _ := wt.Marshal()
+	tb[HdrLen] = 'j'
+	go func() {
+		hb := make([]byte, 4)
+		binary.BigEndian.PutUint32(hb, uint32(len(tb)))
+		ic.Write(append(hb, tb...))
+	}()
+	_, err = acceptor.Read(make([]byte, 5))
+	assert.Error(t, err, "tampered wrap token should not be read")
+}
+
+func TestConn_Sealed(t *testing.T) {
+	t.Parallel()
+	ic, ac := net.Pipe()
+	defer ic.Close()
+	defer ac.Close()
+	initiator := NewConn(ic, MessageContext{Key: getSessionKey(), Initiator: true})
+
+	// The data is not sent in the clear
+	go initiator.Write([]byte("hello world"))
+	hb := make([]byte, 4)
+	_, err := io.ReadFull(ac, hb)
+	if err != nil {
+		t.Fatalf("error reading token length: %v", err)
+	}
+	tb := make([]byte, binary.BigEndian.Uint32(hb))
+	_, err = io.ReadFull(ac, tb)
+	if err != nil {
+		t.Fatalf("error reading token: %v", err)
+	}
+	assert.Equal(t, byte(0x02), tb[2]&0x02, "sealed flag not set")
+	assert.NotContains(t, string(tb), "hello world", "payload should be encrypted")
+
+	read := func(ctx MessageContext, tb []byte) ([]byte, error) {
+		ic, ac := net.Pipe()
+		defer ic.Close()
+		defer ac.Close()
+		go func() {
+			hb := make([]byte, 4)
+			binary.BigEndian.PutUint32(hb, uint32(len(tb)))
+			ic.Write(append(hb, tb...))
+		}()
+		b := make([]byte, 32)
+		n, err := NewConn(ac, ctx).Read(b)
+		return b[:n], err
+	}
+	b, err := read(MessageContext{Key: getSessionKey()}, tb)
+	if err != nil {
+		t.Fatalf("error reading sealed token: %v", err)
+	}
+	assert.Equal(t, "hello world", string(b), "payload read by acceptor not as expected")
+
+	// Encrypted data rotated by the sender, as Microsoft's implementation does, is read
+	rtb := append([]byte{}, tb[:HdrLen]...)
+	ct := tb[HdrLen:]
+	binary.BigEndian.PutUint16(rtb[6:8], 28)
+	rtb = append(rtb, ct[len(ct)-28:]...)
+	rtb = append(rtb, ct[:len(ct)-28]...)
+	b, err = read(MessageContext{Key: getSessionKey()}, rtb)
+	if err != nil {
+		t.Fatalf("error reading rotated sealed token: %v", err)
+	}
+	assert.Equal(t, "hello world", string(b), "payload of rotated token read by acceptor not as expected")
+
+	// A token whose header differs from that encrypted within it is not read
+	mtb := append([]byte{}, tb...)
+	mtb[15] = 1
+	_, err = read(MessageContext{Key: getSessionKey(), RecvSeqNum: 1}, mtb)
+	assert.Error(t, err, "sealed token with a modified header should not be read")
+
+	mtb = append([]byte{}, tb...)
+	mtb[len(mtb)-1] ^= 0xFF
+	_, err = read(MessageContext{Key: getSessionKey()}, mtb)
+	assert.Error(t, err, "tampered sealed token should not be read")
+
+	// Tokens that are not sealed are only read if the context is integrity only
+	wt, _ := NewInitiatorWrapToken([]byte("\x00hello"), getSessionKey())
+	utb, _ := wt.Marshal()
+	_, err = read(MessageContext{Key: getSessionKey()}, utb)
+	assert.Error(t, err, "token that is not sealed should not be read")
+	b, err = read(MessageContext{Key: getSessionKey(), IntegrityOnly: true}, utb)
+	if err != nil {
+		t.Fatalf("error reading token that is not sealed when integrity only: %v", err)
+	}
+	assert.Equal(t, "hello", string(b), "payload read by integrity only acceptor not as expected")
+
+	// The type of the payload is protected within the token
+	wt, _ = NewInitiatorWrapToken([]byte("\x07hello"), getSessionKey())
+	utb, _ = wt.Marshal()
+	_, err = read(MessageContext{Key: getSessionKey(), IntegrityOnly: true}, utb)
+	assert.Error(t, err, "token with an unknown payload type should not be read")
+}
+
+func TestConn_SequenceNumber(t *testing.T) {
+	t.Parallel()
+	ic, ac := net.Pipe()
+	defer ic.Close()
+	defer ac.Close()
+	initiator := NewConn(ic, MessageContext{Key: getSessionKey(), Initiator: true, SendSeqNum: 1})
+	acceptor := NewConn(ac, MessageContext{Key: getSessionKey(), RecvSeqNum: 0})
+
+	go initiator.Write([]byte("hello"))
+	_, err := acceptor.Read(make([]byte, 5))
+	assert.Error(t, err, "wrap token with an unexpected sequence number should not be read")
+}
+
+func TestConn_Rekey(t *testing.T) {
+	t.Parallel()
+	ic, ac := net.Pipe()
+	defer ic.Close()
+	defer ac.Close()
+	initiator := NewConn(ic, MessageContext{Key: getSessionKey(), Initiator: true, RekeyMessages: 2})
+	acceptor := NewConn(ac, MessageContext{Key: getSessionKey(), RekeyInterval: time.Nanosecond})
+
+	done := make(chan struct{})
+	go func() {
+		for _, m := range []string{"one", "two", "three", "four", "five"} {
+			initiator.Write([]byte(m))
+		}
+		close(done)
+	}()
+	b := make([]byte, 19)
+	_, err := io.ReadFull(acceptor, b)
+	if err != nil {
+		t.Fatalf("error reading from acceptor conn: %v", err)
+	}
+	<-done
+	assert.Equal(t, "onetwothreefourfive", string(b), "payload read by acceptor not as expected")
+	c := initiator.(*Conn)
+	assert.NotEqual(t, getSessionKey().KeyValue, c.sendKey.KeyValue, "initiator send key was not replaced")
+	assert.Equal(t, uint64(7), c.ctx.SendSeqNum, "re-keying tokens should use sequence numbers")
+	assert.Equal(t, c.sendKey, acceptor.(*Conn).recvKey, "acceptor receive key does not match the initiator send key")
+
+	go acceptor.Write([]byte("reply"))
+	b = make([]byte, 5)
+	_, err = io.ReadFull(initiator, b)
+	if err != nil {
+		t.Fatalf("error reading from initiator conn: %v", err)
+	}
+	assert.Equal(t, "reply", string(b), "payload read by initiator not as expected")
+	assert.NotEqual(t, acceptor.(*Conn).sendKey, c.sendKey, "each direction should be re-keyed independently")
+	assert.Equal(t, acceptor.(*Conn).sendKey, c.recvKey, "initiator receive key does not match the acceptor send key")
+}
@@ -0,0 +1,38 @@
+package gssapi
+
+import (
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+)
+
+// ContextAttributes holds the attributes of an established security context.
+// This mirrors the outputs of GSS_Inquire_context: https://tools.ietf.org/html/rfc2743#section-2.2.6
+type ContextAttributes struct {
+	InitiatorName    string
+	TargetName       string
+	Expiry           time.Time
+	Mech             asn1.ObjectIdentifier
+	Flags            uint32
+	LocallyInitiated bool
+	Open             bool
+	// ConfAvail indicates if per-message confidentiality (Wrap with confidentiality) is available with the context.
+	ConfAvail bool
+	// IntegAvail indicates if per-message integrity (GetMIC and Wrap) is available with the context.
+	IntegAvail bool
+}
+
+// Lifetime returns the remaining lifetime of the security context.
+// Zero is returned if the context has expired.
+func (a ContextAttributes) Lifetime() time.Duration {
+	d := time.Until(a.Expiry)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// IsFlagSet tests if the context flag provided, for example ContextFlagMutual, is set on the security context.
+func (a ContextAttributes) IsFlagSet(f int) bool {
+	return a.Flags&uint32(f) != 0
+}
@@ -13,6 +13,7 @@ const (
 	// GSS-API OID names
 	OIDKRB5         OIDName = "KRB5"         // MechType OID for Kerberos 5
 	OIDMSLegacyKRB5 OIDName = "MSLegacyKRB5" // MechType OID for Kerberos 5
+	OIDKRB5U2U      OIDName = "KRB5U2U"      // MechType OID for Kerberos 5 user-to-user
 	OIDSPNEGO       OIDName = "SPNEGO"
 	OIDGSSIAKerb    OIDName = "GSSIAKerb" // Indicates the client cannot get a service ticket and asks the server to serve as an intermediate to the target KDC. http://k5wiki.kerberos.org/wiki/Projects/IAKERB#IAKERB_mech
 )
@@ -46,6 +47,13 @@ const (
 	StatusGapToken
 )
 
+// GSS-API credential usage values, as the cred_usage input to GSS_Acquire_cred: https://tools.ietf.org/html/rfc2743#section-2.1.1
+const (
+	CredUsageInitiateAndAccept = iota // credential may be used both to initiate and to accept security contexts
+	CredUsageInitiateOnly             // credential may only be used to initiate security contexts
+	CredUsageAcceptOnly               // credential may only be used to accept security contexts
+)
+
 // ContextToken is an interface for a GSS-API context token.
 type ContextToken interface {
 	Marshal() ([]byte, error)
@@ -126,6 +134,8 @@ func (o OIDName) OID() asn1.ObjectIdentifier {
 		return asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2}
 	case OIDMSLegacyKRB5:
 		return asn1.ObjectIdentifier{1, 2, 840, 48018, 1, 2, 2}
+	case OIDKRB5U2U:
+		return asn1.ObjectIdentifier{1, 2, 840, 113554, 1, 2, 2, 3}
 	case OIDGSSIAKerb:
 		return asn1.ObjectIdentifier{1, 3, 6, 1, 5, 2, 5}
 	}
@@ -3,12 +3,14 @@ package keytab
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 	"unsafe"
@@ -93,11 +95,64 @@ func (kt *Keytab) GetEncryptionKey(princName types.PrincipalName, realm string,
 		}
 	}
 	if len(key.KeyValue) < 1 {
-		return key, 0, fmt.Errorf("matching key not found in keytab. Looking for %q realm: %v kvno: %v etype: %v", princName.PrincipalNameString(), realm, kvno, etype)
+		return key, 0, fmt.Errorf("matching key not found in keytab. Looking for %q realm: %v kvno: %v etype: %v%s", princName.PrincipalNameString(), realm, kvno, etype, kt.principalKeys(princName, realm))
 	}
 	return key, kv, nil
 }
 
+// principalKeys describes the kvno and etype of the keys held in the keytab for the principal, to explain why a
+// key lookup did not match.
+func (kt *Keytab) principalKeys(princName types.PrincipalName, realm string) string {
+	var keys []string
+	for _, k := range kt.Entries {
+		if k.Principal.Realm == realm && strings.Join(k.Principal.Components, "/") == strings.Join(princName.NameString, "/") {
+			keys = append(keys, fmt.Sprintf("kvno: %d etype: %d", k.KVNO, k.Key.KeyType))
+		}
+	}
+	if len(keys) < 1 {
+		return ". No keys found for the principal"
+	}
+	return ". Keys found for the principal: " + strings.Join(keys, ", ")
+}
+
+// SupportedETypes returns the distinct encryption types of the keys held in the keytab in ascending order.
+func (kt *Keytab) SupportedETypes() []int {
+	var ets []int
+	for _, e := range kt.Entries {
+		et := int(e.Key.KeyType)
+		var found bool
+		for _, i := range ets {
+			if i == et {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ets = append(ets, et)
+		}
+	}
+	sort.Ints(ets)
+	return ets
+}
+
+// ValidateETypes checks that the keytab holds keys for all of the encryption types specified, for example those the
+// KDC issues service tickets with. An error describing the missing encryption types is returned if not so that a
+// misconfigured keytab can be detected before clients fail to authenticate.
+func (kt *Keytab) ValidateETypes(etypes []int32) error {
+	ets := kt.SupportedETypes()
+	var missing []int32
+	for _, et := range etypes {
+		i := sort.SearchInts(ets, int(et))
+		if i >= len(ets) || ets[i] != int(et) {
+			missing = append(missing, et)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("keytab does not contain keys for etypes %v, it only contains keys for etypes %v", missing, ets)
+	}
+	return nil
+}
+
 // Create a new Keytab entry.
 func newEntry() entry {
 	var b []byte
@@ -136,9 +191,6 @@ func (kt *Keytab) AddEntry(principalName, realm, password string, ts time.Time,
 	// Populate the keytab entry principal
 	ktep := newPrincipal()
 	ktep.NumComponents = int16(len(princ.NameString))
-	if kt.version == 1 {
-		ktep.NumComponents += 1
-	}
 
 	ktep.Realm = realm
 	ktep.Components = princ.NameString
@@ -178,6 +230,28 @@ func Load(ktPath string) (*Keytab, error) {
 	return kt, err
 }
 
+// FromBase64 creates a Keytab type from the base64 encoding of a keytab's bytes.
+// Any whitespace, such as line breaks introduced by encoding tools, is ignored.
+func FromBase64(s string) (*Keytab, error) {
+	kt := new(Keytab)
+	s = strings.Join(strings.Fields(s), "")
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return kt, fmt.Errorf("error base64 decoding keytab: %v", err)
+	}
+	err = kt.Unmarshal(b)
+	return kt, err
+}
+
+// FromEnv creates a Keytab type from the base64 encoded keytab held in the named environment variable.
+func FromEnv(name string) (*Keytab, error) {
+	s, ok := os.LookupEnv(name)
+	if !ok || s == "" {
+		return new(Keytab), fmt.Errorf("environment variable %s is not set", name)
+	}
+	return FromBase64(s)
+}
+
 // Marshal keytab into byte slice
 func (kt *Keytab) Marshal() ([]byte, error) {
 	b := []byte{keytabFirstByte, kt.version}
@@ -191,6 +265,12 @@ func (kt *Keytab) Marshal() ([]byte, error) {
 	return b, nil
 }
 
+// Version returns the file format version of the keytab, 1 or 2. Version 1 keytabs, as generated by older versions of
+// ktutil, use native byte order whereas version 2 keytabs always use big-endian byte order.
+func (kt *Keytab) Version() uint8 {
+	return kt.version
+}
+
 // Write the keytab bytes to io.Writer.
 // Returns the number of bytes written
 func (kt *Keytab) Write(w io.Writer) (int, error) {
@@ -391,7 +471,12 @@ func (p principal) marshal(v int) ([]byte, error) {
 	if v == 1 && isNativeEndianLittle() {
 		endian = binary.LittleEndian
 	}
-	endian.PutUint16(b[0:], uint16(p.NumComponents))
+	nc := p.NumComponents
+	if v == 1 {
+		//In version 1 the number of components includes the realm
+		nc++
+	}
+	endian.PutUint16(b[0:], uint16(nc))
 	realm, err := marshalString(p.Realm, v)
 	if err != nil {
 		return b, err
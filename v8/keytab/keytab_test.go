@@ -1,6 +1,7 @@
 package keytab
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
@@ -55,6 +56,75 @@ func TestMarshal(t *testing.T) {
 	}
 }
 
+func TestUnmarshal_Version1(t *testing.T) {
+	t.Parallel()
+	// Version 1 keytabs use native byte order, the number of components includes the realm and there is no name type
+	var e binary.ByteOrder = binary.BigEndian
+	if isNativeEndianLittle() {
+		e = binary.LittleEndian
+	}
+	i16 := func(i uint16) []byte {
+		b := make([]byte, 2)
+		e.PutUint16(b, i)
+		return b
+	}
+	i32 := func(i uint32) []byte {
+		b := make([]byte, 4)
+		e.PutUint32(b, i)
+		return b
+	}
+	eb := i16(2)
+	for _, s := range []string{"TEST.GOKRB5", "testuser1"} {
+		eb = append(eb, i16(uint16(len(s)))...)
+		eb = append(eb, s...)
+	}
+	eb = append(eb, i32(1500000000)...)
+	eb = append(eb, 3)
+	eb = append(eb, i16(18)...)
+	eb = append(eb, i16(32)...)
+	eb = append(eb, bytes.Repeat([]byte{0x01}, 32)...)
+	b := append([]byte{5, 1}, i32(uint32(len(eb)))...)
+	b = append(b, eb...)
+
+	kt := New()
+	err := kt.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Error parsing version 1 keytab data: %v\n", err)
+	}
+	assert.Equal(t, uint8(1), kt.Version(), "keytab version not as expected")
+	if assert.Equal(t, 1, len(kt.Entries), "number of entries not as expected") {
+		assert.Equal(t, "testuser1@TEST.GOKRB5", kt.Entries[0].Principal.String(), "principal not as expected")
+		assert.Equal(t, int16(1), kt.Entries[0].Principal.NumComponents, "number of components in principal not as expected")
+		assert.Equal(t, uint32(3), kt.Entries[0].KVNO, "KVNO not as expected")
+		assert.Equal(t, int32(18), kt.Entries[0].Key.KeyType, "key type not as expected")
+		assert.Equal(t, bytes.Repeat([]byte{0x01}, 32), kt.Entries[0].Key.KeyValue, "key not as expected")
+	}
+
+	// The version 1 format is kept when marshaled
+	err = kt.AddEntry("testuser2", "TEST.GOKRB5", "passwordvalue", time.Unix(1500000000, 0), 1, 18)
+	if err != nil {
+		t.Fatalf("error adding entry: %v", err)
+	}
+	mb, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("Error marshaling: %v", err)
+	}
+	assert.Equal(t, []byte{5, 1}, mb[:2], "marshaled keytab version not as expected")
+	assert.Equal(t, i16(2), mb[6:8], "marshaled number of components should include the realm")
+	mkt := New()
+	err = mkt.Unmarshal(mb)
+	if err != nil {
+		t.Fatalf("Error parsing marshaled bytes: %v", err)
+	}
+	if assert.Equal(t, 2, len(mkt.Entries), "number of entries not as expected after marshaling") {
+		assert.Equal(t, kt.Entries[0], mkt.Entries[0], "entry not as expected after marshaling")
+		// The name type is not held in the version 1 format
+		assert.Equal(t, kt.Entries[1].Principal.String(), mkt.Entries[1].Principal.String(), "principal not as expected after marshaling")
+		assert.Equal(t, kt.Entries[1].Key, mkt.Entries[1].Key, "key not as expected after marshaling")
+	}
+	assert.Equal(t, uint8(2), New().Version(), "new keytabs should be version 2")
+}
+
 func TestLoad(t *testing.T) {
 	t.Parallel()
 	f := "test/testdata/testuser1.testtab"
@@ -99,6 +169,42 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestFromBase64(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.KEYTAB_TESTUSER1_TEST_GOKRB5)
+	s := base64.StdEncoding.EncodeToString(b)
+	kt, err := FromBase64(s)
+	if err != nil {
+		t.Fatalf("error loading keytab from base64: %v", err)
+	}
+	assert.Equal(t, "testuser1", kt.Entries[0].Principal.Components[0], "Component in principal not as expected")
+
+	// Line breaks as produced by base64 tools should be tolerated
+	kt, err = FromBase64(s[:20] + "\n" + s[20:] + "\n")
+	if err != nil {
+		t.Fatalf("error loading keytab from base64 with line breaks: %v", err)
+	}
+	mb, _ := kt.Marshal()
+	assert.Equal(t, b, mb, "keytab bytes not as expected")
+
+	_, err = FromBase64("not base64!")
+	assert.Error(t, err, "expected error for invalid base64")
+}
+
+func TestFromEnv(t *testing.T) {
+	b, _ := hex.DecodeString(testdata.KEYTAB_TESTUSER1_TEST_GOKRB5)
+	os.Setenv("GOKRB5_TEST_KEYTAB", base64.StdEncoding.EncodeToString(b))
+	defer os.Unsetenv("GOKRB5_TEST_KEYTAB")
+	kt, err := FromEnv("GOKRB5_TEST_KEYTAB")
+	if err != nil {
+		t.Fatalf("error loading keytab from environment variable: %v", err)
+	}
+	assert.Equal(t, "TEST.GOKRB5", kt.Entries[0].Principal.Realm, "Realm of principal not as expected")
+
+	_, err = FromEnv("GOKRB5_TEST_KEYTAB_NOT_SET")
+	assert.Error(t, err, "expected error for unset environment variable")
+}
+
 // This test provides inputs to readBytes that previously
 // caused a panic.
 func TestReadBytes(t *testing.T) {
@@ -248,4 +354,31 @@ func TestKeytab_GetEncryptionKey(t *testing.T) {
 		t.Error(err)
 	}
 	assert.Equal(t, 3, kvno)
+	_, _, err = kt.GetEncryptionKey(pn, realm, 6, 17)
+	if assert.Error(t, err, "no key should match") {
+		assert.Contains(t, err.Error(), "kvno: 1 etype: 18, kvno: 2 etype: 18", "error does not list the keys held for the principal")
+	}
+}
+
+func TestKeytab_SupportedETypes(t *testing.T) {
+	t.Parallel()
+	princ := "HTTP/princ.test.gokrb5"
+	realm := "TEST.GOKRB5"
+
+	kt := New()
+	kt.AddEntry(princ, realm, "abcdefg", time.Unix(100, 0), 1, 23)
+	kt.AddEntry(princ, realm, "abcdefg", time.Unix(100, 0), 1, 18)
+	kt.AddEntry(princ, realm, "abcdefg", time.Unix(200, 0), 2, 18)
+	kt.AddEntry(princ, realm, "abcdefg", time.Unix(200, 0), 2, 17)
+
+	assert.Equal(t, []int{17, 18, 23}, kt.SupportedETypes(), "supported etypes not as expected")
+	assert.NoError(t, kt.ValidateETypes([]int32{18, 17}), "keytab should be valid for etypes held")
+
+	kt = New()
+	kt.AddEntry(princ, realm, "abcdefg", time.Unix(100, 0), 1, 23)
+	err := kt.ValidateETypes([]int32{18, 23})
+	if assert.Error(t, err, "keytab with only RC4 keys should not be valid for AES256") {
+		assert.Contains(t, err.Error(), "[18]", "error should list the missing etype")
+	}
+	assert.Error(t, New().ValidateETypes([]int32{18}), "empty keytab should not be valid")
 }
@@ -5,7 +5,11 @@ import (
 	"time"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana"
 	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/krberror"
 	"github.com/jcmturner/gokrb5/v8/types"
@@ -26,6 +30,28 @@ type EncAPRepPart struct {
 	SequenceNumber int64               `asn1:"optional,explicit,tag:3"`
 }
 
+// NewAPRep generates a new KRB_AP_REP struct in reply to the authenticator of a verified AP_REQ, providing mutual
+// authentication to the client. The encrypted part is encrypted with the session key of the ticket.
+func NewAPRep(sessionKey types.EncryptionKey, auth types.Authenticator) (APRep, error) {
+	e := EncAPRepPart{
+		CTime: auth.CTime,
+		Cusec: auth.Cusec,
+	}
+	b, err := e.Marshal()
+	if err != nil {
+		return APRep{}, err
+	}
+	ed, err := crypto.GetEncryptedData(b, sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return APRep{}, krberror.Errorf(err, krberror.EncryptingError, "error encrypting AP_REP encrypted part")
+	}
+	return APRep{
+		PVNO:    iana.PVNO,
+		MsgType: msgtype.KRB_AP_REP,
+		EncPart: ed,
+	}, nil
+}
+
 // Unmarshal bytes b into the APRep struct.
 func (a *APRep) Unmarshal(b []byte) error {
 	_, err := asn1.UnmarshalWithParams(b, a, fmt.Sprintf("application,explicit,tag:%v", asnAppTag.APREP))
@@ -47,3 +73,23 @@ func (a *EncAPRepPart) Unmarshal(b []byte) error {
 	}
 	return nil
 }
+
+// Marshal the APRep struct.
+func (a *APRep) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*a)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "marshaling error of AP_REP")
+	}
+	b = asn1tools.AddASNAppTag(b, asnAppTag.APREP)
+	return b, nil
+}
+
+// Marshal the APRep encrypted part struct.
+func (a *EncAPRepPart) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*a)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "marshaling error of AP_REP encrypted part")
+	}
+	b = asn1tools.AddASNAppTag(b, asnAppTag.EncAPRepPart)
+	return b, nil
+}
@@ -5,9 +5,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -67,3 +72,72 @@ func TestUnmarshalEncAPRepPart_optionalsNULL(t *testing.T) {
 	assert.Equal(t, tt, a.CTime, "CTime not as expected")
 	assert.Equal(t, 123456, a.Cusec, "Client microseconds not as expected")
 }
+
+func TestMarshalAPRep(t *testing.T) {
+	t.Parallel()
+	var a APRep
+	b, err := hex.DecodeString(testdata.MarshaledKRB5ap_rep)
+	if err != nil {
+		t.Fatalf("Test vector read error: %v", err)
+	}
+	err = a.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	mb, err := a.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal of AP_REP errored: %v", err)
+	}
+	assert.Equal(t, b, mb, "Marshal bytes of AP_REP not as expected")
+}
+
+func TestMarshalEncAPRepPart(t *testing.T) {
+	t.Parallel()
+	for _, v := range []string{testdata.MarshaledKRB5ap_rep_enc_part, testdata.MarshaledKRB5ap_rep_enc_partOptionalsNULL} {
+		var a EncAPRepPart
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			t.Fatalf("Test vector read error: %v", err)
+		}
+		err = a.Unmarshal(b)
+		if err != nil {
+			t.Fatalf("Unmarshal error: %v", err)
+		}
+		mb, err := a.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal of AP_REP encrypted part errored: %v", err)
+		}
+		assert.Equal(t, b, mb, "Marshal bytes of AP_REP encrypted part not as expected")
+	}
+}
+
+func TestNewAPRep(t *testing.T) {
+	t.Parallel()
+	et, _ := crypto.GetEtype(etypeID.AES256_CTS_HMAC_SHA1_96)
+	sessionKey, _ := types.GenerateEncryptionKey(et)
+	auth, _ := types.NewAuthenticator(testdata.TEST_REALM, types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"))
+	a, err := NewAPRep(sessionKey, auth)
+	if err != nil {
+		t.Fatalf("error creating AP_REP: %v", err)
+	}
+	b, err := a.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REP: %v", err)
+	}
+	var r APRep
+	err = r.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("error unmarshaling AP_REP: %v", err)
+	}
+	db, err := crypto.DecryptEncPart(r.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		t.Fatalf("error decrypting AP_REP encrypted part: %v", err)
+	}
+	var e EncAPRepPart
+	err = e.Unmarshal(db)
+	if err != nil {
+		t.Fatalf("error unmarshaling AP_REP encrypted part: %v", err)
+	}
+	assert.True(t, auth.CTime.Equal(e.CTime), "CTime not that of the authenticator")
+	assert.Equal(t, auth.Cusec, e.Cusec, "Cusec not that of the authenticator")
+}
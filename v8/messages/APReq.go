@@ -37,7 +37,10 @@ type APReq struct {
 }
 
 // NewAPReq generates a new KRB_AP_REQ struct.
-func NewAPReq(tkt Ticket, sessionKey types.EncryptionKey, auth types.Authenticator) (APReq, error) {
+// Only the ticket and its session key are required so AP_REQs can be built from ticket material held directly, for
+// example from S4U2Proxy or a cache, without a client. Any AP options provided are set in the AP_REQ,
+// eg. flags.APOptionMutualRequired.
+func NewAPReq(tkt Ticket, sessionKey types.EncryptionKey, auth types.Authenticator, apOptions ...int) (APReq, error) {
 	var a APReq
 	ed, err := encryptAuthenticator(auth, sessionKey, tkt)
 	if err != nil {
@@ -50,6 +53,9 @@ func NewAPReq(tkt Ticket, sessionKey types.EncryptionKey, auth types.Authenticat
 		Ticket:                 tkt,
 		EncryptedAuthenticator: ed,
 	}
+	for _, o := range apOptions {
+		types.SetFlag(&a.APOptions, o)
+	}
 	return a, nil
 }
 
@@ -84,7 +90,7 @@ func (a *APReq) DecryptAuthenticator(sessionKey types.EncryptionKey) error {
 }
 
 func authenticatorKeyUsage(pn types.PrincipalName) int {
-	if pn.NameString[0] == "krbtgt" {
+	if len(pn.NameString) > 0 && pn.NameString[0] == "krbtgt" {
 		return keyusage.TGS_REQ_PA_TGS_REQ_AP_REQ_AUTHENTICATOR
 	}
 	return keyusage.AP_REQ_AUTHENTICATOR
@@ -3,11 +3,16 @@ package messages
 import (
 	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/jcmturner/gokrb5/v8/iana"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -52,3 +57,47 @@ func TestMarshalAPReq(t *testing.T) {
 	}
 	assert.Equal(t, b, mb, "Marshal bytes of Authenticator not as expected")
 }
+
+func TestNewAPReq(t *testing.T) {
+	t.Parallel()
+	kt := keytab.New()
+	kt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "passwordvalue", time.Now(), 1, 18)
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	now := time.Now().UTC()
+	tkt, sessionKey, err := NewTicket(cname, "TEST.GOKRB5", sname, "TEST.GOKRB5", types.NewKrbFlags(), kt, 18, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error creating ticket: %v", err)
+	}
+	auth, err := types.NewAuthenticator("TEST.GOKRB5", cname)
+	if err != nil {
+		t.Fatalf("error creating authenticator: %v", err)
+	}
+	a, err := NewAPReq(tkt, sessionKey, auth, flags.APOptionMutualRequired)
+	if err != nil {
+		t.Fatalf("error creating AP_REQ: %v", err)
+	}
+	b, err := a.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REQ: %v", err)
+	}
+	var u APReq
+	err = u.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("error unmarshaling AP_REQ: %v", err)
+	}
+	assert.True(t, types.IsFlagSet(&u.APOptions, flags.APOptionMutualRequired), "mutual required AP option not set")
+	assert.False(t, types.IsFlagSet(&u.APOptions, flags.APOptionUseSessionKey), "use session key AP option should not be set")
+	err = u.DecryptAuthenticator(sessionKey)
+	if err != nil {
+		t.Fatalf("error decrypting authenticator: %v", err)
+	}
+	assert.Equal(t, cname.NameString, u.Authenticator.CName.NameString, "authenticator cname not as expected")
+}
+
+func TestAuthenticatorKeyUsage(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, keyusage.TGS_REQ_PA_TGS_REQ_AP_REQ_AUTHENTICATOR, authenticatorKeyUsage(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")), "key usage for krbtgt not as expected")
+	assert.Equal(t, keyusage.AP_REQ_AUTHENTICATOR, authenticatorKeyUsage(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")), "key usage for service not as expected")
+	assert.Equal(t, keyusage.AP_REQ_AUTHENTICATOR, authenticatorKeyUsage(types.PrincipalName{}), "key usage for empty principal name not as expected")
+}
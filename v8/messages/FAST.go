@@ -0,0 +1,325 @@
+package messages
+
+// Reference: https://tools.ietf.org/html/rfc6113
+// Section: 5.4
+
+import (
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/krberror"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// FX_FAST_ARMOR_AP_REQUEST is the armor type of a KrbFastArmor carrying an AP_REQ: https://tools.ietf.org/html/rfc6113#section-5.4.1.1
+const FX_FAST_ARMOR_AP_REQUEST int32 = 1
+
+// KrbFastArmor implements RFC 6113 KrbFastArmor: https://tools.ietf.org/html/rfc6113#section-5.4.1
+type KrbFastArmor struct {
+	ArmorType  int32  `asn1:"explicit,tag:0"`
+	ArmorValue []byte `asn1:"explicit,tag:1"`
+}
+
+// KrbFastArmoredReq implements RFC 6113 KrbFastArmoredReq: https://tools.ietf.org/html/rfc6113#section-5.4.2
+type KrbFastArmoredReq struct {
+	Armor       KrbFastArmor        `asn1:"explicit,optional,tag:0"`
+	ReqChecksum types.Checksum      `asn1:"explicit,tag:1"`
+	EncFastReq  types.EncryptedData `asn1:"explicit,tag:2"`
+}
+
+// KrbFastReq implements RFC 6113 KrbFastReq: https://tools.ietf.org/html/rfc6113#section-5.4.2
+type KrbFastReq struct {
+	FastOptions asn1.BitString       `asn1:"explicit,tag:0"`
+	PAData      types.PADataSequence `asn1:"explicit,tag:1"`
+	ReqBody     KDCReqBody           `asn1:"explicit,tag:2"`
+}
+
+type marshalKrbFastReq struct {
+	FastOptions asn1.BitString       `asn1:"explicit,tag:0"`
+	PAData      types.PADataSequence `asn1:"explicit,tag:1"`
+	ReqBody     asn1.RawValue        `asn1:"explicit,tag:2"`
+}
+
+// KrbFastArmoredRep implements RFC 6113 KrbFastArmoredRep: https://tools.ietf.org/html/rfc6113#section-5.4.3
+type KrbFastArmoredRep struct {
+	EncFastRep types.EncryptedData `asn1:"explicit,tag:0"`
+}
+
+// KrbFastResponse implements RFC 6113 KrbFastResponse: https://tools.ietf.org/html/rfc6113#section-5.4.3
+type KrbFastResponse struct {
+	PAData        types.PADataSequence `asn1:"explicit,tag:0"`
+	StrengthenKey types.EncryptionKey  `asn1:"explicit,optional,tag:1"`
+	Finished      KrbFastFinished      `asn1:"explicit,optional,tag:2"`
+	Nonce         int                  `asn1:"explicit,tag:3"`
+}
+
+// KrbFastFinished implements RFC 6113 KrbFastFinished: https://tools.ietf.org/html/rfc6113#section-5.4.3
+type KrbFastFinished struct {
+	Timestamp      time.Time           `asn1:"generalized,explicit,tag:0"`
+	Usec           int                 `asn1:"explicit,tag:1"`
+	CRealm         string              `asn1:"generalstring,explicit,tag:2"`
+	CName          types.PrincipalName `asn1:"explicit,tag:3"`
+	TicketChecksum types.Checksum      `asn1:"explicit,tag:4"`
+}
+
+// NewKrbFastArmoredReq creates the armored request to carry in the PA-FX-FAST padata of a KDC request, as defined in
+// RFC 6113 section 5.4.2. The inner request is encrypted with the armor key and the checksum is formed over the
+// request body of the outer KDC request.
+func NewKrbFastArmoredReq(armor KrbFastArmor, armorKey types.EncryptionKey, fastReq KrbFastReq, outerBody KDCReqBody) (KrbFastArmoredReq, error) {
+	var a KrbFastArmoredReq
+	etype, err := crypto.GetEtype(armorKey.KeyType)
+	if err != nil {
+		return a, krberror.Errorf(err, krberror.EncryptingError, "error getting etype of the FAST armor key")
+	}
+	bb, err := outerBody.Marshal()
+	if err != nil {
+		return a, krberror.Errorf(err, krberror.EncodingError, "error marshaling KDC request body for the FAST request checksum")
+	}
+	cb, err := etype.GetChecksumHash(armorKey.KeyValue, bb, keyusage.KEY_USAGE_FAST_REQ_CHKSUM)
+	if err != nil {
+		return a, krberror.Errorf(err, krberror.ChksumError, "error getting FAST request checksum")
+	}
+	fb, err := fastReq.Marshal()
+	if err != nil {
+		return a, err
+	}
+	ed, err := crypto.GetEncryptedData(fb, armorKey, keyusage.KEY_USAGE_FAST_ENC, 0)
+	if err != nil {
+		return a, krberror.Errorf(err, krberror.EncryptingError, "error encrypting FAST request")
+	}
+	a = KrbFastArmoredReq{
+		Armor: armor,
+		ReqChecksum: types.Checksum{
+			CksumType: etype.GetHashID(),
+			Checksum:  cb,
+		},
+		EncFastReq: ed,
+	}
+	return a, nil
+}
+
+// Unmarshal bytes b into the PA-FX-FAST-REQUEST armored-data choice.
+func (a *KrbFastArmoredReq) Unmarshal(b []byte) error {
+	// PA-FX-FAST-REQUEST is a choice with the only option being armored-data [0] KrbFastArmoredReq
+	var r asn1.RawValue
+	_, err := asn1.Unmarshal(b, &r)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling PA-FX-FAST-REQUEST")
+	}
+	if r.Class != asn1.ClassContextSpecific || r.Tag != 0 {
+		return krberror.NewErrorf(krberror.EncodingError, "PA-FX-FAST-REQUEST contains an unsupported choice. Class: %d; Tag: %d", r.Class, r.Tag)
+	}
+	_, err = asn1.Unmarshal(r.Bytes, a)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KrbFastArmoredReq")
+	}
+	return nil
+}
+
+// Marshal the KrbFastArmoredReq as the armored-data choice of a PA-FX-FAST-REQUEST.
+func (a *KrbFastArmoredReq) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*a)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling KrbFastArmoredReq")
+	}
+	r := asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		IsCompound: true,
+		Tag:        0,
+		Bytes:      b,
+	}
+	b, err = asn1.Marshal(r)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling PA-FX-FAST-REQUEST")
+	}
+	return b, nil
+}
+
+// Decrypt the encrypted FAST request with the armor key.
+func (a *KrbFastArmoredReq) Decrypt(armorKey types.EncryptionKey) (KrbFastReq, error) {
+	var r KrbFastReq
+	b, err := crypto.DecryptEncPart(a.EncFastReq, armorKey, keyusage.KEY_USAGE_FAST_ENC)
+	if err != nil {
+		return r, krberror.Errorf(err, krberror.DecryptingError, "error decrypting FAST request")
+	}
+	err = r.Unmarshal(b)
+	return r, err
+}
+
+// Unmarshal bytes b into the KrbFastReq struct.
+func (r *KrbFastReq) Unmarshal(b []byte) error {
+	var m marshalKrbFastReq
+	_, err := asn1.Unmarshal(b, &m)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KrbFastReq")
+	}
+	var reqb KDCReqBody
+	err = reqb.Unmarshal(m.ReqBody.Bytes)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error processing KrbFastReq body")
+	}
+	r.FastOptions = m.FastOptions
+	r.PAData = m.PAData
+	r.ReqBody = reqb
+	return nil
+}
+
+// Marshal the KrbFastReq into bytes.
+func (r *KrbFastReq) Marshal() ([]byte, error) {
+	m := marshalKrbFastReq{
+		FastOptions: r.FastOptions,
+		PAData:      r.PAData,
+	}
+	b, err := r.ReqBody.Marshal()
+	if err != nil {
+		return b, err
+	}
+	m.ReqBody = asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		IsCompound: true,
+		Tag:        2,
+		Bytes:      b,
+	}
+	b, err = asn1.Marshal(m)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling KrbFastReq")
+	}
+	return b, nil
+}
+
+// Unmarshal bytes b into the PA-FX-FAST-REPLY armored-data choice.
+func (a *KrbFastArmoredRep) Unmarshal(b []byte) error {
+	// PA-FX-FAST-REPLY is a choice with the only option being armored-data [0] KrbFastArmoredRep
+	var r asn1.RawValue
+	_, err := asn1.Unmarshal(b, &r)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling PA-FX-FAST-REPLY")
+	}
+	if r.Class != asn1.ClassContextSpecific || r.Tag != 0 {
+		return krberror.NewErrorf(krberror.EncodingError, "PA-FX-FAST-REPLY contains an unsupported choice. Class: %d; Tag: %d", r.Class, r.Tag)
+	}
+	_, err = asn1.Unmarshal(r.Bytes, a)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KrbFastArmoredRep")
+	}
+	return nil
+}
+
+// Marshal the KrbFastArmoredRep as the armored-data choice of a PA-FX-FAST-REPLY.
+func (a *KrbFastArmoredRep) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*a)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling KrbFastArmoredRep")
+	}
+	r := asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		IsCompound: true,
+		Tag:        0,
+		Bytes:      b,
+	}
+	b, err = asn1.Marshal(r)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling PA-FX-FAST-REPLY")
+	}
+	return b, nil
+}
+
+// Decrypt the encrypted FAST response with the armor key.
+func (a *KrbFastArmoredRep) Decrypt(armorKey types.EncryptionKey) (KrbFastResponse, error) {
+	var r KrbFastResponse
+	b, err := crypto.DecryptEncPart(a.EncFastRep, armorKey, keyusage.KEY_USAGE_FAST_REP)
+	if err != nil {
+		return r, krberror.Errorf(err, krberror.DecryptingError, "error decrypting FAST response")
+	}
+	err = r.Unmarshal(b)
+	return r, err
+}
+
+// Unmarshal bytes b into the KrbFastResponse struct.
+func (r *KrbFastResponse) Unmarshal(b []byte) error {
+	_, err := asn1.Unmarshal(b, r)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KrbFastResponse")
+	}
+	return nil
+}
+
+// Marshal the KrbFastResponse into bytes.
+func (r *KrbFastResponse) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*r)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling KrbFastResponse")
+	}
+	return b, nil
+}
+
+// IsFASTArmored indicates if the KRBError's e-data carries a PA-FX-FAST armored response.
+//
+// When FAST is in use the KDC returns the actual error encrypted within the armored response.
+func (k *KRBError) IsFASTArmored() bool {
+	if len(k.EData) < 1 {
+		return false
+	}
+	var pas types.PADataSequence
+	if err := pas.Unmarshal(k.EData); err != nil {
+		return false
+	}
+	return pas.Contains(patype.PA_FX_FAST)
+}
+
+// DecryptFASTError decrypts the armored FAST response carried in the KRBError's e-data using the armor key and
+// returns the KRBError the KDC placed in the PA-FX-ERROR padata.
+//
+// The PA data from the FAST response, other than the PA-FX-ERROR, is placed in the e-data of the returned KRBError
+// so that it can be processed in the same way as an unarmored KRBError, for example to find the ETYPE-INFO2.
+func (k *KRBError) DecryptFASTError(armorKey types.EncryptionKey) (KRBError, error) {
+	var krberr KRBError
+	var pas types.PADataSequence
+	if err := pas.Unmarshal(k.EData); err != nil {
+		return krberr, krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KRBError e-data")
+	}
+	var armored KrbFastArmoredRep
+	var found bool
+	for _, pa := range pas {
+		if pa.PADataType == patype.PA_FX_FAST {
+			if err := armored.Unmarshal(pa.PADataValue); err != nil {
+				return krberr, err
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return krberr, krberror.NewErrorf(krberror.KRBMsgError, "KRBError does not contain a FAST armored response")
+	}
+	fastRep, err := armored.Decrypt(armorKey)
+	if err != nil {
+		return krberr, err
+	}
+	var other types.PADataSequence
+	found = false
+	for _, pa := range fastRep.PAData {
+		if pa.PADataType == patype.PA_FX_ERROR {
+			if err := krberr.Unmarshal(pa.PADataValue); err != nil {
+				return krberr, krberror.Errorf(err, krberror.EncodingError, "error unmarshaling the PA-FX-ERROR KRBError")
+			}
+			found = true
+			continue
+		}
+		other = append(other, pa)
+	}
+	if !found {
+		return krberr, krberror.NewErrorf(krberror.KRBMsgError, "FAST response does not contain a PA-FX-ERROR")
+	}
+	if len(other) > 0 {
+		b, err := asn1.Marshal(other)
+		if err != nil {
+			return krberr, krberror.Errorf(err, krberror.EncodingError, "error marshaling FAST response PA data")
+		}
+		krberr.EData = b
+	}
+	return krberr, nil
+}
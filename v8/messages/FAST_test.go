@@ -0,0 +1,90 @@
+package messages
+
+import (
+	"testing"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func armoredKRBError(t *testing.T, armorKey types.EncryptionKey, inner KRBError, pas types.PADataSequence) KRBError {
+	ib, err := inner.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling inner KRBError: %v", err)
+	}
+	fastRep := KrbFastResponse{
+		PAData: append(pas, types.PAData{PADataType: patype.PA_FX_ERROR, PADataValue: ib}),
+		Nonce:  12345,
+	}
+	fb, err := fastRep.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling KrbFastResponse: %v", err)
+	}
+	ed, err := crypto.GetEncryptedData(fb, armorKey, keyusage.KEY_USAGE_FAST_REP, 0)
+	if err != nil {
+		t.Fatalf("error encrypting KrbFastResponse: %v", err)
+	}
+	armored := KrbFastArmoredRep{EncFastRep: ed}
+	ab, err := armored.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling KrbFastArmoredRep: %v", err)
+	}
+	edata, err := asn1.Marshal(types.PADataSequence{{PADataType: patype.PA_FX_FAST, PADataValue: ab}})
+	if err != nil {
+		t.Fatalf("error marshaling e-data: %v", err)
+	}
+	outer := NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "")
+	outer.EData = edata
+	return outer
+}
+
+func TestKRBError_DecryptFASTError(t *testing.T) {
+	t.Parallel()
+	et, _ := crypto.GetEtype(etypeID.AES256_CTS_HMAC_SHA1_96)
+	armorKey, _ := types.GenerateEncryptionKey(et)
+	inner := NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_FAILED, "bad password")
+	info, _ := asn1.Marshal(types.ETypeInfo2{{EType: etypeID.AES256_CTS_HMAC_SHA1_96, Salt: "TEST.GOKRB5testuser1"}})
+	outer := armoredKRBError(t, armorKey, inner, types.PADataSequence{{PADataType: patype.PA_ETYPE_INFO2, PADataValue: info}})
+
+	b, err := outer.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling armored KRBError: %v", err)
+	}
+	var received KRBError
+	if err := received.Unmarshal(b); err != nil {
+		t.Fatalf("error unmarshaling armored KRBError: %v", err)
+	}
+	assert.True(t, received.IsFASTArmored(), "KRBError should be identified as FAST armored")
+
+	krberr, err := received.DecryptFASTError(armorKey)
+	if err != nil {
+		t.Fatalf("error decrypting FAST armored KRBError: %v", err)
+	}
+	assert.Equal(t, errorcode.KDC_ERR_PREAUTH_FAILED, krberr.ErrorCode, "inner error code not as expected")
+	assert.Equal(t, "bad password", krberr.EText, "inner error text not as expected")
+	var pas types.PADataSequence
+	if err := pas.Unmarshal(krberr.EData); err != nil {
+		t.Fatalf("error unmarshaling inner e-data: %v", err)
+	}
+	assert.True(t, pas.Contains(patype.PA_ETYPE_INFO2), "FAST response PA data not carried into the inner KRBError")
+	assert.False(t, pas.Contains(patype.PA_FX_ERROR), "PA-FX-ERROR should not be in the inner KRBError e-data")
+
+	wrongKey, _ := types.GenerateEncryptionKey(et)
+	_, err = received.DecryptFASTError(wrongKey)
+	assert.Error(t, err, "decrypting with the wrong armor key should fail")
+}
+
+func TestKRBError_IsFASTArmored_NotArmored(t *testing.T) {
+	t.Parallel()
+	krberr := NewKRBError(types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TEST.GOKRB5", errorcode.KDC_ERR_PREAUTH_REQUIRED, "")
+	assert.False(t, krberr.IsFASTArmored(), "KRBError without e-data should not be FAST armored")
+	_, err := krberr.DecryptFASTError(types.EncryptionKey{})
+	assert.Error(t, err, "expected error decrypting a KRBError that is not armored")
+}
@@ -0,0 +1,59 @@
+package messages
+
+// Reference: https://docs.microsoft.com/en-us/openspecs/windows_protocols/ms-kkdcp/5bcebb8d-b747-4ee5-9453-428aec1c5c38
+// Section: 2.2.2
+
+import (
+	"encoding/binary"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/krberror"
+)
+
+// KDCProxyMessage implements MS-KKDCP KDC-PROXY-MESSAGE used to send Kerberos messages to a KDC via a HTTPS proxy.
+type KDCProxyMessage struct {
+	KerbMessage   []byte `asn1:"explicit,tag:0"`
+	TargetDomain  string `asn1:"generalstring,optional,explicit,tag:1"`
+	DCLocatorHint int    `asn1:"optional,explicit,tag:2"`
+}
+
+// NewKDCProxyMessage creates a new KDCProxyMessage carrying the Kerberos message b for the realm specified.
+// The message is prefixed with its length as is required when sending to a KDC over TCP.
+func NewKDCProxyMessage(b []byte, realm string) KDCProxyMessage {
+	hb := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(hb, uint32(len(b)))
+	return KDCProxyMessage{
+		KerbMessage:  append(hb, b...),
+		TargetDomain: realm,
+	}
+}
+
+// Unmarshal bytes b into the KDCProxyMessage struct.
+func (k *KDCProxyMessage) Unmarshal(b []byte) error {
+	_, err := asn1.Unmarshal(b, k)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling KDC-PROXY-MESSAGE")
+	}
+	return nil
+}
+
+// Marshal the KDCProxyMessage into bytes.
+func (k *KDCProxyMessage) Marshal() ([]byte, error) {
+	b, err := asn1.Marshal(*k)
+	if err != nil {
+		return b, krberror.Errorf(err, krberror.EncodingError, "error marshaling KDC-PROXY-MESSAGE")
+	}
+	return b, nil
+}
+
+// Message returns the Kerberos message carried in the KDCProxyMessage with the TCP length prefix removed.
+func (k *KDCProxyMessage) Message() ([]byte, error) {
+	if len(k.KerbMessage) < 4 {
+		return nil, krberror.New(krberror.EncodingError, "KDC-PROXY-MESSAGE kerb-message is too short")
+	}
+	l := binary.BigEndian.Uint32(k.KerbMessage[:4])
+	if int(l) != len(k.KerbMessage)-4 {
+		return nil, krberror.New(krberror.EncodingError, "KDC-PROXY-MESSAGE kerb-message length prefix does not match the message length")
+	}
+	return k.KerbMessage[4:], nil
+}
@@ -0,0 +1,59 @@
+package messages
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalKDCProxyMessage(t *testing.T) {
+	t.Parallel()
+	var a KDCProxyMessage
+	b, err := hex.DecodeString(testdata.MarshaledKRB5kkdcp_message)
+	if err != nil {
+		t.Fatalf("Test vector read error: %v", err)
+	}
+	err = a.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	assert.Equal(t, "krb5data", a.TargetDomain, "target domain not as expected")
+	assert.Equal(t, 0, a.DCLocatorHint, "DC locator hint not as expected")
+	var r ASReq
+	err = r.Unmarshal(a.KerbMessage)
+	if err != nil {
+		t.Fatalf("error unmarshaling kerb-message: %v", err)
+	}
+	assert.Equal(t, msgtype.KRB_AS_REQ, r.MsgType, "kerb-message type not as expected")
+}
+
+func TestKDCProxyMessage_Marshal(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(testdata.MarshaledKRB5as_req)
+	if err != nil {
+		t.Fatalf("Test vector read error: %v", err)
+	}
+	m := NewKDCProxyMessage(b, "TEST.GOKRB5")
+	mb, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling KDC-PROXY-MESSAGE: %v", err)
+	}
+	var u KDCProxyMessage
+	err = u.Unmarshal(mb)
+	if err != nil {
+		t.Fatalf("error unmarshaling KDC-PROXY-MESSAGE: %v", err)
+	}
+	assert.Equal(t, "TEST.GOKRB5", u.TargetDomain, "target domain not as expected")
+	rb, err := u.Message()
+	if err != nil {
+		t.Fatalf("error getting kerb-message: %v", err)
+	}
+	assert.Equal(t, b, rb, "kerb-message not as expected")
+
+	u.KerbMessage = u.KerbMessage[:len(u.KerbMessage)-1]
+	_, err = u.Message()
+	assert.Error(t, err, "truncated kerb-message should error")
+}
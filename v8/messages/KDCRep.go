@@ -188,16 +188,22 @@ func (k *TGSRep) Marshal() ([]byte, error) {
 }
 
 // Unmarshal bytes b into encrypted part of KRB_KDC_REP.
+//
+// The EncASRepPart and EncTGSRepPart application tags are accepted interchangeably.
+// Ref: RFC 4120 - mentions that some implementations use application tag number 26 wether or not the reply is
+// a AS-REP or a TGS-REP.
 func (e *EncKDCRepPart) Unmarshal(b []byte) error {
-	_, err := asn1.UnmarshalWithParams(b, e, fmt.Sprintf("application,explicit,tag:%v", asnAppTag.EncASRepPart))
+	var r asn1.RawValue
+	_, err := asn1.Unmarshal(b, &r)
 	if err != nil {
-		// Try using tag 26
-		// Ref: RFC 4120 - mentions that some implementations use application tag number 26 wether or not the reply is
-		// a AS-REP or a TGS-REP.
-		_, err = asn1.UnmarshalWithParams(b, e, fmt.Sprintf("application,explicit,tag:%v", asnAppTag.EncTGSRepPart))
-		if err != nil {
-			return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling encrypted part within KDC_REP")
-		}
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling encrypted part within KDC_REP")
+	}
+	if r.Class != asn1.ClassApplication || (r.Tag != asnAppTag.EncASRepPart && r.Tag != asnAppTag.EncTGSRepPart) {
+		return krberror.NewErrorf(krberror.EncodingError, "encrypted part within KDC_REP has an unexpected tag. Class: %d; Tag: %d", r.Class, r.Tag)
+	}
+	_, err = asn1.UnmarshalWithParams(b, e, fmt.Sprintf("application,explicit,tag:%v", r.Tag))
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error unmarshaling encrypted part within KDC_REP")
 	}
 	return nil
 }
@@ -257,6 +263,33 @@ func (k *ASRep) Verify(cfg *config.Config, creds *credentials.Credentials, asReq
 	if err != nil {
 		return false, krberror.Errorf(err, krberror.DecryptingError, "error decrypting EncPart of AS_REP")
 	}
+	return k.verifyEncPart(cfg, asReq, key)
+}
+
+// VerifyWithReplyKey checks the validity of an AS_REP message whose encrypted part is encrypted with the reply key
+// provided rather than the client's long-term key, such as the strengthened reply key of a FAST exchange.
+func (k *ASRep) VerifyWithReplyKey(cfg *config.Config, key types.EncryptionKey, asReq ASReq) (bool, error) {
+	if !k.CName.Equal(asReq.ReqBody.CName) {
+		return false, krberror.NewErrorf(krberror.KRBMsgError, "CName in response does not match what was requested. Requested: %+v; Reply: %+v", asReq.ReqBody.CName, k.CName)
+	}
+	if k.CRealm != asReq.ReqBody.Realm {
+		return false, krberror.NewErrorf(krberror.KRBMsgError, "CRealm in response does not match what was requested. Requested: %s; Reply: %s", asReq.ReqBody.Realm, k.CRealm)
+	}
+	b, err := crypto.DecryptEncPart(k.EncPart, key, keyusage.AS_REP_ENCPART)
+	if err != nil {
+		return false, krberror.Errorf(err, krberror.DecryptingError, "error decrypting EncPart of AS_REP")
+	}
+	var denc EncKDCRepPart
+	err = denc.Unmarshal(b)
+	if err != nil {
+		return false, krberror.Errorf(err, krberror.EncodingError, "error unmarshaling decrypted encpart of AS_REP")
+	}
+	k.DecryptedEncPart = denc
+	return k.verifyEncPart(cfg, asReq, key)
+}
+
+// verifyEncPart checks the decrypted encrypted part of the AS_REP against the AS_REQ.
+func (k *ASRep) verifyEncPart(cfg *config.Config, asReq ASReq, key types.EncryptionKey) (bool, error) {
 	if k.DecryptedEncPart.Nonce != asReq.ReqBody.Nonce {
 		return false, krberror.NewErrorf(krberror.KRBMsgError, "possible replay attack, nonce in response does not match that in request")
 	}
@@ -357,10 +390,11 @@ func (k *TGSRep) Verify(cfg *config.Config, tgsReq TGSReq) (bool, error) {
 		}
 
 	}
-	if time.Since(k.DecryptedEncPart.StartTime) > cfg.LibDefaults.Clockskew || k.DecryptedEncPart.StartTime.Sub(time.Now().UTC()) > cfg.LibDefaults.Clockskew {
-		if time.Since(k.DecryptedEncPart.AuthTime) > cfg.LibDefaults.Clockskew || k.DecryptedEncPart.AuthTime.Sub(time.Now().UTC()) > cfg.LibDefaults.Clockskew {
-			return false, krberror.NewErrorf(krberror.KRBMsgError, "clock skew with KDC too large. Greater than %v seconds.", cfg.LibDefaults.Clockskew.Seconds())
-		}
+	// The auth time is that of the TGT used, which may have been obtained long before, such as a TGT loaded from a
+	// credential cache, so only the start time, when the KDC includes it, indicates the clock skew with the KDC.
+	st := k.DecryptedEncPart.StartTime
+	if !st.IsZero() && (time.Since(st) > cfg.LibDefaults.Clockskew || st.Sub(time.Now().UTC()) > cfg.LibDefaults.Clockskew) {
+		return false, krberror.NewErrorf(krberror.KRBMsgError, "clock skew with KDC too large. Greater than %v seconds.", cfg.LibDefaults.Clockskew.Seconds())
 	}
 	return true, nil
 }
@@ -231,6 +231,40 @@ func TestUnmarshalEncKDCRepPart(t *testing.T) {
 	}
 }
 
+func TestUnmarshalEncKDCRepPart_ApplicationTags(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(testdata.MarshaledKRB5enc_kdc_rep_part)
+	if err != nil {
+		t.Fatalf("Test vector read error: %v", err)
+	}
+	var tests = []struct {
+		tag   byte
+		valid bool
+	}{
+		{0x79, true},  // [APPLICATION 25] EncASRepPart
+		{0x7a, true},  // [APPLICATION 26] EncTGSRepPart
+		{0x7b, false}, // [APPLICATION 27] EncAPRepPart
+		{0xa1, false}, // [1] context specific
+	}
+	for _, test := range tests {
+		tb := make([]byte, len(b))
+		copy(tb, b)
+		tb[0] = test.tag
+		var a EncKDCRepPart
+		err = a.Unmarshal(tb)
+		if test.valid {
+			if err != nil {
+				t.Errorf("error unmarshaling with tag %x: %v", test.tag, err)
+				continue
+			}
+			assert.Equal(t, testdata.TEST_NONCE, a.Nonce, "Nonce not as expected with tag %x", test.tag)
+			assert.Equal(t, testdata.TEST_REALM, a.SRealm, "SRealm not as expected with tag %x", test.tag)
+		} else {
+			assert.Error(t, err, "unmarshal should fail with tag %x", test.tag)
+		}
+	}
+}
+
 func TestUnmarshalEncKDCRepPart_optionalsNULL(t *testing.T) {
 	t.Parallel()
 	var a EncKDCRepPart
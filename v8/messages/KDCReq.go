@@ -139,8 +139,9 @@ func NewASReq(realm string, c *config.Config, cname, sname types.PrincipalName)
 	}
 	if c.LibDefaults.RenewLifetime != 0 {
 		types.SetFlag(&a.ReqBody.KDCOptions, flags.Renewable)
+		// Accept a renewable ticket if the KDC cannot grant the lifetime requested
+		types.SetFlag(&a.ReqBody.KDCOptions, flags.RenewableOK)
 		a.ReqBody.RTime = t.Add(c.LibDefaults.RenewLifetime)
-		a.ReqBody.RTime = t.Add(time.Duration(48) * time.Hour)
 	}
 	if !c.LibDefaults.NoAddresses {
 		ha, err := types.LocalHostAddresses()
@@ -175,6 +176,56 @@ func NewUser2UserTGSReq(cname types.PrincipalName, kdcRealm string, c *config.Co
 	return a, err
 }
 
+// SetAuthorizationData encrypts the authorization data provided with the TGT session key and places it in the
+// enc-authorization-data of the TGS_REQ body so that the KDC will copy it into the ticket issued.
+// The TGS_REQ's PAData is regenerated as the authenticator's checksum covers the request body.
+func (k *TGSReq) SetAuthorizationData(ad types.AuthorizationData, tgt Ticket, sessionKey types.EncryptionKey) error {
+	b, err := asn1.Marshal(ad)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncodingError, "error marshaling authorization data")
+	}
+	ed, err := crypto.GetEncryptedData(b, sessionKey, keyusage.TGS_REQ_KDC_REQ_BODY_AUTHDATA_SESSION_KEY, 0)
+	if err != nil {
+		return krberror.Errorf(err, krberror.EncryptingError, "error encrypting authorization data")
+	}
+	k.ReqBody.EncAuthData = ed
+	return k.setPAData(tgt, sessionKey)
+}
+
+// SetUser2User requests that the ticket issued be encrypted with the session key of the verifying TGT provided, for
+// user-to-user authentication, by placing the TGT in the additional tickets of the TGS_REQ and setting the
+// ENC-TKT-IN-SKEY option. The TGS_REQ's PAData is regenerated as the authenticator's checksum covers the request body.
+func (k *TGSReq) SetUser2User(verifyingTGT Ticket, tgt Ticket, sessionKey types.EncryptionKey) error {
+	k.ReqBody.AdditionalTickets = []Ticket{verifyingTGT}
+	types.SetFlag(&k.ReqBody.KDCOptions, flags.EncTktInSkey)
+	return k.setPAData(tgt, sessionKey)
+}
+
+// SetAddresses places the addresses provided in the TGS_REQ body, replacing any already present, so that the ticket
+// issued is restricted to them. The TGS_REQ's PAData is regenerated as the authenticator's checksum covers the request
+// body.
+func (k *TGSReq) SetAddresses(ha []types.HostAddress, tgt Ticket, sessionKey types.EncryptionKey) error {
+	k.ReqBody.Addresses = ha
+	return k.setPAData(tgt, sessionKey)
+}
+
+// AuthorizationData returns the authorization data in the TGS_REQ body, decrypted with the TGT session key.
+func (k *TGSReq) AuthorizationData(sessionKey types.EncryptionKey) (types.AuthorizationData, error) {
+	var ad types.AuthorizationData
+	if len(k.ReqBody.EncAuthData.Cipher) < 1 {
+		return ad, nil
+	}
+	b, err := crypto.DecryptEncPart(k.ReqBody.EncAuthData, sessionKey, keyusage.TGS_REQ_KDC_REQ_BODY_AUTHDATA_SESSION_KEY)
+	if err != nil {
+		return ad, krberror.Errorf(err, krberror.DecryptingError, "error decrypting authorization data")
+	}
+	err = ad.Unmarshal(b)
+	if err != nil {
+		return ad, krberror.Errorf(err, krberror.EncodingError, "error unmarshaling authorization data")
+	}
+	return ad, nil
+}
+
 // tgsReq populates the fields for a TGS_REQ
 func tgsReq(cname, sname types.PrincipalName, kdcRealm string, renewal bool, c *config.Config) (TGSReq, error) {
 	nonce, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt32))
@@ -207,6 +258,7 @@ func tgsReq(cname, sname types.PrincipalName, kdcRealm string, renewal bool, c *
 	}
 	if c.LibDefaults.RenewLifetime > time.Duration(0) {
 		types.SetFlag(&k.ReqBody.KDCOptions, flags.Renewable)
+		types.SetFlag(&k.ReqBody.KDCOptions, flags.RenewableOK)
 		k.ReqBody.RTime = t.Add(c.LibDefaults.RenewLifetime)
 	}
 	if !c.LibDefaults.NoAddresses {
@@ -6,12 +6,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/iana"
 	"github.com/jcmturner/gokrb5/v8/iana/addrtype"
+	"github.com/jcmturner/gokrb5/v8/iana/adtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/iana/patype"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -424,3 +430,77 @@ func TestMarshalTGSReq(t *testing.T) {
 	}
 	assert.Equal(t, b, mb, "Marshal bytes of TGSReq not as expected")
 }
+
+func TestTGSReq_SetAuthorizationData(t *testing.T) {
+	t.Parallel()
+	c := config.New()
+	et, _ := crypto.GetEtype(etypeID.AES256_CTS_HMAC_SHA1_96)
+	sessionKey, _ := types.GenerateEncryptionKey(et)
+	tgt := Ticket{
+		TktVNO: iana.PVNO,
+		Realm:  testdata.TEST_REALM,
+		SName:  types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+testdata.TEST_REALM),
+	}
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	tgsReq, err := NewTGSReq(cname, testdata.TEST_REALM, c, tgt, sessionKey, sname, false)
+	if err != nil {
+		t.Fatalf("error creating TGS_REQ: %v", err)
+	}
+	pa := tgsReq.PAData[0].PADataValue
+
+	ad := types.AuthorizationData{
+		{ADType: adtype.ADIfRelevant, ADData: []byte{0x30, 0x00}},
+	}
+	err = tgsReq.SetAuthorizationData(ad, tgt, sessionKey)
+	if err != nil {
+		t.Fatalf("error setting authorization data: %v", err)
+	}
+	assert.NotEqual(t, pa, tgsReq.PAData[0].PADataValue, "PAData should have been regenerated")
+
+	b, err := tgsReq.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling TGS_REQ: %v", err)
+	}
+	var received TGSReq
+	err = received.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("error unmarshaling TGS_REQ: %v", err)
+	}
+	rad, err := received.AuthorizationData(sessionKey)
+	if err != nil {
+		t.Fatalf("error getting authorization data from TGS_REQ: %v", err)
+	}
+	assert.Equal(t, ad, rad, "authorization data not as expected")
+}
+
+func TestNewASReq_Renewable(t *testing.T) {
+	t.Parallel()
+	c := config.New()
+	c.LibDefaults.NoAddresses = true
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+testdata.TEST_REALM)
+
+	a, err := NewASReq(testdata.TEST_REALM, c, cname, sname)
+	if err != nil {
+		t.Fatalf("error creating AS_REQ: %v", err)
+	}
+	assert.False(t, types.IsFlagSet(&a.ReqBody.KDCOptions, flags.Renewable), "renewable should not be requested without a renew lifetime")
+	assert.True(t, a.ReqBody.RTime.IsZero(), "RTime should not be set without a renew lifetime")
+
+	c.LibDefaults.RenewLifetime = time.Hour * 72
+	a, err = NewASReq(testdata.TEST_REALM, c, cname, sname)
+	if err != nil {
+		t.Fatalf("error creating AS_REQ: %v", err)
+	}
+	assert.True(t, types.IsFlagSet(&a.ReqBody.KDCOptions, flags.Renewable), "renewable not requested")
+	assert.True(t, types.IsFlagSet(&a.ReqBody.KDCOptions, flags.RenewableOK), "renewable-ok not set")
+	assert.WithinDuration(t, time.Now().UTC().Add(c.LibDefaults.RenewLifetime), a.ReqBody.RTime, time.Minute, "RTime not set from the renew lifetime")
+
+	tgsReq, err := tgsReq(cname, sname, testdata.TEST_REALM, false, c)
+	if err != nil {
+		t.Fatalf("error creating TGS_REQ: %v", err)
+	}
+	assert.True(t, types.IsFlagSet(&tgsReq.ReqBody.KDCOptions, flags.Renewable), "renewable not requested in TGS_REQ")
+	assert.True(t, types.IsFlagSet(&tgsReq.ReqBody.KDCOptions, flags.RenewableOK), "renewable-ok not set in TGS_REQ")
+}
@@ -92,6 +92,47 @@ func (k *KRBCred) DecryptEncPart(key types.EncryptionKey) error {
 	return nil
 }
 
+// ValidateKRBCred decrypts the encrypted part of a KRB_CRED, such as a credential forwarded by a client delegating to a
+// service, with the session key provided and checks the credentials enclosed are valid for use before they are
+// trusted. The decrypted encrypted part is returned.
+//
+// An error is returned if the KRB_CRED cannot be decrypted, indicating it has been tampered with or was not protected
+// with the session key, if the credential information does not correspond to the tickets enclosed or if any of the
+// tickets have expired or are not yet valid.
+func ValidateKRBCred(cred KRBCred, sessionKey types.EncryptionKey) (EncKrbCredPart, error) {
+	err := cred.DecryptEncPart(sessionKey)
+	if err != nil {
+		return EncKrbCredPart{}, krberror.Errorf(err, krberror.DecryptingError, "KRB_CRED could not be decrypted with the session key")
+	}
+	denc := cred.DecryptedEncPart
+	if len(cred.Tickets) < 1 {
+		return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED does not contain any tickets")
+	}
+	if len(denc.TicketInfo) != len(cred.Tickets) {
+		return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED contains %d tickets but credential information for %d", len(cred.Tickets), len(denc.TicketInfo))
+	}
+	now := time.Now().UTC()
+	for i, info := range denc.TicketInfo {
+		tkt := cred.Tickets[i]
+		if len(info.Key.KeyValue) < 1 {
+			return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED credential information for ticket %d does not contain a session key", i)
+		}
+		if info.SRealm != "" && info.SRealm != tkt.Realm {
+			return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED credential information for ticket %d is for realm %s but the ticket is for %s", i, info.SRealm, tkt.Realm)
+		}
+		if len(info.SName.NameString) > 0 && !info.SName.Equal(tkt.SName) {
+			return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED credential information for ticket %d is for %s but the ticket is for %s", i, info.SName.PrincipalNameString(), tkt.SName.PrincipalNameString())
+		}
+		if info.EndTime.IsZero() || !now.Before(info.EndTime) {
+			return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED ticket %d for %s expired at %v", i, tkt.SName.PrincipalNameString(), info.EndTime)
+		}
+		if now.Before(info.StartTime) {
+			return denc, krberror.NewErrorf(krberror.KRBMsgError, "KRB_CRED ticket %d for %s is not valid until %v", i, tkt.SName.PrincipalNameString(), info.StartTime)
+		}
+	}
+	return denc, nil
+}
+
 // Unmarshal bytes b into the encrypted part of KRB_CRED.
 func (k *EncKrbCredPart) Unmarshal(b []byte) error {
 	_, err := asn1.UnmarshalWithParams(b, k, fmt.Sprintf("application,explicit,tag:%v", asnAppTag.EncKrbCredPart))
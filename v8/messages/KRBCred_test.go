@@ -6,11 +6,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/iana"
 	"github.com/jcmturner/gokrb5/v8/iana/addrtype"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -130,3 +136,71 @@ func TestUnmarshalEncCredPart_optionalsNULL(t *testing.T) {
 		assert.Equal(t, "12d00023", hex.EncodeToString(addr.Address), fmt.Sprintf("Host address not as expected for address item %d within ticket info %d", j+1, i+1))
 	}
 }
+
+func TestValidateKRBCred(t *testing.T) {
+	t.Parallel()
+	now := time.Now().UTC()
+	sessionKey := types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)}
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+testdata.TEST_REALM)
+	krbCred := func(start, end time.Time, key types.EncryptionKey) KRBCred {
+		denc := EncKrbCredPart{
+			TicketInfo: []KrbCredInfo{
+				{
+					Key:       types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)},
+					PRealm:    testdata.TEST_REALM,
+					PName:     types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"),
+					AuthTime:  start,
+					StartTime: start,
+					EndTime:   end,
+					SRealm:    testdata.TEST_REALM,
+					SName:     sname,
+				},
+			},
+		}
+		b, err := asn1.Marshal(denc)
+		if err != nil {
+			t.Fatalf("error marshaling EncKrbCredPart: %v", err)
+		}
+		b = asn1tools.AddASNAppTag(b, asnAppTag.EncKrbCredPart)
+		ed, err := crypto.GetEncryptedData(b, key, keyusage.KRB_CRED_ENCPART, 0)
+		if err != nil {
+			t.Fatalf("error encrypting EncKrbCredPart: %v", err)
+		}
+		return KRBCred{
+			PVNO:    iana.PVNO,
+			MsgType: msgtype.KRB_CRED,
+			Tickets: []Ticket{{TktVNO: iana.PVNO, Realm: testdata.TEST_REALM, SName: sname}},
+			EncPart: ed,
+		}
+	}
+
+	denc, err := ValidateKRBCred(krbCred(now.Add(-time.Hour), now.Add(time.Hour), sessionKey), sessionKey)
+	if err != nil {
+		t.Fatalf("error validating KRB_CRED: %v", err)
+	}
+	assert.Equal(t, "testuser1", denc.TicketInfo[0].PName.PrincipalNameString(), "decrypted credential information not as expected")
+
+	var tests = []struct {
+		name string
+		cred KRBCred
+		err  string
+	}{
+		{"expired", krbCred(now.Add(-time.Hour*2), now.Add(-time.Hour), sessionKey), "expired"},
+		{"not yet valid", krbCred(now.Add(time.Hour), now.Add(time.Hour*2), sessionKey), "not valid until"},
+		{"wrong key", krbCred(now.Add(-time.Hour), now.Add(time.Hour), types.EncryptionKey{KeyType: 18, KeyValue: []byte("0123456789abcdef0123456789abcdef")}), "could not be decrypted"},
+	}
+	for _, test := range tests {
+		_, err := ValidateKRBCred(test.cred, sessionKey)
+		if assert.Error(t, err, "%s KRB_CRED should not be valid", test.name) {
+			assert.Contains(t, err.Error(), test.err, "error for %s KRB_CRED not as expected", test.name)
+		}
+	}
+
+	c := krbCred(now.Add(-time.Hour), now.Add(time.Hour), sessionKey)
+	c.Tickets[0].SName = types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/OTHER.GOKRB5")
+	_, err = ValidateKRBCred(c, sessionKey)
+	assert.Error(t, err, "KRB_CRED with credential information not corresponding to the ticket should not be valid")
+	c.Tickets = append(c.Tickets, c.Tickets[0])
+	_, err = ValidateKRBCred(c, sessionKey)
+	assert.Error(t, err, "KRB_CRED with more tickets than credential information should not be valid")
+}
@@ -1,6 +1,8 @@
 package messages
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -190,13 +192,35 @@ func (t *Ticket) DecryptEncPart(keytab *keytab.Keytab, sname *types.PrincipalNam
 	if sname == nil {
 		sname = &t.SName
 	}
-	key, _, err := keytab.GetEncryptionKey(*sname, t.Realm, t.EncPart.KVNO, t.EncPart.EType)
+	key, err := t.keytabKey(keytab, *sname)
 	if err != nil {
 		return NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_NOKEY, fmt.Sprintf("Could not get key from keytab: %v", err))
 	}
 	return t.Decrypt(key)
 }
 
+// KVNO returns the key version number of the ticket's encrypted part along with the identifier of the Active Directory
+// Read-Only Domain Controller (RODC) that issued the ticket. An RODC holds its identifier in the high 16 bits of the
+// kvno, so the identifier is zero for a ticket not issued by an RODC.
+func (t *Ticket) KVNO() (kvno, rodcID int) {
+	v := uint32(t.EncPart.KVNO)
+	return int(v & 0xFFFF), int(v >> 16)
+}
+
+// keytabKey returns the key from the keytab for the ticket's encrypted part. If the keytab does not hold the kvno of a
+// ticket issued by an RODC the key is matched on the key version number without the RODC identifier.
+func (t *Ticket) keytabKey(kt *keytab.Keytab, sname types.PrincipalName) (types.EncryptionKey, error) {
+	key, _, err := kt.GetEncryptionKey(sname, t.Realm, t.EncPart.KVNO, t.EncPart.EType)
+	if err != nil {
+		if kvno, rodcID := t.KVNO(); rodcID != 0 {
+			if k, _, kerr := kt.GetEncryptionKey(sname, t.Realm, kvno, t.EncPart.EType); kerr == nil {
+				return k, nil
+			}
+		}
+	}
+	return key, err
+}
+
 // Decrypt decrypts the encrypted part of the ticket using the key provided.
 func (t *Ticket) Decrypt(key types.EncryptionKey) error {
 	b, err := crypto.DecryptEncPart(t.EncPart, key, keyusage.KDC_REP_TICKET)
@@ -212,44 +236,209 @@ func (t *Ticket) Decrypt(key types.EncryptionKey) error {
 	return nil
 }
 
+// DecryptTicket decrypts a ticket with the key provided and returns its encrypted part. The bytes may be either a
+// marshaled ticket or an AP_REQ containing the ticket, such as one captured from the network.
+//
+// This is intended as a diagnostic aid to check that a ticket is for a service, and can be decrypted with the key held
+// for it, without processing the ticket as an acceptor.
+func DecryptTicket(b []byte, key types.EncryptionKey) (EncTicketPart, error) {
+	var tkt Ticket
+	if err := tkt.Unmarshal(b); err != nil {
+		var apReq APReq
+		if aerr := apReq.Unmarshal(b); aerr != nil {
+			return EncTicketPart{}, fmt.Errorf("bytes are neither a ticket (%v) nor an AP_REQ (%v)", err, aerr)
+		}
+		tkt = apReq.Ticket
+	}
+	if tkt.EncPart.EType != key.KeyType {
+		return EncTicketPart{}, fmt.Errorf("ticket is encrypted with etype %d but the key is of etype %d", tkt.EncPart.EType, key.KeyType)
+	}
+	err := tkt.Decrypt(key)
+	if err != nil {
+		return EncTicketPart{}, err
+	}
+	return tkt.DecryptedEncPart, nil
+}
+
+// ticketFlagNames are the names of the ticket flags used when formatting the encrypted part of a ticket.
+var ticketFlagNames = []struct {
+	flag int
+	name string
+}{
+	{flags.Forwardable, "forwardable"},
+	{flags.Forwarded, "forwarded"},
+	{flags.Proxiable, "proxiable"},
+	{flags.Proxy, "proxy"},
+	{flags.MayPostDate, "may-postdate"},
+	{flags.PostDated, "postdated"},
+	{flags.Invalid, "invalid"},
+	{flags.Renewable, "renewable"},
+	{flags.Initial, "initial"},
+	{flags.PreAuthent, "pre-authent"},
+	{flags.HWAuthent, "hw-authent"},
+	{flags.TransitedPolicyChecked, "transited-policy-checked"},
+	{flags.OKAsDelegate, "ok-as-delegate"},
+	{flags.Anonymous, "anonymous"},
+}
+
+// jsonEncTicketPart is used to format the details of the encrypted part of a ticket as JSON.
+type jsonEncTicketPart struct {
+	CName                  string
+	CRealm                 string
+	Flags                  []string
+	SessionKeyType         int32
+	AuthTime               time.Time
+	StartTime              time.Time
+	EndTime                time.Time
+	RenewTill              time.Time
+	Addresses              []string
+	TransitedType          int32
+	Transited              string
+	AuthorizationDataTypes []int32
+}
+
+// JSON returns the details of the encrypted part of a ticket formatted as JSON for display.
+// The value of the session key is not included.
+func (t *EncTicketPart) JSON() (string, error) {
+	j := jsonEncTicketPart{
+		CName:          t.CName.PrincipalNameString(),
+		CRealm:         t.CRealm,
+		Flags:          []string{},
+		SessionKeyType: t.Key.KeyType,
+		AuthTime:       t.AuthTime,
+		StartTime:      t.StartTime,
+		EndTime:        t.EndTime,
+		RenewTill:      t.RenewTill,
+		TransitedType:  t.Transited.TRType,
+		Transited:      string(t.Transited.Contents),
+	}
+	for _, f := range ticketFlagNames {
+		if len(t.Flags.Bytes) > f.flag/8 && types.IsFlagSet(&t.Flags, f.flag) {
+			j.Flags = append(j.Flags, f.name)
+		}
+	}
+	for i := range t.CAddr {
+		a, err := t.CAddr[i].GetAddress()
+		if err != nil {
+			return "", fmt.Errorf("error getting ticket address: %v", err)
+		}
+		j.Addresses = append(j.Addresses, a)
+	}
+	for _, ad := range t.AuthorizationData {
+		j.AuthorizationDataTypes = append(j.AuthorizationDataTypes, ad.ADType)
+	}
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 // GetPACType returns a Microsoft PAC that has been extracted from the ticket and processed.
 func (t *Ticket) GetPACType(keytab *keytab.Keytab, sname *types.PrincipalName, l *log.Logger) (bool, pac.PACType, error) {
-	var isPAC bool
+	b, isPAC := types.ExtractPAC(t.DecryptedEncPart.AuthorizationData)
+	if !isPAC {
+		return isPAC, pac.PACType{}, nil
+	}
+	var p pac.PACType
+	err := p.Unmarshal(b)
+	if err != nil {
+		return isPAC, p, fmt.Errorf("error unmarshaling PAC: %v", err)
+	}
+	if sname == nil {
+		sname = &t.SName
+	}
+	key, err := t.keytabKey(keytab, *sname)
+	if err != nil {
+		return isPAC, p, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_NOKEY, fmt.Sprintf("Could not get key from keytab: %v", err))
+	}
+	err = p.ProcessPACInfoBuffers(key, l)
+	return isPAC, p, err
+}
+
+// adKDCIssued is the AD-KDC-ISSUED container with its elements held in their received encoding, over which the
+// checksum is calculated.
+type adKDCIssued struct {
+	ADChecksum types.Checksum      `asn1:"explicit,tag:0"`
+	IRealm     string              `asn1:"optional,generalstring,explicit,tag:1"`
+	Isname     types.PrincipalName `asn1:"optional,explicit,tag:2"`
+	Elements   asn1.RawValue       `asn1:"explicit,tag:3"`
+}
+
+// VerifyADKDCIssued verifies the checksum of the marshaled AD-KDC-ISSUED authorization data container with the key
+// provided, which is the session key of the ticket the container is in, as defined in RFC 4120 section 5.2.6.2.
+// The authorization data elements of the container are only returned if the checksum is valid.
+func VerifyADKDCIssued(b []byte, key types.EncryptionKey) (types.AuthorizationData, error) {
+	var a adKDCIssued
+	_, err := asn1.Unmarshal(b, &a)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling AD-KDC-ISSUED: %v", err)
+	}
+	et, err := crypto.GetChksumEtype(a.ADChecksum.CksumType)
+	if err != nil {
+		return nil, fmt.Errorf("error getting etype for AD-KDC-ISSUED checksum: %v", err)
+	}
+	if !et.VerifyChecksum(key.KeyValue, a.Elements.FullBytes, a.ADChecksum.Checksum, keyusage.AD_KDC_ISSUED_CHKSUM) {
+		return nil, errors.New("AD-KDC-ISSUED checksum is not valid")
+	}
+	var elements types.AuthorizationData
+	err = elements.Unmarshal(a.Elements.FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling AD-KDC-ISSUED elements: %v", err)
+	}
+	return elements, nil
+}
+
+// KDCIssuedAuthorizationData returns the authorization data elements of the AD-KDC-ISSUED containers in the decrypted
+// ticket, including those within AD-IF-RELEVANT containers. The checksum of each container is verified with the
+// ticket's session key so that the elements returned can be trusted to have been issued by the KDC. An error is
+// returned if any container's checksum is not valid.
+func (t *Ticket) KDCIssuedAuthorizationData() (types.AuthorizationData, error) {
+	var verified types.AuthorizationData
 	for _, ad := range t.DecryptedEncPart.AuthorizationData {
+		entries := types.AuthorizationData{ad}
 		if ad.ADType == adtype.ADIfRelevant {
 			var ad2 types.AuthorizationData
-			err := ad2.Unmarshal(ad.ADData)
-			if err != nil {
-				l.Printf("PAC authorization data could not be unmarshaled: %v", err)
+			if err := ad2.Unmarshal(ad.ADData); err != nil {
 				continue
 			}
-			if ad2[0].ADType == adtype.ADWin2KPAC {
-				isPAC = true
-				var p pac.PACType
-				err = p.Unmarshal(ad2[0].ADData)
-				if err != nil {
-					return isPAC, p, fmt.Errorf("error unmarshaling PAC: %v", err)
-				}
-				if sname == nil {
-					sname = &t.SName
-				}
-				key, _, err := keytab.GetEncryptionKey(*sname, t.Realm, t.EncPart.KVNO, t.EncPart.EType)
-				if err != nil {
-					return isPAC, p, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_NOKEY, fmt.Sprintf("Could not get key from keytab: %v", err))
-				}
-				err = p.ProcessPACInfoBuffers(key, l)
-				return isPAC, p, err
+			entries = ad2
+		}
+		for _, e := range entries {
+			if e.ADType != adtype.ADKDCIssued {
+				continue
 			}
+			elements, err := VerifyADKDCIssued(e.ADData, t.DecryptedEncPart.Key)
+			if err != nil {
+				return nil, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_BAD_INTEGRITY, err.Error())
+			}
+			verified = append(verified, elements...)
 		}
 	}
-	return isPAC, pac.PACType{}, nil
+	return verified, nil
 }
 
 // Valid checks it the ticket is currently valid. Max duration passed endtime passed in as argument.
+//
+// A postdated ticket is accepted once its start time has been reached provided it has been validated by the KDC,
+// which clears the invalid flag the ticket is issued with.
 func (t *Ticket) Valid(d time.Duration) (bool, error) {
-	// Check for future tickets or invalid tickets
+	postdated := types.IsFlagSet(&t.DecryptedEncPart.Flags, flags.PostDated)
+	// Check for invalid tickets
+	if types.IsFlagSet(&t.DecryptedEncPart.Flags, flags.Invalid) {
+		if postdated {
+			return false, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_TKT_NYV, "postdated service ticket provided has not been validated")
+		}
+		return false, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_TKT_NYV, "service ticket provided is not yet valid")
+	}
+
+	// Check for future tickets
 	time := time.Now().UTC()
-	if t.DecryptedEncPart.StartTime.Sub(time) > d || types.IsFlagSet(&t.DecryptedEncPart.Flags, flags.Invalid) {
+	if t.DecryptedEncPart.StartTime.Sub(time) > d {
+		if postdated {
+			return false, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_TKT_NYV,
+				fmt.Sprintf("postdated service ticket provided is not valid until %v", t.DecryptedEncPart.StartTime))
+		}
 		return false, NewKRBError(t.SName, t.Realm, errorcode.KRB_AP_ERR_TKT_NYV, "service ticket provided is not yet valid")
 	}
 
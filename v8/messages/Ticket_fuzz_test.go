@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package messages
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+func FuzzTicket_DecryptEncPart(f *testing.F) {
+	b, _ := hex.DecodeString(testdata.MarshaledKRB5ticket)
+	f.Add(b)
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	st := time.Now().UTC()
+	tkt, _, _ := NewTicket(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), "TEST.GOKRB5",
+		types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5"), "TEST.GOKRB5",
+		types.NewKrbFlags(), kt, 18, 1,
+		st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour),
+	)
+	b, _ = tkt.Marshal()
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var e EncTicketPart
+		e.Unmarshal(b)
+		var tkt Ticket
+		if err := tkt.Unmarshal(b); err != nil {
+			return
+		}
+		tkt.DecryptEncPart(kt, nil)
+	})
+}
@@ -2,15 +2,22 @@ package messages
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
 	"github.com/jcmturner/gokrb5/v8/iana"
 	"github.com/jcmturner/gokrb5/v8/iana/addrtype"
 	"github.com/jcmturner/gokrb5/v8/iana/adtype"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/iana/trtype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
@@ -164,3 +171,161 @@ func TestAuthorizationData_GetPACType_GOKRB5TestData(t *testing.T) {
 	assert.NotNil(t, pac.KDCChecksum, "PAC KDC Checksum info is nil")
 	assert.NotNil(t, pac.ServerChecksum, "PAC Server checksum info is nil")
 }
+
+func TestUnmarshalTicketsSequence_Malformed(t *testing.T) {
+	t.Parallel()
+	for _, b := range [][]byte{nil, {0x30}, {0x30, 0x85}, {0x30, 0x03, 0xff}} {
+		assert.NotPanics(t, func() { unmarshalTicketsSequence(asn1.RawValue{Bytes: b}) }, "unmarshaling malformed sequence of tickets %x panicked", b)
+	}
+}
+
+func TestTicket_GetPACType_EmptyIfRelevant(t *testing.T) {
+	t.Parallel()
+	var tkt Ticket
+	ad, _ := asn1.Marshal(types.AuthorizationData{})
+	tkt.DecryptedEncPart.AuthorizationData = types.AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: ad}}
+	l := log.New(io.Discard, "", 0)
+	isPAC, _, err := tkt.GetPACType(keytab.New(), nil, l)
+	assert.False(t, isPAC, "empty AD-IF-RELEVANT should not contain a PAC")
+	assert.NoError(t, err, "empty AD-IF-RELEVANT should not error")
+}
+
+func TestDecryptTicket(t *testing.T) {
+	t.Parallel()
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	st := time.Now().UTC().Truncate(time.Second)
+	f := types.NewKrbFlags()
+	types.SetFlag(&f, flags.Forwardable)
+	types.SetFlag(&f, flags.PreAuthent)
+	tkt, sessionKey, err := NewTicket(cname, "TEST.GOKRB5", sname, "TEST.GOKRB5", f, kt, 18, 1,
+		st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour))
+	if err != nil {
+		t.Fatalf("error creating ticket: %v", err)
+	}
+	key, _, err := kt.GetEncryptionKey(sname, "TEST.GOKRB5", 1, 18)
+	if err != nil {
+		t.Fatalf("error getting key from keytab: %v", err)
+	}
+	tb, err := tkt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling ticket: %v", err)
+	}
+	auth, _ := types.NewAuthenticator("TEST.GOKRB5", cname)
+	apReq, err := NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		t.Fatalf("error creating AP_REQ: %v", err)
+	}
+	ab, err := apReq.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REQ: %v", err)
+	}
+
+	for _, b := range [][]byte{tb, ab} {
+		e, err := DecryptTicket(b, key)
+		if err != nil {
+			t.Fatalf("error decrypting ticket: %v", err)
+		}
+		assert.Equal(t, "testuser1", e.CName.PrincipalNameString(), "CName not as expected")
+		assert.Equal(t, sessionKey, e.Key, "session key not as expected")
+		j, err := e.JSON()
+		if err != nil {
+			t.Fatalf("error formatting ticket: %v", err)
+		}
+		assert.Contains(t, j, `"CName": "testuser1"`, "formatted CName not as expected")
+		assert.Contains(t, j, `"forwardable"`, "formatted flags not as expected")
+		assert.Contains(t, j, `"pre-authent"`, "formatted flags not as expected")
+		assert.NotContains(t, j, `"renewable"`, "formatted flags not as expected")
+	}
+
+	wrongKey := types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)}
+	_, err = DecryptTicket(tb, wrongKey)
+	assert.Error(t, err, "decrypting with the wrong key should error")
+	_, err = DecryptTicket(tb, types.EncryptionKey{KeyType: 17, KeyValue: make([]byte, 16)})
+	assert.Error(t, err, "decrypting with a key of a different etype should error")
+	_, err = DecryptTicket([]byte{0x30, 0x00}, key)
+	assert.Error(t, err, "decrypting bytes that are not a ticket should error")
+}
+
+func TestTicket_DecryptEncPart_RODC(t *testing.T) {
+	t.Parallel()
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	st := time.Now().UTC()
+	tkt, _, err := NewTicket(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), "TEST.GOKRB5", sname, "TEST.GOKRB5",
+		types.NewKrbFlags(), kt, 18, 1, st, st, st.Add(time.Hour), st.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error creating ticket: %v", err)
+	}
+	// An RODC with identifier 7 issuing a ticket with key version 1
+	tkt.EncPart.KVNO = 7<<16 | 1
+	kvno, rodcID := tkt.KVNO()
+	assert.Equal(t, 1, kvno, "kvno not as expected")
+	assert.Equal(t, 7, rodcID, "RODC identifier not as expected")
+	err = tkt.DecryptEncPart(kt, nil)
+	if assert.NoError(t, err, "ticket issued by an RODC should decrypt against the keytab's kvno") {
+		assert.Equal(t, "testuser1", tkt.DecryptedEncPart.CName.PrincipalNameString(), "CName not as expected")
+	}
+
+	tkt.EncPart.KVNO = 7<<16 | 9
+	assert.Error(t, tkt.DecryptEncPart(kt, nil), "ticket with a key version not in the keytab should not decrypt")
+
+	tkt.EncPart.KVNO = 1
+	kvno, rodcID = tkt.KVNO()
+	assert.Equal(t, 1, kvno, "kvno not as expected")
+	assert.Equal(t, 0, rodcID, "ticket not issued by an RODC should have no RODC identifier")
+}
+
+func TestTicket_KDCIssuedAuthorizationData(t *testing.T) {
+	t.Parallel()
+	sessionKey := types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)}
+	rand.Read(sessionKey.KeyValue)
+	elements := types.AuthorizationData{{ADType: adtype.ADAndOr, ADData: []byte("restriction")}}
+	newContainer := func(key types.EncryptionKey) []byte {
+		eb, err := asn1.Marshal(elements)
+		if err != nil {
+			t.Fatalf("error marshaling elements: %v", err)
+		}
+		et, _ := crypto.GetEtype(key.KeyType)
+		cksum, err := et.GetChecksumHash(key.KeyValue, eb, keyusage.AD_KDC_ISSUED_CHKSUM)
+		if err != nil {
+			t.Fatalf("error calculating checksum: %v", err)
+		}
+		b, err := asn1.Marshal(adKDCIssued{
+			ADChecksum: types.Checksum{CksumType: et.GetHashID(), Checksum: cksum},
+			IRealm:     "TEST.GOKRB5",
+			Isname:     types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"),
+			Elements:   asn1.RawValue{FullBytes: eb},
+		})
+		if err != nil {
+			t.Fatalf("error marshaling AD-KDC-ISSUED: %v", err)
+		}
+		return b
+	}
+
+	var tkt Ticket
+	tkt.DecryptedEncPart.Key = sessionKey
+	ifRelevant, _ := asn1.Marshal(types.AuthorizationData{{ADType: adtype.ADKDCIssued, ADData: newContainer(sessionKey)}})
+	tkt.DecryptedEncPart.AuthorizationData = types.AuthorizationData{
+		{ADType: adtype.ADKDCIssued, ADData: newContainer(sessionKey)},
+		{ADType: adtype.ADIfRelevant, ADData: ifRelevant},
+	}
+	ad, err := tkt.KDCIssuedAuthorizationData()
+	if err != nil {
+		t.Fatalf("error verifying AD-KDC-ISSUED: %v", err)
+	}
+	assert.Equal(t, append(elements, elements...), ad, "verified authorization data not as expected")
+
+	otherKey := types.EncryptionKey{KeyType: 18, KeyValue: make([]byte, 32)}
+	tkt.DecryptedEncPart.AuthorizationData = types.AuthorizationData{{ADType: adtype.ADKDCIssued, ADData: newContainer(otherKey)}}
+	ad, err = tkt.KDCIssuedAuthorizationData()
+	assert.Nil(t, ad, "authorization data should not be returned when the checksum is not valid")
+	if assert.IsType(t, KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_BAD_INTEGRITY, err.(KRBError).ErrorCode, "error code not as expected")
+	}
+}
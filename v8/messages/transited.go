@@ -0,0 +1,182 @@
+package messages
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/iana/trtype"
+)
+
+// transitedField is a realm name subfield of the DOMAIN-X500-COMPRESS transited encoding.
+type transitedField struct {
+	name    string
+	prepend bool // ends with an unquoted "." so is prepended to the previous realm
+	append  bool // begins with an unquoted "/" so is appended to the previous realm
+	null    bool // empty so all realms between the previous and next realm were traversed
+}
+
+// Realms returns the realms, in order, that the ticket transited between the client's realm and the server's realm
+// by decoding the DOMAIN-X500-COMPRESS encoding defined in RFC 4120 section 3.3.3.2. The client and server realms are
+// not included.
+//
+// A null subfield, which indicates that all realms between the realms either side of it were traversed, is expanded
+// to the realms on the hierarchical path between them. No realms are added for a null subfield between realms of
+// different, domain and X.500, styles as there is no hierarchical path between them.
+func (t TransitedEncoding) Realms(clientRealm, serverRealm string) ([]string, error) {
+	if len(t.Contents) == 0 {
+		return []string{}, nil
+	}
+	if t.TRType != trtype.DOMAIN_X500_COMPRESS {
+		return nil, fmt.Errorf("transited encoding type %d is not supported", t.TRType)
+	}
+	fields, err := parseTransitedFields(string(t.Contents))
+	if err != nil {
+		return nil, err
+	}
+	// Decode the names so that the realms either side of each null subfield are known
+	var prev string
+	decoded := make([]string, len(fields))
+	for i, f := range fields {
+		if f.null {
+			continue
+		}
+		switch {
+		case f.prepend:
+			decoded[i] = f.name + prev
+		case f.append:
+			decoded[i] = prev + f.name
+		default:
+			decoded[i] = f.name
+		}
+		prev = decoded[i]
+	}
+	realms := []string{}
+	for i, f := range fields {
+		if !f.null {
+			realms = append(realms, decoded[i])
+			continue
+		}
+		// Consecutive null subfields, as either side of a lone ",", are expanded once
+		if i > 0 && fields[i-1].null {
+			continue
+		}
+		from := clientRealm
+		if i > 0 {
+			from = decoded[i-1]
+		}
+		to := serverRealm
+		for j := i + 1; j < len(fields); j++ {
+			if !fields[j].null {
+				to = decoded[j]
+				break
+			}
+		}
+		realms = append(realms, hierarchicalPath(from, to)...)
+	}
+	return realms, nil
+}
+
+// parseTransitedFields splits the DOMAIN-X500-COMPRESS encoded contents into its subfields, removing the quoting of
+// the special characters.
+func parseTransitedFields(s string) ([]transitedField, error) {
+	var fields []transitedField
+	var f transitedField
+	var b strings.Builder
+	start := true
+	var lastQuoted bool
+	end := func() {
+		f.name = b.String()
+		if f.name == "" {
+			f.null = true
+		} else if !lastQuoted && strings.HasSuffix(f.name, ".") {
+			f.prepend = true
+		}
+		fields = append(fields, f)
+		f = transitedField{}
+		b.Reset()
+		start = true
+		lastQuoted = false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("transited encoding %q ends with an incomplete quote", s)
+			}
+			i++
+			b.WriteByte(s[i])
+			start = false
+			lastQuoted = true
+			continue
+		case c == ',':
+			end()
+			continue
+		case c == ' ' && start:
+			// A leading space is not part of the name and marks a name beginning with "/" as standing by itself
+			f.append = false
+			start = false
+			continue
+		case c == '/' && start:
+			f.append = true
+		}
+		b.WriteByte(c)
+		start = false
+		lastQuoted = false
+	}
+	end()
+	return fields, nil
+}
+
+// hierarchicalPath returns the realms strictly between the two realms provided on the path through the realm
+// hierarchy from one to the other, for domain style or X.500 style realm names.
+func hierarchicalPath(from, to string) []string {
+	x500 := strings.HasPrefix(from, "/")
+	if x500 != strings.HasPrefix(to, "/") || from == "" || to == "" {
+		return nil
+	}
+	// Components ordered from the root of the hierarchy
+	components := func(r string) []string {
+		if x500 {
+			return strings.Split(strings.TrimPrefix(r, "/"), "/")
+		}
+		c := strings.Split(r, ".")
+		for i, j := 0, len(c)-1; i < j; i, j = i+1, j-1 {
+			c[i], c[j] = c[j], c[i]
+		}
+		return c
+	}
+	name := func(c []string) string {
+		if x500 {
+			return "/" + strings.Join(c, "/")
+		}
+		r := make([]string, len(c))
+		for i := range c {
+			r[len(c)-1-i] = c[i]
+		}
+		return strings.Join(r, ".")
+	}
+	fc := components(from)
+	tc := components(to)
+	var common int
+	for common < len(fc) && common < len(tc) && fc[common] == tc[common] {
+		common++
+	}
+	if common == 0 {
+		return nil
+	}
+	var path []string
+	// Up the hierarchy from the first realm to the common ancestor
+	for l := len(fc) - 1; l >= common; l-- {
+		// The common ancestor is the second realm itself if it is an ancestor of the first
+		if l == common && common == len(tc) {
+			break
+		}
+		path = append(path, name(fc[:l]))
+	}
+	// Down the hierarchy from the common ancestor to the second realm
+	for l := common + 1; l < len(tc); l++ {
+		path = append(path, name(tc[:l]))
+	}
+	return path
+}
@@ -0,0 +1,44 @@
+package messages
+
+import (
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/iana/trtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransitedEncoding_Realms(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		contents string
+		crealm   string
+		srealm   string
+		realms   []string
+	}{
+		// Examples from RFC 4120 section 3.3.3.2
+		{"EDU,MIT.,ATHENA.,WASHINGTON.EDU,CS.", "A.ORG", "B.ORG", []string{"EDU", "MIT.EDU", "ATHENA.MIT.EDU", "WASHINGTON.EDU", "CS.WASHINGTON.EDU"}},
+		{"EDU,MIT.,WASHINGTON.EDU", "ATHENA.MIT.EDU", "CS.WASHINGTON.EDU", []string{"EDU", "MIT.EDU", "WASHINGTON.EDU"}},
+		{"/COM,/HP,/APOLLO, /COM/DEC", "/A", "/B", []string{"/COM", "/COM/HP", "/COM/HP/APOLLO", "/COM/DEC"}},
+		{"/COM,/HP", "/COM/HP/APOLLO", "/COM/DEC", []string{"/COM", "/COM/HP"}},
+		// Null subfields are expanded to the hierarchical path
+		{",", "ATHENA.MIT.EDU", "CS.WASHINGTON.EDU", []string{"MIT.EDU", "EDU", "WASHINGTON.EDU"}},
+		{",EDU, /COM,", "ATHENA.MIT.EDU", "/COM/HP/APOLLO", []string{"MIT.EDU", "EDU", "/COM", "/COM/HP"}},
+		{"", "ATHENA.MIT.EDU", "MIT.EDU", []string{}},
+		// Quoted special characters
+		{`A\,B.ORG,C\.`, "X.ORG", "Y.ORG", []string{"A,B.ORG", "C."}},
+	}
+	for _, test := range tests {
+		te := TransitedEncoding{TRType: trtype.DOMAIN_X500_COMPRESS, Contents: []byte(test.contents)}
+		realms, err := te.Realms(test.crealm, test.srealm)
+		if err != nil {
+			t.Errorf("error decoding transited encoding %q: %v", test.contents, err)
+			continue
+		}
+		assert.Equal(t, test.realms, realms, "transited realms of %q not as expected", test.contents)
+	}
+
+	_, err := TransitedEncoding{TRType: 2, Contents: []byte("EDU")}.Realms("A.EDU", "B.EDU")
+	assert.Error(t, err, "unsupported transited encoding type should error")
+	_, err = TransitedEncoding{TRType: trtype.DOMAIN_X500_COMPRESS, Contents: []byte(`EDU\`)}.Realms("A.EDU", "B.EDU")
+	assert.Error(t, err, "incomplete quote should error")
+}
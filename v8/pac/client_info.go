@@ -2,7 +2,11 @@ package pac
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/jcmturner/rpc/v2/mstypes"
 )
 
@@ -29,3 +33,16 @@ func (k *ClientInfo) Unmarshal(b []byte) (err error) {
 	k.Name, err = r.UTF16String(int(k.NameLength))
 	return
 }
+
+// Verify checks that the client name and authentication time in the ClientInfo match the client principal name and
+// authtime of the ticket the PAC was issued in. A mismatch indicates the PAC has been taken from another ticket.
+// Account names are not case sensitive so the name is compared without regard to case.
+func (k *ClientInfo) Verify(cname types.PrincipalName, authTime time.Time) error {
+	if !strings.EqualFold(k.Name, cname.PrincipalNameString()) {
+		return fmt.Errorf("PAC client name %q does not match the ticket client name %q", k.Name, cname.PrincipalNameString())
+	}
+	if !k.ClientID.Time().Equal(authTime.Truncate(time.Second)) {
+		return fmt.Errorf("PAC client authtime %v does not match the ticket authtime %v", k.ClientID.Time(), authTime)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,3 +26,21 @@ func TestPAC_ClientInfo_Unmarshal(t *testing.T) {
 	assert.Equal(t, uint16(18), k.NameLength, "Client name length not as expected")
 	assert.Equal(t, "testuser1", k.Name, "Client name not as expected")
 }
+
+func TestPAC_ClientInfo_Verify(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(testdata.MarshaledPAC_Client_Info)
+	if err != nil {
+		t.Fatal("Could not decode test data hex string")
+	}
+	var k ClientInfo
+	err = k.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Error unmarshaling test data: %v", err)
+	}
+	authTime := time.Date(2017, 5, 6, 15, 53, 11, 0, time.UTC)
+	assert.NoError(t, k.Verify(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), authTime), "client info should match")
+	assert.NoError(t, k.Verify(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "TestUser1"), authTime), "client name should match without regard to case")
+	assert.Error(t, k.Verify(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser2"), authTime), "different client name should not match")
+	assert.Error(t, k.Verify(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), authTime.Add(time.Minute)), "different authtime should not match")
+}
@@ -249,3 +249,54 @@ func (pac *PACType) verify(key types.EncryptionKey) (bool, error) {
 
 	return true, nil
 }
+
+// VerifyServerSignature verifies the server signature of the marshaled PAC provided using the service's key, detecting
+// whether the PAC has been modified since it was signed by the KDC. Only the server signature is verified as the KDC
+// signature requires the key of the KDC.
+//
+// The signature fields of the PAC are zeroed and the checksum recomputed over the PAC as specified in MS-PAC section
+// 2.8, allowing the PAC to be checked without processing its other buffers.
+func VerifyServerSignature(b []byte, key types.EncryptionKey) error {
+	var pac PACType
+	err := pac.Unmarshal(b)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling PAC: %v", err)
+	}
+	var kdcSig bool
+	for _, buf := range pac.Buffers {
+		if buf.ULType != infoTypePACServerSignatureData && buf.ULType != infoTypePACKDCSignatureData {
+			continue
+		}
+		// Only the first buffer of each signature type is used
+		if (buf.ULType == infoTypePACServerSignatureData && pac.ServerChecksum != nil) ||
+			(buf.ULType == infoTypePACKDCSignatureData && kdcSig) {
+			continue
+		}
+		end := buf.Offset + uint64(buf.CBBufferSize)
+		if end < buf.Offset || end > uint64(len(b)) {
+			return fmt.Errorf("PAC signature buffer of type %d is beyond the end of the PAC", buf.ULType)
+		}
+		var k SignatureData
+		zb, err := k.Unmarshal(b[buf.Offset:end])
+		if err != nil {
+			return fmt.Errorf("error unmarshaling PAC signature buffer of type %d: %v", buf.ULType, err)
+		}
+		copy(pac.ZeroSigData[buf.Offset:end], zb)
+		if buf.ULType == infoTypePACServerSignatureData {
+			pac.ServerChecksum = &k
+		} else {
+			kdcSig = true
+		}
+	}
+	if pac.ServerChecksum == nil {
+		return errors.New("PAC does not contain a server signature")
+	}
+	etype, err := crypto.GetChksumEtype(int32(pac.ServerChecksum.SignatureType))
+	if err != nil {
+		return fmt.Errorf("PAC server signature type %d not supported: %v", pac.ServerChecksum.SignatureType, err)
+	}
+	if !etype.VerifyChecksum(key.KeyValue, pac.ZeroSigData, pac.ServerChecksum.Signature, keyusage.KERB_NON_KERB_CKSUM_SALT) {
+		return errors.New("PAC server signature does not match, the PAC may have been modified or the key is incorrect")
+	}
+	return nil
+}
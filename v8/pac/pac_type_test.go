@@ -67,3 +67,43 @@ func TestPACTypeVerify(t *testing.T) {
 	}
 
 }
+
+func TestVerifyServerSignature(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(testdata.MarshaledPAC_AD_WIN2K_PAC)
+	if err != nil {
+		t.Fatalf("Test vector read error: %v", err)
+	}
+	kb, _ := hex.DecodeString(testdata.KEYTAB_SYSHTTP_TEST_GOKRB5)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	pn, _ := types.ParseSPNString("sysHTTP")
+	key, _, err := kt.GetEncryptionKey(pn, "TEST.GOKRB5", 2, 18)
+	if err != nil {
+		t.Fatalf("Error getting key: %v", err)
+	}
+	assert.NoError(t, VerifyServerSignature(b, key), "server signature of reference PAC should verify")
+
+	var pac PACType
+	pac.Unmarshal(b)
+	for _, buf := range pac.Buffers {
+		switch buf.ULType {
+		case infoTypeKerbValidationInfo:
+			// Modifying the data the signature is over should fail verification
+			mb := make([]byte, len(b))
+			copy(mb, b)
+			mb[buf.Offset+8] ^= 0xFF
+			assert.Error(t, VerifyServerSignature(mb, key), "modified PAC should fail verification")
+		case infoTypePACKDCSignatureData:
+			// The KDC signature is zeroed so changing it should not affect the server signature
+			mb := make([]byte, len(b))
+			copy(mb, b)
+			mb[buf.Offset+4] ^= 0xFF
+			assert.NoError(t, VerifyServerSignature(mb, key), "KDC signature should not be covered by the server signature")
+		}
+	}
+	wrongKey := types.EncryptionKey{KeyType: key.KeyType, KeyValue: make([]byte, len(key.KeyValue))}
+	assert.Error(t, VerifyServerSignature(b, wrongKey), "verification with the wrong key should fail")
+	assert.Error(t, VerifyServerSignature(b[:len(b)-8], key), "truncated PAC should fail verification")
+	assert.Error(t, VerifyServerSignature([]byte{0x01}, key), "malformed PAC should fail verification")
+}
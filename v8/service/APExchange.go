@@ -1,29 +1,118 @@
 package service
 
 import (
+	"crypto/hmac"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
 	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
 )
 
+// anonymousRealm is the well-known anonymous realm defined in RFC 8062.
+const anonymousRealm = "WELLKNOWN:ANONYMOUS"
+
+// policyErrorCode is the error code of the KRBError returned when an otherwise valid AP_REQ does not meet the
+// requirements the service is configured with, such as RequirePreAuth or MinEType. KRB_AP_ERR_METHOD tells the client
+// that authentication with the ticket or authenticator presented is not acceptable to the service.
+const policyErrorCode = errorcode.KRB_AP_ERR_METHOD
+
 // VerifyAPREQ verifies an AP_REQ sent to the service. Returns a boolean for if the AP_REQ is valid and the client's principal name and realm.
 func VerifyAPREQ(APReq *messages.APReq, s *Settings) (bool, *credentials.Credentials, error) {
+	ok, creds, err := verifyAPREQ(APReq, s)
+	if err != nil {
+		s.Diagnose(DiagnoseAPREQ(APReq, s, err))
+	}
+	return ok, creds, err
+}
+
+func verifyAPREQ(APReq *messages.APReq, s *Settings) (bool, *credentials.Credentials, error) {
 	var creds *credentials.Credentials
-	ok, err := APReq.Verify(s.Keytab, s.MaxClockSkew(), s.ClientAddress(), s.KeytabPrincipal())
+	// A TGT is only ever presented to a KDC. Service authentication always uses a service ticket, including for
+	// clients of a trusted realm, which present a service ticket issued by that realm.
+	if isTGT(APReq.Ticket) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_NOT_US, "ticket presented is a TGT which is not accepted for service authentication")
+	}
+	if len(s.ServiceClasses()) > 0 && !isServiceClass(APReq.Ticket.SName, s.ServiceClasses()) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_NOT_US, fmt.Sprintf("ticket for %s is not for an accepted service class", APReq.Ticket.SName.PrincipalNameString()))
+	}
+	if s.RequireMutualAuth() && !isMutualRequired(APReq.APOptions) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "mutual authentication is required by the service")
+	}
+	if s.MinEType() != 0 && etypeStrength(APReq.Ticket.EncPart.EType) < etypeStrength(s.MinEType()) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, fmt.Sprintf("ticket encryption type %d is weaker than the minimum permitted", APReq.Ticket.EncPart.EType))
+	}
+	kt, ktprinc, err := ticketKeytab(APReq.Ticket, s)
+	if err != nil {
+		return false, creds, err
+	}
+	kt, err = ticketKVNOKeytab(APReq.Ticket, kt, ktprinc, s)
+	if err != nil {
+		return false, creds, err
+	}
+	ok, err := APReq.Verify(kt, s.MaxClockSkew(), s.ClientAddress(), ktprinc)
 	if err != nil || !ok {
 		return false, creds, err
 	}
 
+	if d := s.MaxAuthenticatorAge(); d > 0 {
+		ct := APReq.Authenticator.CTime.Add(time.Duration(APReq.Authenticator.Cusec) * time.Microsecond)
+		if age := time.Now().UTC().Sub(ct); age > d {
+			return false, creds,
+				messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_SKEW, fmt.Sprintf("authenticator created %v ago is older than the maximum age of %v", age.Truncate(time.Second), d))
+		}
+	}
+
 	if s.RequireHostAddr() && len(APReq.Ticket.DecryptedEncPart.CAddr) < 1 {
 		return false, creds,
 			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_BADADDR, "ticket does not contain HostAddress values required")
 	}
 
-	// Check for replay
-	rc := GetReplayCache(s.MaxClockSkew())
-	if rc.IsReplay(APReq.Ticket.SName, APReq.Authenticator) {
+	if s.RequirePreAuth() && !types.IsFlagSet(&APReq.Ticket.DecryptedEncPart.Flags, flags.PreAuthent) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "ticket was not issued with pre-authentication as required by the service")
+	}
+
+	if !s.AllowAnonymous() && isAnonymous(APReq.Ticket) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "anonymous tickets are not accepted")
+	}
+
+	subkey := APReq.Authenticator.SubKey
+	if s.RequireSubkey() && len(subkey.KeyValue) < 1 {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "authenticator does not contain the subkey required by the service for message protection")
+	}
+	if s.RejectWeakSubkeys() && len(subkey.KeyValue) > 0 && isWeakEType(subkey.KeyType) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, fmt.Sprintf("authenticator subkey encryption type %d is not permitted", subkey.KeyType))
+	}
+	if s.RequireGSSChecksum() && !hasGSSChecksum(APReq.Authenticator) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "authenticator does not carry the GSS checksum required by the service")
+	}
+	if cb := s.ChannelBindings(); cb != nil && !hasChannelBindings(APReq.Authenticator, *cb) {
+		return false, creds,
+			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, policyErrorCode, "authenticator does not carry the channel bindings required by the service")
+	}
+
+	// Check for replay last so that an authenticator rejected by the checks above is not recorded in the replay cache
+	if s.ReplayCache().IsReplay(s.ReplayWindow(), APReq.Ticket.SName, APReq.Authenticator) {
 		return false, creds,
 			messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_REPEAT, "replay detected")
 	}
@@ -33,14 +122,58 @@ func VerifyAPREQ(APReq *messages.APReq, s *Settings) (bool, *credentials.Credent
 	creds.SetAuthTime(time.Now().UTC())
 	creds.SetAuthenticated(true)
 	creds.SetValidUntil(APReq.Ticket.DecryptedEncPart.EndTime)
+	creds.SetAttribute(credentials.AttributeKeyTicketFlags, APReq.Ticket.DecryptedEncPart.Flags)
+	creds.SetAttribute(credentials.AttributeKeyTicketEType, APReq.Ticket.EncPart.EType)
+	creds.SetAttribute(credentials.AttributeKeySessionKeyEType, APReq.Ticket.DecryptedEncPart.Key.KeyType)
+	creds.SetAttribute(credentials.AttributeKeyTicketAuthTime, APReq.Ticket.DecryptedEncPart.AuthTime)
+	if !APReq.Ticket.DecryptedEncPart.RenewTill.IsZero() {
+		creds.SetAttribute(credentials.AttributeKeyTicketRenewTill, APReq.Ticket.DecryptedEncPart.RenewTill)
+	}
+	if len(subkey.KeyValue) > 0 {
+		creds.SetAttribute(credentials.AttributeKeySubkeyEType, subkey.KeyType)
+	}
+	// The KDC is responsible for checking the transited realms against policy so a field that cannot be decoded does
+	// not prevent authentication, the realms are just not recorded
+	transited, err := APReq.Ticket.DecryptedEncPart.Transited.Realms(APReq.Ticket.DecryptedEncPart.CRealm, APReq.Ticket.Realm)
+	if err == nil {
+		creds.SetAttribute(credentials.AttributeKeyTransitedRealms, transited)
+	} else if s.Logger() != nil {
+		s.Logger().Printf("could not decode the transited realms of the ticket for %s: %v", APReq.Ticket.SName.PrincipalNameString(), err)
+	}
+
+	if s.ContextValue(ContextValueTicket) {
+		b, err := APReq.Ticket.Marshal()
+		if err != nil {
+			return false, creds, fmt.Errorf("error marshaling ticket for the client's credentials: %v", err)
+		}
+		creds.SetAttribute(credentials.AttributeKeyTicket, b)
+	}
+	if s.ContextValue(ContextValueSessionKey) {
+		// The key value is copied as the ticket's session key is zeroized when the security context is deleted
+		k := APReq.Ticket.DecryptedEncPart.Key
+		creds.SetAttribute(credentials.AttributeKeySessionKey, types.EncryptionKey{KeyType: k.KeyType, KeyValue: append([]byte(nil), k.KeyValue...)})
+	}
+	if s.ContextValue(ContextValueAuthorizationData) {
+		creds.SetAttribute(credentials.AttributeKeyAuthorizationData, APReq.Ticket.DecryptedEncPart.AuthorizationData)
+	}
 
 	//PAC decoding
-	if !s.disablePACDecoding {
-		isPAC, pac, err := APReq.Ticket.GetPACType(s.Keytab, s.KeytabPrincipal(), s.Logger())
+	if !s.disablePACDecoding && (s.ContextValue(ContextValueGroups|ContextValueClaims) || s.VerifyPACClientInfo()) {
+		isPAC, pac, err := APReq.Ticket.GetPACType(kt, ktprinc, s.Logger())
 		if isPAC && err != nil {
 			return false, creds, err
 		}
-		if isPAC {
+		if isPAC && s.VerifyPACClientInfo() {
+			err = pac.ClientInfo.Verify(APReq.Ticket.DecryptedEncPart.CName, APReq.Ticket.DecryptedEncPart.AuthTime)
+			if err != nil {
+				return false, creds,
+					messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_MODIFIED, fmt.Sprintf("PAC does not match the ticket: %v", err))
+			}
+		}
+		if isPAC && s.ContextValue(ContextValueClaims) && pac.ClientClaimsInfo != nil {
+			creds.SetAttribute(credentials.AttributeKeyClientClaims, pac.ClientClaimsInfo.ClaimsSet)
+		}
+		if isPAC && s.ContextValue(ContextValueGroups) {
 			// There is a valid PAC. Adding attributes to creds
 			creds.SetADCredentials(credentials.ADCredentials{
 				GroupMembershipSIDs: pac.KerbValidationInfo.GetGroupMembershipSIDs(),
@@ -59,3 +192,323 @@ func VerifyAPREQ(APReq *messages.APReq, s *Settings) (bool, *credentials.Credent
 	}
 	return true, creds, nil
 }
+
+// ticketKeytab returns the first of the service's keytabs, in priority order, holding a key that decrypts the ticket
+// and the principal whose key is to be used if it is not the ticket's SName.
+// When only one keytab is configured it is returned without attempting decryption.
+func ticketKeytab(tkt messages.Ticket, s *Settings) (*keytab.Keytab, *types.PrincipalName, error) {
+	kts := s.Keytabs()
+	var errs []string
+	for _, kt := range kts {
+		if s.RealmCaseInsensitive() {
+			kt = s.realmFoldedKeytab(kt, tkt.Realm)
+		}
+		if s.TrustedRealm(tkt.Realm) {
+			kt = trustedRealmKeytab(kt, tkt.Realm)
+		}
+		ktprinc := s.KeytabPrincipal()
+		if ktprinc == nil && isSPNAlias(tkt.SName, s.SPNAliases()) {
+			p, err := aliasKeytabPrincipal(tkt, kt)
+			if err != nil {
+				if len(kts) == 1 {
+					return nil, nil, err
+				}
+				errs = append(errs, err.Error())
+				continue
+			}
+			ktprinc = p
+		}
+		if len(kts) == 1 {
+			return kt, ktprinc, nil
+		}
+		if err := tkt.DecryptEncPart(kt, ktprinc); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return kt, ktprinc, nil
+	}
+	return nil, nil, messages.NewKRBError(tkt.SName, tkt.Realm, errorcode.KRB_AP_ERR_NOKEY,
+		fmt.Sprintf("no keytab decrypts the ticket: %s", strings.Join(errs, "; ")))
+}
+
+// ticketKVNOKeytab checks the keytab holds a key of the ticket's kvno for the principal. During a key rollover a client
+// may present a ticket issued under a kvno the service no longer holds, which is reported with a KRB_AP_ERR_BADKEYVER
+// KRBError listing the ticket's kvno and those held in the keytab. If the service is configured with KVNOFallback the
+// principal's other keys of the ticket's encryption type are tried instead, in descending kvno order, and a keytab
+// holding the key that decrypts the ticket under the ticket's kvno is returned.
+//
+// If the keytab holds no keys for the principal and encryption type the keytab is returned as is so that the usual
+// error for a missing key is reported.
+func ticketKVNOKeytab(tkt messages.Ticket, kt *keytab.Keytab, ktprinc *types.PrincipalName, s *Settings) (*keytab.Keytab, error) {
+	if tkt.EncPart.KVNO == 0 {
+		return kt, nil
+	}
+	sname := tkt.SName
+	if ktprinc != nil {
+		sname = *ktprinc
+	}
+	kvno, _ := tkt.KVNO()
+	var entries []int
+	var held []int
+	for i, e := range kt.Entries {
+		if e.Principal.Realm != tkt.Realm || e.Key.KeyType != tkt.EncPart.EType ||
+			strings.Join(e.Principal.Components, "/") != strings.Join(sname.NameString, "/") {
+			continue
+		}
+		if e.KVNO == uint32(tkt.EncPart.KVNO) || e.KVNO == uint32(kvno) {
+			return kt, nil
+		}
+		entries = append(entries, i)
+		held = append(held, int(e.KVNO))
+	}
+	if len(entries) < 1 {
+		return kt, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return kt.Entries[entries[i]].KVNO > kt.Entries[entries[j]].KVNO
+	})
+	sort.Sort(sort.Reverse(sort.IntSlice(held)))
+	if s.KVNOFallback() {
+		for _, i := range entries {
+			e := kt.Entries[i]
+			if err := tkt.Decrypt(e.Key); err != nil {
+				continue
+			}
+			if s.Logger() != nil {
+				s.Logger().Printf("ticket for %s has kvno %d which is not in the keytab, it was decrypted with the key of kvno %d", sname.PrincipalNameString(), tkt.EncPart.KVNO, e.KVNO)
+			}
+			e.KVNO = uint32(tkt.EncPart.KVNO)
+			e.KVNO8 = uint8(tkt.EncPart.KVNO)
+			fkt := keytab.New()
+			fkt.Entries = append(fkt.Entries, e)
+			return fkt, nil
+		}
+	}
+	msg := fmt.Sprintf("ticket for %s has kvno %d but the keytab holds kvnos %v for etype %d, the service key may have been rolled over", sname.PrincipalNameString(), tkt.EncPart.KVNO, held, tkt.EncPart.EType)
+	if s.Logger() != nil {
+		s.Logger().Print(msg)
+	}
+	return nil, messages.NewKRBError(tkt.SName, tkt.Realm, errorcode.KRB_AP_ERR_BADKEYVER, msg)
+}
+
+// foldKeytabRealm returns a keytab with the realm of the entries of principals that have no key in the realm provided,
+// but do in a realm that differs from it only in case, replaced with it. If there are no such entries the keytab is
+// returned as is.
+func foldKeytabRealm(kt *keytab.Keytab, realm string) *keytab.Keytab {
+	held := make(map[string]bool)
+	for _, e := range kt.Entries {
+		if e.Principal.Realm == realm {
+			held[strings.Join(e.Principal.Components, "/")] = true
+		}
+	}
+	fold := func(r string, components []string) bool {
+		return r != realm && strings.EqualFold(r, realm) && !held[strings.Join(components, "/")]
+	}
+	var n int
+	for _, e := range kt.Entries {
+		if fold(e.Principal.Realm, e.Principal.Components) {
+			n++
+		}
+	}
+	if n < 1 {
+		return kt
+	}
+	fkt := keytab.New()
+	for _, e := range kt.Entries {
+		if fold(e.Principal.Realm, e.Principal.Components) {
+			e.Principal.Realm = realm
+		}
+		fkt.Entries = append(fkt.Entries, e)
+	}
+	return fkt
+}
+
+// foldKeytabRealms returns, for each of the keytabs provided, the keytabs folded by foldKeytabRealm to the upper case
+// form of each realm they hold keys for, which is the form in which Active Directory issues tickets.
+func foldKeytabRealms(kts []*keytab.Keytab) map[*keytab.Keytab]map[string]*keytab.Keytab {
+	folded := make(map[*keytab.Keytab]map[string]*keytab.Keytab)
+	for _, kt := range kts {
+		if kt == nil {
+			continue
+		}
+		folded[kt] = make(map[string]*keytab.Keytab)
+		for _, e := range kt.Entries {
+			realm := strings.ToUpper(e.Principal.Realm)
+			if _, ok := folded[kt][realm]; !ok {
+				folded[kt][realm] = foldKeytabRealm(kt, realm)
+			}
+		}
+	}
+	return folded
+}
+
+// trustedRealmKeytab returns a keytab that, in addition to the entries of the keytab provided, holds the entries of
+// principals that have no key in the trusted realm relabelled with that realm. This allows a ticket issued by the
+// trusted realm to be decrypted with the service's key from its own realm where the key is shared between the realms.
+// If every principal has a key in the trusted realm the keytab is returned as is.
+func trustedRealmKeytab(kt *keytab.Keytab, realm string) *keytab.Keytab {
+	held := make(map[string]bool)
+	for _, e := range kt.Entries {
+		if e.Principal.Realm == realm {
+			held[strings.Join(e.Principal.Components, "/")] = true
+		}
+	}
+	rkt := keytab.New()
+	rkt.Entries = append(rkt.Entries, kt.Entries...)
+	for _, e := range kt.Entries {
+		if e.Principal.Realm != realm && !held[strings.Join(e.Principal.Components, "/")] {
+			e.Principal.Realm = realm
+			rkt.Entries = append(rkt.Entries, e)
+		}
+	}
+	if len(rkt.Entries) == len(kt.Entries) {
+		return kt
+	}
+	return rkt
+}
+
+// isSPNAlias indicates if the service principal name is one of the aliases provided.
+func isSPNAlias(sname types.PrincipalName, aliases []string) bool {
+	spn := sname.PrincipalNameString()
+	for _, a := range aliases {
+		if strings.EqualFold(a, spn) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasKeytabPrincipal returns the principal of the keytab whose key decrypts the ticket issued to an SPN alias.
+// Keys are tried in order of descending kvno and then timestamp, so that the selection does not depend on the order of
+// the entries in the keytab.
+// If no key in the keytab decrypts the ticket a KRB_AP_ERR_NOKEY KRBError is returned listing each key attempted
+// and why it could not be used.
+func aliasKeytabPrincipal(tkt messages.Ticket, kt *keytab.Keytab) (*types.PrincipalName, error) {
+	var reasons []string
+	kvno, _ := tkt.KVNO()
+	for _, i := range newestEntries(kt) {
+		e := kt.Entries[i]
+		pn := types.PrincipalName{
+			NameType:   e.Principal.NameType,
+			NameString: e.Principal.Components,
+		}
+		key := fmt.Sprintf("%s@%s kvno %d etype %d", pn.PrincipalNameString(), e.Principal.Realm, e.KVNO, e.Key.KeyType)
+		switch {
+		case e.Principal.Realm != tkt.Realm:
+			reasons = append(reasons, fmt.Sprintf("%s: realm does not match ticket realm %s", key, tkt.Realm))
+		case e.Key.KeyType != tkt.EncPart.EType:
+			reasons = append(reasons, fmt.Sprintf("%s: etype does not match ticket etype %d", key, tkt.EncPart.EType))
+		case tkt.EncPart.KVNO != 0 && e.KVNO != uint32(tkt.EncPart.KVNO) && e.KVNO != uint32(kvno):
+			reasons = append(reasons, fmt.Sprintf("%s: kvno does not match ticket kvno %d", key, tkt.EncPart.KVNO))
+		default:
+			err := tkt.Decrypt(e.Key)
+			if err == nil {
+				return &pn, nil
+			}
+			reasons = append(reasons, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	if len(reasons) < 1 {
+		reasons = append(reasons, "keytab has no entries")
+	}
+	return nil, messages.NewKRBError(tkt.SName, tkt.Realm, errorcode.KRB_AP_ERR_NOKEY,
+		fmt.Sprintf("no key in the keytab decrypts the ticket for SPN alias %s: %s", tkt.SName.PrincipalNameString(), strings.Join(reasons, "; ")))
+}
+
+// newestEntries returns the indexes of the keytab's entries sorted by descending kvno and then timestamp.
+func newestEntries(kt *keytab.Keytab) []int {
+	idx := make([]int, len(kt.Entries))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		ei, ej := kt.Entries[idx[i]], kt.Entries[idx[j]]
+		if ei.KVNO != ej.KVNO {
+			return ei.KVNO > ej.KVNO
+		}
+		return ei.Timestamp.After(ej.Timestamp)
+	})
+	return idx
+}
+
+// isTGT indicates if the ticket is a ticket granting ticket, that is a ticket for a krbtgt principal.
+func isTGT(tkt messages.Ticket) bool {
+	return len(tkt.SName.NameString) > 0 && strings.EqualFold(tkt.SName.NameString[0], "krbtgt")
+}
+
+// hasGSSChecksum indicates if the authenticator checksum is a GSS checksum as defined in RFC 4121 Section 4.1.1.
+func hasGSSChecksum(a types.Authenticator) bool {
+	// The first four octets hold the length of the channel bindings hash which is always 16
+	return a.Cksum.CksumType == chksumtype.GSSAPI && len(a.Cksum.Checksum) >= 24 && binary.LittleEndian.Uint32(a.Cksum.Checksum[0:4]) == 16
+}
+
+// hasChannelBindings indicates if the authenticator checksum carries the hash of the channel bindings provided.
+func hasChannelBindings(a types.Authenticator, cb gssapi.ChannelBindings) bool {
+	// RFC 4121 Section 4.1.1 the channel bindings hash is in octets 4 to 19 of the GSS checksum
+	if a.Cksum.CksumType != chksumtype.GSSAPI || len(a.Cksum.Checksum) < 24 {
+		return false
+	}
+	return hmac.Equal(a.Cksum.Checksum[4:20], cb.Hash())
+}
+
+// isMutualRequired indicates if the AP options request mutual authentication.
+func isMutualRequired(o asn1.BitString) bool {
+	return len(o.Bytes) > 0 && types.IsFlagSet(&o, flags.APOptionMutualRequired)
+}
+
+// isServiceClass indicates if the SPN is of one of the service classes provided.
+func isServiceClass(sname types.PrincipalName, classes []string) bool {
+	// A principal name of a single component is not an SPN and so has no service class
+	if len(sname.NameString) < 2 {
+		return false
+	}
+	for _, c := range classes {
+		if strings.EqualFold(sname.NameString[0], c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAnonymous indicates if the decrypted ticket is an anonymous ticket, either by having the anonymous flag set or by
+// being issued to the well-known anonymous principal or realm (RFC 8062).
+func isAnonymous(tkt messages.Ticket) bool {
+	if types.IsFlagSet(&tkt.DecryptedEncPart.Flags, flags.Anonymous) {
+		return true
+	}
+	if tkt.DecryptedEncPart.CRealm == anonymousRealm {
+		return true
+	}
+	cname := tkt.DecryptedEncPart.CName
+	return len(cname.NameString) == 2 && cname.NameString[0] == "WELLKNOWN" && cname.NameString[1] == "ANONYMOUS"
+}
+
+// isWeakEType indicates if the encryption type is weaker than AES, such as the DES, triple DES or RC4 encryption types,
+// or is not known.
+func isWeakEType(et int32) bool {
+	return etypeStrength(et) < etypeStrength(etypeID.AES128_CTS_HMAC_SHA1_96)
+}
+
+// etypeStrength returns a relative measure of the strength of the encryption type, higher being stronger.
+// Unknown encryption types have a strength of zero.
+func etypeStrength(et int32) int {
+	switch et {
+	case etypeID.DES_CBC_CRC, etypeID.DES_CBC_MD4, etypeID.DES_CBC_MD5, etypeID.DES_CBC_RAW, etypeID.DES_HMAC_SHA1:
+		return 1
+	case etypeID.RC4_HMAC_EXP:
+		return 2
+	case etypeID.RC4_HMAC:
+		return 3
+	case etypeID.DES3_CBC_MD5, etypeID.DES3_CBC_RAW, etypeID.DES3_CBC_SHA1, etypeID.DES3_CBC_SHA1_KD:
+		return 4
+	case etypeID.AES128_CTS_HMAC_SHA1_96:
+		return 5
+	case etypeID.AES128_CTS_HMAC_SHA256_128:
+		return 6
+	case etypeID.AES256_CTS_HMAC_SHA1_96:
+		return 7
+	case etypeID.AES256_CTS_HMAC_SHA384_192:
+		return 8
+	}
+	return 0
+}
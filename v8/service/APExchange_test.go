@@ -3,15 +3,25 @@ package service
 import (
 	"encoding/hex"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
 	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
 	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/trtype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
@@ -61,6 +71,583 @@ func TestVerifyAPREQ(t *testing.T) {
 	}
 }
 
+func TestVerifyAPREQ_TicketFlags(t *testing.T) {
+	t.Parallel()
+	f := types.NewKrbFlags()
+	types.SetFlag(&f, flags.Forwardable)
+	APReq, kt := newTestAPReq(t, f)
+
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	s := NewSettings(kt, ClientAddress(h))
+	ok, creds, err := VerifyAPREQ(&APReq, s)
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ failed when it should not have: %v", err)
+	}
+	tf, ok := creds.Attributes()[credentials.AttributeKeyTicketFlags].(asn1.BitString)
+	if !ok {
+		t.Fatal("ticket flags not set in the credentials attributes")
+	}
+	assert.True(t, types.IsFlagSet(&tf, flags.Forwardable), "forwardable flag not set in credentials ticket flags")
+	assert.Equal(t, int32(18), creds.Attributes()[credentials.AttributeKeyTicketEType], "ticket etype attribute not as expected")
+	assert.Equal(t, int32(18), creds.Attributes()[credentials.AttributeKeySessionKeyEType], "session key etype attribute not as expected")
+}
+
+func TestVerifyAPREQ_RejectWeakSubkeys(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	var tests = []struct {
+		etype  int32
+		size   int
+		reject bool
+	}{
+		{18, 32, false},
+		{17, 16, false},
+		{23, 16, true},
+		{16, 24, true},
+	}
+	for _, test := range tests {
+		auth, _ := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+		auth.GenerateSeqNumberAndSubKey(test.etype, test.size)
+
+		// Subkeys are accepted when weak subkeys are not rejected
+		APReq, kt := newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+		ok, creds, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+		if !ok || err != nil {
+			t.Fatalf("Validation of AP_REQ with subkey etype %d failed when it should not have: %v", test.etype, err)
+		}
+		assert.Equal(t, test.etype, creds.Attributes()[credentials.AttributeKeySubkeyEType], "subkey etype attribute not as expected")
+
+		auth, _ = types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+		auth.GenerateSeqNumberAndSubKey(test.etype, test.size)
+		APReq, kt = newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+		ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RejectWeakSubkeys(true)))
+		if test.reject {
+			assert.False(t, ok, "AP_REQ with weak subkey etype %d should not be valid", test.etype)
+			if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+				assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+			}
+			continue
+		}
+		if !ok || err != nil {
+			t.Errorf("Validation of AP_REQ with subkey etype %d failed when it should not have: %v", test.etype, err)
+		}
+	}
+}
+
+func TestVerifyAPREQ_RealmCaseInsensitive(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	// Store the keys under a realm that differs only in case from the ticket's, as seen with Active Directory
+	lkt := keytab.New()
+	for _, e := range kt.Entries {
+		e.Principal.Realm = strings.ToLower(e.Principal.Realm)
+		lkt.Entries = append(lkt.Entries, e)
+	}
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(lkt, ClientAddress(h)))
+	assert.False(t, ok, "AP_REQ should not be valid when the keytab realm case differs by default")
+	assert.Error(t, err, "AP_REQ should error when the keytab realm case differs by default")
+
+	APReq, _ = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(lkt, ClientAddress(h), RealmCaseInsensitive(true)))
+	if !ok || err != nil {
+		t.Errorf("validation of AP_REQ failed with a case insensitive realm match: %v", err)
+	}
+	assert.Equal(t, "test.gokrb5", lkt.Entries[0].Principal.Realm, "keytab provided should not be modified")
+
+	// The keytab is folded once when the settings are built
+	s := NewSettings(lkt, ClientAddress(h), RealmCaseInsensitive(true))
+	fkt := s.realmFoldedKeytab(lkt, "TEST.GOKRB5")
+	assert.True(t, fkt == s.realmFoldedKeytab(lkt, "TEST.GOKRB5"), "keytab should not be folded for each request")
+	assert.Equal(t, "TEST.GOKRB5", fkt.Entries[0].Principal.Realm, "folded keytab realm not as expected")
+
+	// An entry of another principal in the ticket's realm does not prevent the service's keys being folded
+	lkt.AddEntry("HTTP/other.test.gokrb5", "TEST.GOKRB5", "password", time.Now(), 1, 18)
+	APReq, _ = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(lkt, ClientAddress(h), RealmCaseInsensitive(true)))
+	if !ok || err != nil {
+		t.Errorf("validation of AP_REQ failed with a case insensitive realm match when the keytab holds another principal in the ticket's realm: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_MinEType(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	var tests = []struct {
+		min    int32
+		reject bool
+	}{
+		{etypeID.RC4_HMAC, false},
+		{etypeID.AES128_CTS_HMAC_SHA1_96, false},
+		{etypeID.AES256_CTS_HMAC_SHA1_96, false},
+		{etypeID.AES256_CTS_HMAC_SHA384_192, true},
+	}
+	for _, test := range tests {
+		// The test ticket is encrypted with aes256-cts-hmac-sha1-96
+		APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+		ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), MinEType(test.min)))
+		if test.reject {
+			assert.False(t, ok, "AP_REQ should not be valid with minimum etype %d", test.min)
+			if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+				assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+			}
+			continue
+		}
+		if !ok || err != nil {
+			t.Errorf("Validation of AP_REQ failed with minimum etype %d when it should not have: %v", test.min, err)
+		}
+	}
+	assert.True(t, etypeStrength(etypeID.RC4_HMAC) < etypeStrength(etypeID.AES128_CTS_HMAC_SHA1_96), "RC4 should be weaker than AES128")
+	assert.True(t, etypeStrength(etypeID.DES_CBC_MD5) < etypeStrength(etypeID.DES3_CBC_SHA1_KD), "DES should be weaker than triple DES")
+}
+
+func TestVerifyAPREQ_Anonymous(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	f := types.NewKrbFlags()
+	types.SetFlag(&f, flags.Anonymous)
+
+	APReq, kt := newTestAPReq(t, f)
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	assert.False(t, ok, "anonymous AP_REQ should not be valid by default")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+
+	APReq, kt = newTestAPReq(t, f)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), AllowAnonymous(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of anonymous AP_REQ failed when anonymous tickets are allowed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_TGT(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	APReq.Ticket.SName = types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5")
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	assert.False(t, ok, "AP_REQ presenting a TGT should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_NOT_US, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+	assert.False(t, isTGT(messages.Ticket{SName: types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")}), "service ticket should not be a TGT")
+}
+
+func TestVerifyAPREQ_TrustedRealms(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	// A ticket issued by the trusted realm encrypted with the service's key shared between the realms
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	APReq.Ticket.Realm = "TRUSTED.GOKRB5"
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	assert.False(t, ok, "AP_REQ with a ticket from another realm should not be valid by default")
+	assert.Error(t, err, "AP_REQ with a ticket from another realm should error by default")
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	APReq.Ticket.Realm = "TRUSTED.GOKRB5"
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), TrustedRealms("TRUSTED.GOKRB5")))
+	if !ok || err != nil {
+		t.Errorf("validation of AP_REQ with a ticket from a trusted realm failed: %v", err)
+	}
+	assert.Equal(t, "TEST.GOKRB5", kt.Entries[0].Principal.Realm, "keytab provided should not be modified")
+
+	// A cross-realm TGT issued by the trusted realm encrypted with the cross-realm TGS principal's key
+	tgskt := keytab.New()
+	for _, e := range kt.Entries {
+		e.Principal.Components = []string{"krbtgt", "TEST.GOKRB5"}
+		e.Principal.Realm = "TRUSTED.GOKRB5"
+		tgskt.Entries = append(tgskt.Entries, e)
+	}
+	cl := getClient()
+	st := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+		types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/TEST.GOKRB5"), "TRUSTED.GOKRB5",
+		types.NewKrbFlags(), tgskt, 18, 1, st, st, st.Add(time.Hour), st.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("error getting test cross-realm TGT: %v", err)
+	}
+	newAPReq := func() messages.APReq {
+		APReq, err := messages.NewAPReq(tkt, sessionKey, newTestAuthenticator(*cl.Credentials))
+		if err != nil {
+			t.Fatalf("error getting test AP_REQ: %v", err)
+		}
+		return APReq
+	}
+	APReq = newAPReq()
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(tgskt, ClientAddress(h)))
+	assert.False(t, ok, "AP_REQ presenting a cross-realm TGT should not be valid by default")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_NOT_US, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+	APReq = newAPReq()
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(tgskt, ClientAddress(h), TrustedRealms("TRUSTED.GOKRB5")))
+	assert.False(t, ok, "AP_REQ presenting a cross-realm TGT from a trusted realm should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_NOT_US, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+}
+
+func TestVerifyAPREQ_ServiceClasses(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	// The test ticket is for HTTP/host.test.gokrb5
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), ServiceClasses("host", "http")))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ for an accepted service class failed: %v", err)
+	}
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), ServiceClasses("cifs")))
+	assert.False(t, ok, "AP_REQ for a service class not accepted should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_NOT_US, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+	assert.False(t, isServiceClass(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP"), []string{"HTTP"}), "principal of a single component should not have a service class")
+}
+
+func TestVerifyAPREQ_RequireMutualAuth(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequireMutualAuth(true)))
+	assert.False(t, ok, "AP_REQ without mutual authentication should not be valid when it is required")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	types.SetFlag(&APReq.APOptions, flags.APOptionMutualRequired)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequireMutualAuth(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ requesting mutual authentication failed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_RequireSubkey(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	auth, _ := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	APReq, kt := newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequireSubkey(true)))
+	assert.False(t, ok, "AP_REQ without a subkey should not be valid when one is required")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequireSubkey(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with a subkey failed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_RequireGSSChecksum(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	// The test authenticator does not carry a GSS checksum
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	rc := new(testReplayCache)
+	s := NewSettings(kt, ClientAddress(h), CustomReplayCache(rc), RequireGSSChecksum(true))
+	ok, _, err := VerifyAPREQ(&APReq, s)
+	assert.False(t, ok, "AP_REQ without a GSS checksum should not be valid when one is required")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+	assert.Equal(t, DiagnosticStepPolicy, DiagnoseAPREQ(&APReq, s, err).Step, "diagnostic step not as expected")
+	assert.Equal(t, 0, rc.calls, "authenticator rejected by policy should not be checked against the replay cache")
+
+	cl := getClient()
+	auth := newTestAuthenticator(*cl.Credentials)
+	auth.Cksum, _ = gssapi.NewAuthenticatorChecksum(nil, gssapi.ContextFlagInteg|gssapi.ContextFlagConf, nil)
+	APReq, kt = newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequireGSSChecksum(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with a GSS checksum failed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_ChannelBindings(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	cb := gssapi.ChannelBindings{ApplicationData: []byte("tls-server-end-point:test")}
+	other := gssapi.ChannelBindings{ApplicationData: []byte("tls-server-end-point:other")}
+	cl := getClient()
+	newAPReq := func(bnd []byte) (messages.APReq, *keytab.Keytab) {
+		auth := newTestAuthenticator(*cl.Credentials)
+		auth.Cksum, _ = gssapi.NewAuthenticatorChecksum(bnd, gssapi.ContextFlagInteg|gssapi.ContextFlagConf, nil)
+		return newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+	}
+
+	APReq, kt := newAPReq(other.Hash())
+	rc := new(testReplayCache)
+	s := NewSettings(kt, ClientAddress(h), CustomReplayCache(rc), ChannelBindings(cb))
+	ok, _, err := VerifyAPREQ(&APReq, s)
+	assert.False(t, ok, "AP_REQ with other channel bindings should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+	assert.Equal(t, DiagnosticStepPolicy, DiagnoseAPREQ(&APReq, s, err).Step, "diagnostic step not as expected")
+	assert.Equal(t, 0, rc.calls, "authenticator rejected by policy should not be checked against the replay cache")
+
+	APReq, kt = newAPReq(cb.Hash())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), ChannelBindings(cb)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with the required channel bindings failed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_RequirePreAuth(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequirePreAuth(true)))
+	assert.False(t, ok, "ticket without the PRE-AUTHENT flag should not be valid when pre-authentication is required")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_METHOD, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+
+	f := types.NewKrbFlags()
+	types.SetFlag(&f, flags.PreAuthent)
+	APReq, kt = newTestAPReq(t, f)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), RequirePreAuth(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with a pre-authenticated ticket failed: %v", err)
+	}
+}
+
+func TestVerifyAPREQ_KVNOMismatch(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	// The ticket is issued under a kvno the service no longer holds
+	APReq.Ticket.EncPart.KVNO = 50
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	assert.False(t, ok, "ticket of a kvno not in the keytab should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_BADKEYVER, err.(messages.KRBError).ErrorCode, "error code not as expected")
+		assert.Contains(t, err.Error(), "has kvno 50", "error should report the ticket's kvno")
+		assert.Contains(t, err.Error(), "keytab holds kvnos [", "error should report the keytab's kvnos")
+	}
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	APReq.Ticket.EncPart.KVNO = 50
+	ok, creds, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), KVNOFallback(true)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with the kvno fallback failed: %v", err)
+	}
+	assert.Equal(t, "testuser1", creds.UserName(), "client name not as expected")
+}
+
+func TestVerifyAPREQ_ContextValues(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	ok, creds, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ failed when it should not have: %v", err)
+	}
+	_, ok = creds.Attributes()[credentials.AttributeKeyTicket]
+	assert.False(t, ok, "ticket should not be extracted by default")
+	_, ok = creds.Attributes()[credentials.AttributeKeySessionKey]
+	assert.False(t, ok, "session key should not be extracted by default")
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	ok, creds, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), ContextValues(ContextValueTicket, ContextValueSessionKey)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ failed when it should not have: %v", err)
+	}
+	assert.Equal(t, "testuser1", creds.UserName(), "name not extracted")
+	b, _ := APReq.Ticket.Marshal()
+	assert.Equal(t, b, creds.Attributes()[credentials.AttributeKeyTicket], "raw ticket not extracted")
+	assert.Equal(t, APReq.Ticket.DecryptedEncPart.Key, creds.Attributes()[credentials.AttributeKeySessionKey], "session key not extracted")
+}
+
+func TestVerifyAPREQ_TransitedRealms(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	ok, creds, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ failed: %v", err)
+	}
+	assert.Equal(t, []string{}, creds.Attributes()[credentials.AttributeKeyTransitedRealms], "ticket from the service's realm should not have transited realms")
+
+	// Re-encrypt the ticket with a transited field as issued for a cross-realm authentication
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	key, _, err := kt.GetEncryptionKey(APReq.Ticket.SName, APReq.Ticket.Realm, APReq.Ticket.EncPart.KVNO, APReq.Ticket.EncPart.EType)
+	if err != nil {
+		t.Fatalf("error getting ticket key: %v", err)
+	}
+	if err := APReq.Ticket.Decrypt(key); err != nil {
+		t.Fatalf("error decrypting ticket: %v", err)
+	}
+	etp := APReq.Ticket.DecryptedEncPart
+	etp.Transited = messages.TransitedEncoding{TRType: trtype.DOMAIN_X500_COMPRESS, Contents: []byte("GOKRB5,RESDOM.")}
+	b, _ := asn1.Marshal(etp)
+	b = asn1tools.AddASNAppTag(b, asnAppTag.EncTicketPart)
+	APReq.Ticket.EncPart, err = crypto.GetEncryptedData(b, key, keyusage.KDC_REP_TICKET, APReq.Ticket.EncPart.KVNO)
+	if err != nil {
+		t.Fatalf("error encrypting ticket: %v", err)
+	}
+	APReq.Ticket.DecryptedEncPart = messages.EncTicketPart{}
+	ok, creds, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with transited realms failed: %v", err)
+	}
+	assert.Equal(t, []string{"GOKRB5", "RESDOM.GOKRB5"}, creds.Attributes()[credentials.AttributeKeyTransitedRealms], "transited realms not as expected")
+}
+
+func TestIsAnonymous(t *testing.T) {
+	t.Parallel()
+	var tkt messages.Ticket
+	tkt.DecryptedEncPart.Flags = types.NewKrbFlags()
+	tkt.DecryptedEncPart.CRealm = "TEST.GOKRB5"
+	tkt.DecryptedEncPart.CName = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	assert.False(t, isAnonymous(tkt), "ticket should not be anonymous")
+
+	tkt.DecryptedEncPart.CName = types.NewPrincipalName(nametype.KRB_NT_WELLKNOWN, "WELLKNOWN/ANONYMOUS")
+	assert.True(t, isAnonymous(tkt), "ticket for the anonymous principal should be anonymous")
+
+	tkt.DecryptedEncPart.CName = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1")
+	tkt.DecryptedEncPart.CRealm = anonymousRealm
+	assert.True(t, isAnonymous(tkt), "ticket for the anonymous realm should be anonymous")
+}
+
+func TestVerifyAPREQ_SPNAlias(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	// The KDC encrypts the ticket for the alias with the key of the primary SPN
+	aliaskt := keytab.New()
+	for _, e := range kt.Entries {
+		e.Principal.Components = []string{"HTTP", "alias.test.gokrb5"}
+		aliaskt.Entries = append(aliaskt.Entries, e)
+	}
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/alias.test.gokrb5")
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+
+	newAPReq := func() messages.APReq {
+		st := time.Now().UTC()
+		tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+			sname, "TEST.GOKRB5",
+			types.NewKrbFlags(),
+			aliaskt,
+			18,
+			1,
+			st,
+			st,
+			st.Add(time.Duration(24)*time.Hour),
+			st.Add(time.Duration(48)*time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("Error getting test ticket: %v", err)
+		}
+		APReq, err := messages.NewAPReq(tkt, sessionKey, newTestAuthenticator(*cl.Credentials))
+		if err != nil {
+			t.Fatalf("Error getting test AP_REQ: %v", err)
+		}
+		return APReq
+	}
+
+	APReq := newAPReq()
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	assert.False(t, ok, "ticket for an alias not configured should not be valid")
+	assert.Error(t, err, "ticket for an alias not configured should error")
+
+	APReq = newAPReq()
+	ok, creds, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), SPNAliases("HTTP/alias.test.gokrb5")))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ for an SPN alias failed when it should not have: %v", err)
+	}
+	assert.Equal(t, cl.Credentials.UserName(), creds.UserName(), "username not as expected")
+
+	// An alias whose ticket is not encrypted with a key in the keytab is still rejected
+	otherkt := keytab.New()
+	otherkt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "notthekey", time.Now(), 1, 18)
+	APReq = newAPReq()
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(otherkt, ClientAddress(h), SPNAliases("HTTP/alias.test.gokrb5")))
+	assert.False(t, ok, "ticket for an alias not decrypted by the keytab should not be valid")
+	assert.Error(t, err, "ticket for an alias not decrypted by the keytab should error")
+
+	// The error details why each key could not be used
+	otherkt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "notthekey", time.Now(), 2, 18)
+	otherkt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "notthekey", time.Now(), 1, 17)
+	otherkt.AddEntry("HTTP/host.test.gokrb5", "OTHER.GOKRB5", "notthekey", time.Now(), 1, 18)
+	APReq = newAPReq()
+	_, _, err = VerifyAPREQ(&APReq, NewSettings(otherkt, ClientAddress(h), SPNAliases("HTTP/alias.test.gokrb5")))
+	if _, isKRBErr := err.(messages.KRBError); !isKRBErr {
+		t.Fatalf("error should be a KRBError: %v", err)
+	}
+	for _, reason := range []string{
+		"HTTP/host.test.gokrb5@TEST.GOKRB5 kvno 1 etype 18: error decrypting",
+		"HTTP/host.test.gokrb5@TEST.GOKRB5 kvno 2 etype 18: kvno does not match ticket kvno 1",
+		"HTTP/host.test.gokrb5@TEST.GOKRB5 kvno 1 etype 17: etype does not match ticket etype 18",
+		"HTTP/host.test.gokrb5@OTHER.GOKRB5 kvno 1 etype 18: realm does not match ticket realm TEST.GOKRB5",
+	} {
+		assert.Contains(t, err.Error(), reason, "error does not detail the reason a key could not be used")
+	}
+}
+
+func TestAliasKeytabPrincipal_Order(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	// Two principals sharing the key that encrypts the ticket, as the SPNs of one account do, held in either order
+	ts := time.Now()
+	oldfirst, newfirst := keytab.New(), keytab.New()
+	for _, e := range kt.Entries {
+		if e.KVNO != 1 || e.Key.KeyType != 18 {
+			continue
+		}
+		older, newer := e, e
+		older.Principal.Components = []string{"host", "host.test.gokrb5"}
+		older.Timestamp = ts.Add(-time.Hour)
+		newer.Principal.Components = []string{"HTTP", "host.test.gokrb5"}
+		newer.Timestamp = ts
+		oldfirst.Entries = append(oldfirst.Entries, older, newer)
+		newfirst.Entries = append(newfirst.Entries, newer, older)
+	}
+	for _, akt := range []*keytab.Keytab{oldfirst, newfirst} {
+		APReq, _ := newTestAPReq(t, types.NewKrbFlags())
+		APReq.Ticket.SName = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/alias.test.gokrb5")
+		p, err := aliasKeytabPrincipal(APReq.Ticket, akt)
+		if err != nil {
+			t.Fatalf("error getting the keytab principal for the alias: %v", err)
+		}
+		assert.Equal(t, "HTTP/host.test.gokrb5", p.PrincipalNameString(), "newest key not selected regardless of keytab order")
+	}
+}
+
+func TestVerifyAPREQ_AdditionalKeytabs(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	newkt := keytab.New()
+	newkt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "newpassword", time.Now(), 2, 18)
+
+	// The ticket is encrypted with the retiring key held in the additional keytab
+	APReq, oldkt := newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(newkt, ClientAddress(h), AdditionalKeytabs(oldkt)))
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ with the key in an additional keytab failed when it should not have: %v", err)
+	}
+
+	otherkt := keytab.New()
+	otherkt.AddEntry("HTTP/host.test.gokrb5", "TEST.GOKRB5", "otherpassword", time.Now(), 1, 18)
+	APReq, _ = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(newkt, ClientAddress(h), AdditionalKeytabs(otherkt)))
+	assert.False(t, ok, "AP_REQ not decrypted by any keytab should not be valid")
+	if _, isKRBErr := err.(messages.KRBError); !isKRBErr {
+		t.Errorf("error should be a KRBError: %v", err)
+	}
+}
+
 func TestVerifyAPREQWithPrincipalOverride(t *testing.T) {
 	t.Parallel()
 	cl := getClient()
@@ -206,6 +793,32 @@ func TestVerifyAPREQ_LargeClockSkew(t *testing.T) {
 	}
 }
 
+func TestVerifyAPREQ_MaxAuthenticatorAge(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	a := newTestAuthenticator(*cl.Credentials)
+	a.CTime = a.CTime.Add(-time.Minute)
+	APReq, kt := newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), a)
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h)))
+	if !ok || err != nil {
+		t.Fatalf("validation of AP_REQ within the clock skew failed: %v", err)
+	}
+
+	APReq, kt = newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), a)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), MaxAuthenticatorAge(30*time.Second)))
+	assert.False(t, ok, "AP_REQ with an authenticator older than the maximum age should not be valid")
+	if assert.IsType(t, messages.KRBError{}, err, "error should be a KRBError") {
+		assert.Equal(t, errorcode.KRB_AP_ERR_SKEW, err.(messages.KRBError).ErrorCode, "error code not as expected")
+	}
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), MaxAuthenticatorAge(30*time.Second)))
+	if !ok || err != nil {
+		t.Errorf("validation of AP_REQ with a recent authenticator failed: %v", err)
+	}
+}
+
 func TestVerifyAPREQ_Replay(t *testing.T) {
 	t.Parallel()
 	cl := getClient()
@@ -255,6 +868,122 @@ func TestVerifyAPREQ_Replay(t *testing.T) {
 	assert.Equal(t, errorcode.KRB_AP_ERR_REPEAT, err.(messages.KRBError).ErrorCode, "Error code not as expected")
 }
 
+type testReplayCache struct {
+	window time.Duration
+	calls  int
+}
+
+func (c *testReplayCache) IsReplay(window time.Duration, sname types.PrincipalName, a types.Authenticator) bool {
+	c.window = window
+	c.calls++
+	return false
+}
+
+func TestVerifyAPREQ_CustomReplayCache(t *testing.T) {
+	t.Parallel()
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+
+	rc := new(testReplayCache)
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	s := NewSettings(kt, ClientAddress(h), MaxClockSkew(time.Minute), CustomReplayCache(rc))
+	for i := 0; i < 2; i++ {
+		ok, _, err := VerifyAPREQ(&APReq, s)
+		if !ok || err != nil {
+			t.Fatalf("Validation of AP_REQ failed when it should not have as the custom replay cache does not detect replays: %v", err)
+		}
+	}
+	assert.Equal(t, 2, rc.calls, "custom replay cache not used")
+	assert.Equal(t, time.Minute, rc.window, "skew not passed to custom replay cache as the replay window")
+
+	// An authenticator rejected by the service's policy is not recorded in the replay cache
+	cl := getClient()
+	auth, _ := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	auth.GenerateSeqNumberAndSubKey(23, 16)
+	APReq, kt = newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+	rc = new(testReplayCache)
+	ok, _, err := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), CustomReplayCache(rc), RejectWeakSubkeys(true)))
+	assert.False(t, ok, "AP_REQ with a weak subkey should not be valid")
+	assert.Error(t, err, "AP_REQ with a weak subkey should error")
+	auth, _ = types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	APReq, kt = newTestAPReqWithAuthenticator(t, types.NewKrbFlags(), auth)
+	ok, _, err = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), CustomReplayCache(rc), RequireSubkey(true)))
+	assert.False(t, ok, "AP_REQ without a subkey should not be valid when one is required")
+	assert.Error(t, err, "AP_REQ without a subkey should error when one is required")
+	assert.Equal(t, 0, rc.calls, "authenticator rejected by policy should not be checked against the replay cache")
+
+	APReq, kt = newTestAPReq(t, types.NewKrbFlags())
+	s = NewSettings(kt, ClientAddress(h), MaxClockSkew(time.Minute), ReplayWindow(time.Hour*10), CustomReplayCache(rc))
+	ok, _, err = VerifyAPREQ(&APReq, s)
+	if !ok || err != nil {
+		t.Fatalf("Validation of AP_REQ failed when it should not have: %v", err)
+	}
+	assert.Equal(t, time.Hour*10, rc.window, "replay window not passed to custom replay cache")
+	assert.Equal(t, time.Minute, NewSettings(kt, MaxClockSkew(time.Minute), ReplayWindow(time.Second)).ReplayWindow(), "replay window should not be shorter than the clock skew")
+}
+
+func TestGetReplayCache_Retention(t *testing.T) {
+	t.Parallel()
+	c := GetReplayCache(time.Minute)
+	r := c.retentionPeriod()
+	assert.True(t, r >= time.Minute, "retention not as expected")
+	GetReplayCache(r + time.Hour)
+	assert.Equal(t, r+time.Hour, c.retentionPeriod(), "retention not extended for a longer replay window")
+	GetReplayCache(time.Second)
+	assert.Equal(t, r+time.Hour, c.retentionPeriod(), "retention should not be reduced for a shorter replay window")
+}
+
+func TestCache_Rotate(t *testing.T) {
+	t.Parallel()
+	c := &Cache{entries: make(map[string]clientEntries), retention: time.Second * 30}
+	cl := getClient()
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	old := newTestAuthenticator(*cl.Credentials)
+	old.CTime = old.CTime.Add(-time.Minute)
+	recent := newTestAuthenticator(*cl.Credentials)
+	assert.False(t, c.IsReplay(sname, old), "first presentation should not be a replay")
+	assert.False(t, c.IsReplay(sname, recent), "first presentation should not be a replay")
+	// Age the entry for the old authenticator
+	ce := c.entries[old.CName.PrincipalNameString()]
+	ct := old.CTime.Add(time.Duration(old.Cusec) * time.Microsecond)
+	e := ce.replayMap[ct]
+	e.presentedTime = e.presentedTime.Add(-time.Minute)
+	ce.replayMap[ct] = e
+
+	// A shorter duration does not reduce the retention another service sharing the cache may rely on
+	c.Rotate(time.Second)
+	assert.Equal(t, time.Second*30, c.retentionPeriod(), "retention should not be reduced by rotation")
+	assert.True(t, c.IsReplay(sname, recent), "authenticator presented within the retention should be a replay after rotation")
+	assert.False(t, c.IsReplay(sname, old), "entry older than the retention should be discarded by rotation")
+	c.Rotate(0)
+	assert.Equal(t, time.Second*30, c.retentionPeriod(), "retention should not be changed by a rotation with a zero duration")
+	c.Rotate(-time.Second)
+	assert.Equal(t, time.Second*30, c.retentionPeriod(), "retention should not be changed by a rotation with a negative duration")
+	assert.True(t, c.IsReplay(sname, recent), "authenticator presented within the retention should be a replay after rotation")
+	c.Rotate(time.Hour)
+	assert.Equal(t, time.Hour, c.retentionPeriod(), "retention not extended by rotation")
+
+	// Concurrent presentations of the same authenticator during rotations are detected exactly once
+	a := newTestAuthenticator(*cl.Credentials)
+	a.CTime = a.CTime.Add(time.Second)
+	var wg sync.WaitGroup
+	var accepted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if !c.IsReplay(sname, a) {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c.Rotate(time.Minute)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), accepted, "authenticator should be accepted once across rotations")
+}
+
 func TestVerifyAPREQ_FutureTicket(t *testing.T) {
 	t.Parallel()
 	cl := getClient()
@@ -352,6 +1081,75 @@ func TestVerifyAPREQ_InvalidTicket(t *testing.T) {
 	}
 }
 
+func TestVerifyAPREQ_PostdatedTicket(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	sname := types.PrincipalName{
+		NameType:   nametype.KRB_NT_PRINCIPAL,
+		NameString: []string{"HTTP", "host.test.gokrb5"},
+	}
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	now := time.Now().UTC()
+	var tests = []struct {
+		name      string
+		invalid   bool
+		startTime time.Time
+		valid     bool
+		etext     string
+	}{
+		{"not validated", true, now.Add(-time.Minute), false, "has not been validated"},
+		{"before start time", false, now.Add(time.Hour), false, "is not valid until"},
+		{"validated", false, now.Add(-time.Minute), true, ""},
+	}
+	for _, test := range tests {
+		f := types.NewKrbFlags()
+		types.SetFlag(&f, flags.PostDated)
+		if test.invalid {
+			types.SetFlag(&f, flags.Invalid)
+		}
+		tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+			sname, "TEST.GOKRB5",
+			f,
+			kt,
+			18,
+			1,
+			now.Add(-time.Hour),
+			test.startTime,
+			now.Add(time.Duration(24)*time.Hour),
+			now.Add(time.Duration(48)*time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("Error getting test ticket: %v", err)
+		}
+		APReq, err := messages.NewAPReq(
+			tkt,
+			sessionKey,
+			newTestAuthenticator(*cl.Credentials),
+		)
+		if err != nil {
+			t.Fatalf("Error getting test AP_REQ: %v", err)
+		}
+
+		h, _ := types.GetHostAddress("127.0.0.1:1234")
+		s := NewSettings(kt, ClientAddress(h))
+		ok, _, err := VerifyAPREQ(&APReq, s)
+		if test.valid {
+			assert.True(t, ok, "postdated ticket %s should be valid", test.name)
+			assert.NoError(t, err, "postdated ticket %s should be valid", test.name)
+			continue
+		}
+		assert.False(t, ok, "postdated ticket %s should not be valid", test.name)
+		if krberr, isKRBErr := err.(messages.KRBError); isKRBErr {
+			assert.Equal(t, errorcode.KRB_AP_ERR_TKT_NYV, krberr.ErrorCode, "Error code not as expected for postdated ticket %s", test.name)
+			assert.Contains(t, krberr.EText, test.etext, "Error text not as expected for postdated ticket %s", test.name)
+		} else {
+			t.Errorf("Error is not a KRBError for postdated ticket %s: %v", test.name, err)
+		}
+	}
+}
+
 func TestVerifyAPREQ_ExpiredTicket(t *testing.T) {
 	t.Parallel()
 	cl := getClient()
@@ -400,6 +1198,48 @@ func TestVerifyAPREQ_ExpiredTicket(t *testing.T) {
 	}
 }
 
+// newTestAPReq returns an AP_REQ for HTTP/host.test.gokrb5 with a ticket with the flags provided
+// and the keytab for the service.
+func newTestAPReq(t *testing.T, f asn1.BitString) (messages.APReq, *keytab.Keytab) {
+	cl := getClient()
+	return newTestAPReqWithAuthenticator(t, f, newTestAuthenticator(*cl.Credentials))
+}
+
+func newTestAPReqWithAuthenticator(t *testing.T, f asn1.BitString, auth types.Authenticator) (messages.APReq, *keytab.Keytab) {
+	cl := getClient()
+	sname := types.PrincipalName{
+		NameType:   nametype.KRB_NT_PRINCIPAL,
+		NameString: []string{"HTTP", "host.test.gokrb5"},
+	}
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	st := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+		sname, "TEST.GOKRB5",
+		f,
+		kt,
+		18,
+		1,
+		st,
+		st,
+		st.Add(time.Duration(24)*time.Hour),
+		st.Add(time.Duration(48)*time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Error getting test ticket: %v", err)
+	}
+	APReq, err := messages.NewAPReq(
+		tkt,
+		sessionKey,
+		auth,
+	)
+	if err != nil {
+		t.Fatalf("Error getting test AP_REQ: %v", err)
+	}
+	return APReq, kt
+}
+
 func newTestAuthenticator(creds credentials.Credentials) types.Authenticator {
 	auth, _ := types.NewAuthenticator(creds.Domain(), creds.CName())
 	auth.GenerateSeqNumberAndSubKey(18, 32)
@@ -53,14 +53,23 @@ func (a KRB5BasicAuthenticator) Authenticate() (i goidentity.Identity, ok bool,
 		err = fmt.Errorf("could not get service ticket: %v", err)
 		return
 	}
-	err = tkt.DecryptEncPart(a.serviceSettings.Keytab, a.serviceSettings.KeytabPrincipal())
+	kt, ktprinc, err := ticketKeytab(tkt, a.serviceSettings)
+	if err == nil {
+		err = tkt.DecryptEncPart(kt, ktprinc)
+	}
 	if err != nil {
 		err = fmt.Errorf("could not decrypt service ticket: %v", err)
 		return
 	}
 	cl.Credentials.SetAuthTime(time.Now().UTC())
 	cl.Credentials.SetAuthenticated(true)
-	isPAC, pac, err := tkt.GetPACType(a.serviceSettings.Keytab, a.serviceSettings.KeytabPrincipal(), a.serviceSettings.Logger())
+	cl.Credentials.SetAttribute(credentials.AttributeKeyTicketEType, tkt.EncPart.EType)
+	cl.Credentials.SetAttribute(credentials.AttributeKeySessionKeyEType, tkt.DecryptedEncPart.Key.KeyType)
+	cl.Credentials.SetAttribute(credentials.AttributeKeyTicketAuthTime, tkt.DecryptedEncPart.AuthTime)
+	if !tkt.DecryptedEncPart.RenewTill.IsZero() {
+		cl.Credentials.SetAttribute(credentials.AttributeKeyTicketRenewTill, tkt.DecryptedEncPart.RenewTill)
+	}
+	isPAC, pac, err := tkt.GetPACType(kt, ktprinc, a.serviceSettings.Logger())
 	if isPAC && err != nil {
 		err = fmt.Errorf("error processing PAC: %v", err)
 		return
@@ -9,10 +9,29 @@ import (
 
 // Replay cache is required as specified in RFC 4120 section 3.2.3
 
+// ReplayCache must provide a way to test if an authenticator presented to the service is a replay.
+//
+// IsReplay is provided with the replay window the service is configured with, which is the maximum clock skew unless a
+// longer window has been configured with ReplayWindow, the service principal name and the authenticator presented. It
+// must return true if the authenticator has already been presented to the service within the window. If it is not a
+// replay the implementation should record the authenticator so that future replays of it are detected.
+type ReplayCache interface {
+	IsReplay(window time.Duration, sname types.PrincipalName, a types.Authenticator) bool
+}
+
+// defaultReplayCache implements the ReplayCache interface using the in memory Cache singleton.
+type defaultReplayCache struct{}
+
+// IsReplay tests if the Authenticator provided is a replay using the in memory Cache singleton.
+func (defaultReplayCache) IsReplay(window time.Duration, sname types.PrincipalName, a types.Authenticator) bool {
+	return GetReplayCache(window).IsReplay(sname, a)
+}
+
 // Cache for tickets received from clients keyed by fully qualified client name. Used to track replay of tickets.
 type Cache struct {
-	entries map[string]clientEntries
-	mux     sync.RWMutex
+	entries   map[string]clientEntries
+	retention time.Duration
+	mux       sync.RWMutex
 }
 
 // clientEntries holds entries of client details sent to the service.
@@ -29,74 +48,71 @@ type replayCacheEntry struct {
 	cTime         time.Time // This combines the ticket's CTime and Cusec
 }
 
-func (c *Cache) getClientEntries(cname types.PrincipalName) (clientEntries, bool) {
-	c.mux.RLock()
-	defer c.mux.RUnlock()
-	ce, ok := c.entries[cname.PrincipalNameString()]
-	return ce, ok
-}
-
-func (c *Cache) getClientEntry(cname types.PrincipalName, t time.Time) (replayCacheEntry, bool) {
-	if ce, ok := c.getClientEntries(cname); ok {
-		c.mux.RLock()
-		defer c.mux.RUnlock()
-		if e, ok := ce.replayMap[t]; ok {
-			return e, true
-		}
-	}
-	return replayCacheEntry{}, false
-}
-
 // Instance of the ServiceCache. This needs to be a singleton.
 var replayCache Cache
 var once sync.Once
 
 // GetReplayCache returns a pointer to the Cache singleton.
+// Entries are retained for the longest duration the singleton has been requested with, so that services sharing it
+// with different replay windows each detect replays within their own window.
 func GetReplayCache(d time.Duration) *Cache {
 	// Create a singleton of the ReplayCache and start a background thread to regularly clean out old entries
 	once.Do(func() {
 		replayCache = Cache{
-			entries: make(map[string]clientEntries),
+			entries:   make(map[string]clientEntries),
+			retention: d,
 		}
 		go func() {
 			for {
 				// TODO consider using a context here.
-				time.Sleep(d)
-				replayCache.ClearOldEntries(d)
+				time.Sleep(replayCache.retentionPeriod())
+				replayCache.ClearOldEntries(replayCache.retentionPeriod())
 			}
 		}()
 	})
+	replayCache.extendRetention(d)
 	return &replayCache
 }
 
+// retentionPeriod returns the duration entries are retained in the cache for.
+func (c *Cache) retentionPeriod() time.Duration {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return c.retention
+}
+
+// extendRetention increases the duration entries are retained in the cache for if the duration provided is longer.
+func (c *Cache) extendRetention(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if d > c.retention {
+		c.retention = d
+	}
+}
+
 // AddEntry adds an entry to the Cache.
 func (c *Cache) AddEntry(sname types.PrincipalName, a types.Authenticator) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.addEntry(sname, a)
+}
+
+// addEntry adds an entry to the Cache. The caller must hold the write lock.
+func (c *Cache) addEntry(sname types.PrincipalName, a types.Authenticator) {
 	ct := a.CTime.Add(time.Duration(a.Cusec) * time.Microsecond)
-	if ce, ok := c.getClientEntries(a.CName); ok {
-		c.mux.Lock()
-		defer c.mux.Unlock()
-		ce.replayMap[ct] = replayCacheEntry{
-			presentedTime: time.Now().UTC(),
-			sName:         sname,
-			cTime:         ct,
-		}
-		ce.seqNumber = a.SeqNumber
-		ce.subKey = a.SubKey
-	} else {
-		c.mux.Lock()
-		defer c.mux.Unlock()
-		c.entries[a.CName.PrincipalNameString()] = clientEntries{
-			replayMap: map[time.Time]replayCacheEntry{
-				ct: {
-					presentedTime: time.Now().UTC(),
-					sName:         sname,
-					cTime:         ct,
-				},
-			},
-			seqNumber: a.SeqNumber,
-			subKey:    a.SubKey,
-		}
+	e := replayCacheEntry{
+		presentedTime: time.Now().UTC(),
+		sName:         sname,
+		cTime:         ct,
 	}
+	ce, ok := c.entries[a.CName.PrincipalNameString()]
+	if !ok {
+		ce.replayMap = make(map[time.Time]replayCacheEntry)
+	}
+	ce.replayMap[ct] = e
+	ce.seqNumber = a.SeqNumber
+	ce.subKey = a.SubKey
+	c.entries[a.CName.PrincipalNameString()] = ce
 }
 
 // ClearOldEntries clears entries from the Cache that are older than the duration provided.
@@ -116,13 +132,50 @@ func (c *Cache) ClearOldEntries(d time.Duration) {
 }
 
 // IsReplay tests if the Authenticator provided is a replay within the duration defined. If this is not a replay add the entry to the cache for tracking.
+// The test and the addition of the entry are made under the same lock so that concurrent presentations of the same
+// authenticator cannot both pass, and so that they are consistent with a concurrent Rotate.
 func (c *Cache) IsReplay(sname types.PrincipalName, a types.Authenticator) bool {
 	ct := a.CTime.Add(time.Duration(a.Cusec) * time.Microsecond)
-	if e, ok := c.getClientEntry(a.CName, ct); ok {
-		if e.sName.Equal(sname) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if ce, ok := c.entries[a.CName.PrincipalNameString()]; ok {
+		if e, ok := ce.replayMap[ct]; ok && e.sName.Equal(sname) {
 			return true
 		}
 	}
-	c.AddEntry(sname, a)
+	c.addEntry(sname, a)
 	return false
 }
+
+// Rotate atomically replaces the entries of the Cache with a fresh set, for example to release the memory of
+// accumulated entries or after the replay window has been changed. Entries presented within the longer of the duration
+// provided and the Cache's retention are carried over to the fresh set, so that no authenticator can be replayed within
+// the replay window of any service sharing the Cache because of the rotation, and all older entries are discarded.
+// IsReplay checks in progress complete against the entries as they were before the rotation, and those made after it
+// against the fresh set.
+//
+// The retention is extended to the duration provided if it is longer, it is never reduced. A duration that is not
+// positive rotates the entries with the current retention.
+func (c *Cache) Rotate(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if d < c.retention {
+		d = c.retention
+	}
+	now := time.Now().UTC()
+	entries := make(map[string]clientEntries)
+	for k, ce := range c.entries {
+		replayMap := make(map[time.Time]replayCacheEntry)
+		for t, e := range ce.replayMap {
+			if now.Sub(e.presentedTime) <= d {
+				replayMap[t] = e
+			}
+		}
+		if len(replayMap) > 0 {
+			ce.replayMap = replayMap
+			entries[k] = ce
+		}
+	}
+	c.entries = entries
+	c.retention = d
+}
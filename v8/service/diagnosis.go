@@ -0,0 +1,185 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/messages"
+)
+
+// Steps of accepting a client's context token at which it can be rejected, as reported in a Diagnosis.
+const (
+	DiagnosticStepBase64               = "base64"
+	DiagnosticStepNegTokenParse        = "neg-token parse"
+	DiagnosticStepMechOID              = "mech OID"
+	DiagnosticStepService              = "service"
+	DiagnosticStepTicketDecrypt        = "ticket decrypt"
+	DiagnosticStepValidity             = "validity"
+	DiagnosticStepAddress              = "address"
+	DiagnosticStepAuthenticatorDecrypt = "authenticator decrypt"
+	DiagnosticStepCNameMatch           = "cname match"
+	DiagnosticStepSkew                 = "skew"
+	DiagnosticStepReplay               = "replay"
+	DiagnosticStepPolicy               = "policy"
+	DiagnosticStepPAC                  = "pac"
+	DiagnosticStepVerify               = "verify"
+)
+
+// Diagnosis is a structured explanation of why a client's AP_REQ, or the token carrying it, was rejected. It is logged
+// when the service is configured with Diagnostics.
+type Diagnosis struct {
+	// Step is the step of accepting the token that failed, one of the DiagnosticStep values.
+	Step string
+	// ErrorCode is the Kerberos error code of the rejection, zero if there is none.
+	ErrorCode int32
+	// SName is the service principal name the ticket was issued for.
+	SName string
+	// Realm is the realm of the ticket.
+	Realm string
+	// EType is the encryption type of the ticket.
+	EType int32
+	// KVNO is the key version number of the ticket.
+	KVNO int
+	// KeytabKVNOs are the key version numbers the keytab holds for the ticket's principal and encryption type.
+	KeytabKVNOs []int
+	// Skew is the difference between the service's clock and the time in the client's authenticator.
+	Skew time.Duration
+	// Reason is the error the token was rejected with.
+	Reason string
+	// Hint is a suggested remediation.
+	Hint string
+}
+
+// String returns the diagnosis as space separated key=value pairs, omitting values that are not known.
+func (d Diagnosis) String() string {
+	s := []string{fmt.Sprintf("step=%q", d.Step)}
+	if d.ErrorCode != 0 {
+		// The lookup is of the form "(code) NAME description"
+		if f := strings.Fields(errorcode.Lookup(d.ErrorCode)); len(f) > 1 {
+			s = append(s, fmt.Sprintf("error=%s", f[1]))
+		}
+	}
+	if d.SName != "" {
+		s = append(s, fmt.Sprintf("sname=%s@%s", d.SName, d.Realm))
+	}
+	if d.EType != 0 {
+		s = append(s, fmt.Sprintf("etype=%d", d.EType))
+	}
+	if d.KVNO != 0 {
+		s = append(s, fmt.Sprintf("kvno=%d", d.KVNO))
+	}
+	if len(d.KeytabKVNOs) > 0 {
+		s = append(s, fmt.Sprintf("keytab_kvnos=%v", d.KeytabKVNOs))
+	}
+	if d.Skew != 0 {
+		s = append(s, fmt.Sprintf("skew_seconds=%d", int64(d.Skew/time.Second)))
+	}
+	if d.Reason != "" {
+		s = append(s, fmt.Sprintf("reason=%q", d.Reason))
+	}
+	if d.Hint != "" {
+		s = append(s, fmt.Sprintf("hint=%q", d.Hint))
+	}
+	return strings.Join(s, " ")
+}
+
+// DiagnoseAPREQ explains why the AP_REQ was rejected by VerifyAPREQ with the error provided, identifying the step that
+// failed along with the ticket's encryption type and kvno, the kvnos held in the service's keytabs and the clock skew
+// with the client where relevant, and a hint at how to remedy the failure.
+func DiagnoseAPREQ(APReq *messages.APReq, s *Settings, err error) Diagnosis {
+	d := Diagnosis{
+		Step:   DiagnosticStepVerify,
+		SName:  APReq.Ticket.SName.PrincipalNameString(),
+		Realm:  APReq.Ticket.Realm,
+		EType:  APReq.Ticket.EncPart.EType,
+		KVNO:   APReq.Ticket.EncPart.KVNO,
+		Reason: err.Error(),
+	}
+	krberr, ok := err.(messages.KRBError)
+	if !ok {
+		// Failures to decrypt the ticket are wrapped rather than returned as a KRBError
+		if len(APReq.Ticket.DecryptedEncPart.Key.KeyValue) < 1 {
+			d.Step = DiagnosticStepTicketDecrypt
+			d.KeytabKVNOs = keytabKVNOs(APReq, s)
+			if strings.Contains(d.Reason, "KRB_AP_ERR_NOKEY") {
+				d.ErrorCode = errorcode.KRB_AP_ERR_NOKEY
+				d.Hint = "the keytab holds no key for the ticket's principal, encryption type and kvno; check the SPN the client requested is in the keytab and the keytab was exported with this encryption type"
+			} else {
+				d.Hint = "the keytab key does not decrypt the ticket; the keytab is likely stale or was generated with the wrong password or salt, export it again from the KDC"
+			}
+		}
+		return d
+	}
+	d.ErrorCode = krberr.ErrorCode
+	switch krberr.ErrorCode {
+	case errorcode.KRB_AP_ERR_NOT_US:
+		d.Step = DiagnosticStepService
+		d.Hint = "the ticket is not for a service accepted here; check the SPN the client requested and the service's accepted service classes"
+	case errorcode.KRB_AP_ERR_NOKEY:
+		d.Step = DiagnosticStepTicketDecrypt
+		d.KeytabKVNOs = keytabKVNOs(APReq, s)
+		d.Hint = "no keytab holds a key that decrypts the ticket; check the SPN the client requested is in the keytab and the keytab was exported with this encryption type"
+	case errorcode.KRB_AP_ERR_BADKEYVER:
+		d.Step = DiagnosticStepTicketDecrypt
+		d.KeytabKVNOs = keytabKVNOs(APReq, s)
+		d.Hint = "the keytab does not hold the ticket's kvno; the service's key may have been rolled over, export the keytab again or configure KVNOFallback"
+	case errorcode.KRB_AP_ERR_TKT_EXPIRED, errorcode.KRB_AP_ERR_TKT_NYV:
+		d.Step = DiagnosticStepValidity
+		d.Hint = "the ticket is outside its validity period; the client should obtain a fresh ticket, and the clocks of the client, KDC and service should be synchronised"
+	case errorcode.KRB_AP_ERR_BADADDR:
+		d.Step = DiagnosticStepAddress
+		d.Hint = "the ticket's addresses do not include the client's; check for proxies or NAT between the client and service, or request addressless tickets"
+	case errorcode.KRB_AP_ERR_BAD_INTEGRITY:
+		if APReq.Authenticator.CTime.IsZero() {
+			d.Step = DiagnosticStepAuthenticatorDecrypt
+			d.Hint = "the authenticator could not be decrypted with the ticket's session key; the AP_REQ may be corrupt or assembled from mismatched parts"
+		} else {
+			d.Step = DiagnosticStepPAC
+			d.Hint = "the ticket's authorization data failed its integrity check; it may have been tampered with"
+		}
+	case errorcode.KRB_AP_ERR_BADMATCH:
+		d.Step = DiagnosticStepCNameMatch
+		d.Hint = "the client name in the authenticator differs from that in the ticket; the client may be presenting another principal's ticket"
+	case errorcode.KRB_AP_ERR_SKEW:
+		d.Step = DiagnosticStepSkew
+		d.Skew = time.Now().UTC().Sub(APReq.Authenticator.CTime)
+		d.Hint = fmt.Sprintf("the client's clock differs from the service's by more than the %v permitted; synchronise the clocks with NTP", s.MaxClockSkew())
+		if a := s.MaxAuthenticatorAge(); a > 0 && d.Skew > a && d.Skew <= s.MaxClockSkew() {
+			d.Hint = fmt.Sprintf("the authenticator is older than the maximum age of %v; the client may be resending a cached token, or its clock is behind the service's", a)
+		}
+	case errorcode.KRB_AP_ERR_REPEAT:
+		d.Step = DiagnosticStepReplay
+		d.Hint = "the authenticator has been seen before; the client may be resending a cached token, or the request is a replay attack"
+	case errorcode.KRB_AP_ERR_MODIFIED:
+		d.Step = DiagnosticStepPAC
+		d.Hint = "the ticket's PAC does not match the ticket; it may have been tampered with or taken from another ticket"
+	case policyErrorCode:
+		d.Step = DiagnosticStepPolicy
+		d.Hint = "the ticket or authenticator does not meet the service's configured requirements"
+	}
+	return d
+}
+
+// keytabKVNOs returns the kvnos, in ascending order, the service's keytabs hold for the ticket's principal and
+// encryption type.
+func keytabKVNOs(APReq *messages.APReq, s *Settings) []int {
+	sname := APReq.Ticket.SName
+	if s.KeytabPrincipal() != nil {
+		sname = *s.KeytabPrincipal()
+	}
+	var kvnos []int
+	for _, kt := range s.Keytabs() {
+		for _, e := range kt.Entries {
+			if strings.EqualFold(e.Principal.Realm, APReq.Ticket.Realm) &&
+				strings.Join(e.Principal.Components, "/") == sname.PrincipalNameString() &&
+				e.Key.KeyType == APReq.Ticket.EncPart.EType {
+				kvnos = append(kvnos, int(e.KVNO))
+			}
+		}
+	}
+	sort.Ints(kvnos)
+	return kvnos
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyAPREQ_Diagnostics(t *testing.T) {
+	t.Parallel()
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	APReq.Ticket.EncPart.KVNO = 5
+	ok, _, _ := VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), Logger(l)))
+	assert.False(t, ok, "AP_REQ with a kvno not in the keytab should not be valid")
+	assert.NotContains(t, buf.String(), "diagnosis", "diagnosis should not be logged by default")
+
+	ok, _, _ = VerifyAPREQ(&APReq, NewSettings(kt, ClientAddress(h), Logger(l), Diagnostics(true)))
+	assert.False(t, ok, "AP_REQ with a kvno not in the keytab should not be valid")
+	for _, s := range []string{`step="ticket decrypt"`, "error=KRB_AP_ERR_BADKEYVER", "sname=HTTP/host.test.gokrb5@TEST.GOKRB5", "etype=18", "kvno=5", "keytab_kvnos=[1 2]", "KVNOFallback"} {
+		assert.Contains(t, buf.String(), s, "diagnosis not as expected")
+	}
+}
+
+func TestDiagnoseAPREQ(t *testing.T) {
+	t.Parallel()
+	APReq, kt := newTestAPReq(t, types.NewKrbFlags())
+	s := NewSettings(kt)
+
+	// The authenticator has not been decrypted
+	d := DiagnoseAPREQ(&APReq, s, errors.New("error decrypting encpart of service ticket provided"))
+	assert.Equal(t, DiagnosticStepTicketDecrypt, d.Step, "step not as expected for a ticket that could not be decrypted")
+	assert.Equal(t, []int{1, 2}, d.KeytabKVNOs, "keytab kvnos not as expected")
+
+	var tests = []struct {
+		code int32
+		step string
+	}{
+		{errorcode.KRB_AP_ERR_NOT_US, DiagnosticStepService},
+		{errorcode.KRB_AP_ERR_NOKEY, DiagnosticStepTicketDecrypt},
+		{errorcode.KRB_AP_ERR_TKT_EXPIRED, DiagnosticStepValidity},
+		{errorcode.KRB_AP_ERR_BAD_INTEGRITY, DiagnosticStepAuthenticatorDecrypt},
+		{errorcode.KRB_AP_ERR_BADMATCH, DiagnosticStepCNameMatch},
+		{errorcode.KRB_AP_ERR_REPEAT, DiagnosticStepReplay},
+		{errorcode.KRB_AP_ERR_MODIFIED, DiagnosticStepPAC},
+		{errorcode.KRB_AP_ERR_METHOD, DiagnosticStepPolicy},
+	}
+	for _, test := range tests {
+		d := DiagnoseAPREQ(&APReq, s, messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, test.code, "test"))
+		assert.Equal(t, test.step, d.Step, "step not as expected for error code %d", test.code)
+		assert.NotEmpty(t, d.Hint, "hint should be provided for error code %d", test.code)
+	}
+
+	APReq.Authenticator.CTime = time.Now().UTC().Add(-10 * time.Minute)
+	d = DiagnoseAPREQ(&APReq, s, messages.NewKRBError(APReq.Ticket.SName, APReq.Ticket.Realm, errorcode.KRB_AP_ERR_SKEW, "test"))
+	assert.Equal(t, DiagnosticStepSkew, d.Step, "step not as expected for clock skew")
+	assert.Contains(t, d.String(), "skew_seconds=600", "skew not as expected")
+}
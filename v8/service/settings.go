@@ -3,8 +3,10 @@ package service
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/jcmturner/gokrb5/v8/gssapi"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/types"
 )
@@ -20,8 +22,54 @@ type Settings struct {
 	maxClockSkew       time.Duration
 	logger             *log.Logger
 	sessionMgr         SessionMgr
+	replayCache        ReplayCache
+	rejectWeakSubkeys  bool
+	spnAliases         []string
+	allowAnonymous     bool
+	channelBindings    *gssapi.ChannelBindings
+	additionalKeytabs  []*keytab.Keytab
+	challengeFailures  bool
+	minEType           int32
+	requireGSSChksum   bool
+	realmFold          bool
+	foldedKeytabs      map[*keytab.Keytab]map[string]*keytab.Keytab
+	dynamicNegResp     bool
+	serviceClasses     []string
+	requireMutual      bool
+	replayWindow       time.Duration
+	requireSubkey      bool
+	requirePreAuth     bool
+	kvnoFallback       bool
+	verifyPACClient    bool
+	trustedRealms      []string
+	diagnostics        bool
+	maxAuthAge         time.Duration
+	contextValues      ContextValue
+	authSchemes        []string
 }
 
+// ContextValue identifies a value extracted from a verified AP_REQ into the attributes of the client's credentials.
+// Values are combined with a bitwise OR.
+type ContextValue int
+
+// Context values that can be extracted for an authenticated client.
+const (
+	// ContextValueName the client's principal name and realm along with the ticket's flags, times and encryption
+	// types. These are always extracted.
+	ContextValueName ContextValue = 1 << iota
+	// ContextValueGroups the AD credentials, including group membership SIDs, decoded from the ticket's PAC.
+	ContextValueGroups
+	// ContextValueTicket the raw ASN.1 encoding of the ticket as presented by the client.
+	ContextValueTicket
+	// ContextValueSessionKey the session key of the ticket.
+	ContextValueSessionKey
+	// ContextValueClaims the client claims decoded from the ticket's PAC.
+	ContextValueClaims
+	// ContextValueAuthorizationData the authorization data of the ticket, including any restrictions requested by the
+	// client, so that they can be carried forward when the service requests tickets on behalf of the client.
+	ContextValueAuthorizationData
+)
+
 // NewSettings creates a new service Settings.
 func NewSettings(kt *keytab.Keytab, settings ...func(*Settings)) *Settings {
 	s := new(Settings)
@@ -29,6 +77,9 @@ func NewSettings(kt *keytab.Keytab, settings ...func(*Settings)) *Settings {
 	for _, set := range settings {
 		set(s)
 	}
+	if s.realmFold {
+		s.foldedKeytabs = foldKeytabRealms(s.Keytabs())
+	}
 	return s
 }
 
@@ -104,6 +155,265 @@ func (s *Settings) KeytabPrincipal() *types.PrincipalName {
 	return s.ktprinc
 }
 
+// SPNAliases used to configure the service to accept tickets issued to aliases of its SPN, such as those from AD's
+// msDS-AdditionalDnsHostName. A ticket for an alias is decrypted using the key of a principal held in the keytab,
+// for example HTTP/primary.example.com, as long as that key decrypts it.
+//
+// s := NewSettings(kt, SPNAliases("HTTP/alias.example.com", "HTTP/alias2.example.com"))
+func SPNAliases(spns ...string) func(*Settings) {
+	return func(s *Settings) {
+		s.spnAliases = spns
+	}
+}
+
+// SPNAliases returns the aliases of the service's SPN that tickets are accepted for.
+func (s *Settings) SPNAliases() []string {
+	return s.spnAliases
+}
+
+// ServiceClasses used to configure the service to only accept tickets for SPNs of the service classes provided, the
+// first component of the SPN such as HTTP. Tickets for other service classes are rejected even if the keytab holds a
+// key that decrypts them, so that a service sharing a machine account does not accept tickets meant for other services
+// on the host. Service classes are matched without regard to case. By default tickets for any service class are
+// accepted.
+//
+// s := NewSettings(kt, ServiceClasses("HTTP"))
+func ServiceClasses(classes ...string) func(*Settings) {
+	return func(s *Settings) {
+		s.serviceClasses = classes
+	}
+}
+
+// ServiceClasses returns the service classes the service accepts tickets for. If nil tickets for any service class are
+// accepted.
+func (s *Settings) ServiceClasses() []string {
+	return s.serviceClasses
+}
+
+// AllowAnonymous used to configure the service to accept anonymous tickets, as defined in RFC 8062.
+// By default tickets with the anonymous flag set or issued to the anonymous principal are rejected.
+//
+// s := NewSettings(kt, AllowAnonymous(true))
+func AllowAnonymous(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.allowAnonymous = b
+	}
+}
+
+// AllowAnonymous indicates if the service should accept anonymous tickets.
+func (s *Settings) AllowAnonymous() bool {
+	return s.allowAnonymous
+}
+
+// ChannelBindings used to configure the service to require that the GSS-API authenticator checksum carries the hash
+// of the channel bindings provided. For Extended Protection for Authentication over TLS the bindings can be derived
+// from the server's certificate with gssapi.NewTLSServerEndPointBindings.
+//
+// s := NewSettings(kt, ChannelBindings(cb))
+func ChannelBindings(cb gssapi.ChannelBindings) func(*Settings) {
+	return func(s *Settings) {
+		s.channelBindings = &cb
+	}
+}
+
+// ChannelBindings returns the channel bindings the service requires. If none are required nil is returned.
+func (s *Settings) ChannelBindings() *gssapi.ChannelBindings {
+	return s.channelBindings
+}
+
+// RequireGSSChecksum used to configure the service to require that the authenticator of an AP_REQ carries a GSS-API
+// checksum (type 0x8003), as defined in RFC 4121 section 4.1.1, and to reject it otherwise. Its absence can indicate a
+// client that is not using GSS-API or a token that has been tampered with.
+//
+// s := NewSettings(kt, RequireGSSChecksum(true))
+func RequireGSSChecksum(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.requireGSSChksum = b
+	}
+}
+
+// RequireGSSChecksum indicates if the service should require the authenticator of an AP_REQ to carry a GSS-API
+// checksum.
+func (s *Settings) RequireGSSChecksum() bool {
+	return s.requireGSSChksum
+}
+
+// RequireMutualAuth used to configure the service to reject AP_REQs that do not set the MUTUAL-REQUIRED AP option, so
+// that clients must complete mutual authentication by verifying the AP_REP returned by the service. Such AP_REQs are
+// rejected with a KRB_AP_ERR_METHOD KRBError. An SPNEGO service configured with RequireMutualAuth returns the AP_REP in
+// the NegTokenResp as if configured with DynamicNegTokenResp.
+//
+// s := NewSettings(kt, RequireMutualAuth(true))
+func RequireMutualAuth(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.requireMutual = b
+	}
+}
+
+// RequireMutualAuth indicates if the service should reject AP_REQs that do not request mutual authentication.
+func (s *Settings) RequireMutualAuth() bool {
+	return s.requireMutual
+}
+
+// RequireSubkey used to configure the service to reject AP_REQs whose authenticator does not contain a subkey, for a
+// service that always protects its messages with the client's subkey rather than the ticket's session key.
+// Such AP_REQs are rejected with a KRB_AP_ERR_METHOD KRBError before the authenticator is recorded in the replay cache.
+//
+// s := NewSettings(kt, RequireSubkey(true))
+func RequireSubkey(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.requireSubkey = b
+	}
+}
+
+// RequireSubkey indicates if the service should reject AP_REQs whose authenticator does not contain a subkey.
+func (s *Settings) RequireSubkey() bool {
+	return s.requireSubkey
+}
+
+// RequirePreAuth used to configure the service to reject tickets that do not have the PRE-AUTHENT flag set, so that
+// only clients that proved knowledge of their credentials to the KDC, rather than using an account that does not
+// require pre-authentication, are accepted.
+//
+// s := NewSettings(kt, RequirePreAuth(true))
+func RequirePreAuth(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.requirePreAuth = b
+	}
+}
+
+// RequirePreAuth indicates if the service should reject tickets that were issued without pre-authentication.
+func (s *Settings) RequirePreAuth() bool {
+	return s.requirePreAuth
+}
+
+// KVNOFallback used to configure the service to try each of its keys of the ticket's encryption type when the keytab
+// does not hold the key version the ticket was issued under. During a key rollover clients may still present tickets
+// issued under a kvno the service no longer holds. Without the fallback such tickets are rejected with a
+// KRB_AP_ERR_BADKEYVER error reporting the ticket's kvno and those held in the keytab.
+//
+// s := NewSettings(kt, KVNOFallback(true))
+func KVNOFallback(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.kvnoFallback = b
+	}
+}
+
+// KVNOFallback indicates if the service should try each of its keys when the keytab does not hold the ticket's kvno.
+func (s *Settings) KVNOFallback() bool {
+	return s.kvnoFallback
+}
+
+// VerifyPACClientInfo used to configure the service to check that the client name and authtime in the PAC_CLIENT_INFO
+// buffer of a ticket's PAC match the ticket's client name and authtime. A mismatch indicates the PAC has been tampered
+// with or taken from another ticket and the AP_REQ is rejected with KRB_AP_ERR_MODIFIED. The PAC is decoded for the
+// check even if the groups and claims are not extracted, unless PAC decoding is disabled.
+//
+// s := NewSettings(kt, VerifyPACClientInfo(true))
+func VerifyPACClientInfo(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.verifyPACClient = b
+	}
+}
+
+// VerifyPACClientInfo indicates if the service should check the PAC client info matches the ticket.
+func (s *Settings) VerifyPACClientInfo() bool {
+	return s.verifyPACClient
+}
+
+// TrustedRealms used to configure the realms the service has a direct cross-realm trust with. Tickets issued by a
+// trusted realm are accepted with the key the keytab holds for the ticket's principal in that realm, or, where the
+// keytab holds none, with the principal's key from another realm, as when the service's key is shared between the
+// realms. A TGT, including a cross-realm TGT issued by a trusted realm, is never accepted.
+//
+// s := NewSettings(kt, TrustedRealms("TRUSTED.REALM"))
+func TrustedRealms(realms ...string) func(*Settings) {
+	return func(s *Settings) {
+		s.trustedRealms = append(s.trustedRealms, realms...)
+	}
+}
+
+// TrustedRealms returns the realms the service has a direct cross-realm trust with.
+func (s *Settings) TrustedRealms() []string {
+	return s.trustedRealms
+}
+
+// TrustedRealm indicates if the realm provided is one the service has a direct cross-realm trust with.
+func (s *Settings) TrustedRealm(realm string) bool {
+	for _, r := range s.trustedRealms {
+		if strings.EqualFold(r, realm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diagnostics used to configure the service to log, on rejecting a client's token, a structured explanation of the step
+// that failed, the relevant values such as the ticket's encryption type, kvno and the clock skew with the client, and a
+// hint at how to remedy the failure. See Diagnosis. A logger must also be configured.
+//
+// s := NewSettings(kt, Logger(l), Diagnostics(true))
+func Diagnostics(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.diagnostics = b
+	}
+}
+
+// Diagnostics indicates if the service should log a diagnosis when rejecting a client's token.
+func (s *Settings) Diagnostics() bool {
+	return s.diagnostics
+}
+
+// Diagnose logs the diagnosis provided if the service is configured with Diagnostics and a logger.
+func (s *Settings) Diagnose(d Diagnosis) {
+	if s.diagnostics && s.Logger() != nil {
+		s.Logger().Printf("diagnosis: %s", d)
+	}
+}
+
+// ContextValues used to configure which values are extracted from a verified AP_REQ into the client's credentials, so
+// that a service only pays for the decoding it uses. By default the name and groups are extracted. The PAC is only
+// decoded if groups or claims are requested and PAC decoding is enabled.
+//
+// s := NewSettings(kt, ContextValues(ContextValueName, ContextValueTicket))
+func ContextValues(v ...ContextValue) func(*Settings) {
+	return func(s *Settings) {
+		s.contextValues = ContextValueName
+		for _, c := range v {
+			s.contextValues |= c
+		}
+	}
+}
+
+// ContextValues returns the values to be extracted from a verified AP_REQ into the client's credentials.
+func (s *Settings) ContextValues() ContextValue {
+	if s.contextValues == 0 {
+		return ContextValueName | ContextValueGroups
+	}
+	return s.contextValues
+}
+
+// ContextValue indicates if the value specified is to be extracted from a verified AP_REQ.
+func (s *Settings) ContextValue(v ContextValue) bool {
+	return s.ContextValues()&v != 0
+}
+
+// AdditionalKeytabs used to configure further keytabs to try, in priority order, after the service's keytab when
+// decrypting tickets. This allows a migration between keytabs, such as to a new service account, to run with both
+// the retiring and the new keys available.
+//
+// s := NewSettings(newkt, AdditionalKeytabs(oldkt))
+func AdditionalKeytabs(kts ...*keytab.Keytab) func(*Settings) {
+	return func(s *Settings) {
+		s.additionalKeytabs = kts
+	}
+}
+
+// Keytabs returns the keytabs of the service in the priority order they are to be tried when decrypting tickets.
+func (s *Settings) Keytabs() []*keytab.Keytab {
+	return append([]*keytab.Keytab{s.Keytab}, s.additionalKeytabs...)
+}
+
 // MaxClockSkew used to configure service side with the maximum acceptable clock skew
 // between the service and the issue time of kerberos tickets
 //
@@ -123,6 +433,44 @@ func (s *Settings) MaxClockSkew() time.Duration {
 	return s.maxClockSkew
 }
 
+// MaxAuthenticatorAge used to configure the maximum age of the authenticator in an AP_REQ, independent of the validity
+// of the ticket and the symmetric clock skew check. An authenticator created longer ago than this is rejected with
+// KRB_AP_ERR_SKEW, narrowing the window within which a captured AP_REQ could be replayed. By default only the maximum
+// clock skew applies.
+//
+// s := NewSettings(kt, MaxAuthenticatorAge(30*time.Second))
+func MaxAuthenticatorAge(d time.Duration) func(*Settings) {
+	return func(s *Settings) {
+		s.maxAuthAge = d
+	}
+}
+
+// MaxAuthenticatorAge returns the maximum age of an authenticator accepted by the service.
+// Zero is returned if no maximum, beyond the clock skew, is configured.
+func (s *Settings) MaxAuthenticatorAge() time.Duration {
+	return s.maxAuthAge
+}
+
+// ReplayWindow used to configure the duration within which authenticators presented to the service are detected as
+// replays, decoupled from the maximum clock skew so that slow replays can also be detected. The window may be as long
+// as the maximum ticket lifetime if desired. A window shorter than the maximum clock skew has no effect.
+//
+// s := NewSettings(kt, ReplayWindow(d))
+func ReplayWindow(d time.Duration) func(*Settings) {
+	return func(s *Settings) {
+		s.replayWindow = d
+	}
+}
+
+// ReplayWindow returns the duration within which authenticators presented to the service are detected as replays.
+// This is the maximum clock skew unless a longer window is configured.
+func (s *Settings) ReplayWindow() time.Duration {
+	if s.replayWindow > s.MaxClockSkew() {
+		return s.replayWindow
+	}
+	return s.MaxClockSkew()
+}
+
 // SName used provide a specific service name to the service settings.
 //
 // s := NewSettings(kt, SName("HTTP/some.service.com"))
@@ -161,3 +509,140 @@ type SessionMgr interface {
 	New(w http.ResponseWriter, r *http.Request, k string, v []byte) error
 	Get(r *http.Request, k string) ([]byte, error)
 }
+
+// CustomReplayCache configures the service to use the ReplayCache implementation provided
+// rather than the default in memory replay cache.
+//
+// s := NewSettings(kt, CustomReplayCache(rc))
+func CustomReplayCache(rc ReplayCache) func(*Settings) {
+	return func(s *Settings) {
+		s.replayCache = rc
+	}
+}
+
+// RejectWeakSubkeys used to configure the service to reject AP_REQs where the authenticator carries a subkey of a weak
+// encryption type, that is one weaker than AES such as DES, triple DES or RC4, so that subsequent message protection
+// cannot be downgraded by the subkey.
+// Such AP_REQs are rejected with a KRB_AP_ERR_METHOD KRBError before the authenticator is recorded in the replay cache.
+//
+// s := NewSettings(kt, RejectWeakSubkeys(true))
+func RejectWeakSubkeys(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.rejectWeakSubkeys = b
+	}
+}
+
+// RejectWeakSubkeys indicates if the service should reject authenticator subkeys of weak encryption types.
+func (s *Settings) RejectWeakSubkeys() bool {
+	return s.rejectWeakSubkeys
+}
+
+// MinEType used to configure the service to reject AP_REQs where the ticket is encrypted with an encryption type weaker
+// than that specified. For example to require at least AES128 so that no legacy encryption types are accepted.
+//
+// In order of strength, weakest first, the encryption types are: DES, RC4, triple DES, aes128-cts-hmac-sha1-96,
+// aes128-cts-hmac-sha256-128, aes256-cts-hmac-sha1-96 and aes256-cts-hmac-sha384-192.
+//
+// s := NewSettings(kt, MinEType(etypeID.AES128_CTS_HMAC_SHA1_96))
+func MinEType(etype int32) func(*Settings) {
+	return func(s *Settings) {
+		s.minEType = etype
+	}
+}
+
+// MinEType returns the weakest encryption type the service will accept tickets encrypted with.
+// Zero is returned if no minimum is configured.
+func (s *Settings) MinEType() int32 {
+	return s.minEType
+}
+
+// RealmCaseInsensitive used to configure the service to match the ticket's realm to that of the keytab's entries
+// without regard to case. Active Directory may issue tickets with a realm that differs in case from the realm the
+// service's keys are stored under in the keytab, which otherwise would prevent the key being found. The keytabs are
+// folded to the upper case form of their realms when the settings are built, so changes made to the keytabs afterwards
+// are not seen for tickets of those realms.
+//
+// s := NewSettings(kt, RealmCaseInsensitive(true))
+func RealmCaseInsensitive(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.realmFold = b
+	}
+}
+
+// RealmCaseInsensitive indicates if the ticket's realm is to be matched to the keytab's entries without regard to case.
+func (s *Settings) RealmCaseInsensitive() bool {
+	return s.realmFold
+}
+
+// realmFoldedKeytab returns the keytab folded to the ticket's realm. The keytabs folded when the settings were built are
+// used where the realm is one they were folded to, otherwise the keytab is folded for the realm.
+func (s *Settings) realmFoldedKeytab(kt *keytab.Keytab, realm string) *keytab.Keytab {
+	if fkt, ok := s.foldedKeytabs[kt][realm]; ok {
+		return fkt
+	}
+	return foldKeytabRealm(kt, realm)
+}
+
+// DynamicNegTokenResp used to configure an SPNEGO service to marshal the NegTokenResp returned on successful
+// authentication for each client rather than return a static token. The NegTokenResp then includes an AP_REP when the
+// client requested mutual authentication, and a mechListMIC when the client's NegTokenInit included one, at the cost
+// of forming the token for each authentication. The NegTokenResp is always marshalled for each client when the service
+// is configured with RequireMutualAuth.
+//
+// s := NewSettings(kt, DynamicNegTokenResp(true))
+func DynamicNegTokenResp(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.dynamicNegResp = b
+	}
+}
+
+// DynamicNegTokenResp indicates if an SPNEGO service is to marshal the NegTokenResp for each successful authentication.
+// This is implied by RequireMutualAuth as the client cannot complete mutual authentication without the AP_REP.
+func (s *Settings) DynamicNegTokenResp() bool {
+	return s.dynamicNegResp || s.requireMutual
+}
+
+// AdditionalAuthSchemes used to configure further WWW-Authenticate challenges, such as `Basic realm="example"` or
+// `Bearer`, that an SPNEGO service sends alongside Negotiate when it responds 401 to a client that has not
+// authenticated or whose authentication was rejected. This lets clients that cannot use Kerberos fall back to another
+// scheme, which the application must then handle itself.
+//
+// s := NewSettings(kt, AdditionalAuthSchemes(`Basic realm="example"`))
+func AdditionalAuthSchemes(challenges ...string) func(*Settings) {
+	return func(s *Settings) {
+		s.authSchemes = challenges
+	}
+}
+
+// AdditionalAuthSchemes returns the further WWW-Authenticate challenges an SPNEGO service sends alongside Negotiate.
+func (s *Settings) AdditionalAuthSchemes() []string {
+	return s.authSchemes
+}
+
+// ReplayCache returns the replay cache the service is to use.
+// If no custom implementation is configured the default in memory replay cache is returned.
+func (s *Settings) ReplayCache() ReplayCache {
+	if s.replayCache == nil {
+		return defaultReplayCache{}
+	}
+	return s.replayCache
+}
+
+// ChallengeAuthFailures used to configure the service to distinguish authentication failures from protocol errors when
+// responding to a client. When enabled a client whose ticket or authenticator is rejected, for example because it has
+// expired or been replayed, is sent a bare Negotiate challenge so that it may retry with a fresh ticket, while
+// malformed or unsupported tokens are still sent the SPNEGO reject token to indicate the client should not retry.
+// By default the reject token is sent in all cases.
+//
+// s := NewSettings(kt, ChallengeAuthFailures(true))
+func ChallengeAuthFailures(b bool) func(*Settings) {
+	return func(s *Settings) {
+		s.challengeFailures = b
+	}
+}
+
+// ChallengeAuthFailures indicates if the service should respond to authentication failures with a Negotiate challenge
+// rather than the reject token.
+func (s *Settings) ChallengeAuthFailures() bool {
+	return s.challengeFailures
+}
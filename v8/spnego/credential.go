@@ -0,0 +1,170 @@
+package spnego
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// Credential is a GSS-API credential handle acquired from a keytab, mirroring the output of GSS_Acquire_cred.
+type Credential struct {
+	name   string
+	realm  string
+	usage  int
+	expiry time.Time
+	keytab *keytab.Keytab
+	client *client.Client
+}
+
+// AcquireCredential acquires a credential for the principal name from the keytab, mirroring GSS_Acquire_cred.
+//
+// The name is of the form "primary/instance@REALM". If the realm is omitted the default realm of the configuration is
+// used. For an acceptor the name may be empty, in which case tickets for any principal in the keytab are accepted.
+// The usage is one of gssapi.CredUsageInitiateAndAccept, gssapi.CredUsageInitiateOnly or gssapi.CredUsageAcceptOnly.
+// A credential that can initiate logs in to the KDC using the keytab, the configuration is not needed for an
+// acceptor. A lifetime of zero requests a credential that does not expire.
+func AcquireCredential(name string, kt *keytab.Keytab, c *config.Config, usage int, lifetime time.Duration) (*Credential, error) {
+	if kt == nil || len(kt.Entries) < 1 {
+		return nil, errors.New("cannot acquire credential: keytab has no entries")
+	}
+	if lifetime < 0 {
+		return nil, errors.New("cannot acquire credential: lifetime must not be negative")
+	}
+	cred := &Credential{
+		usage:  usage,
+		keytab: kt,
+	}
+	cred.name = name
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		cred.name, cred.realm = name[:i], name[i+1:]
+	}
+	if cred.realm == "" && c != nil {
+		cred.realm = c.LibDefaults.DefaultRealm
+	}
+	if lifetime > 0 {
+		cred.expiry = time.Now().UTC().Add(lifetime)
+	}
+	switch usage {
+	case gssapi.CredUsageInitiateAndAccept, gssapi.CredUsageInitiateOnly:
+		if cred.name == "" {
+			return nil, errors.New("cannot acquire credential: a name is required to initiate security contexts")
+		}
+		if c == nil {
+			return nil, errors.New("cannot acquire credential: a configuration is required to initiate security contexts")
+		}
+		cl := client.NewWithKeytab(cred.name, cred.realm, kt, c)
+		err := cl.Login()
+		if err != nil {
+			return nil, fmt.Errorf("cannot acquire credential for %s@%s: %v", cred.name, cred.realm, err)
+		}
+		cred.client = cl
+	case gssapi.CredUsageAcceptOnly:
+	default:
+		return nil, fmt.Errorf("cannot acquire credential: unknown credential usage %d", usage)
+	}
+	if cred.CanAccept() && cred.name != "" && !hasPrincipal(kt, cred.name, cred.realm) {
+		return nil, fmt.Errorf("cannot acquire credential: keytab has no keys for %s@%s", cred.name, cred.realm)
+	}
+	return cred, nil
+}
+
+// hasPrincipal indicates if the keytab holds keys for the principal. An empty realm matches any realm.
+func hasPrincipal(kt *keytab.Keytab, name, realm string) bool {
+	pn, _ := types.ParseSPNString(name)
+	for _, e := range kt.Entries {
+		if (realm == "" || e.Principal.Realm == realm) && strings.Join(e.Principal.Components, "/") == strings.Join(pn.NameString, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the principal name of the credential, without the realm. This is empty for an acceptor credential
+// that accepts tickets for any principal in its keytab.
+func (c *Credential) Name() string {
+	return c.name
+}
+
+// Realm returns the realm of the credential.
+func (c *Credential) Realm() string {
+	return c.realm
+}
+
+// Usage returns the usage the credential was acquired for.
+func (c *Credential) Usage() int {
+	return c.usage
+}
+
+// CanInitiate indicates if the credential may be used to initiate security contexts.
+func (c *Credential) CanInitiate() bool {
+	return c.usage == gssapi.CredUsageInitiateAndAccept || c.usage == gssapi.CredUsageInitiateOnly
+}
+
+// CanAccept indicates if the credential may be used to accept security contexts.
+func (c *Credential) CanAccept() bool {
+	return c.usage == gssapi.CredUsageInitiateAndAccept || c.usage == gssapi.CredUsageAcceptOnly
+}
+
+// Expiry returns the time the credential expires. The zero time is returned if the credential does not expire.
+func (c *Credential) Expiry() time.Time {
+	return c.expiry
+}
+
+// Lifetime returns the remaining lifetime of the credential, mirroring the lifetime output of GSS_Inquire_cred.
+// Zero is returned if the credential has expired. A credential that does not expire returns the maximum duration.
+func (c *Credential) Lifetime() time.Duration {
+	if c.expiry.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	d := time.Until(c.expiry)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Expired indicates if the credential has expired.
+func (c *Credential) Expired() bool {
+	return !c.expiry.IsZero() && time.Now().UTC().After(c.expiry)
+}
+
+// Release destroys the client's session of a credential that can initiate, mirroring GSS_Release_cred.
+func (c *Credential) Release() {
+	if c.client != nil {
+		c.client.Destroy()
+	}
+}
+
+// SPNEGOServiceWithCredential configures the SPNEGO mechanism for service side use with a credential that can accept
+// security contexts. Once the credential expires security contexts are no longer accepted.
+func SPNEGOServiceWithCredential(cred *Credential, options ...func(*service.Settings)) (*SPNEGO, error) {
+	if !cred.CanAccept() {
+		return nil, errors.New("credential cannot be used to accept security contexts")
+	}
+	if cred.name != "" {
+		// Only tickets that decrypt with the keys of the credential's principal are accepted
+		options = append([]func(*service.Settings){service.KeytabPrincipal(cred.name)}, options...)
+	}
+	s := SPNEGOService(cred.keytab, options...)
+	s.cred = cred
+	return s, nil
+}
+
+// SPNEGOClientWithCredential configures the SPNEGO mechanism for client side use with a credential that can initiate
+// security contexts to the service with the SPN provided.
+func SPNEGOClientWithCredential(cred *Credential, spn string) (*SPNEGO, error) {
+	if !cred.CanInitiate() {
+		return nil, errors.New("credential cannot be used to initiate security contexts")
+	}
+	s := SPNEGOClient(cred.client, spn)
+	s.cred = cred
+	return s, nil
+}
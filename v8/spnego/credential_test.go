@@ -0,0 +1,72 @@
+package spnego
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireCredential_Accept(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	h, _ := types.GetHostAddress("127.0.0.1:1234")
+
+	cred, err := AcquireCredential("HTTP/host.test.gokrb5@TEST.GOKRB5", kt, nil, gssapi.CredUsageAcceptOnly, time.Hour)
+	if err != nil {
+		t.Fatalf("error acquiring acceptor credential: %v", err)
+	}
+	assert.Equal(t, "HTTP/host.test.gokrb5", cred.Name(), "credential name not as expected")
+	assert.Equal(t, "TEST.GOKRB5", cred.Realm(), "credential realm not as expected")
+	assert.True(t, cred.CanAccept(), "acceptor credential should be able to accept")
+	assert.False(t, cred.CanInitiate(), "accept only credential should not be able to initiate")
+	assert.True(t, cred.Lifetime() > 59*time.Minute && cred.Lifetime() <= time.Hour, "credential lifetime not as expected")
+
+	_, err = SPNEGOClientWithCredential(cred, "HTTP/host.test.gokrb5")
+	assert.Error(t, err, "accept only credential should not configure a client")
+
+	s, err := SPNEGOServiceWithCredential(cred, service.ClientAddress(h))
+	if err != nil {
+		t.Fatalf("error configuring service with credential: %v", err)
+	}
+	st := &SPNEGOToken{Init: true, NegTokenInit: offlineNegTokenInit(t, types.NewKrbFlags())}
+	ok, _, status := s.AcceptSecContext(st)
+	assert.True(t, ok, "security context not accepted with the credential: %v", status)
+
+	// Once the credential expires no further security contexts are accepted
+	cred.expiry = time.Now().UTC().Add(-time.Minute)
+	assert.Equal(t, time.Duration(0), cred.Lifetime(), "expired credential should have no lifetime")
+	st = &SPNEGOToken{Init: true, NegTokenInit: offlineNegTokenInit(t, types.NewKrbFlags())}
+	ok, _, status = s.AcceptSecContext(st)
+	assert.False(t, ok, "security context should not be accepted with an expired credential")
+	assert.Equal(t, gssapi.StatusCredentialsExpired, status.Code, "status not as expected for an expired credential")
+}
+
+func TestAcquireCredential_Invalid(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+
+	cred, err := AcquireCredential("", kt, nil, gssapi.CredUsageAcceptOnly, 0)
+	if assert.NoError(t, err, "acceptor credential without a name should be acquired") {
+		assert.True(t, cred.Expiry().IsZero(), "credential with no lifetime requested should not expire")
+		assert.False(t, cred.Expired(), "credential with no lifetime requested should not expire")
+	}
+	_, err = AcquireCredential("HTTP/other.test.gokrb5@TEST.GOKRB5", kt, nil, gssapi.CredUsageAcceptOnly, 0)
+	assert.Error(t, err, "credential for a principal not in the keytab should not be acquired")
+	_, err = AcquireCredential("HTTP/host.test.gokrb5@TEST.GOKRB5", kt, nil, gssapi.CredUsageInitiateOnly, 0)
+	assert.Error(t, err, "initiator credential should not be acquired without a configuration")
+	_, err = AcquireCredential("HTTP/host.test.gokrb5@TEST.GOKRB5", kt, nil, 99, 0)
+	assert.Error(t, err, "credential with an unknown usage should not be acquired")
+	_, err = AcquireCredential("HTTP/host.test.gokrb5@TEST.GOKRB5", keytab.New(), nil, gssapi.CredUsageAcceptOnly, 0)
+	assert.Error(t, err, "credential should not be acquired from an empty keytab")
+}
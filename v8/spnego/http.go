@@ -1,7 +1,9 @@
 package spnego
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -11,12 +13,14 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/goidentity/v6"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/krberror"
@@ -264,7 +268,7 @@ func SPNEGOKRB5Authenticate(inner http.Handler, kt *keytab.Keytab, settings ...f
 		if err == nil && id.Authenticated() {
 			// There is an established session so bypass auth and serve
 			spnego.Log("%s - SPNEGO request served under session %s", r.RemoteAddr, id.SessionID())
-			inner.ServeHTTP(w, goidentity.AddToHTTPRequestContext(&id, r))
+			serveInner(spnego, inner, w, goidentity.AddToHTTPRequestContext(&id, r))
 			return
 		}
 
@@ -273,15 +277,21 @@ func SPNEGOKRB5Authenticate(inner http.Handler, kt *keytab.Keytab, settings ...f
 			// response to client and logging handled in function above so just return
 			return
 		}
+		// The keys in the token are not needed once the context token has been validated
+		defer st.Delete()
 
 		// Validate the context token
 		authed, ctx, status := spnego.AcceptSecContext(st)
 		if status.Code != gssapi.StatusComplete && status.Code != gssapi.StatusContinueNeeded {
+			if isAuthFailure(spnego, status) {
+				spnegoResponseChallenge(spnego, w, "%s - SPNEGO validation error: %v", r.RemoteAddr, status)
+				return
+			}
 			spnegoResponseReject(spnego, w, "%s - SPNEGO validation error: %v", r.RemoteAddr, status)
 			return
 		}
 		if status.Code == gssapi.StatusContinueNeeded {
-			spnegoNegotiateKRB5MechType(spnego, w, "%s - SPNEGO GSS-API continue needed", r.RemoteAddr)
+			spnegoNegotiateMechType(spnego, w, negTokenRespIncomplete(st), "%s - SPNEGO GSS-API continue needed", r.RemoteAddr)
 			return
 		}
 
@@ -293,22 +303,184 @@ func SPNEGOKRB5Authenticate(inner http.Handler, kt *keytab.Keytab, settings ...f
 			if err != nil {
 				return
 			}
-			spnegoResponseAcceptCompleted(spnego, w, "%s %s@%s - SPNEGO authentication succeeded", r.RemoteAddr, id.UserName(), id.Domain())
+			hv, err := negTokenRespAcceptCompleted(spnego, st)
+			if err != nil {
+				spnegoInternalServerError(spnego, w, "%s - SPNEGO could not create the NegTokenResp: %v", r.RemoteAddr, err)
+				return
+			}
+			ca, _ := id.Attributes()[credentials.AttributeKeyGSSContextAttributes].(gssapi.ContextAttributes)
+			spnegoResponseAcceptCompleted(spnego, w, hv, "%s %s@%s - SPNEGO authentication succeeded (mech: %v, ticket etype: %v, session key etype: %v)", r.RemoteAddr, id.UserName(), id.Domain(),
+				ca.Mech, id.Attributes()[credentials.AttributeKeyTicketEType], id.Attributes()[credentials.AttributeKeySessionKeyEType])
 			// Add the identity to the context and serve the inner/wrapped handler
-			inner.ServeHTTP(w, goidentity.AddToHTTPRequestContext(id, r))
+			serveInner(spnego, inner, w, goidentity.AddToHTTPRequestContext(id, r))
 			return
 		}
 		// If we get to here we have not authenticationed so just reject
+		if spnego.serviceSettings.ChallengeAuthFailures() {
+			spnegoResponseChallenge(spnego, w, "%s - SPNEGO Kerberos authentication failed", r.RemoteAddr)
+			return
+		}
 		spnegoResponseReject(spnego, w, "%s - SPNEGO Kerberos authentication failed", r.RemoteAddr)
 		return
 	})
 }
 
+// isAuthFailure indicates if the status is an authentication failure that the client may recover from by retrying
+// with a fresh ticket, rather than a protocol error, and the service is configured to challenge such failures.
+func isAuthFailure(spnego *SPNEGO, status gssapi.Status) bool {
+	if !spnego.serviceSettings.ChallengeAuthFailures() {
+		return false
+	}
+	switch status.Code {
+	case gssapi.StatusDefectiveCredential, gssapi.StatusCredentialsExpired, gssapi.StatusContextExpired,
+		gssapi.StatusDuplicateToken, gssapi.StatusOldToken:
+		return true
+	}
+	return false
+}
+
+// serveInner serves the wrapped handler recovering from any panic within it, so that the client receives an internal
+// server error and the deferred release of the request's authentication state still takes place. If the handler had
+// already started the response before panicking the panic is only logged, as the status can no longer be changed.
+func serveInner(spnego *SPNEGO, inner http.Handler, w http.ResponseWriter, r *http.Request) {
+	tw := &trackingResponseWriter{ResponseWriter: w}
+	defer func() {
+		if rec := recover(); rec != nil {
+			if rec == http.ErrAbortHandler {
+				// Deliberate abort of the response so leave this to the http server
+				panic(rec)
+			}
+			if tw.written {
+				spnego.Log("%s - SPNEGO wrapped handler panicked after writing the response: %v", r.RemoteAddr, rec)
+				return
+			}
+			spnegoInternalServerError(spnego, w, "%s - SPNEGO wrapped handler panicked: %v", r.RemoteAddr, rec)
+		}
+	}()
+	inner.ServeHTTP(tw, r)
+}
+
+// trackingResponseWriter is an http.ResponseWriter that records whether the response has been started.
+type trackingResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+// WriteHeader sends the response header with the status code provided.
+func (w *trackingResponseWriter) WriteHeader(statusCode int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write writes the data to the response body.
+func (w *trackingResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client if the underlying ResponseWriter supports it.
+func (w *trackingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.written = true
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection if the underlying ResponseWriter supports it.
+func (w *trackingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter does not support hijacking the connection")
+	}
+	w.written = true
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (w *trackingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// IsForwardable indicates if the ticket the client authenticated with is forwardable.
+// The context provided should be the context of an http.Request passed to a handler wrapped by SPNEGOKRB5Authenticate
+// or the context returned from AcceptSecContext.
+// If the context does not hold the identity of an authenticated client false is returned.
+func IsForwardable(ctx context.Context) bool {
+	f, ok := ctxTicketFlags(ctx)
+	if !ok {
+		return false
+	}
+	return types.IsFlagSet(&f, flags.Forwardable)
+}
+
+// TicketAuthTime returns the authtime of the ticket the client authenticated with, which is the time of the client's
+// original authentication to the KDC rather than the ticket's start time. This can be used to ensure an application
+// session does not outlive the original authentication by more than a policy allows.
+// The context provided should be the context of an http.Request passed to a handler wrapped by SPNEGOKRB5Authenticate
+// or the context returned from AcceptSecContext.
+// If the context does not hold the identity of an authenticated client false is returned.
+func TicketAuthTime(ctx context.Context) (time.Time, bool) {
+	id, ok := ctxIdentity(ctx)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, ok := id.Attributes()[credentials.AttributeKeyTicketAuthTime].(time.Time)
+	return t, ok
+}
+
+// TicketRenewTill returns the renew-till time of the ticket the client authenticated with, which is the latest time the
+// client's ticket can be renewed to. This can be used to offer the extension of an application session only up to the
+// renewable lifetime of the ticket.
+// The context provided should be the context of an http.Request passed to a handler wrapped by SPNEGOKRB5Authenticate
+// or the context returned from AcceptSecContext.
+// If the context does not hold the identity of an authenticated client, or the ticket is not renewable, false is returned.
+func TicketRenewTill(ctx context.Context) (time.Time, bool) {
+	id, ok := ctxIdentity(ctx)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, ok := id.Attributes()[credentials.AttributeKeyTicketRenewTill].(time.Time)
+	return t, ok
+}
+
+// InquireContext returns the attributes of the security context established with the client, mirroring GSS_Inquire_context.
+// The context provided should be the context of an http.Request passed to a handler wrapped by SPNEGOKRB5Authenticate
+// or the context returned from AcceptSecContext.
+// If the context does not hold the identity of an authenticated client false is returned.
+func InquireContext(ctx context.Context) (gssapi.ContextAttributes, bool) {
+	id, ok := ctxIdentity(ctx)
+	if !ok {
+		return gssapi.ContextAttributes{}, false
+	}
+	a, ok := id.Attributes()[credentials.AttributeKeyGSSContextAttributes].(gssapi.ContextAttributes)
+	return a, ok
+}
+
+// ctxIdentity returns the identity held in the context.
+func ctxIdentity(ctx context.Context) (goidentity.Identity, bool) {
+	id, ok := ctx.Value(goidentity.CTXKey).(goidentity.Identity)
+	if !ok {
+		id, ok = ctx.Value(ctxCredentials).(goidentity.Identity)
+	}
+	return id, ok
+}
+
+// ctxTicketFlags returns the flags of the ticket from the identity held in the context.
+func ctxTicketFlags(ctx context.Context) (asn1.BitString, bool) {
+	id, ok := ctxIdentity(ctx)
+	if !ok {
+		return asn1.BitString{}, false
+	}
+	f, ok := id.Attributes()[credentials.AttributeKeyTicketFlags].(asn1.BitString)
+	return f, ok
+}
+
 func getAuthorizationNegotiationHeaderAsSPNEGOToken(spnego *SPNEGO, r *http.Request, w http.ResponseWriter) (*SPNEGOToken, error) {
 	s := strings.SplitN(r.Header.Get(HTTPHeaderAuthRequest), " ", 2)
 	if len(s) != 2 || s[0] != HTTPHeaderAuthResponseValueKey {
 		// No Authorization header set so return 401 with WWW-Authenticate Negotiate header
 		w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
+		addAuthSchemes(spnego, w)
 		http.Error(w, UnauthorizedMsg, http.StatusUnauthorized)
 		return nil, errors.New("client did not provide a negotiation authorization header")
 	}
@@ -317,18 +489,40 @@ func getAuthorizationNegotiationHeaderAsSPNEGOToken(spnego *SPNEGO, r *http.Requ
 	b, err := base64.StdEncoding.DecodeString(s[1])
 	if err != nil {
 		err = fmt.Errorf("error in base64 decoding negotiation header: %v", err)
+		diagnoseHeader(spnego, service.DiagnosticStepBase64, err)
+		spnegoNegotiateKRB5MechType(spnego, w, "%s - SPNEGO %v", r.RemoteAddr, err)
+		return nil, err
+	}
+	st, err := unmarshalNegotiationToken(b)
+	if err != nil {
+		err = fmt.Errorf("error in unmarshaling SPNEGO token: %v", err)
+		diagnoseHeader(spnego, service.DiagnosticStepNegTokenParse, err)
 		spnegoNegotiateKRB5MechType(spnego, w, "%s - SPNEGO %v", r.RemoteAddr, err)
 		return nil, err
 	}
+	return &st, nil
+}
+
+// diagnoseHeader logs a diagnosis of the negotiation header that could not be decoded at the step given, if the service
+// is configured with Diagnostics.
+func diagnoseHeader(s *SPNEGO, step string, err error) {
+	hint := "the Authorization header value is not valid base64; a proxy may be altering or truncating the header"
+	if step == service.DiagnosticStepNegTokenParse {
+		hint = "the token is neither an SPNEGO nor a KRB5 token; the client may be sending an NTLM token as it could not obtain a Kerberos ticket for the service"
+	}
+	s.serviceSettings.Diagnose(service.Diagnosis{Step: step, Reason: err.Error(), Hint: hint})
+}
+
+// unmarshalNegotiationToken unmarshals the bytes from the negotiation header into an SPNEGO context token.
+// Some clients send a raw KRB5 context token rather than one framed in SPNEGO - issue #347. These are identified by
+// the KRB5 mechanism OID and wrapped into an SPNEGO NegTokenInit so they are accepted in the same way.
+func unmarshalNegotiationToken(b []byte) (SPNEGOToken, error) {
 	var st SPNEGOToken
-	err = st.Unmarshal(b)
+	err := st.Unmarshal(b)
 	if err != nil {
-		// Check if this is a raw KRB5 context token - issue #347.
 		var k5t KRB5Token
 		if k5t.Unmarshal(b) != nil {
-			err = fmt.Errorf("error in unmarshaling SPNEGO token: %v", err)
-			spnegoNegotiateKRB5MechType(spnego, w, "%s - SPNEGO %v", r.RemoteAddr, err)
-			return nil, err
+			return st, err
 		}
 		// Wrap it into an SPNEGO context token
 		st.Init = true
@@ -337,7 +531,7 @@ func getAuthorizationNegotiationHeaderAsSPNEGOToken(spnego *SPNEGO, r *http.Requ
 			MechTokenBytes: b,
 		}
 	}
-	return &st, nil
+	return st, nil
 }
 
 func getSessionCredentials(spnego *SPNEGO, r *http.Request) (credentials.Credentials, error) {
@@ -378,20 +572,75 @@ func newSession(spnego *SPNEGO, r *http.Request, w http.ResponseWriter, id *cred
 // Log and respond to client for error conditions
 
 func spnegoNegotiateKRB5MechType(s *SPNEGO, w http.ResponseWriter, format string, v ...interface{}) {
+	spnegoNegotiateMechType(s, w, spnegoNegTokenRespIncompleteKRB5, format, v...)
+}
+
+func spnegoNegotiateMechType(s *SPNEGO, w http.ResponseWriter, hv string, format string, v ...interface{}) {
 	s.Log(format, v...)
-	w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespIncompleteKRB5)
+	w.Header().Set(HTTPHeaderAuthResponse, hv)
+	http.Error(w, UnauthorizedMsg, http.StatusUnauthorized)
+}
+
+// negTokenRespIncomplete returns the response header value continuing the negotiation of the SPNEGO token with the
+// Kerberos mechanism selected from those the initiator proposed. The static token is used when the standard KRB5
+// mechanism is selected.
+func negTokenRespIncomplete(st *SPNEGOToken) string {
+	if !st.Init {
+		return spnegoNegTokenRespIncompleteKRB5
+	}
+	n := newNegTokenRespKRB5Incomplete(st.NegTokenInit)
+	if n.SupportedMech.Equal(gssapi.OIDKRB5.OID()) {
+		return spnegoNegTokenRespIncompleteKRB5
+	}
+	b, err := n.Marshal()
+	if err != nil {
+		return spnegoNegTokenRespIncompleteKRB5
+	}
+	return HTTPHeaderAuthResponseValueKey + " " + base64.StdEncoding.EncodeToString(b)
+}
+
+func spnegoResponseChallenge(s *SPNEGO, w http.ResponseWriter, format string, v ...interface{}) {
+	s.Log(format, v...)
+	w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
+	addAuthSchemes(s, w)
 	http.Error(w, UnauthorizedMsg, http.StatusUnauthorized)
 }
 
 func spnegoResponseReject(s *SPNEGO, w http.ResponseWriter, format string, v ...interface{}) {
 	s.Log(format, v...)
 	w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespReject)
+	addAuthSchemes(s, w)
 	http.Error(w, UnauthorizedMsg, http.StatusUnauthorized)
 }
 
-func spnegoResponseAcceptCompleted(s *SPNEGO, w http.ResponseWriter, format string, v ...interface{}) {
+// addAuthSchemes adds the WWW-Authenticate challenges of the further authentication schemes the service is configured
+// to offer alongside Negotiate.
+func addAuthSchemes(s *SPNEGO, w http.ResponseWriter) {
+	for _, c := range s.serviceSettings.AdditionalAuthSchemes() {
+		w.Header().Add(HTTPHeaderAuthResponse, c)
+	}
+}
+
+func spnegoResponseAcceptCompleted(s *SPNEGO, w http.ResponseWriter, hv string, format string, v ...interface{}) {
 	s.Log(format, v...)
-	w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespKRBAcceptCompleted)
+	w.Header().Set(HTTPHeaderAuthResponse, hv)
+}
+
+// negTokenRespAcceptCompleted returns the response header value for the successful authentication of the SPNEGO
+// token. Unless the service is configured to marshal the NegTokenResp for each authentication the static token is used.
+func negTokenRespAcceptCompleted(s *SPNEGO, st *SPNEGOToken) (string, error) {
+	if !s.serviceSettings.DynamicNegTokenResp() {
+		return spnegoNegTokenRespKRBAcceptCompleted, nil
+	}
+	n, err := newNegTokenRespKRB5AcceptCompleted(st)
+	if err != nil {
+		return "", err
+	}
+	b, err := n.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("error marshalling NegTokenResp: %v", err)
+	}
+	return HTTPHeaderAuthResponseValueKey + " " + base64.StdEncoding.EncodeToString(b), nil
 }
 
 func spnegoInternalServerError(s *SPNEGO, w http.ResponseWriter, format string, v ...interface{}) {
@@ -402,14 +651,6 @@ func spnegoInternalServerError(s *SPNEGO, w http.ResponseWriter, format string,
 var ErrNoAuthorizationHeader = errors.New("no Authorization Header")
 
 func Authenticate(kt *keytab.Keytab, w http.ResponseWriter, r *http.Request, settings ...func(*service.Settings)) (bool, goidentity.Identity, error) {
-	// Get the auth header
-	s := strings.SplitN(r.Header.Get(HTTPHeaderAuthRequest), " ", 2)
-	if len(s) != 2 || s[0] != HTTPHeaderAuthResponseValueKey {
-		// No Authorization header set so return 401 with WWW-Authenticate Negotiate header
-		w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
-		return false, nil, ErrNoAuthorizationHeader
-	}
-
 	// Set up the SPNEGO GSS-API mechanism
 	var spnego *SPNEGO
 	h, err := types.GetHostAddress(r.RemoteAddr)
@@ -422,36 +663,64 @@ func Authenticate(kt *keytab.Keytab, w http.ResponseWriter, r *http.Request, set
 		spnego = SPNEGOService(kt, o...)
 	}
 
+	// Get the auth header
+	s := strings.SplitN(r.Header.Get(HTTPHeaderAuthRequest), " ", 2)
+	if len(s) != 2 || s[0] != HTTPHeaderAuthResponseValueKey {
+		// No Authorization header set so return 401 with WWW-Authenticate Negotiate header
+		w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
+		addAuthSchemes(spnego, w)
+		return false, nil, ErrNoAuthorizationHeader
+	}
+
 	// Decode the header into an SPNEGO context token
 	b, err := base64.StdEncoding.DecodeString(s[1])
 	if err != nil {
+		diagnoseHeader(spnego, service.DiagnosticStepBase64, err)
 		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespIncompleteKRB5)
 		return false, nil, fmt.Errorf("%s - SPNEGO error in base64 decoding negotiation header: %v", r.RemoteAddr, err)
 	}
-	var st SPNEGOToken
-	err = st.Unmarshal(b)
+	st, err := unmarshalNegotiationToken(b)
 	if err != nil {
+		diagnoseHeader(spnego, service.DiagnosticStepNegTokenParse, err)
 		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespIncompleteKRB5)
 		return false, nil, fmt.Errorf("%s - SPNEGO error in unmarshaling SPNEGO token: %v", r.RemoteAddr, err)
 	}
+	// The keys in the token are not needed once the context token has been validated
+	defer st.Delete()
 
 	// Validate the context token
 	authed, ctx, status := spnego.AcceptSecContext(&st)
 	if status.Code != gssapi.StatusComplete && status.Code != gssapi.StatusContinueNeeded {
-		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespReject)
+		if isAuthFailure(spnego, status) {
+			w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
+		} else {
+			w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespReject)
+		}
+		addAuthSchemes(spnego, w)
 		return false, nil, fmt.Errorf("%s - SPNEGO validation error: %v", r.RemoteAddr, status)
 	}
 	if status.Code == gssapi.StatusContinueNeeded {
-		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespIncompleteKRB5)
+		w.Header().Set(HTTPHeaderAuthResponse, negTokenRespIncomplete(&st))
 		return false, nil, fmt.Errorf("%s - SPNEGO GSS-API continue needed", r.RemoteAddr)
 	}
 	if authed {
 		c := ctx.Value(ctxCredentials).(goidentity.Identity)
-		spnego.Log("%s %s@%s - SPNEGO authentication succeeded", r.RemoteAddr, c.UserName(), c.Domain())
-		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespKRBAcceptCompleted)
+		ca, _ := c.Attributes()[credentials.AttributeKeyGSSContextAttributes].(gssapi.ContextAttributes)
+		spnego.Log("%s %s@%s - SPNEGO authentication succeeded (mech: %v, ticket etype: %v, session key etype: %v)", r.RemoteAddr, c.UserName(), c.Domain(),
+			ca.Mech, c.Attributes()[credentials.AttributeKeyTicketEType], c.Attributes()[credentials.AttributeKeySessionKeyEType])
+		hv, err := negTokenRespAcceptCompleted(spnego, &st)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s - SPNEGO could not create the NegTokenResp: %v", r.RemoteAddr, err)
+		}
+		w.Header().Set(HTTPHeaderAuthResponse, hv)
 		return true, c, nil
 	} else {
-		w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespReject)
+		if spnego.serviceSettings.ChallengeAuthFailures() {
+			w.Header().Set(HTTPHeaderAuthResponse, HTTPHeaderAuthResponseValueKey)
+		} else {
+			w.Header().Set(HTTPHeaderAuthResponse, spnegoNegTokenRespReject)
+		}
+		addAuthSchemes(spnego, w)
 		return false, nil, fmt.Errorf("%s - SPNEGO Kerberos authentication failed", r.RemoteAddr)
 	}
 }
@@ -2,6 +2,7 @@ package spnego
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
@@ -14,17 +15,28 @@ import (
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/goidentity/v6"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/service"
 	"github.com/jcmturner/gokrb5/v8/test"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -135,6 +147,58 @@ func TestService_SPNEGOKRB_NoAuthHeader(t *testing.T) {
 	assert.Equal(t, "Negotiate", httpResp.Header.Get("WWW-Authenticate"), "Negotiation header not set by server.")
 }
 
+func TestClient_SetSPNEGOHeader_CCacheTicket(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer s.Close()
+
+	tkt, sessionKey := offlineTicket(t, types.NewKrbFlags())
+	tb, err := tkt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling ticket: %v", err)
+	}
+	cb, _ := hex.DecodeString(testdata.CCACHE_TEST)
+	cc := new(credentials.CCache)
+	if err := cc.Unmarshal(cb); err != nil {
+		t.Fatalf("error unmarshaling test credential cache: %v", err)
+	}
+	now := time.Now().UTC()
+	for _, cred := range cc.GetEntries() {
+		cred.StartTime = now.Add(-time.Minute)
+		cred.EndTime = now.Add(time.Hour)
+		if cred.Server.PrincipalName.NameString[0] == "HTTP" {
+			// The service ticket was requested with a short name that the KDC canonicalized
+			cred.Server.PrincipalName = types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host")
+			cred.Ticket = tb
+			cred.Key = sessionKey
+		}
+	}
+	// The KDC is not reachable so the service ticket must be taken from the credential cache
+	c, _ := config.NewFromString(testdata.KRB5_CONF)
+	c.Realms[0].KDC = []string{"127.0.0.1:1"}
+	cl, err := client.NewFromCCache(cc, c)
+	if err != nil {
+		t.Fatalf("error creating client from credential cache: %v", err)
+	}
+
+	for _, spn := range []string{"HTTP/host", "HTTP/host.test.gokrb5"} {
+		r, _ := http.NewRequest("GET", s.URL, nil)
+		err = SetSPNEGOHeader(cl, r, spn)
+		if err != nil {
+			t.Fatalf("error setting client's SPNEGO header with the ticket for %s from the credential cache: %v", spn, err)
+		}
+		httpResp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Request error: %v\n", err)
+		}
+		httpResp.Body.Close()
+		assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request for %s not as expected", spn)
+	}
+}
+
 func TestService_SPNEGOKRB_ValidUser(t *testing.T) {
 	test.Integration(t)
 
@@ -185,6 +249,631 @@ func TestService_SPNEGOKRB_ValidUser_RawKRB5Token(t *testing.T) {
 	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
 }
 
+func TestService_SPNEGOKRB_RawKRB5TokenOffline(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	nb := offlineNegTokenInit(t, types.NewKrbFlags()).MechTokenBytes
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to raw KRB5 token not as expected")
+}
+
+func TestAuthenticate_RawKRB5Token(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+
+	r, _ := http.NewRequest("GET", "http://host.test.gokrb5/", nil)
+	r.RemoteAddr = "127.0.0.1:12345"
+	nb := offlineNegTokenInit(t, types.NewKrbFlags()).MechTokenBytes
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	w := httptest.NewRecorder()
+	authed, id, err := Authenticate(kt, w, r)
+	if err != nil {
+		t.Fatalf("error authenticating raw KRB5 token: %v", err)
+	}
+	assert.True(t, authed, "raw KRB5 token not authenticated")
+	assert.Equal(t, "testuser1", id.UserName(), "username not as expected")
+
+	// Bytes that are neither an SPNEGO nor a KRB5 token are still rejected
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString([]byte{0x60, 0x02, 0x05, 0x00}))
+	authed, _, err = Authenticate(kt, httptest.NewRecorder(), r)
+	assert.False(t, authed, "invalid token should not authenticate")
+	assert.Error(t, err, "invalid token should return an error")
+}
+
+func TestService_SPNEGOKRB_NegTokenInitKRB5APReq(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer s.Close()
+
+	// Form the AP_REQ outside of the SPNEGO token
+	var mt KRB5Token
+	err := mt.Unmarshal(offlineNegTokenInit(t, types.NewKrbFlags()).MechTokenBytes)
+	if err != nil {
+		t.Fatalf("error unmarshaling KRB5 token: %v", err)
+	}
+	apReqBytes, err := mt.APReq.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REQ: %v", err)
+	}
+
+	nt, err := NewNegTokenInitKRB5APReq(apReqBytes)
+	if err != nil {
+		t.Fatalf("error creating NegTokenInit: %v", err)
+	}
+	spt := SPNEGOToken{
+		Init:         true,
+		NegTokenInit: nt,
+	}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+
+	_, err = NewNegTokenInitKRB5APReq([]byte{1, 2, 3})
+	assert.Error(t, err, "invalid AP_REQ bytes should return an error")
+}
+
+func TestService_SPNEGOKRB_IsForwardable(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%t", IsForwardable(r.Context()))
+	})
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(th, kt))
+	defer s.Close()
+
+	var tests = []struct {
+		forwardable bool
+	}{
+		{true},
+		{false},
+	}
+	for _, test := range tests {
+		f := types.NewKrbFlags()
+		if test.forwardable {
+			types.SetFlag(&f, flags.Forwardable)
+		}
+		r, _ := http.NewRequest("GET", s.URL, nil)
+		setOfflineSPNEGOHeader(t, r, f)
+		httpResp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Request error: %v\n", err)
+		}
+		body, _ := io.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+		assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+		assert.Equal(t, fmt.Sprintf("%t", test.forwardable), string(body), "IsForwardable not as expected")
+	}
+	assert.False(t, IsForwardable(context.Background()), "IsForwardable should be false for a context without an identity")
+}
+
+func TestService_SPNEGOKRB_LogETypes(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.Logger(l)))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+	assert.Contains(t, buf.String(), "SPNEGO authentication succeeded (mech: 1.2.840.113554.1.2.2, ticket etype: 18, session key etype: 18)", "success log line does not include the mech and etypes")
+
+	// Authenticate logs the same details
+	buf.Reset()
+	r, _ = http.NewRequest("GET", s.URL, nil)
+	r.RemoteAddr = "127.0.0.1:12345"
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	authed, _, err := Authenticate(kt, httptest.NewRecorder(), r, service.Logger(l))
+	if err != nil {
+		t.Fatalf("error authenticating SPNEGO token: %v", err)
+	}
+	assert.True(t, authed, "SPNEGO token not authenticated")
+	assert.Contains(t, buf.String(), "SPNEGO authentication succeeded (mech: 1.2.840.113554.1.2.2, ticket etype: 18, session key etype: 18)", "Authenticate success log line does not include the mech and etypes")
+}
+
+func TestService_SPNEGOKRB_Diagnostics(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.Logger(l), service.Diagnostics(true)))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, "Negotiate not*base64")
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to an invalid header not as expected")
+	assert.Contains(t, buf.String(), `diagnosis: step="base64"`, "diagnosis of an invalid header not logged")
+
+	r, _ = http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, "Negotiate "+base64.StdEncoding.EncodeToString([]byte("NTLMSSP")))
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Contains(t, buf.String(), `diagnosis: step="neg-token parse"`, "diagnosis of an unparsable token not logged")
+}
+
+func TestService_SPNEGOKRB_TicketAuthTime(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var authTime time.Time
+	var found bool
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authTime, found = TicketAuthTime(r.Context())
+	})
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(th, kt))
+	defer s.Close()
+
+	st := time.Now().UTC()
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+	assert.True(t, found, "ticket authtime not found in the request context")
+	assert.WithinDuration(t, st, authTime, time.Minute, "ticket authtime not as expected")
+	_, found = TicketAuthTime(context.Background())
+	assert.False(t, found, "ticket authtime should not be found for a context without an identity")
+}
+
+func TestService_SPNEGOKRB_TicketRenewTill(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var renewTill time.Time
+	var found bool
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renewTill, found = TicketRenewTill(r.Context())
+	})
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(th, kt))
+	defer s.Close()
+
+	st := time.Now().UTC()
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+	assert.True(t, found, "ticket renew-till not found in the request context")
+	assert.WithinDuration(t, st.Add(time.Hour*48), renewTill, time.Minute, "ticket renew-till not as expected")
+	_, found = TicketRenewTill(context.Background())
+	assert.False(t, found, "ticket renew-till should not be found for a context without an identity")
+}
+
+func TestService_SPNEGOKRB_MechListMIC(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer s.Close()
+
+	var tests = []struct {
+		name   string
+		tamper func(nt *NegTokenInit, key types.EncryptionKey)
+		status int
+	}{
+		{"valid", func(nt *NegTokenInit, key types.EncryptionKey) {}, http.StatusOK},
+		{"mechanism list tampered", func(nt *NegTokenInit, key types.EncryptionKey) {
+			nt.MechTypes = append(nt.MechTypes, gssapi.OIDMSLegacyKRB5.OID())
+		}, http.StatusUnauthorized},
+		{"wrong key", func(nt *NegTokenInit, key types.EncryptionKey) {
+			nt.SetMechListMIC(types.EncryptionKey{KeyType: key.KeyType, KeyValue: make([]byte, len(key.KeyValue))})
+		}, http.StatusUnauthorized},
+		{"malformed", func(nt *NegTokenInit, key types.EncryptionKey) {
+			nt.MechListMIC = []byte{0x04, 0x04}
+		}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		nt, key := offlineNegTokenInitWithKey(t, types.NewKrbFlags())
+		err := nt.SetMechListMIC(key)
+		if err != nil {
+			t.Fatalf("error setting mechListMIC: %v", err)
+		}
+		test.tamper(&nt, key)
+		spt := SPNEGOToken{Init: true, NegTokenInit: nt}
+		nb, err := spt.Marshal()
+		if err != nil {
+			t.Fatalf("error marshaling SPNEGO token: %v", err)
+		}
+		r, _ := http.NewRequest("GET", s.URL, nil)
+		r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+		httpResp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Request error: %v\n", err)
+		}
+		httpResp.Body.Close()
+		assert.Equal(t, test.status, httpResp.StatusCode, "Status code for %s mechListMIC not as expected", test.name)
+	}
+}
+
+func TestService_SPNEGOKRB_DynamicNegTokenResp(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	static := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer static.Close()
+	dynamic := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.DynamicNegTokenResp(true)))
+	defer dynamic.Close()
+
+	tkt, sessionKey := offlineTicket(t, types.NewKrbFlags())
+	mt, err := NewKRB5TokenAPREQ(getClient(), tkt, sessionKey, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		t.Fatalf("error creating KRB5 token: %v", err)
+	}
+	ab, err := mt.APReq.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REQ: %v", err)
+	}
+	nt, err := NewNegTokenInitKRB5APReq(ab)
+	if err != nil {
+		t.Fatalf("error creating NegTokenInit: %v", err)
+	}
+	err = nt.SetMechListMIC(sessionKey)
+	if err != nil {
+		t.Fatalf("error setting mechListMIC: %v", err)
+	}
+	spt := SPNEGOToken{Init: true, NegTokenInit: nt}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	hv := HTTPHeaderAuthResponseValueKey + " " + base64.StdEncoding.EncodeToString(nb)
+
+	// By default the static token is returned
+	r, _ := http.NewRequest("GET", static.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, hv)
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code not as expected")
+	assert.Equal(t, spnegoNegTokenRespKRBAcceptCompleted, httpResp.Header.Get(HTTPHeaderAuthResponse), "static NegTokenResp not returned")
+
+	// A new AP_REQ is needed as the first is now in the replay cache
+	mt, _ = NewKRB5TokenAPREQ(getClient(), tkt, sessionKey, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}, []int{flags.APOptionMutualRequired})
+	ab, _ = mt.APReq.Marshal()
+	nt, _ = NewNegTokenInitKRB5APReq(ab)
+	nt.SetMechListMIC(sessionKey)
+	spt = SPNEGOToken{Init: true, NegTokenInit: nt}
+	nb, _ = spt.Marshal()
+	r, _ = http.NewRequest("GET", dynamic.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code not as expected")
+	s := strings.SplitN(httpResp.Header.Get(HTTPHeaderAuthResponse), " ", 2)
+	if len(s) != 2 {
+		t.Fatalf("response header not as expected: %s", httpResp.Header.Get(HTTPHeaderAuthResponse))
+	}
+	rb, err := base64.StdEncoding.DecodeString(s[1])
+	if err != nil {
+		t.Fatalf("error decoding response header: %v", err)
+	}
+	var resp NegTokenResp
+	err = resp.Unmarshal(rb)
+	if err != nil {
+		t.Fatalf("error unmarshaling NegTokenResp: %v", err)
+	}
+	assert.Equal(t, NegStateAcceptCompleted, resp.State(), "negotiation state not as expected")
+	assert.True(t, resp.SupportedMech.Equal(gssapi.OIDKRB5.OID()), "supported mechanism not as expected")
+
+	var k5t KRB5Token
+	err = k5t.Unmarshal(resp.ResponseToken)
+	if err != nil {
+		t.Fatalf("error unmarshaling response token: %v", err)
+	}
+	assert.True(t, k5t.IsAPRep(), "response token should hold an AP_REP")
+	db, err := crypto.DecryptEncPart(k5t.APRep.EncPart, sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		t.Fatalf("error decrypting AP_REP: %v", err)
+	}
+	var ep messages.EncAPRepPart
+	err = ep.Unmarshal(db)
+	if err != nil {
+		t.Fatalf("error unmarshaling AP_REP encrypted part: %v", err)
+	}
+	err = mt.APReq.DecryptAuthenticator(sessionKey)
+	if err != nil {
+		t.Fatalf("error decrypting authenticator: %v", err)
+	}
+	assert.True(t, mt.APReq.Authenticator.CTime.Equal(ep.CTime), "AP_REP CTime not that of the authenticator")
+	assert.Equal(t, mt.APReq.Authenticator.Cusec, ep.Cusec, "AP_REP Cusec not that of the authenticator")
+
+	var mic gssapi.MICToken
+	err = mic.Unmarshal(resp.MechListMIC, true)
+	if err != nil {
+		t.Fatalf("error unmarshaling mechListMIC: %v", err)
+	}
+	mic.Payload, _ = asn1.Marshal(nt.MechTypes)
+	ok, err := mic.Verify(sessionKey, keyusage.GSSAPI_ACCEPTOR_SIGN)
+	assert.True(t, ok, "acceptor mechListMIC not valid: %v", err)
+}
+
+func TestService_SPNEGOKRB_RequireMutualAuth(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.RequireMutualAuth(true)))
+	defer s.Close()
+
+	tkt, sessionKey := offlineTicket(t, types.NewKrbFlags())
+	mt, err := NewKRB5TokenAPREQ(getClient(), tkt, sessionKey, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagMutual}, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		t.Fatalf("error creating KRB5 token: %v", err)
+	}
+	ab, err := mt.APReq.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling AP_REQ: %v", err)
+	}
+	nt, err := NewNegTokenInitKRB5APReq(ab)
+	if err != nil {
+		t.Fatalf("error creating NegTokenInit: %v", err)
+	}
+	spt := SPNEGOToken{Init: true, NegTokenInit: nt}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code not as expected")
+	hv := strings.SplitN(httpResp.Header.Get(HTTPHeaderAuthResponse), " ", 2)
+	if len(hv) != 2 {
+		t.Fatalf("response header not as expected: %s", httpResp.Header.Get(HTTPHeaderAuthResponse))
+	}
+	assert.NotEqual(t, spnegoNegTokenRespKRBAcceptCompleted, httpResp.Header.Get(HTTPHeaderAuthResponse), "static NegTokenResp returned when mutual authentication is required")
+	rb, err := base64.StdEncoding.DecodeString(hv[1])
+	if err != nil {
+		t.Fatalf("error decoding response header: %v", err)
+	}
+	var resp NegTokenResp
+	err = resp.Unmarshal(rb)
+	if err != nil {
+		t.Fatalf("error unmarshaling NegTokenResp: %v", err)
+	}
+	var k5t KRB5Token
+	err = k5t.Unmarshal(resp.ResponseToken)
+	if err != nil {
+		t.Fatalf("error unmarshaling response token: %v", err)
+	}
+	assert.True(t, k5t.IsAPRep(), "response token should hold an AP_REP")
+}
+
+func TestService_SPNEGOKRB_InnerPanic(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("inner handler failure")
+	})
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(th, kt, service.Logger(l)))
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		r, _ := http.NewRequest("GET", s.URL, nil)
+		setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+		httpResp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("Request error: %v\n", err)
+		}
+		httpResp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, httpResp.StatusCode, "Status code in response when the inner handler panics not as expected")
+	}
+	assert.Contains(t, buf.String(), "SPNEGO wrapped handler panicked: inner handler failure", "panic not logged")
+}
+
+func TestService_SPNEGOKRB_InnerPanicAfterWrite(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var buf, srvBuf bytes.Buffer
+	l := log.New(&buf, "", 0)
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "partial")
+		panic("inner handler failure")
+	})
+	s := httptest.NewUnstartedServer(SPNEGOKRB5Authenticate(th, kt, service.Logger(l)))
+	s.Config.ErrorLog = log.New(&srvBuf, "", 0)
+	s.Start()
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, httpResp.StatusCode, "Status code written by the inner handler before panicking not as expected")
+	assert.Contains(t, buf.String(), "SPNEGO wrapped handler panicked after writing the response: inner handler failure", "panic not logged")
+	assert.NotContains(t, srvBuf.String(), "superfluous", "internal server error should not be written after the response was started")
+}
+
+func TestService_SPNEGOKRB_InquireContext(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	var attrs gssapi.ContextAttributes
+	var found bool
+	th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attrs, found = InquireContext(r.Context())
+	})
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(th, kt))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+	if !found {
+		t.Fatal("context attributes not found in the request context")
+	}
+	assert.Equal(t, "testuser1@TEST.GOKRB5", attrs.InitiatorName, "initiator name not as expected")
+	assert.Equal(t, "HTTP/host.test.gokrb5@TEST.GOKRB5", attrs.TargetName, "target name not as expected")
+	assert.True(t, attrs.Mech.Equal(gssapi.OIDKRB5.OID()), "mech not as expected")
+	assert.True(t, attrs.IsFlagSet(gssapi.ContextFlagInteg), "integrity flag not set")
+	assert.True(t, attrs.IsFlagSet(gssapi.ContextFlagConf), "confidentiality flag not set")
+	assert.False(t, attrs.IsFlagSet(gssapi.ContextFlagDeleg), "delegation flag should not be set")
+	assert.True(t, attrs.Open, "context should be open")
+	assert.True(t, attrs.IntegAvail, "integrity should be available")
+	assert.True(t, attrs.ConfAvail, "confidentiality should be available")
+	assert.False(t, attrs.LocallyInitiated, "context should not be locally initiated on the acceptor")
+	assert.True(t, attrs.Lifetime() > time.Duration(23)*time.Hour, "context lifetime not as expected")
+
+	_, found = InquireContext(context.Background())
+	assert.False(t, found, "context attributes should not be found in a context without an identity")
+}
+
+func TestNegTokenInit_Verify_AcceptedMech(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	for _, mech := range []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID(), gssapi.OIDMSLegacyKRB5.OID()} {
+		nt := offlineNegTokenInit(t, types.NewKrbFlags())
+		nt.MechTypes = []asn1.ObjectIdentifier{mech}
+		nt.MechListMIC = nil
+		nt.settings = service.NewSettings(kt)
+		ok, status := nt.Verify()
+		if !ok {
+			t.Fatalf("NegTokenInit for mech %s not valid: %s", mech.String(), status.Message)
+		}
+		attrs, found := InquireContext(nt.Context())
+		if !found {
+			t.Fatal("context attributes not found in the context")
+		}
+		assert.True(t, attrs.Mech.Equal(mech), "accepted mech %s not as expected", attrs.Mech.String())
+	}
+}
+
+func TestService_SPNEGOKRB_MultiLeg(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt))
+	defer s.Close()
+
+	nt := offlineNegTokenInit(t, types.NewKrbFlags())
+	// First leg prefers NTLM and sends an optimistic token for it
+	ntlm := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+	spt := SPNEGOToken{
+		Init: true,
+		NegTokenInit: NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{ntlm, gssapi.OIDKRB5.OID()},
+			MechTokenBytes: []byte("NTLMSSP"),
+		},
+	}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to first leg not as expected")
+	assert.Equal(t, spnegoNegTokenRespIncompleteKRB5, httpResp.Header.Get(HTTPHeaderAuthResponse), "continuation token not as expected")
+
+	// Second leg is a NegTokenResp carrying the KRB5 token without the supported mechanism
+	spt = SPNEGOToken{
+		Resp: true,
+		NegTokenResp: NegTokenResp{
+			NegState:      asn1.Enumerated(NegStateAcceptIncomplete),
+			ResponseToken: nt.MechTokenBytes,
+		},
+	}
+	nb, err = spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r, _ = http.NewRequest("GET", s.URL, nil)
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to second leg not as expected")
+	assert.Equal(t, spnegoNegTokenRespKRBAcceptCompleted, httpResp.Header.Get(HTTPHeaderAuthResponse), "accept completed token not as expected")
+}
+
 func TestService_SPNEGOKRB_Replay(t *testing.T) {
 	test.Integration(t)
 
@@ -242,6 +931,97 @@ func TestService_SPNEGOKRB_Replay(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to client with no SPNEGO not as expected. Expected a replay to be detected.")
 }
 
+func TestService_SPNEGOKRB_ChallengeAuthFailures(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.ChallengeAuthFailures(true)))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+
+	// A replay is an authentication failure so the client is challenged to retry with a fresh ticket
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to replay not as expected")
+	assert.Equal(t, HTTPHeaderAuthResponseValueKey, httpResp.Header.Get(HTTPHeaderAuthResponse), "replay should be challenged")
+
+	// A token for an unsupported mechanism is a protocol error so the client is sent the reject token
+	spt := SPNEGOToken{
+		Init: true,
+		NegTokenInit: NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}},
+			MechTokenBytes: []byte{1, 2, 3},
+		},
+	}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to protocol error not as expected")
+	assert.Equal(t, spnegoNegTokenRespReject, httpResp.Header.Get(HTTPHeaderAuthResponse), "protocol error should be rejected")
+}
+
+func TestService_SPNEGOKRB_AdditionalAuthSchemes(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	schemes := []string{`Basic realm="test"`, "Bearer"}
+	s := httptest.NewServer(SPNEGOKRB5Authenticate(http.HandlerFunc(testAppHandler), kt, service.AdditionalAuthSchemes(schemes...)))
+	defer s.Close()
+
+	r, _ := http.NewRequest("GET", s.URL, nil)
+	httpResp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to client with no SPNEGO not as expected")
+	assert.Equal(t, append([]string{HTTPHeaderAuthResponseValueKey}, schemes...), httpResp.Header.Values(HTTPHeaderAuthResponse), "challenges not as expected")
+
+	// The further schemes are not offered on success, but are when the replayed token is rejected
+	setOfflineSPNEGOHeader(t, r, types.NewKrbFlags())
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusOK, httpResp.StatusCode, "Status code in response to client SPNEGO request not as expected")
+	assert.Equal(t, []string{spnegoNegTokenRespKRBAcceptCompleted}, httpResp.Header.Values(HTTPHeaderAuthResponse), "further schemes should not be offered on success")
+	httpResp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("Request error: %v\n", err)
+	}
+	httpResp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, httpResp.StatusCode, "Status code in response to replay not as expected")
+	assert.Equal(t, append([]string{spnegoNegTokenRespReject}, schemes...), httpResp.Header.Values(HTTPHeaderAuthResponse), "challenges not as expected")
+
+	// Authenticate also offers the further schemes
+	r, _ = http.NewRequest("GET", "http://host.test.gokrb5/", nil)
+	w := httptest.NewRecorder()
+	_, _, err = Authenticate(kt, w, r, service.AdditionalAuthSchemes(schemes...))
+	assert.Equal(t, ErrNoAuthorizationHeader, err, "error not as expected")
+	assert.Equal(t, append([]string{HTTPHeaderAuthResponseValueKey}, schemes...), w.Header().Values(HTTPHeaderAuthResponse), "challenges not as expected")
+}
+
 func TestService_SPNEGOKRB_ReplayCache_Concurrency(t *testing.T) {
 	test.Integration(t)
 
@@ -400,6 +1180,61 @@ func getClient() *client.Client {
 	return cl
 }
 
+// setOfflineSPNEGOHeader sets an SPNEGO header on the request using a ticket for HTTP/host.test.gokrb5 generated
+// locally from the service's keytab so that a KDC is not required.
+func setOfflineSPNEGOHeader(t *testing.T, r *http.Request, f asn1.BitString) {
+	spt := SPNEGOToken{
+		Init:         true,
+		NegTokenInit: offlineNegTokenInit(t, f),
+	}
+	nb, err := spt.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling SPNEGO token: %v", err)
+	}
+	r.Header.Set(HTTPHeaderAuthRequest, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(nb))
+}
+
+// offlineNegTokenInit creates a NegTokenInit for HTTP/host.test.gokrb5 from a ticket created without a KDC.
+func offlineNegTokenInit(t *testing.T, f asn1.BitString) NegTokenInit {
+	nt, _ := offlineNegTokenInitWithKey(t, f)
+	return nt
+}
+
+// offlineNegTokenInitWithKey creates a NegTokenInit as offlineNegTokenInit and also returns the session key of the ticket.
+func offlineNegTokenInitWithKey(t *testing.T, f asn1.BitString) (NegTokenInit, types.EncryptionKey) {
+	cl := getClient()
+	tkt, sessionKey := offlineTicket(t, f)
+	nt, err := NewNegTokenInitKRB5(cl, tkt, sessionKey)
+	if err != nil {
+		t.Fatalf("error creating NegTokenInit: %v", err)
+	}
+	return nt, sessionKey
+}
+
+func offlineTicket(t *testing.T, f asn1.BitString) (messages.Ticket, types.EncryptionKey) {
+	cl := getClient()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	st := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+		sname, "TEST.GOKRB5",
+		f,
+		kt,
+		18,
+		1,
+		st,
+		st,
+		st.Add(time.Duration(24)*time.Hour),
+		st.Add(time.Duration(48)*time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("error getting test ticket: %v", err)
+	}
+	return tkt, sessionKey
+}
+
 type SessionMgr struct {
 	skey       []byte
 	store      sessions.Store
@@ -2,6 +2,7 @@ package spnego
 
 import (
 	"context"
+	"crypto/hmac"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -13,6 +14,8 @@ import (
 	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/gssapi"
 	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/krberror"
 	"github.com/jcmturner/gokrb5/v8/messages"
@@ -36,6 +39,9 @@ type KRB5Token struct {
 	KRBError messages.KRBError
 	settings *service.Settings
 	context  context.Context
+	// mech is the mechanism OID negotiated for the token, which may be Microsoft's legacy KRB5 OID rather than the
+	// KRB5 OID the token itself carries.
+	mech asn1.ObjectIdentifier
 }
 
 // Marshal a KRB5Token into a slice of bytes.
@@ -52,7 +58,10 @@ func (m *KRB5Token) Marshal() ([]byte, error) {
 			return []byte{}, fmt.Errorf("error marshalling AP_REQ for MechToken: %v", err)
 		}
 	case TOK_ID_KRB_AP_REP:
-		return []byte{}, errors.New("marshal of AP_REP GSSAPI MechToken not supported by gokrb5")
+		tb, err = m.APRep.Marshal()
+		if err != nil {
+			return []byte{}, fmt.Errorf("error marshalling AP_REP for MechToken: %v", err)
+		}
 	case TOK_ID_KRB_ERROR:
 		return []byte{}, errors.New("marshal of KRB_ERROR GSSAPI MechToken not supported by gokrb5")
 	}
@@ -65,6 +74,9 @@ func (m *KRB5Token) Marshal() ([]byte, error) {
 
 // Unmarshal a KRB5Token.
 func (m *KRB5Token) Unmarshal(b []byte) error {
+	if len(b) < 1 {
+		return errors.New("KRB5Token is empty")
+	}
 	var oid asn1.ObjectIdentifier
 	r, err := asn1.UnmarshalWithParams(b, &oid, fmt.Sprintf("application,explicit,tag:%v", 0))
 	if err != nil {
@@ -77,6 +89,9 @@ func (m *KRB5Token) Unmarshal(b []byte) error {
 	if len(r) < 2 {
 		return fmt.Errorf("krb5token too short")
 	}
+	if len(r) < 3 {
+		return fmt.Errorf("krb5token contains no kerberos message")
+	}
 	m.tokID = r[0:2]
 	switch hex.EncodeToString(m.tokID) {
 	case TOK_ID_KRB_AP_REQ:
@@ -100,21 +115,48 @@ func (m *KRB5Token) Unmarshal(b []byte) error {
 			return fmt.Errorf("error unmarshalling KRB5Token KRBError: %v", err)
 		}
 		m.KRBError = a
+	default:
+		return fmt.Errorf("error unmarshalling KRB5Token, unknown TOK_ID %x", m.tokID)
 	}
 	return nil
 }
 
+// apReqStatusCode returns the GSS-API status code for the error from verifying an AP_REQ.
+// Failures of the client's ticket or authenticator are distinguished from a defective token so that the client can
+// be told it may retry with a fresh ticket.
+func apReqStatusCode(err error) int {
+	krberr, ok := err.(messages.KRBError)
+	if !ok {
+		return gssapi.StatusDefectiveToken
+	}
+	switch krberr.ErrorCode {
+	case errorcode.KRB_AP_ERR_TKT_EXPIRED, errorcode.KRB_AP_ERR_TKT_NYV:
+		return gssapi.StatusCredentialsExpired
+	case errorcode.KRB_AP_ERR_REPEAT:
+		return gssapi.StatusDuplicateToken
+	case errorcode.KRB_AP_ERR_SKEW, errorcode.KRB_AP_ERR_NOKEY, errorcode.KRB_AP_ERR_BADKEYVER,
+		errorcode.KRB_AP_ERR_MODIFIED, errorcode.KRB_AP_ERR_BAD_INTEGRITY:
+		return gssapi.StatusDefectiveCredential
+	}
+	return gssapi.StatusDefectiveToken
+}
+
 // Verify a KRB5Token.
 func (m *KRB5Token) Verify() (bool, gssapi.Status) {
 	switch hex.EncodeToString(m.tokID) {
 	case TOK_ID_KRB_AP_REQ:
 		ok, creds, err := service.VerifyAPREQ(&m.APReq, m.settings)
 		if err != nil {
-			return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: err.Error()}
+			code := apReqStatusCode(err)
+			if m.missingChannelBindings(err) {
+				code = gssapi.StatusBadBindings
+			}
+			return false, gssapi.Status{Code: code, Message: err.Error()}
 		}
 		if !ok {
 			return false, gssapi.Status{Code: gssapi.StatusDefectiveCredential, Message: "KRB5_AP_REQ token not valid"}
 		}
+		creds.SetAttribute(credentials.AttributeKeyGSSContextAttributes, m.contextAttributes(creds))
 		m.context = context.Background()
 		m.context = context.WithValue(m.context, ctxCredentials, creds)
 		return true, gssapi.Status{Code: gssapi.StatusComplete}
@@ -131,6 +173,69 @@ func (m *KRB5Token) Verify() (bool, gssapi.Status) {
 	return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "unknown TOK_ID in KRB5 token"}
 }
 
+// contextAttributes returns the attributes of the security context established by the verified AP_REQ.
+func (m *KRB5Token) contextAttributes(creds *credentials.Credentials) gssapi.ContextAttributes {
+	a := gssapi.ContextAttributes{
+		InitiatorName: fmt.Sprintf("%s@%s", creds.CName().PrincipalNameString(), creds.Realm()),
+		TargetName:    fmt.Sprintf("%s@%s", m.APReq.Ticket.SName.PrincipalNameString(), m.APReq.Ticket.Realm),
+		Expiry:        m.APReq.Ticket.DecryptedEncPart.EndTime,
+		Mech:          m.acceptedMech(),
+		Open:          true,
+	}
+	// RFC 4121 the Kerberos mechanism provides integrity and confidentiality with the context key regardless of the
+	// flags the initiator requested
+	if len(m.contextKey().KeyValue) > 0 {
+		a.IntegAvail = true
+		a.ConfAvail = true
+	}
+	// RFC 4121 Section 4.1.1 the flags are in octets 20 to 23 of the GSS checksum
+	if m.APReq.Authenticator.Cksum.CksumType == chksumtype.GSSAPI && len(m.APReq.Authenticator.Cksum.Checksum) >= 24 {
+		a.Flags = binary.LittleEndian.Uint32(m.APReq.Authenticator.Cksum.Checksum[20:24])
+	}
+	if types.IsFlagSet(&m.APReq.APOptions, flags.APOptionMutualRequired) {
+		a.Flags |= uint32(gssapi.ContextFlagMutual)
+	}
+	return a
+}
+
+// acceptedMech returns the mechanism OID accepted for the token. This is the mechanism negotiated with SPNEGO if the
+// token was received in an SPNEGO negotiation, otherwise the OID of the token.
+func (m *KRB5Token) acceptedMech() asn1.ObjectIdentifier {
+	if len(m.mech) > 0 {
+		return m.mech
+	}
+	return m.OID
+}
+
+// contextKey returns the key of the security context established by the verified AP_REQ. This is the authenticator's
+// subkey, if present, otherwise the session key of the ticket.
+func (m *KRB5Token) contextKey() types.EncryptionKey {
+	if len(m.APReq.Authenticator.SubKey.KeyValue) > 0 {
+		return m.APReq.Authenticator.SubKey
+	}
+	return m.APReq.Ticket.DecryptedEncPart.Key
+}
+
+// missingChannelBindings indicates if the error is the service's policy rejecting the AP_REQ, once its authenticator
+// has been decrypted, without the channel bindings it requires.
+func (m *KRB5Token) missingChannelBindings(err error) bool {
+	krberr, ok := err.(messages.KRBError)
+	if !ok || krberr.ErrorCode != errorcode.KRB_AP_ERR_METHOD {
+		return false
+	}
+	cb := m.settings.ChannelBindings()
+	return cb != nil && m.APReq.Authenticator.CRealm != "" && !m.hasChannelBindings(*cb)
+}
+
+// hasChannelBindings indicates if the authenticator checksum carries the hash of the channel bindings provided.
+func (m *KRB5Token) hasChannelBindings(cb gssapi.ChannelBindings) bool {
+	// RFC 4121 Section 4.1.1 the channel bindings hash is in octets 4 to 19 of the GSS checksum
+	if m.APReq.Authenticator.Cksum.CksumType != chksumtype.GSSAPI || len(m.APReq.Authenticator.Cksum.Checksum) < 24 {
+		return false
+	}
+	return hmac.Equal(m.APReq.Authenticator.Cksum.Checksum[4:20], cb.Hash())
+}
+
 // IsAPReq tests if the MechToken contains an AP_REQ.
 func (m *KRB5Token) IsAPReq() bool {
 	if hex.EncodeToString(m.tokID) == TOK_ID_KRB_AP_REQ {
@@ -160,7 +265,16 @@ func (m *KRB5Token) Context() context.Context {
 	return m.context
 }
 
+// Delete zeroizes the key material held by the KRB5 token, namely the ticket's session key and the authenticator's
+// subkey, once the security context is no longer required.
+func (m *KRB5Token) Delete() {
+	m.APReq.Ticket.DecryptedEncPart.Key.Zeroize()
+	m.APReq.Authenticator.SubKey.Zeroize()
+}
+
 // NewKRB5TokenAPREQ creates a new KRB5 token with AP_REQ
+// No credentials are delegated with the token so a ContextFlagDeleg GSS-API flag requested is not set in the
+// authenticator checksum.
 func NewKRB5TokenAPREQ(cl *client.Client, tkt messages.Ticket, sessionKey types.EncryptionKey, GSSAPIFlags []int, APOptions []int) (KRB5Token, error) {
 	// TODO consider providing the SPN rather than the specific tkt and key and get these from the krb client.
 	var m KRB5Token
@@ -176,13 +290,11 @@ func NewKRB5TokenAPREQ(cl *client.Client, tkt messages.Ticket, sessionKey types.
 		tkt,
 		sessionKey,
 		auth,
+		APOptions...,
 	)
 	if err != nil {
 		return m, err
 	}
-	for _, o := range APOptions {
-		types.SetFlag(&APReq.APOptions, o)
-	}
 	m.APReq = APReq
 	return m, nil
 }
@@ -194,25 +306,14 @@ func krb5TokenAuthenticator(creds *credentials.Credentials, flags []int) (types.
 	if err != nil {
 		return auth, krberror.Errorf(err, krberror.KRBMsgError, "error generating new authenticator")
 	}
-	auth.Cksum = types.Checksum{
-		CksumType: chksumtype.GSSAPI,
-		Checksum:  newAuthenticatorChksum(flags),
-	}
-	return auth, nil
-}
-
-// Create new authenticator checksum for kerberos MechToken
-func newAuthenticatorChksum(flags []int) []byte {
-	a := make([]byte, 24)
-	binary.LittleEndian.PutUint32(a[:4], 16)
+	var f gssapi.GSSFlags
 	for _, i := range flags {
-		if i == gssapi.ContextFlagDeleg {
-			x := make([]byte, 28-len(a))
-			a = append(a, x...)
-		}
-		f := binary.LittleEndian.Uint32(a[20:24])
-		f |= uint32(i)
-		binary.LittleEndian.PutUint32(a[20:24], f)
+		f |= gssapi.GSSFlags(i)
 	}
-	return a
+	// No credentials are delegated with the token so the delegation flag is cleared
+	auth.Cksum, err = gssapi.NewAuthenticatorChecksum(nil, f, nil)
+	if err != nil {
+		return auth, krberror.Errorf(err, krberror.KRBMsgError, "error generating authenticator checksum")
+	}
+	return auth, nil
 }
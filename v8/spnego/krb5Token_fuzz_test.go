@@ -0,0 +1,52 @@
+//go:build go1.18
+// +build go1.18
+
+package spnego
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+func FuzzKRB5Token_Unmarshal(f *testing.F) {
+	b, _ := hex.DecodeString(KRB5TokenHex)
+	f.Add(b)
+	f.Add(fuzzKRB5TokenSeed())
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var mt KRB5Token
+		if err := mt.Unmarshal(b); err != nil {
+			return
+		}
+		mt.settings = service.NewSettings(kt)
+		mt.Verify()
+	})
+}
+
+// fuzzKRB5TokenSeed returns a marshaled KRB5 token with an AP_REQ that the HTTP_KEYTAB can decrypt.
+func fuzzKRB5TokenSeed() []byte {
+	cl := getClient()
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	st := time.Now().UTC()
+	tkt, sessionKey, _ := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+		types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5"), "TEST.GOKRB5",
+		types.NewKrbFlags(), kt, 18, 1,
+		st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour),
+	)
+	mt, _ := NewKRB5TokenAPREQ(cl, tkt, sessionKey, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf}, []int{})
+	b, _ := mt.Marshal()
+	return b
+}
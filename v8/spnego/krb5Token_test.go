@@ -2,16 +2,23 @@ package spnego
 
 import (
 	"encoding/hex"
+	"errors"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/credentials"
 	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/errorcode"
 	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
 	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
@@ -40,14 +47,89 @@ func TestKRB5Token_Unmarshal(t *testing.T) {
 	assert.Equal(t, int32(18), mt.APReq.EncryptedAuthenticator.EType, "Authenticator within AP_REQ does not have the etype expected.")
 }
 
-func TestKRB5Token_newAuthenticatorChksum(t *testing.T) {
+func TestKRB5Token_Unmarshal_Malformed(t *testing.T) {
+	t.Parallel()
+	b, err := hex.DecodeString(KRB5TokenHex)
+	if err != nil {
+		t.Fatalf("Error decoding KRB5Token hex: %v", err)
+	}
+	oid, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+	var tests = []struct {
+		name string
+		b    []byte
+		err  string
+	}{
+		{"nil", nil, "KRB5Token is empty"},
+		{"empty", []byte{}, "KRB5Token is empty"},
+		{"OID only", asn1tools.AddASNAppTag(oid, 0), "krb5token too short"},
+		{"TOK_ID only", asn1tools.AddASNAppTag(append(oid, 1, 0), 0), "krb5token contains no kerberos message"},
+		{"unknown TOK_ID", asn1tools.AddASNAppTag(append(oid, 9, 9, 0), 0), "unknown TOK_ID 0909"},
+		{"garbage AP_REQ", asn1tools.AddASNAppTag(append(oid, 1, 0, 0xff, 0xff), 0), "error unmarshalling KRB5Token AP_REQ"},
+		{"garbage", []byte{0xde, 0xad, 0xbe, 0xef}, "error unmarshalling KRB5Token OID"},
+	}
+	for _, test := range tests {
+		var mt KRB5Token
+		err := mt.Unmarshal(test.b)
+		if assert.Error(t, err, "%s mech token should not unmarshal", test.name) {
+			assert.Contains(t, err.Error(), test.err, "%s mech token error not as expected", test.name)
+		}
+	}
+	// Every truncation of a valid token must be rejected
+	for i := 0; i < len(b); i++ {
+		var mt KRB5Token
+		assert.Error(t, mt.Unmarshal(b[:i]), "mech token truncated to %d bytes should not unmarshal", i)
+	}
+}
+
+func TestNegTokenInit_Verify_EmptyMechToken(t *testing.T) {
+	t.Parallel()
+	n := NegTokenInit{
+		MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+		MechTokenBytes: []byte{},
+	}
+	ok, status := n.Verify()
+	assert.False(t, ok, "NegTokenInit with an empty mech token should not verify")
+	assert.Equal(t, gssapi.StatusDefectiveToken, status.Code, "status code not as expected")
+	assert.Equal(t, "MechToken is empty", status.Message, "status message not as expected")
+
+	r := NegTokenResp{ResponseToken: []byte{}}
+	ok, status = r.Verify()
+	assert.False(t, ok, "NegTokenResp with an empty response token should not verify")
+	assert.Equal(t, gssapi.StatusDefectiveToken, status.Code, "status code not as expected")
+}
+
+func TestAPReqStatusCode(t *testing.T) {
+	t.Parallel()
+	pn := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	var tests = []struct {
+		err  error
+		code int
+	}{
+		{messages.NewKRBError(pn, "TEST.GOKRB5", errorcode.KRB_AP_ERR_REPEAT, "replay detected"), gssapi.StatusDuplicateToken},
+		{messages.NewKRBError(pn, "TEST.GOKRB5", errorcode.KRB_AP_ERR_TKT_EXPIRED, "ticket expired"), gssapi.StatusCredentialsExpired},
+		{messages.NewKRBError(pn, "TEST.GOKRB5", errorcode.KRB_AP_ERR_SKEW, "clock skew"), gssapi.StatusDefectiveCredential},
+		{messages.NewKRBError(pn, "TEST.GOKRB5", errorcode.KRB_AP_ERR_BADADDR, "bad address"), gssapi.StatusDefectiveToken},
+		{errors.New("not a KRBError"), gssapi.StatusDefectiveToken},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.code, apReqStatusCode(test.err), "status code not as expected for: %v", test.err)
+	}
+}
+
+func TestKRB5Token_krb5TokenAuthenticatorChksum(t *testing.T) {
 	t.Parallel()
 	b, err := hex.DecodeString(AuthChksum)
 	if err != nil {
 		t.Fatalf("Error decoding KRB5Token hex: %v", err)
 	}
-	cb := newAuthenticatorChksum([]int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf})
-	assert.Equal(t, b, cb, "SPNEGO Authenticator checksum not as expected")
+	creds := credentials.New("hftsai", testdata.TEST_REALM)
+	creds.SetCName(types.PrincipalName{NameType: nametype.KRB_NT_PRINCIPAL, NameString: testdata.TEST_PRINCIPALNAME_NAMESTRING})
+	a, err := krb5TokenAuthenticator(creds, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf})
+	if err != nil {
+		t.Fatalf("Error creating authenticator: %v", err)
+	}
+	assert.Equal(t, chksumtype.GSSAPI, a.Cksum.CksumType, "SPNEGO Authenticator checksum type not as expected")
+	assert.Equal(t, b, a.Cksum.Checksum, "SPNEGO Authenticator checksum not as expected")
 }
 
 // Test with explicit subkey generation.
@@ -144,3 +226,173 @@ func TestNewAPREQKRB5Token_and_Marshal(t *testing.T) {
 	assert.Equal(t, testdata.TEST_PRINCIPALNAME_NAMESTRING, mt.APReq.Ticket.SName.NameString, "SName in ticket within the AP_REQ of the KRB5Token not as expected.")
 	assert.Equal(t, int32(18), mt.APReq.EncryptedAuthenticator.EType, "Authenticator within AP_REQ does not have the etype expected.")
 }
+
+func TestKRB5Token_Delete(t *testing.T) {
+	t.Parallel()
+	var mt KRB5Token
+	mt.APReq.Ticket.DecryptedEncPart.Key = types.EncryptionKey{KeyType: 18, KeyValue: []byte{1, 2, 3, 4}}
+	mt.APReq.Authenticator.SubKey = types.EncryptionKey{KeyType: 18, KeyValue: []byte{5, 6, 7, 8}}
+	st := SPNEGOToken{
+		Init: true,
+		NegTokenInit: NegTokenInit{
+			mechToken: &mt,
+		},
+	}
+	st.Delete()
+	assert.Equal(t, []byte{0, 0, 0, 0}, mt.APReq.Ticket.DecryptedEncPart.Key.KeyValue, "session key not zeroized")
+	assert.Equal(t, []byte{0, 0, 0, 0}, mt.APReq.Authenticator.SubKey.KeyValue, "subkey not zeroized")
+}
+
+func TestKRB5Token_Delete_SessionKeyAttribute(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+	st := time.Now().UTC()
+	tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+		sname, "TEST.GOKRB5", types.NewKrbFlags(), kt, 18, 1,
+		st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("error getting test ticket: %v", err)
+	}
+	auth, err := krb5TokenAuthenticator(cl.Credentials, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf})
+	if err != nil {
+		t.Fatalf("error creating authenticator: %v", err)
+	}
+	APReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		t.Fatalf("error creating AP_REQ: %v", err)
+	}
+	tb, _ := hex.DecodeString(TOK_ID_KRB_AP_REQ)
+	mt := KRB5Token{
+		OID:      gssapi.OIDKRB5.OID(),
+		tokID:    tb,
+		APReq:    APReq,
+		settings: service.NewSettings(kt, service.ContextValues(service.ContextValueSessionKey)),
+	}
+	ok, status := mt.Verify()
+	if !ok {
+		t.Fatalf("token should be valid: %s", status.Message)
+	}
+	creds := mt.Context().Value(ctxCredentials).(*credentials.Credentials)
+	mt.Delete()
+	assert.Equal(t, sessionKey, creds.Attributes()[credentials.AttributeKeySessionKey], "session key attribute should not be zeroized when the token is deleted")
+}
+
+func TestKRB5Token_Verify_ChannelBindings(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	cb := gssapi.ChannelBindings{ApplicationData: []byte("tls-server-end-point:test")}
+
+	newToken := func(bnd []byte, settings ...func(*service.Settings)) KRB5Token {
+		sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+		st := time.Now().UTC()
+		tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+			sname, "TEST.GOKRB5", types.NewKrbFlags(), kt, 18, 1,
+			st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("error getting test ticket: %v", err)
+		}
+		auth, err := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+		if err != nil {
+			t.Fatalf("error creating authenticator: %v", err)
+		}
+		auth.Cksum, err = gssapi.NewAuthenticatorChecksum(bnd, gssapi.ContextFlagInteg|gssapi.ContextFlagConf, nil)
+		if err != nil {
+			t.Fatalf("error creating authenticator checksum: %v", err)
+		}
+		APReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+		if err != nil {
+			t.Fatalf("error creating AP_REQ: %v", err)
+		}
+		tb, _ := hex.DecodeString(TOK_ID_KRB_AP_REQ)
+		return KRB5Token{
+			OID:      gssapi.OIDKRB5.OID(),
+			tokID:    tb,
+			APReq:    APReq,
+			settings: service.NewSettings(kt, settings...),
+		}
+	}
+
+	mt := newToken(cb.Hash(), service.ChannelBindings(cb))
+	ok, status := mt.Verify()
+	assert.True(t, ok, "token with the required channel bindings should be valid: %s", status.Message)
+
+	mt = newToken(nil, service.ChannelBindings(cb))
+	ok, status = mt.Verify()
+	assert.False(t, ok, "token without channel bindings should not be valid when they are required")
+	assert.Equal(t, gssapi.StatusBadBindings, status.Code, "status code not as expected")
+
+	other := gssapi.ChannelBindings{ApplicationData: []byte("tls-server-end-point:other")}
+	mt = newToken(other.Hash(), service.ChannelBindings(cb))
+	ok, _ = mt.Verify()
+	assert.False(t, ok, "token with other channel bindings should not be valid")
+
+	mt = newToken(nil)
+	ok, status = mt.Verify()
+	assert.True(t, ok, "token without channel bindings should be valid when none are required: %s", status.Message)
+}
+
+func TestKRB5Token_Verify_RequireGSSChecksum(t *testing.T) {
+	t.Parallel()
+	cl := getClient()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+
+	newToken := func(cksum *types.Checksum, settings ...func(*service.Settings)) KRB5Token {
+		sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "HTTP/host.test.gokrb5")
+		st := time.Now().UTC()
+		tkt, sessionKey, err := messages.NewTicket(cl.Credentials.CName(), cl.Credentials.Domain(),
+			sname, "TEST.GOKRB5", types.NewKrbFlags(), kt, 18, 1,
+			st, st, st.Add(time.Duration(24)*time.Hour), st.Add(time.Duration(48)*time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("error getting test ticket: %v", err)
+		}
+		auth, err := krb5TokenAuthenticator(cl.Credentials, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf})
+		if err != nil {
+			t.Fatalf("error creating authenticator: %v", err)
+		}
+		if cksum != nil {
+			auth.Cksum = *cksum
+		}
+		APReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+		if err != nil {
+			t.Fatalf("error creating AP_REQ: %v", err)
+		}
+		tb, _ := hex.DecodeString(TOK_ID_KRB_AP_REQ)
+		return KRB5Token{
+			OID:      gssapi.OIDKRB5.OID(),
+			tokID:    tb,
+			APReq:    APReq,
+			settings: service.NewSettings(kt, settings...),
+		}
+	}
+
+	mt := newToken(nil, service.RequireGSSChecksum(true))
+	ok, status := mt.Verify()
+	assert.True(t, ok, "token with a GSS checksum should be valid: %s", status.Message)
+
+	other := &types.Checksum{CksumType: chksumtype.HMAC_SHA1_96_AES256, Checksum: make([]byte, 12)}
+	mt = newToken(other, service.RequireGSSChecksum(true))
+	ok, status = mt.Verify()
+	assert.False(t, ok, "token without a GSS checksum should not be valid when one is required")
+	assert.Equal(t, gssapi.StatusDefectiveToken, status.Code, "status code not as expected")
+
+	short := &types.Checksum{CksumType: chksumtype.GSSAPI, Checksum: make([]byte, 8)}
+	mt = newToken(short, service.RequireGSSChecksum(true))
+	ok, _ = mt.Verify()
+	assert.False(t, ok, "token with a malformed GSS checksum should not be valid when one is required")
+
+	mt = newToken(other)
+	ok, status = mt.Verify()
+	assert.True(t, ok, "token without a GSS checksum should be valid when one is not required: %s", status.Message)
+}
@@ -2,12 +2,15 @@ package spnego
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
 	"github.com/jcmturner/gokrb5/v8/messages"
 	"github.com/jcmturner/gokrb5/v8/service"
 	"github.com/jcmturner/gokrb5/v8/types"
@@ -108,23 +111,21 @@ func (n *NegTokenInit) Unmarshal(b []byte) error {
 // Verify an Init negotiation token
 func (n *NegTokenInit) Verify() (bool, gssapi.Status) {
 	// Check if supported mechanisms are in the MechTypeList
-	var mtSupported bool
-	for _, m := range n.MechTypes {
-		if m.Equal(gssapi.OIDKRB5.OID()) || m.Equal(gssapi.OIDMSLegacyKRB5.OID()) {
-			if n.mechToken == nil && n.MechTokenBytes == nil {
-				return false, gssapi.Status{Code: gssapi.StatusContinueNeeded}
-			}
-			mtSupported = true
-			break
-		}
-	}
-	if !mtSupported {
+	if _, ok := selectKRB5Mech(n.MechTypes); !ok {
 		return false, gssapi.Status{Code: gssapi.StatusBadMech, Message: "no supported mechanism specified in negotiation"}
 	}
+	// Any optimistic mechanism token is for the initiator's preferred mechanism so unless that is a supported KRB5
+	// mechanism another leg is needed for the initiator to send a token for the mechanism selected.
+	if !isKRB5OID(n.MechTypes[0]) || (n.mechToken == nil && n.MechTokenBytes == nil) {
+		return false, gssapi.Status{Code: gssapi.StatusContinueNeeded}
+	}
 	// There should be some mechtoken bytes for a KRB5Token (other mech types are not supported)
 	mt := new(KRB5Token)
 	mt.settings = n.settings
 	if n.mechToken == nil {
+		if len(n.MechTokenBytes) < 1 {
+			return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "MechToken is empty"}
+		}
 		err := mt.Unmarshal(n.MechTokenBytes)
 		if err != nil {
 			return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: err.Error()}
@@ -137,8 +138,58 @@ func (n *NegTokenInit) Verify() (bool, gssapi.Status) {
 			return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "MechToken is not a KRB5 token as expected"}
 		}
 	}
+	// The optimistic token is for the initiator's preferred mechanism, which is the KRB5 mechanism accepted
+	mt.mech = n.MechTypes[0]
 	// Verify the mechtoken
-	return n.mechToken.Verify()
+	ok, status := n.mechToken.Verify()
+	if !ok || len(n.MechListMIC) < 1 {
+		return ok, status
+	}
+	// RFC 4178 Section 5 the mechListMIC protects the mechanism list against tampering
+	if err := n.verifyMechListMIC(mt.contextKey()); err != nil {
+		return false, gssapi.Status{Code: gssapi.StatusBadMIC, Message: err.Error()}
+	}
+	return ok, status
+}
+
+// SetMechListMIC sets the mechListMIC of the initiator's NegTokenInit, which protects the mechanism list from
+// tampering, using the key of the security context being established. This is the authenticator's subkey, if present,
+// otherwise the session key of the service ticket.
+func (n *NegTokenInit) SetMechListMIC(key types.EncryptionKey) error {
+	b, err := asn1.Marshal(n.MechTypes)
+	if err != nil {
+		return fmt.Errorf("error marshalling mechanism list; %v", err)
+	}
+	mic, err := gssapi.NewInitiatorMICToken(b, key)
+	if err != nil {
+		return fmt.Errorf("error creating mechListMIC; %v", err)
+	}
+	mb, err := mic.Marshal()
+	if err != nil {
+		return fmt.Errorf("error marshalling mechListMIC; %v", err)
+	}
+	n.MechListMIC = mb
+	return nil
+}
+
+// verifyMechListMIC verifies the initiator's mechListMIC over the mechanism list with the key provided.
+func (n *NegTokenInit) verifyMechListMIC(key types.EncryptionKey) error {
+	if len(key.KeyValue) < 1 {
+		return errors.New("no security context key to verify the mechListMIC")
+	}
+	var mic gssapi.MICToken
+	err := mic.Unmarshal(n.MechListMIC, false)
+	if err != nil {
+		return fmt.Errorf("mechListMIC not valid: %v", err)
+	}
+	mic.Payload, err = asn1.Marshal(n.MechTypes)
+	if err != nil {
+		return fmt.Errorf("error marshalling mechanism list to verify the mechListMIC: %v", err)
+	}
+	if ok, err := mic.Verify(key, keyusage.GSSAPI_INITIATOR_SIGN); !ok {
+		return fmt.Errorf("mechListMIC not valid, the mechanism list may have been tampered with: %v", err)
+	}
+	return nil
 }
 
 // Context returns the SPNEGO context which will contain any verify user identity information.
@@ -197,13 +248,18 @@ func (n *NegTokenResp) Unmarshal(b []byte) error {
 
 // Verify a Resp/Targ negotiation token
 func (n *NegTokenResp) Verify() (bool, gssapi.Status) {
-	if n.SupportedMech.Equal(gssapi.OIDKRB5.OID()) || n.SupportedMech.Equal(gssapi.OIDMSLegacyKRB5.OID()) {
+	// The supported mechanism is only present in the acceptor's first response so a NegTokenResp from the initiator
+	// for a subsequent leg may not include it, in which case it continues the KRB5 negotiation.
+	if len(n.SupportedMech) == 0 || isKRB5OID(n.SupportedMech) {
 		if n.mechToken == nil && n.ResponseToken == nil {
 			return false, gssapi.Status{Code: gssapi.StatusContinueNeeded}
 		}
 		mt := new(KRB5Token)
 		mt.settings = n.settings
 		if n.mechToken == nil {
+			if len(n.ResponseToken) < 1 {
+				return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "ResponseToken is empty"}
+			}
 			err := mt.Unmarshal(n.ResponseToken)
 			if err != nil {
 				return false, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: err.Error()}
@@ -219,6 +275,10 @@ func (n *NegTokenResp) Verify() (bool, gssapi.Status) {
 		if mt == nil {
 			return false, gssapi.Status{Code: gssapi.StatusContinueNeeded}
 		}
+		if len(n.SupportedMech) > 0 {
+			mt.mech = n.SupportedMech
+		}
+		// Any mechListMIC is not verified as the mechanism list of the initiator's first token is not held between legs.
 		// Verify the mechtoken
 		return mt.Verify()
 	}
@@ -252,6 +312,9 @@ func UnmarshalNegToken(b []byte) (bool, interface{}, error) {
 	if err != nil {
 		return false, nil, fmt.Errorf("error unmarshalling NegotiationToken: %v", err)
 	}
+	if a.Class != asn1.ClassContextSpecific {
+		return false, nil, fmt.Errorf("NegotiationToken has unexpected ASN.1 class %d", a.Class)
+	}
 	switch a.Tag {
 	case 0:
 		var n marshalNegTokenInit
@@ -285,6 +348,91 @@ func UnmarshalNegToken(b []byte) (bool, interface{}, error) {
 
 }
 
+// krb5MechPreference is the order of preference of the Kerberos mechanisms the acceptor supports. The standard KRB5
+// OID is preferred over Microsoft's legacy OID. User-to-user is not supported by the acceptor.
+var krb5MechPreference = []gssapi.OIDName{gssapi.OIDKRB5, gssapi.OIDMSLegacyKRB5}
+
+// selectKRB5Mech returns the most preferred Kerberos mechanism the acceptor supports from those the initiator proposed,
+// so that the selection does not depend on the order in which the initiator listed them.
+func selectKRB5Mech(mechTypes []asn1.ObjectIdentifier) (asn1.ObjectIdentifier, bool) {
+	for _, p := range krb5MechPreference {
+		for _, m := range mechTypes {
+			if m.Equal(p.OID()) {
+				return m, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// newNegTokenRespKRB5Incomplete creates the acceptor's Resp negotiation token continuing the negotiation with the
+// Kerberos mechanism selected from those proposed in the initiator's NegTokenInit.
+func newNegTokenRespKRB5Incomplete(n NegTokenInit) NegTokenResp {
+	mech, ok := selectKRB5Mech(n.MechTypes)
+	if !ok {
+		mech = gssapi.OIDKRB5.OID()
+	}
+	return NegTokenResp{
+		NegState:      asn1.Enumerated(NegStateAcceptIncomplete),
+		SupportedMech: mech,
+	}
+}
+
+// newNegTokenRespKRB5AcceptCompleted creates the acceptor's Resp negotiation token completing the negotiation of the
+// verified SPNEGO token provided. An AP_REP is included as the response token if the client requested mutual
+// authentication and a mechListMIC is included if the client's NegTokenInit included one.
+func newNegTokenRespKRB5AcceptCompleted(st *SPNEGOToken) (NegTokenResp, error) {
+	n := NegTokenResp{
+		NegState:      asn1.Enumerated(NegStateAcceptCompleted),
+		SupportedMech: gssapi.OIDKRB5.OID(),
+	}
+	mechToken := st.NegTokenResp.mechToken
+	if st.Init {
+		mechToken = st.NegTokenInit.mechToken
+		// Respond with the OID the client used to identify the KRB5 mechanism
+		n.SupportedMech = st.NegTokenInit.MechTypes[0]
+	}
+	mt, ok := mechToken.(*KRB5Token)
+	if !ok || !mt.IsAPReq() {
+		return n, errors.New("SPNEGO token does not hold a verified KRB5 AP_REQ")
+	}
+	if types.IsFlagSet(&mt.APReq.APOptions, flags.APOptionMutualRequired) {
+		apRep, err := messages.NewAPRep(mt.APReq.Ticket.DecryptedEncPart.Key, mt.APReq.Authenticator)
+		if err != nil {
+			return n, fmt.Errorf("error creating AP_REP: %v", err)
+		}
+		tb, _ := hex.DecodeString(TOK_ID_KRB_AP_REP)
+		k := KRB5Token{
+			OID:   gssapi.OIDKRB5.OID(),
+			tokID: tb,
+			APRep: apRep,
+		}
+		n.ResponseToken, err = k.Marshal()
+		if err != nil {
+			return n, err
+		}
+	}
+	if st.Init && len(st.NegTokenInit.MechListMIC) > 0 {
+		b, err := asn1.Marshal(st.NegTokenInit.MechTypes)
+		if err != nil {
+			return n, fmt.Errorf("error marshalling mechanism list; %v", err)
+		}
+		mic := gssapi.MICToken{
+			Flags:   gssapi.MICTokenFlagSentByAcceptor,
+			Payload: b,
+		}
+		err = mic.SetChecksum(mt.contextKey(), keyusage.GSSAPI_ACCEPTOR_SIGN)
+		if err != nil {
+			return n, fmt.Errorf("error creating mechListMIC; %v", err)
+		}
+		n.MechListMIC, err = mic.Marshal()
+		if err != nil {
+			return n, fmt.Errorf("error marshalling mechListMIC; %v", err)
+		}
+	}
+	return n, nil
+}
+
 // NewNegTokenInitKRB5 creates new Init negotiation token for Kerberos 5
 func NewNegTokenInitKRB5(cl *client.Client, tkt messages.Ticket, sessionKey types.EncryptionKey) (NegTokenInit, error) {
 	mt, err := NewKRB5TokenAPREQ(cl, tkt, sessionKey, []int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf}, []int{})
@@ -300,3 +448,28 @@ func NewNegTokenInitKRB5(cl *client.Client, tkt messages.Ticket, sessionKey type
 		MechTokenBytes: mtb,
 	}, nil
 }
+
+// NewNegTokenInitKRB5APReq creates a new Init negotiation token for Kerberos 5 carrying the marshaled AP_REQ provided.
+// This allows a caller that has formed its own AP_REQ to construct the token the acceptor expects. To be sent in the
+// Authorization header the NegTokenInit should be placed in an SPNEGOToken and marshaled.
+func NewNegTokenInitKRB5APReq(apReqBytes []byte) (NegTokenInit, error) {
+	var apReq messages.APReq
+	err := apReq.Unmarshal(apReqBytes)
+	if err != nil {
+		return NegTokenInit{}, fmt.Errorf("error unmarshalling AP_REQ; %v", err)
+	}
+	tb, _ := hex.DecodeString(TOK_ID_KRB_AP_REQ)
+	mt := KRB5Token{
+		OID:   gssapi.OIDKRB5.OID(),
+		tokID: tb,
+		APReq: apReq,
+	}
+	mtb, err := mt.Marshal()
+	if err != nil {
+		return NegTokenInit{}, fmt.Errorf("error marshalling KRB5 token; %v", err)
+	}
+	return NegTokenInit{
+		MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+		MechTokenBytes: mtb,
+	}, nil
+}
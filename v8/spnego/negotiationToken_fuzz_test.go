@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package spnego
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+)
+
+func FuzzUnmarshalNegToken(f *testing.F) {
+	for _, s := range []string{testNegTokenInit, testNegTokenResp} {
+		b, _ := hex.DecodeString(s)
+		f.Add(b)
+	}
+	nt := NegTokenInit{
+		MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+		MechTokenBytes: fuzzKRB5TokenSeed(),
+	}
+	b, _ := nt.Marshal()
+	f.Add(b)
+	kb, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(kb)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		isInit, nt, err := UnmarshalNegToken(b)
+		if err != nil {
+			return
+		}
+		if isInit {
+			n := nt.(NegTokenInit)
+			n.settings = service.NewSettings(kt)
+			n.Verify()
+			return
+		}
+		n := nt.(NegTokenResp)
+		n.settings = service.NewSettings(kt)
+		n.Verify()
+	})
+}
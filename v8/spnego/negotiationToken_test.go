@@ -1,10 +1,17 @@
 package spnego
 
 import (
+	"encoding/base64"
 	"encoding/hex"
+	"strings"
 	"testing"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+	"github.com/jcmturner/gokrb5/v8/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -105,3 +112,60 @@ func TestUnmarshal_negTokenInitWithReqFlags(t *testing.T) {
 		t.Errorf("unmarshal did not return the correct number of mechToken bytes")
 	}
 }
+
+func TestSelectKRB5Mech(t *testing.T) {
+	t.Parallel()
+	ntlm := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+	krb5 := gssapi.OIDKRB5.OID()
+	legacy := gssapi.OIDMSLegacyKRB5.OID()
+	u2u := gssapi.OIDKRB5U2U.OID()
+	var tests = []struct {
+		mechs    []asn1.ObjectIdentifier
+		selected asn1.ObjectIdentifier
+	}{
+		{[]asn1.ObjectIdentifier{krb5, legacy}, krb5},
+		{[]asn1.ObjectIdentifier{legacy, krb5}, krb5},
+		{[]asn1.ObjectIdentifier{u2u, ntlm, legacy, krb5}, krb5},
+		{[]asn1.ObjectIdentifier{ntlm, legacy}, legacy},
+		{[]asn1.ObjectIdentifier{ntlm, u2u}, nil},
+	}
+	for i, test := range tests {
+		mech, ok := selectKRB5Mech(test.mechs)
+		assert.Equal(t, test.selected != nil, ok, "test %d: mech selection not as expected", i)
+		assert.True(t, mech.Equal(test.selected), "test %d: mech selected %v not as expected", i, mech)
+	}
+}
+
+func TestNegTokenInit_Verify_SelectMech(t *testing.T) {
+	t.Parallel()
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	kt := keytab.New()
+	kt.Unmarshal(b)
+	ntlm := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+
+	// The initiator prefers another mechanism so negotiation continues with the best Kerberos mechanism proposed
+	nt := offlineNegTokenInit(t, types.NewKrbFlags())
+	nt.MechTypes = []asn1.ObjectIdentifier{ntlm, gssapi.OIDKRB5U2U.OID(), gssapi.OIDMSLegacyKRB5.OID()}
+	nt.MechListMIC = nil
+	nt.settings = service.NewSettings(kt)
+	_, status := nt.Verify()
+	assert.Equal(t, gssapi.StatusContinueNeeded, status.Code, "status not as expected")
+	st := &SPNEGOToken{Init: true, NegTokenInit: nt}
+	hv, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(negTokenRespIncomplete(st), HTTPHeaderAuthResponseValueKey+" "))
+	if err != nil {
+		t.Fatalf("error decoding response token: %v", err)
+	}
+	var resp NegTokenResp
+	if err := resp.Unmarshal(hv); err != nil {
+		t.Fatalf("error unmarshaling response token: %v", err)
+	}
+	assert.Equal(t, NegStateAcceptIncomplete, resp.State(), "negotiation state not as expected")
+	assert.True(t, resp.SupportedMech.Equal(gssapi.OIDMSLegacyKRB5.OID()), "supported mech %v not as expected", resp.SupportedMech)
+
+	// The standard KRB5 mechanism is selected wherever the initiator listed it
+	st.NegTokenInit.MechTypes = append(st.NegTokenInit.MechTypes, gssapi.OIDKRB5.OID())
+	assert.Equal(t, spnegoNegTokenRespIncompleteKRB5, negTokenRespIncomplete(st), "response not as expected")
+	n := newNegTokenRespKRB5Incomplete(st.NegTokenInit)
+	b, _ = n.Marshal()
+	assert.Equal(t, spnegoNegTokenRespIncompleteKRB5, HTTPHeaderAuthResponseValueKey+" "+base64.StdEncoding.EncodeToString(b), "static response token does not match")
+}
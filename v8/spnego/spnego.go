@@ -19,6 +19,7 @@ type SPNEGO struct {
 	serviceSettings *service.Settings
 	client          *client.Client
 	spn             string
+	cred            *Credential
 }
 
 // SPNEGOClient configures the SPNEGO mechanism suitable for client side use.
@@ -49,6 +50,9 @@ func (s *SPNEGO) AcquireCred() error {
 
 // InitSecContext is the GSS-API method for the client to a generate a context token to the service via Kerberos.
 func (s *SPNEGO) InitSecContext() (gssapi.ContextToken, error) {
+	if s.cred != nil && s.cred.Expired() {
+		return &SPNEGOToken{}, gssapi.Status{Code: gssapi.StatusCredentialsExpired, Message: "credential to initiate the security context has expired"}
+	}
 	tkt, key, err := s.client.GetServiceTicket(s.spn)
 	if err != nil {
 		return &SPNEGOToken{}, err
@@ -72,15 +76,31 @@ func (s *SPNEGO) AcceptSecContext(ct gssapi.ContextToken) (bool, context.Context
 	if !ok {
 		return false, ctx, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "context token provided was not an SPNEGO token"}
 	}
+	if s.cred != nil && s.cred.Expired() {
+		return false, ctx, gssapi.Status{Code: gssapi.StatusCredentialsExpired, Message: "credential to accept the security context has expired"}
+	}
 	t.settings = s.serviceSettings
-	var oid asn1.ObjectIdentifier
+	var krb5 bool
 	if t.Init {
-		oid = t.NegTokenInit.MechTypes[0]
+		// KRB5 may not be the initiator's preferred mechanism, in which case negotiation continues with KRB5.
+		for _, oid := range t.NegTokenInit.MechTypes {
+			if isKRB5OID(oid) {
+				krb5 = true
+				break
+			}
+		}
 	}
 	if t.Resp {
-		oid = t.NegTokenResp.SupportedMech
-	}
-	if !(oid.Equal(gssapi.OIDKRB5.OID()) || oid.Equal(gssapi.OIDMSLegacyKRB5.OID())) {
+		// Only the acceptor's first response carries the supported mechanism so subsequent tokens from the initiator
+		// may not include it. KRB5 is the only mechanism this acceptor selects.
+		krb5 = len(t.NegTokenResp.SupportedMech) == 0 || isKRB5OID(t.NegTokenResp.SupportedMech)
+	}
+	if !krb5 {
+		s.serviceSettings.Diagnose(service.Diagnosis{
+			Step:   service.DiagnosticStepMechOID,
+			Reason: "SPNEGO OID of MechToken is not of type KRB5",
+			Hint:   "the client did not offer the KRB5 mechanism, it is likely negotiating NTLM as it could not obtain a Kerberos ticket for the service; check the SPN is registered and the client can reach the KDC",
+		})
 		return false, ctx, gssapi.Status{Code: gssapi.StatusDefectiveToken, Message: "SPNEGO OID of MechToken is not of type KRB5"}
 	}
 	// Flags in the NegInit must be used 	t.NegTokenInit.ReqFlags
@@ -89,6 +109,11 @@ func (s *SPNEGO) AcceptSecContext(ct gssapi.ContextToken) (bool, context.Context
 	return ok, ctx, status
 }
 
+// isKRB5OID indicates if the OID is for the KRB5 mechanism, including Microsoft's legacy KRB5 OID.
+func isKRB5OID(oid asn1.ObjectIdentifier) bool {
+	return oid.Equal(gssapi.OIDKRB5.OID()) || oid.Equal(gssapi.OIDMSLegacyKRB5.OID())
+}
+
 // Log will write to the service's logger if it is configured.
 func (s *SPNEGO) Log(format string, v ...interface{}) {
 	if s.serviceSettings.Logger() != nil {
@@ -201,3 +226,13 @@ func (s *SPNEGOToken) Verify() (bool, gssapi.Status) {
 func (s *SPNEGOToken) Context() context.Context {
 	return s.context
 }
+
+// Delete zeroizes the key material held by the SPNEGO token's KRB5 mechanism token once the security context is no
+// longer required. The identity information in the context is not affected.
+func (s *SPNEGOToken) Delete() {
+	for _, mt := range []gssapi.ContextToken{s.NegTokenInit.mechToken, s.NegTokenResp.mechToken} {
+		if k, ok := mt.(*KRB5Token); ok {
+			k.Delete()
+		}
+	}
+}
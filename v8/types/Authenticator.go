@@ -31,18 +31,27 @@ type Authenticator struct {
 
 // NewAuthenticator creates a new Authenticator.
 func NewAuthenticator(realm string, cname PrincipalName) (Authenticator, error) {
+	return NewAuthenticatorWithTime(realm, cname, time.Now())
+}
+
+// NewAuthenticatorWithTime creates a new Authenticator with the client's time set to the time provided rather than the
+// current time. This allows the clock used to be overridden, for example to test the handling of clock skew.
+//
+// The ctime is set to the time with second precision, as KerberosTime has no fractional seconds, and the cusec to the
+// microsecond remainder.
+func NewAuthenticatorWithTime(realm string, cname PrincipalName, t time.Time) (Authenticator, error) {
 	seq, err := rand.Int(rand.Reader, big.NewInt(math.MaxUint32))
 	if err != nil {
 		return Authenticator{}, err
 	}
-	t := time.Now().UTC()
+	t = t.UTC()
 	return Authenticator{
 		AVNO:      iana.PVNO,
 		CRealm:    realm,
 		CName:     cname,
 		Cksum:     Checksum{},
-		Cusec:     int((t.UnixNano() / int64(time.Microsecond)) - (t.Unix() * 1e6)),
-		CTime:     t,
+		Cusec:     t.Nanosecond() / int(time.Microsecond),
+		CTime:     t.Truncate(time.Second),
 		SeqNumber: seq.Int64() & 0x3fffffff,
 	}, nil
 }
@@ -0,0 +1,20 @@
+//go:build go1.18
+// +build go1.18
+
+package types
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/test/testdata"
+)
+
+func FuzzAuthenticator_Unmarshal(f *testing.F) {
+	b, _ := hex.DecodeString(testdata.MarshaledKRB5authenticator)
+	f.Add(b)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var a Authenticator
+		a.Unmarshal(b)
+	})
+}
@@ -97,3 +97,35 @@ func TestMarshalAuthenticator(t *testing.T) {
 	}
 	assert.Equal(t, b, mb, "Marshal bytes of Authenticator not as expected")
 }
+
+func TestNewAuthenticatorWithTime(t *testing.T) {
+	t.Parallel()
+	ct := time.Date(2026, time.March, 4, 10, 11, 12, 345678901, time.UTC)
+	a, err := NewAuthenticatorWithTime("TEST.GOKRB5", NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"), ct)
+	if err != nil {
+		t.Fatalf("error creating authenticator: %v", err)
+	}
+	assert.Equal(t, time.Date(2026, time.March, 4, 10, 11, 12, 0, time.UTC), a.CTime, "ctime should have second precision")
+	assert.Equal(t, 345678, a.Cusec, "cusec should be the microsecond remainder")
+
+	// The client's time is unchanged by marshaling
+	b, err := a.Marshal()
+	if err != nil {
+		t.Fatalf("error marshaling authenticator: %v", err)
+	}
+	var u Authenticator
+	err = u.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("error unmarshaling authenticator: %v", err)
+	}
+	assert.True(t, a.CTime.Equal(u.CTime), "ctime not as expected after marshaling")
+	assert.Equal(t, a.Cusec, u.Cusec, "cusec not as expected after marshaling")
+	assert.Equal(t, ct.Truncate(time.Microsecond), u.CTime.Add(time.Duration(u.Cusec)*time.Microsecond).UTC(), "client time not as expected")
+
+	a, err = NewAuthenticator("TEST.GOKRB5", NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser1"))
+	if err != nil {
+		t.Fatalf("error creating authenticator: %v", err)
+	}
+	assert.Equal(t, 0, a.CTime.Nanosecond(), "ctime should have second precision")
+	assert.True(t, a.Cusec >= 0 && a.Cusec < 1e6, "cusec out of range: %d", a.Cusec)
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/iana/adtype"
 )
 
 // Reference: https://www.ietf.org/rfc/rfc4120.txt
@@ -53,3 +54,48 @@ func (a *AuthorizationDataEntry) Unmarshal(b []byte) error {
 	_, err := asn1.Unmarshal(b, a)
 	return err
 }
+
+// ExtractPAC returns the raw bytes of the Microsoft PAC, authorization data type AD-WIN2K-PAC, walking any AD-IF-RELEVANT
+// containers it is nested within. The bytes can then be parsed and verified with the pac package.
+// If the authorization data does not contain a PAC false is returned.
+func ExtractPAC(authData AuthorizationData) ([]byte, bool) {
+	for _, ad := range authData {
+		switch ad.ADType {
+		case adtype.ADWin2KPAC:
+			return ad.ADData, true
+		case adtype.ADIfRelevant:
+			var ad2 AuthorizationData
+			err := ad2.Unmarshal(ad.ADData)
+			if err != nil {
+				continue
+			}
+			if b, ok := ExtractPAC(ad2); ok {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Restrictions returns the elements of the authorization data that were not issued by the KDC, such as restrictions
+// requested by a client. AD-KDC-ISSUED elements and the PAC, including AD-IF-RELEVANT containers holding it, are
+// omitted as a KDC will not accept them from a client. The elements returned can be requested for inclusion in a
+// further ticket.
+func (a AuthorizationData) Restrictions() AuthorizationData {
+	var r AuthorizationData
+	for _, ad := range a {
+		switch ad.ADType {
+		case adtype.ADKDCIssued, adtype.ADWin2KPAC:
+			continue
+		case adtype.ADIfRelevant:
+			var ad2 AuthorizationData
+			if err := ad2.Unmarshal(ad.ADData); err == nil {
+				if _, ok := ExtractPAC(ad2); ok {
+					continue
+				}
+			}
+		}
+		r = append(r, ad)
+	}
+	return r
+}
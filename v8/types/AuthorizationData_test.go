@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/iana/adtype"
 	"github.com/jcmturner/gokrb5/v8/iana/nametype"
 	"github.com/jcmturner/gokrb5/v8/test/testdata"
@@ -51,3 +52,63 @@ func TestUnmarshalAuthorizationData_kdcissued(t *testing.T) {
 		assert.Equal(t, []byte(testdata.TEST_AUTHORIZATION_DATA_VALUE), ele.ADData, fmt.Sprintf("Authorization data of element %d not as expected", i+1))
 	}
 }
+
+func TestExtractPAC(t *testing.T) {
+	t.Parallel()
+	pacBytes := []byte{1, 2, 3, 4}
+	inner, err := asn1.Marshal(AuthorizationData{
+		{ADType: adtype.ADWin2KPAC, ADData: pacBytes},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling inner authorization data: %v", err)
+	}
+	nested, err := asn1.Marshal(AuthorizationData{
+		{ADType: adtype.ADIfRelevant, ADData: inner},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling nested authorization data: %v", err)
+	}
+	var tests = []struct {
+		authData AuthorizationData
+		pac      bool
+	}{
+		{AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: inner}}, true},
+		{AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: nested}}, true},
+		{AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: []byte{0x30, 0x00}}, {ADType: adtype.ADIfRelevant, ADData: inner}}, true},
+		{AuthorizationData{{ADType: adtype.ADWin2KPAC, ADData: pacBytes}}, true},
+		{AuthorizationData{{ADType: adtype.ADIfRelevant, ADData: []byte{0xff}}}, false},
+		{AuthorizationData{{ADType: adtype.ADKDCIssued, ADData: inner}}, false},
+		{AuthorizationData{}, false},
+	}
+	for i, test := range tests {
+		b, ok := ExtractPAC(test.authData)
+		assert.Equal(t, test.pac, ok, "test %d: PAC found not as expected", i)
+		if test.pac {
+			assert.Equal(t, pacBytes, b, "test %d: PAC bytes not as expected", i)
+		}
+	}
+}
+
+func TestAuthorizationData_Restrictions(t *testing.T) {
+	t.Parallel()
+	pac, err := asn1.Marshal(AuthorizationData{
+		{ADType: adtype.ADWin2KPAC, ADData: []byte{1, 2, 3, 4}},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling PAC authorization data: %v", err)
+	}
+	restriction, err := asn1.Marshal(AuthorizationData{
+		{ADType: 141, ADData: []byte{5, 6}},
+	})
+	if err != nil {
+		t.Fatalf("error marshaling restriction authorization data: %v", err)
+	}
+	authData := AuthorizationData{
+		{ADType: adtype.ADIfRelevant, ADData: pac},
+		{ADType: adtype.ADKDCIssued, ADData: []byte{7}},
+		{ADType: adtype.ADIfRelevant, ADData: restriction},
+		{ADType: adtype.ADIntendedForServer, ADData: []byte{8}},
+	}
+	assert.Equal(t, AuthorizationData{authData[2], authData[3]}, authData.Restrictions(), "restrictions not as expected")
+	assert.Len(t, AuthorizationData{}.Restrictions(), 0, "restrictions of empty authorization data not empty")
+}
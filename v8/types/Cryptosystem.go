@@ -24,6 +24,14 @@ type EncryptionKey struct {
 	KeyValue []byte `asn1:"explicit,tag:1" json:"-"`
 }
 
+// Zeroize overwrites the key value with zeros so that the key material does not remain in memory once it is no longer
+// needed. Any copies of the EncryptionKey sharing the same key value are also cleared.
+func (k *EncryptionKey) Zeroize() {
+	for i := range k.KeyValue {
+		k.KeyValue[i] = 0
+	}
+}
+
 // Checksum implements RFC 4120 type: https://tools.ietf.org/html/rfc4120#section-5.2.9
 type Checksum struct {
 	CksumType int32  `asn1:"explicit,tag:0"`
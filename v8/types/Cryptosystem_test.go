@@ -89,3 +89,14 @@ func TestMarshalEncryptedData(t *testing.T) {
 	}
 	assert.Equal(t, b, mb, "Marshal bytes of Encrypted Data not as expected")
 }
+
+func TestEncryptionKey_Zeroize(t *testing.T) {
+	t.Parallel()
+	k := EncryptionKey{KeyType: 18, KeyValue: []byte{1, 2, 3, 4}}
+	c := k
+	k.Zeroize()
+	assert.Equal(t, []byte{0, 0, 0, 0}, k.KeyValue, "key value not zeroized")
+	assert.Equal(t, []byte{0, 0, 0, 0}, c.KeyValue, "copy of key value not zeroized")
+	var e EncryptionKey
+	e.Zeroize()
+}
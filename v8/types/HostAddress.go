@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/jcmturner/gofork/encoding/asn1"
 	"github.com/jcmturner/gokrb5/v8/iana/addrtype"
@@ -46,8 +47,54 @@ func GetHostAddress(s string) (HostAddress, error) {
 	return h, nil
 }
 
-// GetAddress returns a string representation of the HostAddress.
+// netBIOSAddressLength is the length of a NetBIOS address: https://tools.ietf.org/html/rfc4120#section-7.5.3
+const netBIOSAddressLength = 16
+
+// NewHostAddress returns a HostAddress of the address type specified encoded as defined in RFC 4120 section 7.5.3.
+// IPv4 and IPv6 addresses are provided in their textual form and NetBIOS addresses as the NetBIOS name.
+func NewHostAddress(addrType int32, address string) (HostAddress, error) {
+	switch addrType {
+	case addrtype.IPv4:
+		ip := net.ParseIP(address).To4()
+		if ip == nil {
+			return HostAddress{}, fmt.Errorf("%q is not an IPv4 address", address)
+		}
+		return HostAddress{AddrType: addrType, Address: ip}, nil
+	case addrtype.IPv6:
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			return HostAddress{}, fmt.Errorf("%q is not an IPv6 address", address)
+		}
+		return HostAddress{AddrType: addrType, Address: ip.To16()}, nil
+	case addrtype.NetBios:
+		return HostAddressFromNetBIOSName(address)
+	default:
+		return HostAddress{}, fmt.Errorf("encoding of address type %d is not supported", addrType)
+	}
+}
+
+// HostAddressFromNetBIOSName returns a HostAddress for the NetBIOS name provided. The name of 1 to 15 characters is
+// padded with spaces to 15 octets followed by a NUL octet.
+func HostAddressFromNetBIOSName(name string) (HostAddress, error) {
+	if len(name) < 1 || len(name) >= netBIOSAddressLength {
+		return HostAddress{}, fmt.Errorf("NetBIOS name %q must be between 1 and %d characters", name, netBIOSAddressLength-1)
+	}
+	b := bytes.Repeat([]byte{' '}, netBIOSAddressLength)
+	copy(b, name)
+	b[netBIOSAddressLength-1] = 0
+	return HostAddress{AddrType: addrtype.NetBios, Address: b}, nil
+}
+
+// GetAddress returns a string representation of the HostAddress. IPv4 and IPv6 addresses are returned in their textual
+// form and NetBIOS addresses as the NetBIOS name.
 func (h *HostAddress) GetAddress() (string, error) {
+	switch {
+	case h.AddrType == addrtype.IPv4 && len(h.Address) == net.IPv4len,
+		h.AddrType == addrtype.IPv6 && len(h.Address) == net.IPv6len:
+		return net.IP(h.Address).String(), nil
+	case h.AddrType == addrtype.NetBios && len(h.Address) == netBIOSAddressLength:
+		return strings.TrimRight(string(h.Address), " \x00"), nil
+	}
 	var b []byte
 	_, err := asn1.Unmarshal(h.Address, &b)
 	return string(b), err
@@ -27,3 +27,44 @@ func TestGetHostAddress(t *testing.T) {
 		assert.Equal(t, test.hex, hex.EncodeToString(h.Address), "wrong address bytes for %s", test.str)
 	}
 }
+
+func TestNewHostAddress(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		addrType int32
+		addr     string
+		hex      string
+		str      string
+	}{
+		{addrtype.IPv4, "192.168.1.100", "c0a80164", "192.168.1.100"},
+		{addrtype.IPv6, "fe80::1cf3:b43b:df29:d43e", "fe800000000000001cf3b43bdf29d43e", "fe80::1cf3:b43b:df29:d43e"},
+		{addrtype.NetBios, "HOST1", "484f5354312020202020202020202000", "HOST1"},
+	}
+	for _, test := range tests {
+		h, err := NewHostAddress(test.addrType, test.addr)
+		if err != nil {
+			t.Fatalf("error encoding address %s: %v", test.addr, err)
+		}
+		assert.Equal(t, test.addrType, h.AddrType, "wrong address type for %s", test.addr)
+		assert.Equal(t, test.hex, hex.EncodeToString(h.Address), "wrong address bytes for %s", test.addr)
+		s, err := h.GetAddress()
+		if assert.NoError(t, err, "error getting address string for %s", test.addr) {
+			assert.Equal(t, test.str, s, "wrong address string for %s", test.addr)
+		}
+	}
+
+	var invalid = []struct {
+		addrType int32
+		addr     string
+	}{
+		{addrtype.IPv4, "fe80::1"},
+		{addrtype.IPv6, "192.168.1.100"},
+		{addrtype.NetBios, ""},
+		{addrtype.NetBios, "NAMEOFSIXTEENCHR"},
+		{addrtype.ChaosNet, "1"},
+	}
+	for _, test := range invalid {
+		_, err := NewHostAddress(test.addrType, test.addr)
+		assert.Error(t, err, "expected an error encoding %q as address type %d", test.addr, test.addrType)
+	}
+}